@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	db "github.com/tredoc/go-balances/db/sqlc"
+	"github.com/tredoc/go-balances/internal/config"
+	"github.com/tredoc/go-balances/internal/logging"
+	"github.com/tredoc/go-balances/internal/money"
+	"github.com/tredoc/go-balances/internal/service"
+	"github.com/tredoc/go-balances/internal/store"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const usage = `usage: balancectl [flags] <command> [args]
+
+commands:
+  balances                                 list balances
+  balance <balance id>                     show balance
+  users                                    list users
+  user <user id or username>               show user with balances
+  portfolio [-currency USD] <user id or username>
+                                           show user balances with their total in the currency
+  rate <base> <quote> <rate>               set units of quote currency for one unit of base, like EUR USD 1.0835
+  deposit <balance id> <amount>            add amount, like 12.30, to the balance
+  withdraw <balance id> <amount>           take amount from the balance
+  transfer <from id> <to id> <amount>      move amount between balances
+  statement <balance id>                   list entries and transfers of the balance
+  reconcile                                check balances against entries, transfers and holds
+  freeze [-debit-only] -reason <text> <balance id>
+  unfreeze -reason <text> <balance id>
+
+flags:
+`
+
+var (
+	// errUsage prints usage and exits with code 2
+	errUsage = errors.New("usage")
+	// errAborted is returned when the operator didn't confirm the action
+	errAborted = errors.New("aborted")
+	// errDiscrepancies makes reconcile exit with non zero code
+	errDiscrepancies = errors.New("balances don't match their ledger")
+)
+
+type app struct {
+	services *service.Service
+	out      printer
+	in       *bufio.Reader
+	stderr   io.Writer
+	dryRun   bool
+	yes      bool
+}
+
+func main() {
+	flags := flag.NewFlagSet("balancectl", flag.ExitOnError)
+	output := flags.String("o", "table", "output format: table or json")
+	dryRun := flags.Bool("dry-run", false, "run the operation and roll it back, showing the state it would produce")
+	yes := flags.Bool("y", false, "don't ask for confirmation")
+	actor := flags.String("actor", os.Getenv("USER"), "operator name written to the audit log")
+	configFile := flags.String("config", "", "yaml config file, CONFIG_FILE by default")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(os.Args[1:])
+
+	if flags.NArg() == 0 || (*output != "table" && *output != "json") {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	conn, err := store.Open(cfg.DB)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	slog.SetDefault(logging.New(cfg.Log, os.Stderr))
+
+	storage := store.New(conn)
+	storage.SlowQuery = cfg.Log.SlowQuery
+	services := service.New(storage)
+	services.Retry = service.RetryPolicy(cfg.Retry)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ctx = service.WithInitiator(ctx, service.Initiator{Actor: *actor, Client: "balancectl", RequestID: logging.NewRequestID()})
+	if *dryRun {
+		ctx = service.WithDryRun(ctx)
+	}
+
+	a := &app{
+		services: services,
+		out:      printer{w: os.Stdout, json: *output == "json"},
+		in:       bufio.NewReader(os.Stdin),
+		stderr:   os.Stderr,
+		dryRun:   *dryRun,
+		yes:      *yes,
+	}
+
+	err = a.run(ctx, flags.Arg(0), flags.Args()[1:])
+	if errors.Is(err, errUsage) {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func (a *app) run(ctx context.Context, command string, args []string) error {
+	switch command {
+	case "balances":
+		return a.balances(ctx)
+	case "balance":
+		return a.balance(ctx, args)
+	case "users":
+		return a.users(ctx)
+	case "user":
+		return a.user(ctx, args)
+	case "portfolio":
+		return a.portfolio(ctx, args)
+	case "rate":
+		return a.rate(ctx, args)
+	case "deposit", "withdraw":
+		return a.move(ctx, command, args)
+	case "transfer":
+		return a.transfer(ctx, args)
+	case "statement":
+		return a.statement(ctx, args)
+	case "reconcile":
+		return a.reconcile(ctx)
+	case "freeze", "unfreeze":
+		return a.freeze(ctx, command, args)
+	default:
+		return errUsage
+	}
+}
+
+func (a *app) balances(ctx context.Context) error {
+	balances, err := a.services.GetAllBalances(ctx)
+	if err != nil {
+		return err
+	}
+	return a.out.balances(balances)
+}
+
+func (a *app) balance(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+
+	balance, err := a.services.GetBalanceById(ctx, id)
+	if err != nil {
+		return err
+	}
+	return a.out.balances([]service.Balance{balance})
+}
+
+func (a *app) users(ctx context.Context) error {
+	users, err := a.services.GetAllUsers(ctx)
+	if err != nil {
+		return err
+	}
+	return a.out.users(users)
+}
+
+func (a *app) user(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	user, err := a.findUser(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	balances, err := a.services.GetBalancesByUserId(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	return a.out.user(user, balances)
+}
+
+// findUser looks the user up by username and then by id
+func (a *app) findUser(ctx context.Context, s string) (db.User, error) {
+	user, err := a.services.GetUserByUsername(ctx, s)
+	if id, parseErr := strconv.ParseUint(s, 10, 64); parseErr == nil && errors.Is(err, sql.ErrNoRows) {
+		user, err = a.services.GetUserById(ctx, id)
+	}
+	return user, err
+}
+
+func (a *app) portfolio(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("portfolio", flag.ContinueOnError)
+	flags.SetOutput(a.stderr)
+	currency := flags.String("currency", "USD", "currency of the total")
+	err := flags.Parse(args)
+	if err != nil || flags.NArg() != 1 {
+		return errUsage
+	}
+
+	user, err := a.findUser(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	portfolio, err := a.services.UserPortfolio(ctx, user.ID, *currency)
+	if err != nil {
+		return err
+	}
+	return a.out.portfolio(portfolio)
+}
+
+func (a *app) rate(ctx context.Context, args []string) error {
+	if len(args) != 3 {
+		return errUsage
+	}
+
+	err := a.confirm(fmt.Sprintf("set %s to %s rate to %s", args[0], args[1], args[2]))
+	if err != nil {
+		return err
+	}
+
+	err = a.services.SetExchangeRate(ctx, args[0], args[1], args[2])
+	if err != nil {
+		return err
+	}
+
+	a.reportDryRun()
+	_, err = fmt.Fprintf(a.out.w, "1 %s = %s %s\n", args[0], args[2], args[1])
+	return err
+}
+
+// move deposits to or withdraws from the balance
+func (a *app) move(ctx context.Context, command string, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+
+	balance, err := a.services.GetBalanceById(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	amount, err := money.Parse(args[1], balance.Currency)
+	if err != nil {
+		return err
+	}
+
+	err = a.confirm(fmt.Sprintf("%s %s, balance %d", command, amount, id))
+	if err != nil {
+		return err
+	}
+
+	var result *service.Balance
+	if command == "deposit" {
+		result, err = a.services.Deposit(ctx, id, amount)
+	} else {
+		result, err = a.services.Withdraw(ctx, id, amount)
+	}
+	if err != nil {
+		return err
+	}
+
+	return a.changed(*result)
+}
+
+func (a *app) transfer(ctx context.Context, args []string) error {
+	if len(args) != 3 {
+		return errUsage
+	}
+
+	fromID, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+
+	toID, err := parseID(args[1])
+	if err != nil {
+		return err
+	}
+
+	balanceFrom, err := a.services.GetBalanceById(ctx, fromID)
+	if err != nil {
+		return err
+	}
+
+	amount, err := money.Parse(args[2], balanceFrom.Currency)
+	if err != nil {
+		return err
+	}
+
+	err = a.confirm(fmt.Sprintf("transfer %s from balance %d to balance %d", amount, fromID, toID))
+	if err != nil {
+		return err
+	}
+
+	from, to, err := a.services.Transfer(ctx, fromID, toID, amount)
+	if err != nil {
+		return err
+	}
+
+	return a.changed(*from, *to)
+}
+
+func (a *app) statement(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+
+	statement, err := a.services.Statement(ctx, id)
+	if err != nil {
+		return err
+	}
+	return a.out.statement(statement)
+}
+
+func (a *app) reconcile(ctx context.Context) error {
+	discrepancies, err := a.services.Reconcile(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = a.out.discrepancies(discrepancies)
+	if err != nil {
+		return err
+	}
+
+	if len(discrepancies) > 0 {
+		return errDiscrepancies
+	}
+	return nil
+}
+
+func (a *app) freeze(ctx context.Context, command string, args []string) error {
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	flags.SetOutput(a.stderr)
+	debitOnly := flags.Bool("debit-only", false, "block only debits, credits are still accepted")
+	reason := flags.String("reason", "", "why the status is changed, required")
+	err := flags.Parse(args)
+	if err != nil || flags.NArg() != 1 {
+		return errUsage
+	}
+
+	id, err := parseID(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	err = a.confirm(fmt.Sprintf("%s balance %d", command, id))
+	if err != nil {
+		return err
+	}
+
+	actor := service.InitiatorFrom(ctx).Actor
+
+	var balance *service.Balance
+	if command == "freeze" {
+		balance, err = a.services.FreezeBalance(ctx, id, *debitOnly, actor, *reason)
+	} else {
+		balance, err = a.services.UnfreezeBalance(ctx, id, actor, *reason)
+	}
+	if err != nil {
+		return err
+	}
+
+	return a.changed(*balance)
+}
+
+// changed prints balances after the operation
+func (a *app) changed(balances ...service.Balance) error {
+	a.reportDryRun()
+	return a.out.balances(balances)
+}
+
+// reportDryRun tells that the change was rolled back, it goes to stderr, so json output stays valid
+func (a *app) reportDryRun() {
+	if a.dryRun {
+		fmt.Fprintln(a.stderr, "dry run, the operation was rolled back, nothing was changed")
+	}
+}
+
+// confirm asks the operator to approve the change, it's skipped for dry run or with -y
+func (a *app) confirm(action string) error {
+	if a.yes || a.dryRun {
+		return nil
+	}
+
+	fmt.Fprintf(a.stderr, "%s? [y/N] ", action)
+	answer, err := a.in.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	default:
+		return errAborted
+	}
+}
+
+func parseID(s string) (uint64, error) {
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || id == 0 {
+		return 0, fmt.Errorf("invalid id %q", s)
+	}
+	return id, nil
+}
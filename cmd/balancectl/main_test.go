@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	db "github.com/tredoc/go-balances/db/sqlc"
+	"github.com/tredoc/go-balances/internal/money"
+	"github.com/tredoc/go-balances/internal/service"
+	"io"
+	"strings"
+	"testing"
+)
+
+var usd = money.Currency{Code: "USD", Exponent: 2}
+
+var balance = service.Balance{
+	Balance:  db.Balance{ID: 1, UserID: 2, Amount: 12345, HeldAmount: 45, Status: service.StatusActive},
+	Currency: usd,
+}
+
+func TestPrinter(t *testing.T) {
+	t.Run("Test balances table", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := printer{w: &buf}.balances([]service.Balance{balance})
+		assert.Nil(t, err)
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		assert.Equal(t, len(lines), 2)
+		assert.Equal(t, strings.Fields(lines[0]), []string{"ID", "USER", "CURRENCY", "AMOUNT", "HELD", "AVAILABLE", "STATUS"})
+		assert.Equal(t, strings.Fields(lines[1]), []string{"1", "2", "USD", "123.45", "0.45", "123.00", "active"})
+	})
+
+	t.Run("Test balances json", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := printer{w: &buf, json: true}.balances([]service.Balance{balance})
+		assert.Nil(t, err)
+
+		var views []balanceView
+		assert.Nil(t, json.Unmarshal(buf.Bytes(), &views))
+		assert.Equal(t, views, []balanceView{{ID: 1, UserID: 2, Currency: "USD", Amount: "123.45", Held: "0.45", Available: "123.00", Status: "active"}})
+	})
+
+	t.Run("Test portfolio table", func(t *testing.T) {
+		portfolio := &service.Portfolio{
+			User:     db.User{ID: 2, Username: "Vadym"},
+			Balances: []service.Balance{balance},
+			Total:    money.New(12345, usd),
+			Rates:    map[string]string{"UAH": "0.024", "EUR": "1.0835"},
+		}
+
+		var buf bytes.Buffer
+		err := printer{w: &buf}.portfolio(portfolio)
+		assert.Nil(t, err)
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		assert.Equal(t, lines[0], "user 2 Vadym")
+		assert.Equal(t, lines[len(lines)-3:], []string{"total 123.45 USD", "1 EUR = 1.0835 USD", "1 UAH = 0.024 USD"})
+	})
+
+	t.Run("Test statement json", func(t *testing.T) {
+		statement := &service.Statement{
+			Balance: balance,
+			Lines: []service.StatementLine{
+				{Kind: service.LineEntry, ID: 1, Amount: 12500, Reference: "import-1"},
+				{Kind: service.LineTransferOut, ID: 7, Amount: -155, CounterpartyID: 3},
+				{Kind: service.LineEntry, ID: 9, Amount: -45, BatchID: 4},
+			},
+			Credits: 12500,
+			Debits:  -200,
+		}
+
+		var buf bytes.Buffer
+		err := printer{w: &buf, json: true}.statement(statement)
+		assert.Nil(t, err)
+
+		var view statementView
+		assert.Nil(t, json.Unmarshal(buf.Bytes(), &view))
+		assert.Equal(t, view.Lines[1], lineView{Kind: "transfer_out", ID: 7, Amount: "-1.55", CounterpartyID: 3})
+		assert.Equal(t, view.Lines[2], lineView{Kind: "entry", ID: 9, Amount: "-0.45", BatchID: 4})
+		assert.Equal(t, view.Credits, "125.00")
+		assert.Equal(t, view.Debits, "-2.00")
+	})
+}
+
+func TestConfirm(t *testing.T) {
+	newApp := func(input string) *app {
+		return &app{in: bufio.NewReader(strings.NewReader(input)), stderr: io.Discard}
+	}
+
+	t.Run("Test action is confirmed", func(t *testing.T) {
+		assert.Nil(t, newApp("y\n").confirm("withdraw"))
+		assert.Nil(t, newApp("YES\n").confirm("withdraw"))
+	})
+
+	t.Run("Test action is aborted", func(t *testing.T) {
+		assert.ErrorIs(t, newApp("n\n").confirm("withdraw"), errAborted)
+		assert.ErrorIs(t, newApp("\n").confirm("withdraw"), errAborted)
+		assert.ErrorIs(t, newApp("").confirm("withdraw"), errAborted)
+	})
+
+	t.Run("Test confirmation is skipped", func(t *testing.T) {
+		a := newApp("")
+		a.yes = true
+		assert.Nil(t, a.confirm("withdraw"))
+
+		a = newApp("")
+		a.dryRun = true
+		assert.Nil(t, a.confirm("withdraw"))
+	})
+}
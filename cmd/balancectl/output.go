@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	db "github.com/tredoc/go-balances/db/sqlc"
+	"github.com/tredoc/go-balances/internal/money"
+	"github.com/tredoc/go-balances/internal/service"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// printer writes results as aligned table or as indented json, amounts are decimals in both
+type printer struct {
+	w    io.Writer
+	json bool
+}
+
+type balanceView struct {
+	ID        uint64 `json:"id"`
+	UserID    uint64 `json:"user_id"`
+	Currency  string `json:"currency"`
+	Amount    string `json:"amount"`
+	Held      string `json:"held"`
+	Available string `json:"available"`
+	Status    string `json:"status"`
+}
+
+type userView struct {
+	ID       uint64        `json:"id"`
+	Username string        `json:"username"`
+	Balances []balanceView `json:"balances,omitempty"`
+}
+
+type portfolioView struct {
+	User     userView          `json:"user"`
+	Total    string            `json:"total"`
+	Currency string            `json:"currency"`
+	Rates    map[string]string `json:"rates,omitempty"`
+}
+
+type lineView struct {
+	Kind           string `json:"kind"`
+	ID             uint64 `json:"id"`
+	Amount         string `json:"amount"`
+	CounterpartyID uint64 `json:"counterparty_id,omitempty"`
+	Reference      string `json:"reference,omitempty"`
+	BatchID        uint64 `json:"batch_id,omitempty"`
+}
+
+type statementView struct {
+	Balance balanceView `json:"balance"`
+	Lines   []lineView  `json:"lines"`
+	Credits string      `json:"credits"`
+	Debits  string      `json:"debits"`
+}
+
+type discrepancyView struct {
+	BalanceID uint64 `json:"balance_id"`
+	Field     string `json:"field"`
+	Stored    int64  `json:"stored"`
+	Expected  int64  `json:"expected"`
+}
+
+func newBalanceView(balance service.Balance) balanceView {
+	return balanceView{
+		ID:        balance.ID,
+		UserID:    balance.UserID,
+		Currency:  balance.Currency.Code,
+		Amount:    balance.Total().Decimal(),
+		Held:      balance.Held().Decimal(),
+		Available: balance.Available().Decimal(),
+		Status:    balance.Status,
+	}
+}
+
+func (p printer) balances(balances []service.Balance) error {
+	views := make([]balanceView, 0, len(balances))
+	for _, balance := range balances {
+		views = append(views, newBalanceView(balance))
+	}
+
+	if p.json {
+		return p.encode(views)
+	}
+
+	rows := make([][]any, 0, len(views))
+	for _, v := range views {
+		rows = append(rows, []any{v.ID, v.UserID, v.Currency, v.Amount, v.Held, v.Available, v.Status})
+	}
+	return p.table([]string{"ID", "USER", "CURRENCY", "AMOUNT", "HELD", "AVAILABLE", "STATUS"}, rows)
+}
+
+func (p printer) users(users []db.User) error {
+	views := make([]userView, 0, len(users))
+	for _, user := range users {
+		views = append(views, userView{ID: user.ID, Username: user.Username})
+	}
+
+	if p.json {
+		return p.encode(views)
+	}
+
+	rows := make([][]any, 0, len(views))
+	for _, v := range views {
+		rows = append(rows, []any{v.ID, v.Username})
+	}
+	return p.table([]string{"ID", "USERNAME"}, rows)
+}
+
+func (p printer) user(user db.User, balances []service.Balance) error {
+	if p.json {
+		view := userView{ID: user.ID, Username: user.Username}
+		for _, balance := range balances {
+			view.Balances = append(view.Balances, newBalanceView(balance))
+		}
+		return p.encode(view)
+	}
+
+	fmt.Fprintf(p.w, "user %d %s\n\n", user.ID, user.Username)
+	return p.balances(balances)
+}
+
+func (p printer) portfolio(portfolio *service.Portfolio) error {
+	if p.json {
+		view := portfolioView{
+			User:     userView{ID: portfolio.User.ID, Username: portfolio.User.Username},
+			Total:    portfolio.Total.Decimal(),
+			Currency: portfolio.Total.Currency.Code,
+			Rates:    portfolio.Rates,
+		}
+		for _, balance := range portfolio.Balances {
+			view.User.Balances = append(view.User.Balances, newBalanceView(balance))
+		}
+		return p.encode(view)
+	}
+
+	err := p.user(portfolio.User, portfolio.Balances)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(p.w, "\ntotal %s\n", portfolio.Total)
+	codes := make([]string, 0, len(portfolio.Rates))
+	for code := range portfolio.Rates {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		fmt.Fprintf(p.w, "1 %s = %s %s\n", code, portfolio.Rates[code], portfolio.Total.Currency.Code)
+	}
+	return nil
+}
+
+func (p printer) statement(statement *service.Statement) error {
+	currency := statement.Balance.Currency
+	view := statementView{
+		Balance: newBalanceView(statement.Balance),
+		Lines:   make([]lineView, 0, len(statement.Lines)),
+		Credits: money.New(statement.Credits, currency).Decimal(),
+		Debits:  money.New(statement.Debits, currency).Decimal(),
+	}
+	for _, line := range statement.Lines {
+		view.Lines = append(view.Lines, lineView{
+			Kind:           line.Kind,
+			ID:             line.ID,
+			Amount:         money.New(line.Amount, currency).Decimal(),
+			CounterpartyID: line.CounterpartyID,
+			Reference:      line.Reference,
+			BatchID:        line.BatchID,
+		})
+	}
+
+	if p.json {
+		return p.encode(view)
+	}
+
+	b := view.Balance
+	fmt.Fprintf(p.w, "balance %d, user %d, %s %s, status %s\n\n", b.ID, b.UserID, b.Amount, b.Currency, b.Status)
+
+	rows := make([][]any, 0, len(view.Lines)+2)
+	for _, line := range view.Lines {
+		counterparty := ""
+		if line.CounterpartyID != 0 {
+			counterparty = fmt.Sprint(line.CounterpartyID)
+		}
+		batch := ""
+		if line.BatchID != 0 {
+			batch = fmt.Sprint(line.BatchID)
+		}
+		rows = append(rows, []any{line.Kind, line.ID, line.Amount, counterparty, line.Reference, batch})
+	}
+	rows = append(rows, []any{"credits", "", view.Credits, "", "", ""}, []any{"debits", "", view.Debits, "", "", ""})
+	return p.table([]string{"KIND", "ID", "AMOUNT", "COUNTERPARTY", "REFERENCE", "BATCH"}, rows)
+}
+
+func (p printer) discrepancies(discrepancies []service.Discrepancy) error {
+	views := make([]discrepancyView, 0, len(discrepancies))
+	for _, d := range discrepancies {
+		views = append(views, discrepancyView(d))
+	}
+
+	if p.json {
+		return p.encode(views)
+	}
+
+	if len(views) == 0 {
+		_, err := fmt.Fprintln(p.w, "all balances match their ledger")
+		return err
+	}
+
+	rows := make([][]any, 0, len(views))
+	for _, v := range views {
+		rows = append(rows, []any{v.BalanceID, v.Field, v.Stored, v.Expected})
+	}
+	return p.table([]string{"BALANCE", "FIELD", "STORED", "EXPECTED"}, rows)
+}
+
+func (p printer) encode(v any) error {
+	encoder := json.NewEncoder(p.w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func (p printer) table(header []string, rows [][]any) error {
+	w := tabwriter.NewWriter(p.w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = fmt.Sprint(cell)
+		}
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"github.com/redis/go-redis/v9"
+	"github.com/tredoc/go-balances/internal/config"
+	"github.com/tredoc/go-balances/internal/grpcserver"
+	"github.com/tredoc/go-balances/internal/health"
+	"github.com/tredoc/go-balances/internal/logging"
+	"github.com/tredoc/go-balances/internal/metrics"
+	"github.com/tredoc/go-balances/internal/schema"
+	"github.com/tredoc/go-balances/internal/service"
+	"github.com/tredoc/go-balances/internal/store"
+	"github.com/tredoc/go-balances/internal/tracing"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func main() {
+	configFile := flag.String("config", "", "yaml config file, CONFIG_FILE by default")
+	addr := flag.String("addr", "", "grpc listen address, GRPC_ADDR by default")
+	flag.Parse()
+
+	cfg, err := config.Load(*configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *addr != "" {
+		cfg.Server.GRPCAddr = *addr
+	}
+
+	slog.SetDefault(logging.New(cfg.Log, os.Stderr))
+
+	err = run(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(cfg config.Config) error {
+	err := schema.Ensure(cfg.DB, cfg.Server.AutoMigrate)
+	if err != nil {
+		return err
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing(context.Background())
+
+	conn, err := store.Open(cfg.DB)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	listener, err := net.Listen("tcp", cfg.Server.GRPCAddr)
+	if err != nil {
+		return err
+	}
+
+	storage := store.New(conn)
+	storage.SlowQuery = cfg.Log.SlowQuery
+	services := service.New(storage)
+	services.Retry = service.RetryPolicy(cfg.Retry)
+
+	server := grpcserver.NewGRPCServer(services, cfg.Server.RequestTimeout)
+	if cfg.Features.GRPCReflection {
+		reflection.Register(server)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var redisClient *redis.Client
+	if cfg.Server.ReadyRedis {
+		redisAddr, err := cfg.Redis.Address()
+		if err != nil {
+			return err
+		}
+		redisClient = redis.NewClient(&redis.Options{Addr: redisAddr, Password: cfg.Redis.Pass, DB: cfg.Redis.DB})
+		defer redisClient.Close()
+	}
+
+	// standard grpc health service for grpc probes, http probes are next to metrics
+	healthServer := grpchealth.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+
+	mux := http.NewServeMux()
+	health.New(storage, redisClient).Register(mux, cfg.Features.Diagnostics)
+	if cfg.Features.Metrics {
+		mux.Handle("GET /metrics", metrics.Handler(metrics.NewRegistry(storage)))
+	}
+	metricsServer := &http.Server{Addr: cfg.Server.MetricsAddr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		slog.Info("http listening", "addr", cfg.Server.MetricsAddr)
+		err := metricsServer.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("http server stopped", "error", err)
+		}
+	}()
+	defer metricsServer.Close()
+
+	go func() {
+		<-ctx.Done()
+		// new calls are refused while the running ones finish
+		healthServer.Shutdown()
+		server.GracefulStop()
+	}()
+
+	slog.Info("grpc listening", "addr", listener.Addr().String())
+	return server.Serve(listener)
+}
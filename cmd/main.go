@@ -1,7 +1,276 @@
 package main
 
-import "fmt"
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"github.com/tredoc/go-balances/internal/config"
+	"github.com/tredoc/go-balances/internal/logging"
+	"github.com/tredoc/go-balances/internal/outbox"
+	"github.com/tredoc/go-balances/internal/service"
+	"github.com/tredoc/go-balances/internal/store"
+	"github.com/tredoc/go-balances/internal/tracing"
+	"github.com/tredoc/go-balances/internal/webhook"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const usage = `usage: go-balances <command> [flags]
+
+commands:
+  import        apply deposits and payouts from csv file
+  audit verify  check that audit log records were not changed or removed
+  relay         publish balance events from the outbox to stdout, webhook, webhooks or redis stream
+  webhooks      manage webhook subscriptions, deliver and replay notifications
+  serve         http server with the stream of live balance updates
+  config        print the effective config, secrets are redacted
+  migrate       apply or roll back embedded migrations, print schema status
+  stress        run a random concurrent workload on fresh balances and check ledger invariants
+`
 
 func main() {
-	fmt.Println("Check tests!")
+	if len(os.Args) < 2 {
+		fmt.Print(usage)
+		os.Exit(2)
+	}
+
+	cfg, err := config.Load("")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	slog.SetDefault(logging.New(cfg.Log, os.Stderr))
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	switch os.Args[1] {
+	case "import":
+		err = runImport(cfg, os.Args[2:])
+	case "audit":
+		err = runAudit(cfg, os.Args[2:])
+	case "relay":
+		err = runRelay(cfg, os.Args[2:])
+	case "webhooks":
+		err = runWebhooks(cfg, os.Args[2:])
+	case "serve":
+		err = runServe(cfg, os.Args[2:])
+	case "config":
+		fmt.Print(cfg.Redacted())
+	case "migrate":
+		err = runMigrate(cfg, os.Args[2:])
+	case "stress":
+		err = runStress(cfg, os.Args[2:])
+	default:
+		fmt.Print(usage)
+		os.Exit(2)
+	}
+
+	// flush spans before exit
+	err = errors.Join(err, shutdownTracing(context.Background()))
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// newStore creates store that logs queries slower than the config allows
+func newStore(cfg config.Config, conn *sql.DB) *store.Store {
+	storage := store.New(conn)
+	storage.SlowQuery = cfg.Log.SlowQuery
+	return storage
+}
+
+// newServices creates services with the retry policy of the config
+func newServices(cfg config.Config, conn *sql.DB) *service.Service {
+	services := service.New(newStore(cfg, conn))
+	services.Retry = service.RetryPolicy(cfg.Retry)
+	return services
+}
+
+func runImport(cfg config.Config, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	file := flags.String("file", "", "csv file with balance_id,username,currency,amount,reference columns")
+	chunk := flags.Int("chunk", service.DefaultImportChunk, "number of lines applied in one transaction")
+	report := flags.String("report", "", "path of the result report, stdout by default")
+	flags.Parse(args)
+
+	if *file == "" {
+		flags.Usage()
+		return errors.New("file is required")
+	}
+
+	in, err := os.Open(*file)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	conn, err := store.Open(cfg.DB)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	services := newServices(cfg, conn)
+
+	results, err := services.ImportCSV(context.Background(), in, *chunk)
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if *report != "" {
+		out, err = os.Create(*report)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+
+	err = writeImportReport(out, results)
+	if err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		if result.Status == service.ImportFailed {
+			return errors.New("some lines failed, check the report")
+		}
+	}
+
+	return nil
+}
+
+func writeImportReport(w io.Writer, results []service.ImportResult) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"line", "reference", "balance_id", "entry_id", "status", "error"})
+
+	for _, result := range results {
+		var errText string
+		if result.Err != nil {
+			errText = result.Err.Error()
+		}
+
+		writer.Write([]string{
+			strconv.Itoa(result.Line),
+			result.Reference,
+			strconv.FormatUint(result.BalanceID, 10),
+			strconv.FormatUint(result.EntryID, 10),
+			result.Status,
+			errText,
+		})
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func runAudit(cfg config.Config, args []string) error {
+	if len(args) == 0 || args[0] != "verify" {
+		fmt.Print(usage)
+		os.Exit(2)
+	}
+
+	conn, err := store.Open(cfg.DB)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	services := newServices(cfg, conn)
+
+	verified, err := services.VerifyAudit(context.Background())
+	if err != nil {
+		return fmt.Errorf("audit log is broken after %d valid records: %w", verified, err)
+	}
+
+	fmt.Printf("audit log is valid, %d records verified\n", verified)
+	return nil
+}
+
+func runRelay(cfg config.Config, args []string) error {
+	flags := flag.NewFlagSet("relay", flag.ExitOnError)
+	sinkName := flags.String("sink", "stdout", "where to publish events: stdout, webhook, webhooks or redis")
+	url := flags.String("url", "", "webhook url")
+	redisAddr := flags.String("redis-addr", "", "redis address, REDIS_HOST by default")
+	stream := flags.String("stream", cfg.Redis.Stream, "redis stream name")
+	batch := flags.Int("batch", outbox.DefaultBatchSize, "number of events claimed and published at once")
+	interval := flags.Duration("interval", outbox.DefaultInterval, "pause between polls when there are no events")
+	retryDelay := flags.Duration("retry-delay", outbox.DefaultBaseDelay, "delay before the first retry of a failed event, it doubles after every failure")
+	maxRetryDelay := flags.Duration("max-retry-delay", outbox.DefaultMaxDelay, "longest delay between retries of a failed event")
+	claim := flags.Duration("claim", outbox.DefaultClaim, "how long other relays skip a batch that is being published")
+	once := flags.Bool("once", false, "publish pending events and exit")
+	flags.Parse(args)
+
+	conn, err := store.Open(cfg.DB)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	storage := newStore(cfg, conn)
+
+	var sink outbox.Sink
+	switch *sinkName {
+	case "stdout":
+		sink = outbox.NewWriterSink(os.Stdout)
+	case "webhook":
+		if *url == "" {
+			return errors.New("url is required for webhook sink")
+		}
+		sink = outbox.NewWebhookSink(*url, &http.Client{Timeout: 10 * time.Second})
+	case "webhooks":
+		if !cfg.Features.Webhooks {
+			return errors.New("webhooks are disabled by FEATURE_WEBHOOKS")
+		}
+		sink = webhook.New(storage, &http.Client{Timeout: 10 * time.Second})
+	case "redis":
+		addr := *redisAddr
+		if addr == "" {
+			addr, err = cfg.Redis.Address()
+			if err != nil {
+				return err
+			}
+		}
+		client := redis.NewClient(&redis.Options{Addr: addr, Password: cfg.Redis.Pass, DB: cfg.Redis.DB})
+		defer client.Close()
+		sink = outbox.NewRedisSink(client, *stream)
+	default:
+		flags.Usage()
+		return fmt.Errorf("unknown sink %q", *sinkName)
+	}
+
+	relay := outbox.NewRelay(storage, sink)
+	relay.BatchSize = *batch
+	relay.Interval = *interval
+	relay.BaseDelay = *retryDelay
+	relay.MaxDelay = *maxRetryDelay
+	relay.Claim = *claim
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *once {
+		for {
+			delivered, err := relay.RelayOnce(ctx)
+			if err != nil || delivered == 0 {
+				return err
+			}
+		}
+	}
+
+	return relay.Run(ctx)
 }
@@ -1,81 +1,111 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	ledgerv1 "github.com/tredoc/go-balances/api/ledger/v1"
+	db "github.com/tredoc/go-balances/db/sqlc"
+	"github.com/tredoc/go-balances/internal/config"
+	"github.com/tredoc/go-balances/internal/grpcserver"
+	"github.com/tredoc/go-balances/internal/health"
+	"github.com/tredoc/go-balances/internal/linearize"
+	"github.com/tredoc/go-balances/internal/logging"
+	"github.com/tredoc/go-balances/internal/metrics"
+	"github.com/tredoc/go-balances/internal/money"
+	"github.com/tredoc/go-balances/internal/outbox"
+	"github.com/tredoc/go-balances/internal/schema"
 	"github.com/tredoc/go-balances/internal/service"
 	"github.com/tredoc/go-balances/internal/store"
-	"log"
-	"os"
+	"github.com/tredoc/go-balances/internal/stream"
+	"github.com/tredoc/go-balances/internal/stress"
+	"github.com/tredoc/go-balances/internal/testdb"
+	"github.com/tredoc/go-balances/internal/webhook"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/reflection"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
-var conn *sql.DB
-var services *service.Service
-var storage *store.Store
+var ctx = context.Background()
 
-func TestMain(m *testing.M) {
-	user := os.Getenv("DB_USER")
-	pass := os.Getenv("DB_PASS")
-	host := os.Getenv("DB_HOST")
-	port := os.Getenv("DB_PORT")
-	name := os.Getenv("DB_NAME")
-
-	if user == "" || pass == "" || host == "" || port == "" || name == "" {
-		log.Fatal("missing environment variables")
-	}
-
-	var err error
-	conn, err = sql.Open("mysql", fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", user, pass, host, port, name))
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer conn.Close()
-
-	err = conn.Ping()
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	storage = store.New(conn)
-	services = service.New(storage)
+// newLedger gives the test a database of its own and the service on it, so tests run in parallel
+// and don't depend on changes of each other
+func newLedger(t testing.TB) (*sql.DB, *store.Store, *service.Service) {
+	t.Helper()
+	database := testdb.New(t)
+	storage := store.New(database.Conn)
+	return database.Conn, storage, service.New(storage)
+}
 
-	m.Run()
+// amountOf pairs amount in minor units with the currency of the balance
+func amountOf(t *testing.T, services *service.Service, balanceID uint64, amount int64) money.Money {
+	balance, err := services.GetBalanceById(ctx, balanceID)
+	assert.Nil(t, err)
+	return money.New(amount, balance.Currency)
 }
 
 func TestStore(t *testing.T) {
+	t.Parallel()
+	_, storage, _ := newLedger(t)
+
 	t.Run("Test store creation", func(t *testing.T) {
 		assert.NotEqual(t, storage, &store.Store{})
 	})
 }
 
 func TestService(t *testing.T) {
+	t.Parallel()
+	_, _, services := newLedger(t)
+
 	t.Run("Test service creation", func(t *testing.T) {
 		assert.NotEqual(t, services, &service.Service{})
 	})
 }
 
 func TestDeposit(t *testing.T) {
+	t.Parallel()
+	_, _, services := newLedger(t)
+
 	t.Run("Test single deposit", func(t *testing.T) {
-		balanceID := uint64(1)
-		balance, err := services.GetBalanceById(balanceID)
-		assert.Nil(t, err)
+		balance := testdb.Balance(t, services, "USD", 100)
 
 		amount := int64(100)
-		balanceUPD, err := services.Deposit(balanceID, amount)
+		balanceUPD, err := services.Deposit(ctx, balance.ID, amountOf(t, services, balance.ID, amount))
 		assert.Nil(t, err)
 		assert.Equal(t, balanceUPD.Amount, balance.Amount+amount)
 	})
 
 	t.Run("Test concurrent deposit", func(t *testing.T) {
-		balanceID := uint64(4)
-		balance, err := services.GetBalanceById(balanceID)
-		assert.Nil(t, err)
+		balance := testdb.Balance(t, services, "USDT", 300)
 
-		lastEntryID, err := services.GetLastEntryID()
+		lastEntryID, err := services.GetLastEntryID(ctx)
 		assert.Nil(t, err)
 
 		amount := int64(5)
@@ -86,51 +116,50 @@ func TestDeposit(t *testing.T) {
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
-				_, err := services.Deposit(balanceID, amount)
+				_, err := services.Deposit(ctx, balance.ID, amountOf(t, services, balance.ID, amount))
 				assert.Nil(t, err)
 			}()
 		}
 		wg.Wait()
 
-		lastEntryIDNew, err := services.GetLastEntryID()
+		lastEntryIDNew, err := services.GetLastEntryID(ctx)
 		assert.Nil(t, err)
 		assert.Equal(t, lastEntryIDNew, lastEntryID+uint64(times))
 
-		balanceUPD, err := services.GetBalanceById(balanceID)
+		balanceUPD, err := services.GetBalanceById(ctx, balance.ID)
 		assert.Nil(t, err)
 		assert.Equal(t, balanceUPD.Amount, balance.Amount+amount*int64(times))
 	})
 }
 
 func TestWithdraw(t *testing.T) {
+	t.Parallel()
+	_, _, services := newLedger(t)
+
 	t.Run("Test single successful withdraw", func(t *testing.T) {
-		balanceID := uint64(1)
-		balance, err := services.GetBalanceById(balanceID)
-		assert.Nil(t, err)
+		balance := testdb.Balance(t, services, "USD", 100)
 
 		amount := int64(100)
-		balanceUPD, err := services.Withdraw(balanceID, amount)
+		balanceUPD, err := services.Withdraw(ctx, balance.ID, amountOf(t, services, balance.ID, amount))
 		assert.Nil(t, err)
 		assert.Equal(t, balanceUPD.Amount, balance.Amount-amount)
 
 		amount = int64(1<<63 - 1)
-		_, err = services.Withdraw(1, amount)
+		_, err = services.Withdraw(ctx, balance.ID, amountOf(t, services, balance.ID, amount))
 		assert.Error(t, err)
 	})
 
 	t.Run("Test single overbalance withdraw", func(t *testing.T) {
-		balanceID := uint64(1)
+		balance := testdb.Balance(t, services, "USD", 100)
 		amount := int64(1<<63 - 1)
-		_, err := services.Withdraw(balanceID, amount)
+		_, err := services.Withdraw(ctx, balance.ID, amountOf(t, services, balance.ID, amount))
 		assert.Error(t, err)
 	})
 
 	t.Run("Test concurrent withdraw", func(t *testing.T) {
-		balanceID := uint64(6)
-		balance, err := services.GetBalanceById(balanceID)
-		assert.Nil(t, err)
+		balance := testdb.Balance(t, services, "EUR", 1000)
 
-		lastEntryID, err := services.GetLastEntryID()
+		lastEntryID, err := services.GetLastEntryID(ctx)
 		assert.Nil(t, err)
 
 		amount := int64(5)
@@ -141,63 +170,65 @@ func TestWithdraw(t *testing.T) {
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
-				_, err := services.Withdraw(balanceID, amount)
+				_, err := services.Withdraw(ctx, balance.ID, amountOf(t, services, balance.ID, amount))
 				assert.Nil(t, err)
 			}()
 		}
 		wg.Wait()
 
-		lastEntryIDNew, err := services.GetLastEntryID()
+		lastEntryIDNew, err := services.GetLastEntryID(ctx)
 		assert.Nil(t, err)
 		assert.Equal(t, lastEntryIDNew, lastEntryID+uint64(times))
 
-		balanceUPD, err := services.GetBalanceById(balanceID)
+		balanceUPD, err := services.GetBalanceById(ctx, balance.ID)
 		assert.Nil(t, err)
 		assert.Equal(t, balanceUPD.Amount, balance.Amount-amount*int64(times))
 	})
 }
 
 func TestTransferOneWay(t *testing.T) {
-	t.Run("Test single transfer", func(t *testing.T) {
-		balanceFromID := uint64(2)
-		balanceToID := uint64(10)
-
-		balanceFrom, err := services.GetBalanceById(balanceFromID)
-		assert.Nil(t, err)
+	t.Parallel()
+	_, _, services := newLedger(t)
 
-		balanceTo, err := services.GetBalanceById(balanceToID)
-		assert.Nil(t, err)
+	t.Run("Test single transfer", func(t *testing.T) {
+		balanceFrom := testdb.Balance(t, services, "EUR", 1000)
+		balanceTo := testdb.Balance(t, services, "EUR", 1000)
 
 		amount := int64(10)
-		balanceFromUPD, balanceToUPD, err := services.Transfer(balanceFromID, balanceToID, amount)
+		balanceFromUPD, balanceToUPD, err := services.Transfer(ctx, balanceFrom.ID, balanceTo.ID, amountOf(t, services, balanceFrom.ID, amount))
 		assert.Nil(t, err)
 		assert.Equal(t, balanceFromUPD.Amount, balanceFrom.Amount-amount)
 		assert.Equal(t, balanceToUPD.Amount, balanceTo.Amount+amount)
 
 		amount = int64(1<<63 - 1)
-		_, _, err = services.Transfer(balanceFromID, balanceToID, amount)
+		_, _, err = services.Transfer(ctx, balanceFrom.ID, balanceTo.ID, amountOf(t, services, balanceFrom.ID, amount))
 		assert.Error(t, err)
 	})
 
 	t.Run("Test single overbalance transfer", func(t *testing.T) {
-		balanceFromID := uint64(3)
-		balanceToID := uint64(7)
+		balanceFrom := testdb.Balance(t, services, "UAH", 0)
+		balanceTo := testdb.Balance(t, services, "UAH", 0)
 		amount := int64(1<<63 - 1)
-		_, _, err := services.Transfer(balanceFromID, balanceToID, amount)
+		_, _, err := services.Transfer(ctx, balanceFrom.ID, balanceTo.ID, amountOf(t, services, balanceFrom.ID, amount))
 		assert.Error(t, err)
 	})
 
-	t.Run("Test concurrent transfer in one direction", func(t *testing.T) {
-		balanceFromID := uint64(2)
-		balanceToID := uint64(10)
+	t.Run("Test transfer to the same balance", func(t *testing.T) {
+		balance := testdb.Balance(t, services, "EUR", 1000)
 
-		balanceFrom, err := services.GetBalanceById(balanceFromID)
-		assert.Nil(t, err)
+		_, _, err := services.Transfer(ctx, balance.ID, balance.ID, amountOf(t, services, balance.ID, 10))
+		assert.ErrorIs(t, err, service.ErrSameBalance)
 
-		balanceTo, err := services.GetBalanceById(balanceToID)
+		balanceUPD, err := services.GetBalanceById(ctx, balance.ID)
 		assert.Nil(t, err)
+		assert.Equal(t, balanceUPD.Amount, balance.Amount)
+	})
+
+	t.Run("Test concurrent transfer in one direction", func(t *testing.T) {
+		balanceFrom := testdb.Balance(t, services, "EUR", 1000)
+		balanceTo := testdb.Balance(t, services, "EUR", 1000)
 
-		lastTransferID, err := services.GetLastTransferID()
+		lastTransferID, err := services.GetLastTransferID(ctx)
 		assert.Nil(t, err)
 
 		amount := int64(5)
@@ -208,56 +239,53 @@ func TestTransferOneWay(t *testing.T) {
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
-				_, _, err := services.Transfer(balanceFromID, balanceToID, amount)
+				_, _, err := services.Transfer(ctx, balanceFrom.ID, balanceTo.ID, amountOf(t, services, balanceFrom.ID, amount))
 				assert.Nil(t, err)
 			}()
 		}
 		wg.Wait()
 
-		balanceFromUPD, err := services.GetBalanceById(balanceFromID)
+		balanceFromUPD, err := services.GetBalanceById(ctx, balanceFrom.ID)
 		assert.Nil(t, err)
 		assert.Equal(t, balanceFromUPD.Amount, balanceFrom.Amount-amount*int64(times))
 
-		balanceToUPD, err := services.GetBalanceById(balanceToID)
+		balanceToUPD, err := services.GetBalanceById(ctx, balanceTo.ID)
 		assert.Nil(t, err)
 		assert.Equal(t, balanceToUPD.Amount, balanceTo.Amount+amount*int64(times))
 
-		lastTransferIDNew, err := services.GetLastTransferID()
+		lastTransferIDNew, err := services.GetLastTransferID(ctx)
 		assert.Nil(t, err)
 		assert.Equal(t, lastTransferIDNew, lastTransferID+uint64(times))
 	})
 }
 
 func TestTransferContrary(t *testing.T) {
-	t.Run("Test single contrary transfer", func(t *testing.T) {
-		balanceFromID := uint64(6)
-		balanceToID := uint64(10)
-
-		balanceFrom, err := services.GetBalanceById(balanceFromID)
-		assert.Nil(t, err)
+	t.Parallel()
+	_, _, services := newLedger(t)
 
-		balanceTo, err := services.GetBalanceById(balanceToID)
-		assert.Nil(t, err)
+	t.Run("Test single contrary transfer", func(t *testing.T) {
+		balanceFrom := testdb.Balance(t, services, "EUR", 1000)
+		balanceTo := testdb.Balance(t, services, "EUR", 1000)
 
 		amount := int64(10)
 		var wg sync.WaitGroup
 		wg.Add(2)
 		go func() {
 			defer wg.Done()
-			_, _, err := services.Transfer(balanceFromID, balanceToID, amount)
+			_, _, err := services.Transfer(ctx, balanceFrom.ID, balanceTo.ID, amountOf(t, services, balanceFrom.ID, amount))
 			assert.Nil(t, err)
 		}()
 		go func() {
 			defer wg.Done()
-			_, _, err := services.Transfer(balanceToID, balanceFromID, amount)
+			_, _, err := services.Transfer(ctx, balanceTo.ID, balanceFrom.ID, amountOf(t, services, balanceTo.ID, amount))
 			assert.Nil(t, err)
 		}()
 		wg.Wait()
 
-		balanceFromUPD, err := services.GetBalanceById(balanceFromID)
+		balanceFromUPD, err := services.GetBalanceById(ctx, balanceFrom.ID)
 		assert.Nil(t, err)
 
-		balanceToUPD, err := services.GetBalanceById(balanceToID)
+		balanceToUPD, err := services.GetBalanceById(ctx, balanceTo.ID)
 		assert.Nil(t, err)
 
 		assert.Equal(t, balanceFromUPD.Amount, balanceFrom.Amount)
@@ -266,40 +294,2631 @@ func TestTransferContrary(t *testing.T) {
 	})
 
 	t.Run("Test concurrent contrary transfer", func(t *testing.T) {
-		balanceFromID := uint64(6)
-		balanceToID := uint64(10)
+		balanceFrom := testdb.Balance(t, services, "EUR", 1000)
+		balanceTo := testdb.Balance(t, services, "EUR", 1000)
+
+		amount := int64(10)
+		var wg sync.WaitGroup
+		times := 4
+		wg.Add(2 * times)
+		for i := 0; i < times; i++ {
+			go func() {
+				defer wg.Done()
+				_, _, err := services.Transfer(ctx, balanceFrom.ID, balanceTo.ID, amountOf(t, services, balanceFrom.ID, amount))
+				assert.Nil(t, err)
+			}()
+			go func() {
+				defer wg.Done()
+				_, _, err := services.Transfer(ctx, balanceTo.ID, balanceFrom.ID, amountOf(t, services, balanceTo.ID, amount))
+				assert.Nil(t, err)
+			}()
+		}
+		wg.Wait()
+
+		balanceFromUPD, err := services.GetBalanceById(ctx, balanceFrom.ID)
+		assert.Nil(t, err)
+
+		balanceToUPD, err := services.GetBalanceById(ctx, balanceTo.ID)
+		assert.Nil(t, err)
+
+		assert.Equal(t, balanceFromUPD.Amount, balanceFrom.Amount)
+		assert.Equal(t, balanceToUPD.Amount, balanceTo.Amount)
+	})
+}
+
+func TestHolds(t *testing.T) {
+	t.Parallel()
+	_, _, services := newLedger(t)
+
+	t.Run("Test authorize and capture", func(t *testing.T) {
+		balance := testdb.Balance(t, services, "USDT", 600)
+
+		amount := int64(50)
+		hold, err := services.Authorize(ctx, balance.ID, amountOf(t, services, balance.ID, amount), time.Minute)
+		assert.Nil(t, err)
+		assert.Equal(t, hold.Status, service.HoldPending)
+
+		balanceHeld, err := services.GetBalanceById(ctx, balance.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, balanceHeld.Amount, balance.Amount)
+		assert.Equal(t, balanceHeld.HeldAmount, balance.HeldAmount+amount)
 
-		balanceFrom, err := services.GetBalanceById(balanceFromID)
+		balanceUPD, err := services.Capture(ctx, hold.ID, amountOf(t, services, balance.ID, amount-10))
 		assert.Nil(t, err)
+		assert.Equal(t, balanceUPD.Amount, balance.Amount-amount+10)
+		assert.Equal(t, balanceUPD.HeldAmount, balance.HeldAmount)
 
-		balanceTo, err := services.GetBalanceById(balanceToID)
+		_, err = services.Capture(ctx, hold.ID, amountOf(t, services, balance.ID, amount))
+		assert.ErrorIs(t, err, service.ErrHoldNotPending)
+	})
+
+	t.Run("Test authorize and void", func(t *testing.T) {
+		balance := testdb.Balance(t, services, "USDT", 600)
+
+		hold, err := services.Authorize(ctx, balance.ID, amountOf(t, services, balance.ID, 10), time.Minute)
+		assert.Nil(t, err)
+
+		balanceUPD, err := services.Void(ctx, hold.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, balanceUPD.Amount, balance.Amount)
+		assert.Equal(t, balanceUPD.HeldAmount, balance.HeldAmount)
+
+		_, err = services.Void(ctx, hold.ID)
+		assert.ErrorIs(t, err, service.ErrHoldNotPending)
+	})
+
+	t.Run("Test hold blocks withdraw and transfer", func(t *testing.T) {
+		balance := testdb.Balance(t, services, "USD", 100)
+		other := testdb.Balance(t, services, "USD", 0)
+
+		hold, err := services.Authorize(ctx, balance.ID, amountOf(t, services, balance.ID, balance.Amount-balance.HeldAmount), time.Minute)
+		assert.Nil(t, err)
+
+		_, err = services.Withdraw(ctx, balance.ID, amountOf(t, services, balance.ID, 1))
+		assert.Error(t, err)
+
+		_, _, err = services.Transfer(ctx, balance.ID, other.ID, amountOf(t, services, balance.ID, 1))
+		assert.Error(t, err)
+
+		_, err = services.Void(ctx, hold.ID)
 		assert.Nil(t, err)
+	})
+
+	t.Run("Test hold expiration", func(t *testing.T) {
+		balance := testdb.Balance(t, services, "USD", 100)
+
+		hold, err := services.Authorize(ctx, balance.ID, amountOf(t, services, balance.ID, 10), time.Second)
+		assert.Nil(t, err)
+
+		time.Sleep(2 * time.Second)
+
+		_, err = services.Capture(ctx, hold.ID, amountOf(t, services, balance.ID, 10))
+		assert.ErrorIs(t, err, service.ErrHoldExpired)
+
+		holdUPD, err := services.GetHoldById(ctx, hold.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, holdUPD.Status, service.HoldExpired)
+
+		balanceUPD, err := services.GetBalanceById(ctx, balance.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, balanceUPD.HeldAmount, balance.HeldAmount)
+	})
+
+	t.Run("Test concurrent authorize", func(t *testing.T) {
+		balance := testdb.Balance(t, services, "USD", 100)
 
 		amount := int64(10)
+		times := 20
+		ok := make(chan uint64, times)
+
+		var wg sync.WaitGroup
+		for i := 0; i < times; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				hold, err := services.Authorize(ctx, balance.ID, amountOf(t, services, balance.ID, amount), time.Minute)
+				if err == nil {
+					ok <- hold.ID
+				}
+			}()
+		}
+		wg.Wait()
+		close(ok)
+
+		var held int64
+		for holdID := range ok {
+			held += amount
+			_, err := services.Void(ctx, holdID)
+			assert.Nil(t, err)
+		}
+		assert.LessOrEqual(t, held, balance.Amount-balance.HeldAmount)
+	})
+}
+
+func TestPolicies(t *testing.T) {
+	t.Parallel()
+	_, _, services := newLedger(t)
+
+	t.Run("Test default policy", func(t *testing.T) {
+		balance := testdb.Balance(t, services, "USD", 100)
+
+		policy, err := services.GetBalancePolicy(ctx, balance.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, policy, db.BalancePolicy{BalanceID: balance.ID})
+
+		_, err = services.Withdraw(ctx, balance.ID, amountOf(t, services, balance.ID, int64(1<<63-1)))
+		assert.ErrorIs(t, err, service.ErrInsufficientFunds)
+	})
+
+	t.Run("Test overdraft limit", func(t *testing.T) {
+		balance := testdb.Balance(t, services, "UAH", 0)
+		err := services.SetBalancePolicy(ctx, db.BalancePolicy{BalanceID: balance.ID, OverdraftLimit: 100})
+		assert.Nil(t, err)
+
+		amount := balance.Amount - balance.HeldAmount + 50
+		balanceUPD, err := services.Withdraw(ctx, balance.ID, amountOf(t, services, balance.ID, amount))
+		assert.Nil(t, err)
+		assert.Equal(t, balanceUPD.Amount, balance.Amount-amount)
+
+		_, err = services.Withdraw(ctx, balance.ID, amountOf(t, services, balance.ID, 100))
+		assert.ErrorIs(t, err, service.ErrOverdraftLimit)
+
+		var policyErr *service.PolicyError
+		assert.ErrorAs(t, err, &policyErr)
+		assert.Equal(t, policyErr.BalanceID, balance.ID)
+	})
+
+	t.Run("Test min balance", func(t *testing.T) {
+		balance := testdb.Balance(t, services, "USD", 100)
+		other := testdb.Balance(t, services, "USD", 0)
+
+		err := services.SetBalancePolicy(ctx, db.BalancePolicy{BalanceID: balance.ID, MinBalance: balance.Amount - balance.HeldAmount})
+		assert.Nil(t, err)
+
+		_, err = services.Withdraw(ctx, balance.ID, amountOf(t, services, balance.ID, 1))
+		assert.ErrorIs(t, err, service.ErrMinBalance)
+
+		_, _, err = services.Transfer(ctx, balance.ID, other.ID, amountOf(t, services, balance.ID, 1))
+		assert.ErrorIs(t, err, service.ErrMinBalance)
+	})
+
+	t.Run("Test max transaction amount", func(t *testing.T) {
+		balance := testdb.Balance(t, services, "UAH", 0)
+		err := services.SetBalancePolicy(ctx, db.BalancePolicy{BalanceID: balance.ID, MaxTransactionAmount: 10})
+		assert.Nil(t, err)
+
+		_, err = services.Deposit(ctx, balance.ID, amountOf(t, services, balance.ID, 11))
+		assert.ErrorIs(t, err, service.ErrMaxTransactionAmount)
+
+		_, err = services.Deposit(ctx, balance.ID, amountOf(t, services, balance.ID, 10))
+		assert.Nil(t, err)
+	})
+
+	t.Run("Test invalid policy", func(t *testing.T) {
+		balance := testdb.Balance(t, services, "USD", 0)
+		err := services.SetBalancePolicy(ctx, db.BalancePolicy{BalanceID: balance.ID, OverdraftLimit: -1})
+		assert.ErrorIs(t, err, service.ErrInvalidPolicy)
+	})
+}
+
+func TestBalanceStatus(t *testing.T) {
+	t.Parallel()
+	_, _, services := newLedger(t)
+
+	t.Run("Test freeze debit", func(t *testing.T) {
+		frozen := testdb.Balance(t, services, "UAH", 0)
+		other := testdb.Balance(t, services, "UAH", 0)
+
+		balance, err := services.FreezeBalance(ctx, frozen.ID, true, "tester", "suspicious activity")
+		assert.Nil(t, err)
+		assert.Equal(t, balance.Status, service.StatusFrozenDebit)
+
+		_, err = services.Deposit(ctx, frozen.ID, amountOf(t, services, frozen.ID, 1))
+		assert.Nil(t, err)
+
+		_, err = services.Withdraw(ctx, frozen.ID, amountOf(t, services, frozen.ID, 1))
+		assert.ErrorIs(t, err, service.ErrBalanceFrozen)
+
+		_, _, err = services.Transfer(ctx, frozen.ID, other.ID, amountOf(t, services, frozen.ID, 1))
+		assert.ErrorIs(t, err, service.ErrBalanceFrozen)
+	})
+
+	t.Run("Test freeze all", func(t *testing.T) {
+		frozen := testdb.Balance(t, services, "UAH", 0)
+		other := testdb.Balance(t, services, "UAH", 1)
+
+		_, err := services.FreezeBalance(ctx, frozen.ID, false, "tester", "compromised")
+		assert.Nil(t, err)
+
+		_, err = services.Deposit(ctx, frozen.ID, amountOf(t, services, frozen.ID, 1))
+		assert.ErrorIs(t, err, service.ErrBalanceFrozen)
+
+		_, _, err = services.Transfer(ctx, other.ID, frozen.ID, amountOf(t, services, other.ID, 1))
+		assert.ErrorIs(t, err, service.ErrBalanceFrozen)
+
+		_, err = services.Authorize(ctx, frozen.ID, amountOf(t, services, frozen.ID, 1), time.Minute)
+		assert.ErrorIs(t, err, service.ErrBalanceFrozen)
+
+		balance, err := services.UnfreezeBalance(ctx, frozen.ID, "tester", "restored")
+		assert.Nil(t, err)
+		assert.Equal(t, balance.Status, service.StatusActive)
+
+		changes, err := services.GetBalanceStatusChanges(ctx, frozen.ID)
+		assert.Nil(t, err)
+		assert.Len(t, changes, 2)
+
+		last := changes[len(changes)-1]
+		assert.Equal(t, last.FromStatus, service.StatusFrozenAll)
+		assert.Equal(t, last.ToStatus, service.StatusActive)
+		assert.Equal(t, last.Actor, "tester")
+		assert.Equal(t, last.Reason, "restored")
+	})
+
+	t.Run("Test invalid status changes", func(t *testing.T) {
+		balance := testdb.Balance(t, services, "USD", 0)
+
+		_, err := services.UnfreezeBalance(ctx, balance.ID, "tester", "already active")
+		assert.ErrorIs(t, err, service.ErrInvalidTransition)
+
+		_, err = services.SetBalanceStatus(ctx, balance.ID, "unknown", "tester", "typo")
+		assert.ErrorIs(t, err, service.ErrInvalidStatus)
+
+		_, err = services.FreezeBalance(ctx, balance.ID, true, "", "")
+		assert.ErrorIs(t, err, service.ErrMissingActor)
+	})
+
+	t.Run("Test close non empty balance", func(t *testing.T) {
+		balance := testdb.Balance(t, services, "USD", 100)
+
+		_, err := services.CloseBalance(ctx, balance.ID, "tester", "retire")
+		assert.ErrorIs(t, err, service.ErrBalanceNotEmpty)
+	})
+
+	t.Run("Test close balance with pending hold", func(t *testing.T) {
+		balance := testdb.Balance(t, services, "UAH", 0)
+		err := services.SetBalancePolicy(ctx, db.BalancePolicy{BalanceID: balance.ID, OverdraftLimit: 10})
+		assert.Nil(t, err)
+
+		hold, err := services.Authorize(ctx, balance.ID, amountOf(t, services, balance.ID, 5), time.Minute)
+		assert.Nil(t, err)
+
+		_, err = services.CloseBalance(ctx, balance.ID, "tester", "retire")
+		assert.ErrorIs(t, err, service.ErrPendingHolds)
+
+		_, err = services.Void(ctx, hold.ID)
+		assert.Nil(t, err)
+	})
+}
+
+func TestBatchTransfer(t *testing.T) {
+	t.Parallel()
+	_, _, services := newLedger(t)
+
+	t.Run("Test single batch transfer", func(t *testing.T) {
+		first := testdb.Balance(t, services, "EUR", 1000)
+		second := testdb.Balance(t, services, "EUR", 1000)
+		third := testdb.Balance(t, services, "EUR", 1000)
+
+		legs := []service.TransferLeg{
+			{FromID: first.ID, ToID: second.ID, Amount: amountOf(t, services, first.ID, 10)},
+			{FromID: first.ID, ToID: third.ID, Amount: amountOf(t, services, first.ID, 5)},
+		}
+		batchID, balances, err := services.BatchTransfer(ctx, legs)
+		assert.Nil(t, err)
+		assert.Len(t, balances, 3)
+		assert.Equal(t, balances[0].Amount, first.Amount-15)
+		assert.Equal(t, balances[1].Amount, second.Amount+10)
+		assert.Equal(t, balances[2].Amount, third.Amount+5)
+
+		transfers, err := services.GetTransfersByBatchId(ctx, batchID)
+		assert.Nil(t, err)
+		assert.Len(t, transfers, len(legs))
+		for i, transfer := range transfers {
+			assert.Equal(t, transfer.FromBalanceID, legs[i].FromID)
+			assert.Equal(t, transfer.ToBalanceID, legs[i].ToID)
+			assert.Equal(t, transfer.Amount, legs[i].Amount.Amount)
+			assert.Equal(t, uint64(transfer.BatchID.Int64), batchID)
+		}
+
+		entries, err := services.GetEntriesByBatchId(ctx, batchID)
+		assert.Nil(t, err)
+		assert.Len(t, entries, 2*len(legs))
+		for i, leg := range legs {
+			debit, credit := entries[2*i], entries[2*i+1]
+			assert.Equal(t, debit.BalanceID, leg.FromID)
+			assert.Equal(t, debit.Amount, -leg.Amount.Amount)
+			assert.Equal(t, credit.BalanceID, leg.ToID)
+			assert.Equal(t, credit.Amount, leg.Amount.Amount)
+			assert.Equal(t, uint64(debit.BatchID.Int64), batchID)
+			assert.Equal(t, uint64(credit.BatchID.Int64), batchID)
+		}
+
+		discrepancies, err := services.Reconcile(ctx)
+		assert.Nil(t, err)
+		assert.Empty(t, discrepancies)
+	})
+
+	t.Run("Test batch is applied all or nothing", func(t *testing.T) {
+		first := testdb.Balance(t, services, "EUR", 1000)
+		second := testdb.Balance(t, services, "EUR", 1000)
+		third := testdb.Balance(t, services, "EUR", 1000)
+		usd := testdb.Balance(t, services, "USD", 0)
+
+		lastTransferID, err := services.GetLastTransferID(ctx)
+		assert.Nil(t, err)
+
+		_, _, err = services.BatchTransfer(ctx, []service.TransferLeg{
+			{FromID: first.ID, ToID: second.ID, Amount: amountOf(t, services, first.ID, 10)},
+			{FromID: first.ID, ToID: third.ID, Amount: amountOf(t, services, first.ID, int64(1<<62-1))},
+		})
+		assert.ErrorIs(t, err, service.ErrInsufficientFunds)
+
+		_, _, err = services.BatchTransfer(ctx, []service.TransferLeg{
+			{FromID: first.ID, ToID: second.ID, Amount: amountOf(t, services, first.ID, 10)},
+			{FromID: first.ID, ToID: usd.ID, Amount: amountOf(t, services, first.ID, 10)},
+		})
+		assert.ErrorIs(t, err, service.ErrCurrencyMismatch)
+
+		firstUPD, err := services.GetBalanceById(ctx, first.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, firstUPD.Amount, first.Amount)
+
+		lastTransferIDNew, err := services.GetLastTransferID(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, lastTransferIDNew, lastTransferID)
+	})
+
+	t.Run("Test invalid batch", func(t *testing.T) {
+		first := testdb.Balance(t, services, "EUR", 1000)
+		second := testdb.Balance(t, services, "EUR", 1000)
+
+		_, _, err := services.BatchTransfer(ctx, nil)
+		assert.ErrorIs(t, err, service.ErrEmptyBatch)
+
+		_, _, err = services.BatchTransfer(ctx, []service.TransferLeg{{FromID: first.ID, ToID: first.ID, Amount: amountOf(t, services, first.ID, 1)}})
+		assert.ErrorIs(t, err, service.ErrSameBalance)
+
+		_, _, err = services.BatchTransfer(ctx, []service.TransferLeg{{FromID: first.ID, ToID: second.ID, Amount: amountOf(t, services, first.ID, 0)}})
+		assert.ErrorIs(t, err, service.ErrInvalidAmount)
+	})
+
+	t.Run("Test concurrent contrary batch transfer", func(t *testing.T) {
+		first := testdb.Balance(t, services, "EUR", 1000)
+		second := testdb.Balance(t, services, "EUR", 1000)
+		third := testdb.Balance(t, services, "EUR", 1000)
+
 		var wg sync.WaitGroup
 		times := 4
 		wg.Add(2 * times)
 		for i := 0; i < times; i++ {
 			go func() {
 				defer wg.Done()
-				_, _, err := services.Transfer(balanceFromID, balanceToID, amount)
+				_, _, err := services.BatchTransfer(ctx, []service.TransferLeg{
+					{FromID: third.ID, ToID: second.ID, Amount: amountOf(t, services, third.ID, 5)},
+					{FromID: second.ID, ToID: first.ID, Amount: amountOf(t, services, second.ID, 5)},
+				})
 				assert.Nil(t, err)
 			}()
 			go func() {
 				defer wg.Done()
-				_, _, err := services.Transfer(balanceToID, balanceFromID, amount)
+				_, _, err := services.BatchTransfer(ctx, []service.TransferLeg{
+					{FromID: first.ID, ToID: second.ID, Amount: amountOf(t, services, first.ID, 5)},
+					{FromID: second.ID, ToID: third.ID, Amount: amountOf(t, services, second.ID, 5)},
+				})
 				assert.Nil(t, err)
 			}()
 		}
 		wg.Wait()
 
-		balanceFromUPD, err := services.GetBalanceById(balanceFromID)
+		for _, balance := range []*service.Balance{first, second, third} {
+			balanceUPD, err := services.GetBalanceById(ctx, balance.ID)
+			assert.Nil(t, err)
+			assert.Equal(t, balanceUPD.Amount, balance.Amount)
+		}
+	})
+}
+
+func TestImport(t *testing.T) {
+	t.Parallel()
+	_, _, services := newLedger(t)
+
+	t.Run("Test csv import is resumable", func(t *testing.T) {
+		balance1 := testdb.Balance(t, services, "USD", 100)
+		balance2 := testdb.Balance(t, services, "EUR", 1000)
+		user2, err := services.GetUserById(ctx, balance2.UserID)
 		assert.Nil(t, err)
 
-		balanceToUPD, err := services.GetBalanceById(balanceToID)
+		id1 := strconv.FormatUint(balance1.ID, 10)
+		prefix := strconv.FormatInt(time.Now().UnixNano(), 10)
+		file := "balance_id,username,currency,amount,reference\n" +
+			id1 + ",,,0.10," + prefix + "-1\n" +
+			"," + user2.Username + ",EUR,-0.05," + prefix + "-2\n" +
+			id1 + ",,,-92233720368547758.07," + prefix + "-3\n" +
+			id1 + ",,,0.10," + prefix + "-1\n" +
+			"," + user2.Username + ",XXX,10," + prefix + "-4\n" +
+			id1 + ",,,0," + prefix + "-5\n" +
+			id1 + ",,,abc," + prefix + "-6\n" +
+			id1 + ",,,0.001," + prefix + "-7\n"
+
+		results, err := services.ImportCSV(ctx, strings.NewReader(file), 2)
 		assert.Nil(t, err)
+		assert.Len(t, results, 8)
 
-		assert.Equal(t, balanceFromUPD.Amount, balanceFrom.Amount)
-		assert.Equal(t, balanceToUPD.Amount, balanceTo.Amount)
+		statuses := []string{service.ImportApplied, service.ImportApplied, service.ImportFailed, service.ImportFailed, service.ImportFailed, service.ImportFailed, service.ImportFailed, service.ImportFailed}
+		for i, result := range results {
+			assert.Equal(t, result.Line, i+2)
+			assert.Equal(t, result.Status, statuses[i])
+		}
+		assert.ErrorIs(t, results[2].Err, service.ErrInsufficientFunds)
+		assert.ErrorIs(t, results[3].Err, service.ErrDuplicateReference)
+		assert.ErrorIs(t, results[5].Err, service.ErrZeroAmount)
+		assert.ErrorIs(t, results[6].Err, money.ErrInvalidFormat)
+		assert.ErrorIs(t, results[7].Err, money.ErrPrecision)
+		assert.Equal(t, results[1].BalanceID, balance2.ID)
+
+		balance1UPD, err := services.GetBalanceById(ctx, balance1.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, balance1UPD.Amount, balance1.Amount+10)
+
+		balance2UPD, err := services.GetBalanceById(ctx, balance2.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, balance2UPD.Amount, balance2.Amount-5)
+
+		results, err = services.ImportCSV(ctx, strings.NewReader(file), 2)
+		assert.Nil(t, err)
+		assert.Equal(t, results[0].Status, service.ImportSkipped)
+		assert.Equal(t, results[1].Status, service.ImportSkipped)
+
+		balance1UPD, err = services.GetBalanceById(ctx, balance1.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, balance1UPD.Amount, balance1.Amount+10)
+	})
+
+	t.Run("Test invalid csv header", func(t *testing.T) {
+		_, err := services.ImportCSV(ctx, strings.NewReader("id,amount\n1,10\n"), 2)
+		assert.Error(t, err)
+	})
+}
+
+func TestProvisioning(t *testing.T) {
+	t.Parallel()
+	_, _, services := newLedger(t)
+
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	t.Run("Test create user and currency", func(t *testing.T) {
+		user, err := services.CreateUser(ctx, "user_"+suffix)
+		assert.Nil(t, err)
+
+		userGot, err := services.GetUserByUsername(ctx, "user_"+suffix)
+		assert.Nil(t, err)
+		assert.Equal(t, userGot.ID, user.ID)
+
+		_, err = services.CreateUser(ctx, "user_"+suffix)
+		assert.ErrorIs(t, err, service.ErrUserExists)
+
+		var conflictErr *service.ConflictError
+		assert.ErrorAs(t, err, &conflictErr)
+
+		currency, err := services.CreateCurrency(ctx, "T"+suffix[len(suffix)-9:], 2)
+		assert.Nil(t, err)
+
+		_, err = services.CreateCurrency(ctx, currency.Name, 2)
+		assert.ErrorIs(t, err, service.ErrCurrencyExists)
+	})
+
+	t.Run("Test invalid user and currency", func(t *testing.T) {
+		_, err := services.CreateUser(ctx, "a")
+		assert.ErrorIs(t, err, service.ErrInvalidUsername)
+
+		_, err = services.CreateUser(ctx, "user with spaces")
+		assert.ErrorIs(t, err, service.ErrInvalidUsername)
+
+		_, err = services.CreateCurrency(ctx, "usd", 2)
+		assert.ErrorIs(t, err, service.ErrInvalidCurrencyCode)
+
+		_, err = services.CreateCurrency(ctx, "1USD", 2)
+		assert.ErrorIs(t, err, service.ErrInvalidCurrencyCode)
+
+		_, err = services.CreateCurrency(ctx, "XXX", 19)
+		assert.ErrorIs(t, err, service.ErrInvalidExponent)
+	})
+
+	t.Run("Test open balance", func(t *testing.T) {
+		user, err := services.CreateUser(ctx, "open_"+suffix)
+		assert.Nil(t, err)
+
+		balance, err := services.OpenBalance(ctx, user.ID, 1)
+		assert.Nil(t, err)
+		assert.Equal(t, balance.UserID, user.ID)
+		assert.Equal(t, balance.Amount, int64(0))
+		assert.Equal(t, balance.Status, service.StatusActive)
+
+		_, err = services.OpenBalance(ctx, user.ID, 1)
+		assert.ErrorIs(t, err, service.ErrBalanceExists)
+
+		balanceGot, err := services.GetOrOpenBalance(ctx, user.ID, 1)
+		assert.Nil(t, err)
+		assert.Equal(t, balanceGot.ID, balance.ID)
+
+		balanceClosed, err := services.CloseBalance(ctx, balance.ID, "tester", "not needed")
+		assert.Nil(t, err)
+		assert.Equal(t, balanceClosed.Status, service.StatusClosed)
+
+		_, err = services.Deposit(ctx, balance.ID, amountOf(t, services, balance.ID, 1))
+		assert.ErrorIs(t, err, service.ErrBalanceClosed)
+
+		_, err = services.UnfreezeBalance(ctx, balance.ID, "tester", "reopen")
+		assert.ErrorIs(t, err, service.ErrInvalidTransition)
+	})
+
+	t.Run("Test concurrent get or open balance", func(t *testing.T) {
+		user, err := services.CreateUser(ctx, "race_"+suffix)
+		assert.Nil(t, err)
+
+		times := 10
+		ids := make(chan uint64, times)
+
+		var wg sync.WaitGroup
+		for i := 0; i < times; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				balance, err := services.GetOrOpenBalance(ctx, user.ID, 2)
+				assert.Nil(t, err)
+				if err == nil {
+					ids <- balance.ID
+				}
+			}()
+		}
+		wg.Wait()
+		close(ids)
+
+		assert.Len(t, ids, times)
+		first := <-ids
+		for id := range ids {
+			assert.Equal(t, id, first)
+		}
+	})
+
+	t.Run("Test two racing get or open balance get the same balance", func(t *testing.T) {
+		// both calls miss the balance and try to open it, the one that loses the insert reads it back
+		for i := 0; i < 20; i++ {
+			user, err := services.CreateUser(ctx, fmt.Sprintf("pair_%d_%s", i, suffix))
+			assert.Nil(t, err)
+
+			start := make(chan struct{})
+			var balances [2]*service.Balance
+			var errs [2]error
+
+			var wg sync.WaitGroup
+			for j := range balances {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-start
+					balances[j], errs[j] = services.GetOrOpenBalance(ctx, user.ID, 1)
+				}()
+			}
+			close(start)
+			wg.Wait()
+
+			assert.Nil(t, errs[0])
+			assert.Nil(t, errs[1])
+			if errs[0] == nil && errs[1] == nil {
+				assert.Equal(t, balances[0].ID, balances[1].ID)
+			}
+		}
+	})
+}
+
+func TestCurrencyPrecision(t *testing.T) {
+	t.Parallel()
+	_, _, services := newLedger(t)
+
+	t.Run("Test balance amounts as money", func(t *testing.T) {
+		balance := testdb.Balance(t, services, "USDT", 300)
+		assert.Equal(t, balance.Currency, money.Currency{Code: "USDT", Exponent: 6})
+		assert.Equal(t, balance.Total(), money.New(balance.Amount, balance.Currency))
+		assert.Equal(t, balance.Available().Amount, balance.Amount-balance.HeldAmount)
+	})
+
+	t.Run("Test deposit of parsed amount", func(t *testing.T) {
+		balance := testdb.Balance(t, services, "USDT", 300)
+
+		amount, err := money.Parse("0.000001", balance.Currency)
+		assert.Nil(t, err)
+
+		balanceUPD, err := services.Deposit(ctx, balance.ID, amount)
+		assert.Nil(t, err)
+		assert.Equal(t, balanceUPD.Amount, balance.Amount+1)
+	})
+
+	t.Run("Test currency mismatch", func(t *testing.T) {
+		usd := testdb.Balance(t, services, "USD", 100)
+		eurBalance := testdb.Balance(t, services, "EUR", 0)
+
+		eur, err := money.Parse("1.00", money.Currency{Code: "EUR", Exponent: 2})
+		assert.Nil(t, err)
+
+		_, err = services.Deposit(ctx, usd.ID, eur)
+		assert.ErrorIs(t, err, service.ErrCurrencyMismatch)
+
+		// same code with wrong precision is rejected too
+		_, err = services.Deposit(ctx, usd.ID, money.New(100, money.Currency{Code: "USD", Exponent: 3}))
+		assert.ErrorIs(t, err, service.ErrCurrencyMismatch)
+
+		_, _, err = services.Transfer(ctx, usd.ID, eurBalance.ID, amountOf(t, services, usd.ID, 1))
+		assert.ErrorIs(t, err, service.ErrCurrencyMismatch)
+	})
+}
+
+// openBalance provisions a new user with an empty balance, so tests can move amounts near int64 limits
+func openBalance(t testing.TB, services *service.Service, prefix string, currencyID uint64) *service.Balance {
+	user, err := services.CreateUser(ctx, prefix+"_"+strconv.FormatInt(time.Now().UnixNano(), 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	balance, err := services.OpenBalance(ctx, user.ID, currencyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return balance
+}
+
+func TestOverflow(t *testing.T) {
+	t.Parallel()
+	_, _, services := newLedger(t)
+
+	maxAmount := int64(1<<63 - 1)
+
+	full := openBalance(t, services, "full", 1)
+	_, err := services.Deposit(ctx, full.ID, amountOf(t, services, full.ID, maxAmount))
+	assert.Nil(t, err)
+
+	// min balance - overdraft limit is the minimal int64, so debits reach the lower bound too
+	empty := openBalance(t, services, "empty", 1)
+	err = services.SetBalancePolicy(ctx, db.BalancePolicy{BalanceID: empty.ID, OverdraftLimit: maxAmount, MinBalance: -1})
+	assert.Nil(t, err)
+
+	t.Run("Test deposit overflow", func(t *testing.T) {
+		lastEntryID, err := services.GetLastEntryID(ctx)
+		assert.Nil(t, err)
+
+		_, err = services.Deposit(ctx, full.ID, amountOf(t, services, full.ID, 1))
+		assert.ErrorIs(t, err, service.ErrOverflow)
+
+		var policyErr *service.PolicyError
+		assert.ErrorAs(t, err, &policyErr)
+		assert.Equal(t, policyErr.BalanceID, full.ID)
+
+		balance, err := services.GetBalanceById(ctx, full.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, balance.Amount, maxAmount)
+
+		lastEntryIDNew, err := services.GetLastEntryID(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, lastEntryIDNew, lastEntryID)
+	})
+
+	t.Run("Test transfer and batch overflow", func(t *testing.T) {
+		lastTransferID, err := services.GetLastTransferID(ctx)
+		assert.Nil(t, err)
+
+		_, _, err = services.Transfer(ctx, empty.ID, full.ID, amountOf(t, services, empty.ID, 1))
+		assert.ErrorIs(t, err, service.ErrOverflow)
+
+		_, _, err = services.BatchTransfer(ctx, []service.TransferLeg{
+			{FromID: empty.ID, ToID: full.ID, Amount: amountOf(t, services, empty.ID, 1)},
+		})
+		assert.ErrorIs(t, err, service.ErrOverflow)
+
+		lastTransferIDNew, err := services.GetLastTransferID(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, lastTransferIDNew, lastTransferID)
+
+		balance, err := services.GetBalanceById(ctx, empty.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, balance.Amount, int64(0))
+	})
+
+	t.Run("Test withdraw overflow", func(t *testing.T) {
+		_, err := services.Withdraw(ctx, empty.ID, amountOf(t, services, empty.ID, maxAmount))
+		assert.Nil(t, err)
+
+		balance, err := services.Withdraw(ctx, empty.ID, amountOf(t, services, empty.ID, 1))
+		assert.Nil(t, err)
+		assert.Equal(t, balance.Amount, int64(-1<<63))
+
+		_, err = services.Withdraw(ctx, empty.ID, amountOf(t, services, empty.ID, 1))
+		assert.ErrorIs(t, err, service.ErrOverflow)
+
+		balanceGot, err := services.GetBalanceById(ctx, empty.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, balanceGot.Amount, int64(-1<<63))
+	})
+
+	t.Run("Test policy with overflowing floor", func(t *testing.T) {
+		err := services.SetBalancePolicy(ctx, db.BalancePolicy{BalanceID: empty.ID, OverdraftLimit: maxAmount, MinBalance: -2})
+		assert.ErrorIs(t, err, service.ErrInvalidPolicy)
+	})
+}
+
+// FuzzBalanceOperations moves arbitrary amounts between two balances without any floor
+// and checks that every operation either applies exactly or changes nothing
+func FuzzBalanceOperations(f *testing.F) {
+	_, _, services := newLedger(f)
+
+	f.Add(uint8(0), int64(1<<63-1))
+	f.Add(uint8(1), int64(1<<63-1))
+	f.Add(uint8(2), int64(1))
+	f.Add(uint8(3), int64(-1))
+	f.Add(uint8(0), int64(-1<<63))
+
+	first := openBalance(f, services, "fuzz_a", 1)
+	second := openBalance(f, services, "fuzz_b", 1)
+	for _, id := range []uint64{first.ID, second.ID} {
+		err := services.SetBalancePolicy(ctx, db.BalancePolicy{BalanceID: id, OverdraftLimit: 1<<63 - 1, MinBalance: -1})
+		if err != nil {
+			f.Fatal(err)
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, op uint8, amount int64) {
+		before := make(map[uint64]int64)
+		for _, id := range []uint64{first.ID, second.ID} {
+			balance, err := services.GetBalanceById(ctx, id)
+			assert.Nil(t, err)
+			before[id] = balance.Amount
+		}
+
+		value := money.New(amount, first.Currency)
+		delta := map[uint64]int64{}
+		var err error
+		switch op % 4 {
+		case 0:
+			_, err = services.Deposit(ctx, first.ID, value)
+			delta[first.ID] = amount
+		case 1:
+			_, err = services.Withdraw(ctx, first.ID, value)
+			delta[first.ID] = -amount
+		case 2:
+			_, _, err = services.Transfer(ctx, first.ID, second.ID, value)
+			delta[first.ID], delta[second.ID] = -amount, amount
+		case 3:
+			_, _, err = services.Transfer(ctx, second.ID, first.ID, value)
+			delta[first.ID], delta[second.ID] = amount, -amount
+		}
+
+		if amount <= 0 {
+			assert.ErrorIs(t, err, service.ErrInvalidAmount)
+		}
+
+		for _, id := range []uint64{first.ID, second.ID} {
+			balance, errGet := services.GetBalanceById(ctx, id)
+			assert.Nil(t, errGet)
+
+			expected := new(big.Int).Add(big.NewInt(before[id]), big.NewInt(delta[id]))
+			switch {
+			case err != nil:
+				assert.Equal(t, balance.Amount, before[id])
+			case !expected.IsInt64():
+				t.Fatalf("balance %d wrapped around: %d", id, balance.Amount)
+			default:
+				assert.Equal(t, balance.Amount, expected.Int64())
+			}
+		}
+
+		// an operation can be rejected only by validation or by an overflow,
+		// there is no floor on these balances
+		if err != nil && amount > 0 {
+			assert.ErrorIs(t, err, service.ErrOverflow)
+		}
+	})
+}
+
+func TestAudit(t *testing.T) {
+	t.Parallel()
+	conn, storage, services := newLedger(t)
+
+	initiator := service.Initiator{Actor: "auditor", Client: "main_test", RequestID: strconv.FormatInt(time.Now().UnixNano(), 10)}
+	auditCtx := service.WithInitiator(ctx, initiator)
+
+	lastRecord := func(t *testing.T) db.AuditLog {
+		chain, err := storage.GetAuditChain(ctx)
+		assert.Nil(t, err)
+
+		record, err := services.GetAuditRecordById(ctx, chain.LastID)
+		assert.Nil(t, err)
+		return record
+	}
+
+	t.Run("Test applied operation is recorded", func(t *testing.T) {
+		balance := testdb.Balance(t, services, "USD", 100)
+		prev := lastRecord(t)
+
+		_, err := services.Deposit(auditCtx, balance.ID, amountOf(t, services, balance.ID, 150))
+		assert.Nil(t, err)
+
+		record := lastRecord(t)
+		assert.Equal(t, record.Actor, initiator.Actor)
+		assert.Equal(t, record.Client, initiator.Client)
+		assert.Equal(t, record.RequestID, initiator.RequestID)
+		assert.Equal(t, record.Operation, service.AuditDeposit)
+		assert.Equal(t, record.Params, fmt.Sprintf(`{"balance_id":%d,"amount":"1.50","currency":"USD"}`, balance.ID))
+		assert.Equal(t, record.PreAmounts, fmt.Sprintf(`{"%d":%d}`, balance.ID, balance.Amount))
+		assert.Equal(t, record.PostAmounts, fmt.Sprintf(`{"%d":%d}`, balance.ID, balance.Amount+150))
+		assert.Equal(t, record.Outcome, service.AuditApplied)
+		assert.Equal(t, record.PrevHash, prev.Hash)
+	})
+
+	t.Run("Test rejected operation is recorded", func(t *testing.T) {
+		balanceFrom := testdb.Balance(t, services, "UAH", 0)
+		balanceTo := testdb.Balance(t, services, "UAH", 0)
+
+		_, _, err := services.Transfer(auditCtx, balanceFrom.ID, balanceTo.ID, amountOf(t, services, balanceFrom.ID, balanceFrom.Amount+1))
+		assert.ErrorIs(t, err, service.ErrInsufficientFunds)
+
+		record := lastRecord(t)
+		assert.Equal(t, record.Operation, service.AuditTransfer)
+		assert.Equal(t, record.Outcome, service.AuditRejected)
+		assert.Equal(t, record.ErrorMessage, err.Error())
+		pre := fmt.Sprintf(`{"%d":%d,"%d":%d}`, balanceFrom.ID, balanceFrom.Amount, balanceTo.ID, balanceTo.Amount)
+		assert.Equal(t, record.PreAmounts, pre)
+		assert.Equal(t, record.PostAmounts, record.PreAmounts)
+
+		_, err = services.Withdraw(auditCtx, balanceFrom.ID, amountOf(t, services, balanceFrom.ID, 0))
+		assert.ErrorIs(t, err, service.ErrInvalidAmount)
+
+		record = lastRecord(t)
+		assert.Equal(t, record.Operation, service.AuditWithdraw)
+		assert.Equal(t, record.Outcome, service.AuditRejected)
+		assert.Equal(t, record.PreAmounts, "{}")
+	})
+
+	t.Run("Test every change of balances extends the chain", func(t *testing.T) {
+		first := openBalance(t, services, "audit_a", 1)
+		second := openBalance(t, services, "audit_b", 1)
+
+		amounts := func(t *testing.T, data string) map[uint64]int64 {
+			var result map[uint64]int64
+			assert.Nil(t, json.Unmarshal([]byte(data), &result))
+			return result
+		}
+
+		_, err := services.Deposit(auditCtx, first.ID, amountOf(t, services, first.ID, 1000))
+		assert.Nil(t, err)
+		prev := lastRecord(t)
+
+		hold, err := services.Authorize(auditCtx, first.ID, amountOf(t, services, first.ID, 300), time.Minute)
+		assert.Nil(t, err)
+
+		record := lastRecord(t)
+		assert.Equal(t, record.Operation, service.AuditAuthorize)
+		assert.Equal(t, record.PrevHash, prev.Hash)
+		assert.Equal(t, amounts(t, record.PostAmounts), map[uint64]int64{first.ID: 1000})
+		prev = record
+
+		_, err = services.Capture(auditCtx, hold.ID, amountOf(t, services, first.ID, 200))
+		assert.Nil(t, err)
+
+		record = lastRecord(t)
+		assert.Equal(t, record.Operation, service.AuditCapture)
+		assert.Equal(t, record.Actor, initiator.Actor)
+		assert.Equal(t, record.Params, fmt.Sprintf(`{"balance_id":%d,"hold_id":%d,"amount":"2.00","currency":"USD"}`, first.ID, hold.ID))
+		assert.Equal(t, amounts(t, record.PreAmounts), map[uint64]int64{first.ID: 1000})
+		assert.Equal(t, amounts(t, record.PostAmounts), map[uint64]int64{first.ID: 800})
+		assert.Equal(t, record.PrevHash, prev.Hash)
+		prev = record
+
+		batchID, _, err := services.BatchTransfer(auditCtx, []service.TransferLeg{
+			{FromID: first.ID, ToID: second.ID, Amount: amountOf(t, services, first.ID, 100)},
+		})
+		assert.Nil(t, err)
+
+		record = lastRecord(t)
+		assert.Equal(t, record.Operation, service.AuditBatch)
+		assert.Contains(t, record.Params, fmt.Sprintf(`"batch_id":%d`, batchID))
+		assert.Equal(t, amounts(t, record.PreAmounts), map[uint64]int64{first.ID: 800, second.ID: 0})
+		assert.Equal(t, amounts(t, record.PostAmounts), map[uint64]int64{first.ID: 700, second.ID: 100})
+		assert.Equal(t, record.PrevHash, prev.Hash)
+		prev = record
+
+		file := fmt.Sprintf("balance_id,username,currency,amount,reference\n%d,,,0.50,audit-%d\n", second.ID, second.ID)
+		results, err := services.ImportCSV(auditCtx, strings.NewReader(file), 10)
+		assert.Nil(t, err)
+		assert.Equal(t, results[0].Status, service.ImportApplied)
+
+		record = lastRecord(t)
+		assert.Equal(t, record.Operation, service.AuditImport)
+		assert.Equal(t, amounts(t, record.PreAmounts), map[uint64]int64{second.ID: 100})
+		assert.Equal(t, amounts(t, record.PostAmounts), map[uint64]int64{second.ID: 150})
+		assert.Equal(t, record.PrevHash, prev.Hash)
+		prev = record
+
+		// a rerun skips the line and changes nothing
+		results, err = services.ImportCSV(auditCtx, strings.NewReader(file), 10)
+		assert.Nil(t, err)
+		assert.Equal(t, results[0].Status, service.ImportSkipped)
+		assert.Equal(t, lastRecord(t).ID, prev.ID)
+
+		err = services.SetBalancePolicy(auditCtx, db.BalancePolicy{BalanceID: second.ID, MaxTransactionAmount: 500})
+		assert.Nil(t, err)
+
+		record = lastRecord(t)
+		assert.Equal(t, record.Operation, service.AuditPolicy)
+		assert.Equal(t, record.PrevHash, prev.Hash)
+		prev = record
+
+		_, err = services.FreezeBalance(auditCtx, second.ID, true, "auditor", "checked")
+		assert.Nil(t, err)
+
+		record = lastRecord(t)
+		assert.Equal(t, record.Operation, service.AuditStatus)
+		assert.Equal(t, record.Params, fmt.Sprintf(`{"balance_id":%d,"status":"frozen_debit","reason":"checked"}`, second.ID))
+		assert.Equal(t, record.PrevHash, prev.Hash)
+
+		_, err = services.VerifyAudit(ctx)
+		assert.Nil(t, err)
+	})
+
+	t.Run("Test verify detects tampering", func(t *testing.T) {
+		_, err := services.VerifyAudit(ctx)
+		assert.Nil(t, err)
+
+		record := lastRecord(t)
+		_, err = conn.Exec("UPDATE audit_log SET actor = ? WHERE id = ?", "someone else", record.ID)
+		assert.Nil(t, err)
+
+		_, err = services.VerifyAudit(ctx)
+		assert.ErrorIs(t, err, service.ErrAuditHashMismatch)
+
+		var auditErr *service.AuditError
+		assert.ErrorAs(t, err, &auditErr)
+		assert.Equal(t, auditErr.RecordID, record.ID)
+
+		_, err = conn.Exec("UPDATE audit_log SET actor = ? WHERE id = ?", record.Actor, record.ID)
+		assert.Nil(t, err)
+
+		_, err = services.VerifyAudit(ctx)
+		assert.Nil(t, err)
+	})
+}
+
+// recordingSink keeps published events and fails events of failBalanceID while failures are left
+type recordingSink struct {
+	mu            sync.Mutex
+	events        []outbox.Event
+	failBalanceID uint64
+	failures      int
+}
+
+func (s *recordingSink) Publish(ctx context.Context, event outbox.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if event.BalanceID == s.failBalanceID && s.failures > 0 {
+		s.failures--
+		return errors.New("sink is down")
+	}
+
+	s.events = append(s.events, event)
+	return nil
+}
+
+// sinkFunc publishes events with the function
+type sinkFunc func(ctx context.Context, event outbox.Event) error
+
+func (f sinkFunc) Publish(ctx context.Context, event outbox.Event) error {
+	return f(ctx, event)
+}
+
+// drainOutbox delivers pending events, so the test sees only events it makes
+func drainOutbox(t *testing.T, storage *store.Store) {
+	relay := outbox.NewRelay(storage, &recordingSink{})
+	for {
+		delivered, err := relay.RelayOnce(ctx)
+		assert.Nil(t, err)
+		if delivered == 0 || err != nil {
+			return
+		}
+	}
+}
+
+func lastOutboxEventID(t *testing.T, conn *sql.DB) uint64 {
+	var id uint64
+	err := conn.QueryRow("SELECT COALESCE(MAX(id), 0) FROM outbox_events").Scan(&id)
+	assert.Nil(t, err)
+	return id
+}
+
+func TestOutbox(t *testing.T) {
+	t.Parallel()
+	conn, storage, services := newLedger(t)
+
+	t.Run("Test events are written with changes", func(t *testing.T) {
+		usd := testdb.Balance(t, services, "USD", 100)
+		eurFrom := testdb.Balance(t, services, "EUR", 1000)
+		eurTo := testdb.Balance(t, services, "EUR", 1000)
+		lastID := lastOutboxEventID(t, conn)
+
+		balance, err := services.Deposit(ctx, usd.ID, amountOf(t, services, usd.ID, 100))
+		assert.Nil(t, err)
+
+		balanceFrom, balanceTo, err := services.Transfer(ctx, eurFrom.ID, eurTo.ID, amountOf(t, services, eurFrom.ID, 10))
+		assert.Nil(t, err)
+
+		_, err = services.Withdraw(ctx, usd.ID, amountOf(t, services, usd.ID, 1<<63-1))
+		assert.Error(t, err)
+
+		rows, err := storage.GetOutboxEventsAfterID(ctx, db.GetOutboxEventsAfterIDParams{ID: lastID, Limit: 10})
+		assert.Nil(t, err)
+
+		var types []string
+		var events []service.BalanceEvent
+		for _, row := range rows {
+			types = append(types, row.EventType)
+			assert.False(t, row.DeliveredAt.Valid)
+
+			if row.EventType != service.EventBalanceChanged {
+				continue
+			}
+
+			var event service.BalanceEvent
+			assert.Nil(t, json.Unmarshal([]byte(row.Payload), &event))
+			assert.Equal(t, event.BalanceID, row.BalanceID)
+			events = append(events, event)
+		}
+
+		assert.Equal(t, types, []string{
+			service.EventBalanceChanged,
+			service.EventDepositCompleted,
+			service.EventBalanceChanged,
+			service.EventBalanceChanged,
+			service.EventTransferCompleted,
+			service.EventWithdrawRejected,
+		})
+
+		assert.Equal(t, events[0].Operation, service.OperationDeposit)
+		assert.Equal(t, events[0].Delta, int64(100))
+		assert.Equal(t, events[0].Amount, balance.Amount)
+		assert.NotZero(t, events[0].EntryID)
+
+		assert.Equal(t, events[1].Operation, service.OperationTransfer)
+		assert.Equal(t, events[1].Delta, int64(-10))
+		assert.Equal(t, events[1].Amount, balanceFrom.Amount)
+		assert.Equal(t, events[2].Delta, int64(10))
+		assert.Equal(t, events[2].Amount, balanceTo.Amount)
+		assert.Equal(t, events[2].TransferID, events[1].TransferID)
+
+		var deposit service.EntryEvent
+		assert.Nil(t, json.Unmarshal([]byte(rows[1].Payload), &deposit))
+		assert.Equal(t, deposit, service.EntryEvent{ID: events[0].EntryID, BalanceID: usd.ID, Amount: 100, Decimal: "1.00", Currency: "USD"})
+
+		var transfer service.TransferEvent
+		assert.Nil(t, json.Unmarshal([]byte(rows[4].Payload), &transfer))
+		assert.Equal(t, transfer.ID, events[1].TransferID)
+		assert.Equal(t, transfer.FromBalanceID, eurFrom.ID)
+		assert.Equal(t, transfer.ToBalanceID, eurTo.ID)
+		assert.Equal(t, transfer.Amount, int64(10))
+
+		var rejected service.RejectedEvent
+		assert.Nil(t, json.Unmarshal([]byte(rows[5].Payload), &rejected))
+		assert.Equal(t, rejected.BalanceID, usd.ID)
+		assert.Equal(t, rejected.Reason, "insufficient_funds")
+	})
+
+	t.Run("Test relay keeps order of balance events", func(t *testing.T) {
+		failing := testdb.Balance(t, services, "USD", 0)
+		other := testdb.Balance(t, services, "USD", 0)
+		lastID := lastOutboxEventID(t, conn)
+
+		for i := 0; i < 3; i++ {
+			_, err := services.Deposit(ctx, failing.ID, amountOf(t, services, failing.ID, int64(i+1)))
+			assert.Nil(t, err)
+
+			_, err = services.Deposit(ctx, other.ID, amountOf(t, services, other.ID, int64(i+1)))
+			assert.Nil(t, err)
+		}
+
+		sink := &recordingSink{failBalanceID: failing.ID, failures: 1}
+		relay := outbox.NewRelay(storage, sink)
+		// the failed event is due again right away
+		relay.BaseDelay = 0
+
+		for {
+			delivered, err := relay.RelayOnce(ctx)
+			assert.Nil(t, err)
+			if delivered == 0 {
+				break
+			}
+		}
+
+		// the first run delivers only events of the other balance, the rest of the failing one waits for the failed event
+		deltas := map[uint64][]int64{}
+		for _, event := range sink.events {
+			if event.ID <= lastID || event.Type != service.EventBalanceChanged {
+				continue
+			}
+
+			var payload service.BalanceEvent
+			assert.Nil(t, json.Unmarshal(event.Payload, &payload))
+			deltas[event.BalanceID] = append(deltas[event.BalanceID], payload.Delta)
+		}
+		assert.Equal(t, deltas[failing.ID], []int64{1, 2, 3})
+		assert.Equal(t, deltas[other.ID], []int64{1, 2, 3})
+
+		rows, err := storage.GetOutboxEventsAfterID(ctx, db.GetOutboxEventsAfterIDParams{ID: lastID, Limit: 10})
+		assert.Nil(t, err)
+		for _, row := range rows {
+			assert.True(t, row.DeliveredAt.Valid)
+		}
+		assert.Equal(t, rows[0].Attempts, uint32(2))
+		assert.Equal(t, rows[0].LastError, "sink is down")
+	})
+
+	t.Run("Test failing balance doesn't hold up other balances", func(t *testing.T) {
+		failing := testdb.Balance(t, services, "USD", 0)
+		other := testdb.Balance(t, services, "USD", 0)
+
+		drainOutbox(t, storage)
+		lastID := lastOutboxEventID(t, conn)
+
+		var err error
+		for i := 0; i < 3; i++ {
+			_, err = services.Deposit(ctx, failing.ID, amountOf(t, services, failing.ID, 1))
+			assert.Nil(t, err)
+		}
+		_, err = services.Deposit(ctx, other.ID, amountOf(t, services, other.ID, 1))
+		assert.Nil(t, err)
+
+		sink := &recordingSink{failBalanceID: failing.ID, failures: 100}
+		relay := outbox.NewRelay(storage, sink)
+		// the batch is filled with events of the failing balance
+		relay.BatchSize = 3
+		relay.BaseDelay = time.Minute
+
+		delivered, err := relay.RelayOnce(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, delivered, 0)
+
+		// the failing balance waits for its retry, so events of the other one get into the batch
+		delivered, err = relay.RelayOnce(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, delivered, 2)
+		for _, event := range sink.events {
+			assert.Equal(t, event.BalanceID, other.ID)
+		}
+
+		rows, err := storage.GetOutboxEventsAfterID(ctx, db.GetOutboxEventsAfterIDParams{ID: lastID, Limit: 10})
+		assert.Nil(t, err)
+		failed := rows[0]
+		assert.Equal(t, failed.BalanceID, failing.ID)
+		assert.Equal(t, failed.Attempts, uint32(1))
+		assert.False(t, failed.DeliveredAt.Valid)
+		assert.True(t, failed.NextAttemptAt.Time.After(time.Now().UTC()))
+		assert.False(t, failed.ClaimedUntil.Valid)
+	})
+
+	t.Run("Test claimed batch is published without row locks and skipped by other relays", func(t *testing.T) {
+		balance := testdb.Balance(t, services, "USD", 0)
+		drainOutbox(t, storage)
+		lastID := lastOutboxEventID(t, conn)
+
+		_, err := services.Deposit(ctx, balance.ID, amountOf(t, services, balance.ID, 1))
+		assert.Nil(t, err)
+
+		// while the batch is published, a write to the claimed rows and a second relay must not wait for it
+		other := &recordingSink{}
+		sink := sinkFunc(func(ctx context.Context, event outbox.Event) error {
+			_, err := conn.ExecContext(ctx, "UPDATE outbox_events SET last_error = '' WHERE id = ?", event.ID)
+			if err != nil {
+				return err
+			}
+
+			_, err = outbox.NewRelay(storage, other).RelayOnce(ctx)
+			return err
+		})
+
+		delivered, err := outbox.NewRelay(storage, sink).RelayOnce(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, delivered, 2)
+		assert.Empty(t, other.events)
+
+		rows, err := storage.GetOutboxEventsAfterID(ctx, db.GetOutboxEventsAfterIDParams{ID: lastID, Limit: 10})
+		assert.Nil(t, err)
+		assert.Len(t, rows, 2)
+		for _, row := range rows {
+			assert.True(t, row.DeliveredAt.Valid)
+			assert.False(t, row.ClaimedUntil.Valid)
+		}
+	})
+}
+
+// webhookReceiver collects verified webhook bodies and answers with the next status from statuses, 200 when they are over
+type webhookReceiver struct {
+	mu       sync.Mutex
+	secret   string
+	statuses []int
+	bodies   []webhook.Body
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	body, _ := io.ReadAll(req.Body)
+	err := webhook.Verify(r.secret, req.Header.Get(webhook.SignatureHeader), body, time.Minute, time.Now())
+	if err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	if status == http.StatusOK {
+		var b webhook.Body
+		json.Unmarshal(body, &b)
+		r.bodies = append(r.bodies, b)
+	}
+	w.WriteHeader(status)
+}
+
+// received returns bodies of the event
+func (r *webhookReceiver) received(eventID uint64) []webhook.Body {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var bodies []webhook.Body
+	for _, b := range r.bodies {
+		if b.ID == eventID {
+			bodies = append(bodies, b)
+		}
+	}
+	return bodies
+}
+
+func TestWebhooks(t *testing.T) {
+	t.Parallel()
+	conn, storage, services := newLedger(t)
+
+	balance := testdb.Balance(t, services, "USD", 0)
+	receiver := &webhookReceiver{secret: "webhook-test-secret", statuses: []int{http.StatusServiceUnavailable}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	webhooks := webhook.New(storage, server.Client())
+	webhooks.BaseDelay = 0
+
+	subscription, err := webhooks.Subscribe(ctx, server.URL, receiver.secret, []string{service.EventDepositCompleted, service.EventWithdrawRejected})
+	assert.Nil(t, err)
+	defer webhooks.SetActive(ctx, subscription.ID, false)
+
+	relay := outbox.NewRelay(storage, webhooks)
+	relayAndDeliver := func(t *testing.T) {
+		// deliveries are written while the batch is published, a lock on the event rows would make them
+		// wait for the lock wait timeout, so that fails here instead of hanging
+		relayCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+
+		for {
+			delivered, err := relay.RelayOnce(relayCtx)
+			assert.Nil(t, err)
+			if delivered == 0 {
+				break
+			}
+		}
+
+		for {
+			attempted, err := webhooks.DeliverOnce(ctx)
+			assert.Nil(t, err)
+			if attempted == 0 {
+				break
+			}
+		}
+	}
+
+	t.Run("Test invalid subscription", func(t *testing.T) {
+		_, err := webhooks.Subscribe(ctx, "ftp://example.com", receiver.secret, []string{service.EventDepositCompleted})
+		assert.ErrorIs(t, err, webhook.ErrInvalidURL)
+
+		_, err = webhooks.Subscribe(ctx, server.URL, "short", []string{service.EventDepositCompleted})
+		assert.ErrorIs(t, err, webhook.ErrMissingSecret)
+
+		_, err = webhooks.Subscribe(ctx, server.URL, receiver.secret, []string{"deposit.done"})
+		assert.ErrorIs(t, err, webhook.ErrUnknownEventType)
+	})
+
+	t.Run("Test event is delivered after retry", func(t *testing.T) {
+		lastID := lastOutboxEventID(t, conn)
+
+		_, err := services.Deposit(ctx, balance.ID, amountOf(t, services, balance.ID, 250))
+		assert.Nil(t, err)
+
+		_, err = services.Withdraw(ctx, balance.ID, amountOf(t, services, balance.ID, 1<<63-1))
+		assert.ErrorIs(t, err, service.ErrInsufficientFunds)
+
+		// balance.changed is not subscribed, so the second event of the deposit is deposit.completed
+		rows, err := storage.GetOutboxEventsAfterID(ctx, db.GetOutboxEventsAfterIDParams{ID: lastID, Limit: 10})
+		assert.Nil(t, err)
+		assert.Equal(t, rows[1].EventType, service.EventDepositCompleted)
+		assert.Equal(t, rows[2].EventType, service.EventWithdrawRejected)
+
+		relayAndDeliver(t)
+
+		bodies := receiver.received(rows[1].ID)
+		assert.Equal(t, len(bodies), 1)
+		assert.Equal(t, bodies[0].Type, service.EventDepositCompleted)
+
+		var entry service.EntryEvent
+		assert.Nil(t, json.Unmarshal(bodies[0].Data, &entry))
+		assert.Equal(t, entry.BalanceID, balance.ID)
+		assert.Equal(t, entry.Amount, int64(250))
+
+		var rejected service.RejectedEvent
+		bodies = receiver.received(rows[2].ID)
+		assert.Equal(t, len(bodies), 1)
+		assert.Nil(t, json.Unmarshal(bodies[0].Data, &rejected))
+		assert.Equal(t, rejected.Reason, "insufficient_funds")
+
+		deliveries, err := webhooks.GetDeliveries(ctx, subscription.ID)
+		assert.Nil(t, err)
+		for _, delivery := range deliveries {
+			if delivery.EventID != rows[1].ID {
+				continue
+			}
+
+			assert.Equal(t, delivery.Status, webhook.DeliveryDelivered)
+
+			attempts, err := webhooks.GetAttempts(ctx, delivery.ID)
+			assert.Nil(t, err)
+			assert.Equal(t, len(attempts), int(delivery.Attempts))
+			assert.Equal(t, attempts[len(attempts)-1].StatusCode, int32(http.StatusOK))
+		}
+	})
+
+	t.Run("Test replay of the window", func(t *testing.T) {
+		lastID := lastOutboxEventID(t, conn)
+
+		_, err := services.Deposit(ctx, balance.ID, amountOf(t, services, balance.ID, 1))
+		assert.Nil(t, err)
+
+		relayAndDeliver(t)
+
+		eventID := lastID + 2
+		assert.Equal(t, len(receiver.received(eventID)), 1)
+
+		replayed, err := webhooks.Replay(ctx, subscription.ID, time.Now().Add(-time.Minute), time.Now().Add(time.Minute))
+		assert.Nil(t, err)
+		assert.NotZero(t, replayed)
+
+		relayAndDeliver(t)
+		assert.Equal(t, len(receiver.received(eventID)), 2)
+
+		_, err = webhooks.Replay(ctx, subscription.ID, time.Now(), time.Now().Add(-time.Minute))
+		assert.ErrorIs(t, err, webhook.ErrInvalidWindow)
+	})
+
+	t.Run("Test delivery fails after max attempts", func(t *testing.T) {
+		webhooks.MaxAttempts = 2
+		defer func() { webhooks.MaxAttempts = webhook.DefaultMaxAttempts }()
+
+		receiver.mu.Lock()
+		receiver.statuses = []int{http.StatusInternalServerError, http.StatusInternalServerError}
+		receiver.mu.Unlock()
+
+		lastID := lastOutboxEventID(t, conn)
+
+		_, err := services.Deposit(ctx, balance.ID, amountOf(t, services, balance.ID, 1))
+		assert.Nil(t, err)
+
+		relayAndDeliver(t)
+		assert.Equal(t, len(receiver.received(lastID+2)), 0)
+
+		deliveries, err := webhooks.GetDeliveries(ctx, subscription.ID)
+		assert.Nil(t, err)
+		last := deliveries[len(deliveries)-1]
+		assert.Equal(t, last.EventID, lastID+2)
+		assert.Equal(t, last.Status, webhook.DeliveryFailed)
+		assert.Equal(t, last.Attempts, uint32(2))
+	})
+}
+
+type sseMessage struct {
+	id    uint64
+	event string
+	data  string
+}
+
+// openStream connects to the stream and returns a function reading the next message
+func openStream(t *testing.T, streamURL string, lastEventID string) (func() sseMessage, func()) {
+	reqCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, streamURL, nil)
+	assert.Nil(t, err)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+
+	reader := bufio.NewReader(resp.Body)
+	next := func() sseMessage {
+		var msg sseMessage
+		for {
+			line, err := reader.ReadString('\n')
+			if !assert.Nil(t, err) {
+				return msg
+			}
+
+			line = strings.TrimSuffix(line, "\n")
+			key, value, _ := strings.Cut(line, ": ")
+			switch key {
+			case "id":
+				msg.id, _ = strconv.ParseUint(value, 10, 64)
+			case "event":
+				msg.event = value
+			case "data":
+				msg.data = value
+			case "":
+				if msg.id != 0 {
+					return msg
+				}
+			}
+		}
+	}
+
+	return next, func() {
+		cancel()
+		resp.Body.Close()
+	}
+}
+
+func TestStream(t *testing.T) {
+	t.Parallel()
+	_, storage, services := newLedger(t)
+
+	hubCtx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	hub := stream.NewHub(storage)
+	hub.Interval = 10 * time.Millisecond
+	hub.GapTimeout = 200 * time.Millisecond
+	go hub.Run(hubCtx)
+
+	server := httptest.NewServer(hub.Handler())
+	defer server.Close()
+
+	balanceOne := testdb.Balance(t, services, "EUR", 1000)
+	balanceTwo := testdb.Balance(t, services, "EUR", 1000)
+
+	t.Run("Test invalid filter is rejected", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "?balance_id=abc")
+		assert.Nil(t, err)
+		resp.Body.Close()
+		assert.Equal(t, resp.StatusCode, http.StatusBadRequest)
+	})
+
+	var lastID uint64
+	t.Run("Test updates of subscribed balances are pushed", func(t *testing.T) {
+		next, closeStream := openStream(t, fmt.Sprintf("%s?balance_id=%d", server.URL, balanceTwo.ID), "")
+		defer closeStream()
+
+		_, err := services.Deposit(ctx, balanceOne.ID, amountOf(t, services, balanceOne.ID, 10))
+		assert.Nil(t, err)
+		_, err = services.Deposit(ctx, balanceTwo.ID, amountOf(t, services, balanceTwo.ID, 20))
+		assert.Nil(t, err)
+
+		msg := next()
+		assert.Equal(t, msg.event, service.EventBalanceChanged)
+
+		var event service.BalanceEvent
+		assert.Nil(t, json.Unmarshal([]byte(msg.data), &event))
+		assert.Equal(t, event.BalanceID, balanceTwo.ID)
+		assert.Equal(t, event.Operation, service.OperationDeposit)
+		assert.Equal(t, event.Delta, int64(20))
+
+		lastID = msg.id
+	})
+
+	t.Run("Test reconnected client catches up", func(t *testing.T) {
+		_, _, err := services.Transfer(ctx, balanceTwo.ID, balanceOne.ID, amountOf(t, services, balanceTwo.ID, 5))
+		assert.Nil(t, err)
+		_, err = services.Withdraw(ctx, balanceTwo.ID, amountOf(t, services, balanceTwo.ID, 5))
+		assert.Nil(t, err)
+
+		next, closeStream := openStream(t, fmt.Sprintf("%s?balance_id=%d", server.URL, balanceTwo.ID), strconv.FormatUint(lastID, 10))
+		defer closeStream()
+
+		var event service.BalanceEvent
+		msg := next()
+		assert.Greater(t, msg.id, lastID)
+		assert.Nil(t, json.Unmarshal([]byte(msg.data), &event))
+		assert.Equal(t, event.Operation, service.OperationTransfer)
+		assert.Equal(t, event.Delta, int64(-5))
+
+		msg2 := next()
+		assert.Greater(t, msg2.id, msg.id)
+		assert.Nil(t, json.Unmarshal([]byte(msg2.data), &event))
+		assert.Equal(t, event.Operation, service.OperationWithdraw)
+	})
+
+	t.Run("Test rolled back event doesn't stop the stream", func(t *testing.T) {
+		next, closeStream := openStream(t, fmt.Sprintf("%s?balance_id=%d", server.URL, balanceTwo.ID), "")
+		defer closeStream()
+
+		tx, err := storage.BeginTx(ctx, nil)
+		assert.Nil(t, err)
+		_, err = storage.WithTx(tx).CreateOutboxEvent(ctx, db.CreateOutboxEventParams{
+			BalanceID: balanceOne.ID,
+			EventType: service.EventBalanceChanged,
+			Payload:   "{}",
+		})
+		assert.Nil(t, err)
+		assert.Nil(t, tx.Rollback())
+
+		_, err = services.Deposit(ctx, balanceTwo.ID, amountOf(t, services, balanceTwo.ID, 40))
+		assert.Nil(t, err)
+
+		var event service.BalanceEvent
+		msg := next()
+		assert.Nil(t, json.Unmarshal([]byte(msg.data), &event))
+		assert.Equal(t, event.Delta, int64(40))
+	})
+
+	t.Run("Test updates of user balances are pushed", func(t *testing.T) {
+		next, closeStream := openStream(t, fmt.Sprintf("%s?user_id=%d", server.URL, balanceOne.UserID), "")
+		defer closeStream()
+
+		_, err := services.Deposit(ctx, balanceOne.ID, amountOf(t, services, balanceOne.ID, 30))
+		assert.Nil(t, err)
+
+		for {
+			var event service.BalanceEvent
+			msg := next()
+			assert.Nil(t, json.Unmarshal([]byte(msg.data), &event))
+			if event.BalanceID == balanceOne.ID && event.Delta == 30 {
+				break
+			}
+		}
+	})
+}
+
+func TestGRPC(t *testing.T) {
+	t.Parallel()
+	_, _, services := newLedger(t)
+
+	listener := bufconn.Listen(1 << 20)
+	server := grpcserver.NewGRPCServer(services, time.Minute)
+	reflection.Register(server)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	clientConn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	assert.Nil(t, err)
+	defer clientConn.Close()
+
+	client := ledgerv1.NewLedgerClient(clientConn)
+
+	reqCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	balance := testdb.Balance(t, services, "USD", 100)
+	currency := balance.Currency.Code
+
+	t.Run("Test deposit and withdraw", func(t *testing.T) {
+		amount := money.New(150, balance.Currency).Decimal()
+
+		deposited, err := client.Deposit(reqCtx, &ledgerv1.DepositRequest{BalanceId: balance.ID, Amount: &ledgerv1.Money{Amount: amount, Currency: currency}})
+		assert.Nil(t, err)
+
+		withdrawn, err := client.Withdraw(reqCtx, &ledgerv1.WithdrawRequest{BalanceId: balance.ID, Amount: &ledgerv1.Money{Amount: amount, Currency: currency}})
+		assert.Nil(t, err)
+
+		before, _ := money.Parse(deposited.Amount.Amount, balance.Currency)
+		after, _ := money.Parse(withdrawn.Amount.Amount, balance.Currency)
+		assert.Equal(t, before.Amount-after.Amount, int64(150))
+	})
+
+	t.Run("Test errors are mapped to status codes", func(t *testing.T) {
+		_, err := client.GetBalance(reqCtx, &ledgerv1.GetBalanceRequest{Id: 1 << 62})
+		assert.Equal(t, status.Code(err), codes.NotFound)
+
+		_, err = client.Deposit(reqCtx, &ledgerv1.DepositRequest{BalanceId: balance.ID, Amount: &ledgerv1.Money{Amount: "-1", Currency: currency}})
+		assert.Equal(t, status.Code(err), codes.InvalidArgument)
+
+		_, err = client.Deposit(reqCtx, &ledgerv1.DepositRequest{BalanceId: balance.ID, Amount: &ledgerv1.Money{Amount: "1,5", Currency: currency}})
+		assert.Equal(t, status.Code(err), codes.InvalidArgument)
+
+		_, err = client.Deposit(reqCtx, &ledgerv1.DepositRequest{BalanceId: balance.ID, Amount: &ledgerv1.Money{Amount: "1", Currency: "NOPE"}})
+		assert.Equal(t, status.Code(err), codes.InvalidArgument)
+
+		huge := money.New(1<<62, balance.Currency).Decimal()
+		_, err = client.Withdraw(reqCtx, &ledgerv1.WithdrawRequest{BalanceId: balance.ID, Amount: &ledgerv1.Money{Amount: huge, Currency: currency}})
+		assert.Equal(t, status.Code(err), codes.FailedPrecondition)
+	})
+
+	t.Run("Test expired deadline is reported", func(t *testing.T) {
+		expired, cancel := context.WithTimeout(ctx, -time.Second)
+		defer cancel()
+
+		_, err := client.ListBalances(expired, &ledgerv1.ListBalancesRequest{})
+		assert.Equal(t, status.Code(err), codes.DeadlineExceeded)
+	})
+
+	t.Run("Test listings", func(t *testing.T) {
+		balances, err := client.ListBalances(reqCtx, &ledgerv1.ListBalancesRequest{})
+		assert.Nil(t, err)
+		assert.NotEmpty(t, balances.Balances)
+
+		currencies, err := client.ListCurrencies(reqCtx, &ledgerv1.ListCurrenciesRequest{})
+		assert.Nil(t, err)
+		assert.NotEmpty(t, currencies.Currencies)
+
+		entries, err := client.ListEntries(reqCtx, &ledgerv1.ListEntriesRequest{})
+		assert.Nil(t, err)
+		assert.NotEmpty(t, entries.Entries)
+	})
+
+	t.Run("Test reflection lists the service", func(t *testing.T) {
+		stream, err := reflectionpb.NewServerReflectionClient(clientConn).ServerReflectionInfo(reqCtx)
+		assert.Nil(t, err)
+
+		err = stream.Send(&reflectionpb.ServerReflectionRequest{MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{}})
+		assert.Nil(t, err)
+
+		resp, err := stream.Recv()
+		assert.Nil(t, err)
+
+		var names []string
+		for _, s := range resp.GetListServicesResponse().GetService() {
+			names = append(names, s.Name)
+		}
+		assert.Contains(t, names, "ledger.v1.Ledger")
+	})
+}
+
+func TestDryRun(t *testing.T) {
+	t.Parallel()
+	conn, _, services := newLedger(t)
+
+	t.Run("Test dry run returns the result and changes nothing", func(t *testing.T) {
+		before := testdb.Balance(t, services, "USD", 100)
+		lastEntryID, err := services.GetLastEntryID(ctx)
+		assert.Nil(t, err)
+		lastEventID := lastOutboxEventID(t, conn)
+
+		balance, err := services.Deposit(service.WithDryRun(ctx), before.ID, amountOf(t, services, before.ID, 500))
+		assert.Nil(t, err)
+		assert.Equal(t, balance.Amount, before.Amount+500)
+
+		after, err := services.GetBalanceById(ctx, before.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, after.Amount, before.Amount)
+
+		entryID, err := services.GetLastEntryID(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, entryID, lastEntryID)
+		assert.Equal(t, lastOutboxEventID(t, conn), lastEventID)
+	})
+
+	t.Run("Test dry run reports rejection", func(t *testing.T) {
+		balance := testdb.Balance(t, services, "USD", 100)
+
+		_, err := services.Withdraw(service.WithDryRun(ctx), balance.ID, amountOf(t, services, balance.ID, 1<<62))
+		assert.ErrorIs(t, err, service.ErrInsufficientFunds)
+	})
+}
+
+func TestStatement(t *testing.T) {
+	t.Parallel()
+	_, _, services := newLedger(t)
+
+	t.Run("Test statement adds up to the balance", func(t *testing.T) {
+		balance := testdb.Balance(t, services, "EUR", 1000)
+
+		_, err := services.Deposit(ctx, balance.ID, amountOf(t, services, balance.ID, 40))
+		assert.Nil(t, err)
+
+		statement, err := services.Statement(ctx, balance.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, statement.Balance.ID, balance.ID)
+		assert.Equal(t, statement.Credits+statement.Debits, statement.Balance.Amount)
+
+		last := statement.Lines[0]
+		for _, line := range statement.Lines {
+			if line.Kind == service.LineEntry {
+				last = line
+			}
+		}
+		assert.Equal(t, last.Amount, int64(40))
+	})
+
+	t.Run("Test unknown balance", func(t *testing.T) {
+		_, err := services.Statement(ctx, 1<<62)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	})
+}
+
+func TestReconcile(t *testing.T) {
+	t.Parallel()
+	conn, _, services := newLedger(t)
+
+	balance := testdb.Balance(t, services, "USD", 100)
+	other := testdb.Balance(t, services, "USD", 0)
+
+	_, _, err := services.Transfer(ctx, balance.ID, other.ID, amountOf(t, services, balance.ID, 30))
+	assert.Nil(t, err)
+	_, err = services.Authorize(ctx, balance.ID, amountOf(t, services, balance.ID, 20), time.Hour)
+	assert.Nil(t, err)
+
+	t.Run("Test balances match their ledger", func(t *testing.T) {
+		discrepancies, err := services.Reconcile(ctx)
+		assert.Nil(t, err)
+		assert.Empty(t, discrepancies)
+	})
+
+	t.Run("Test changed amount is found", func(t *testing.T) {
+		_, err := conn.Exec("UPDATE balances SET amount = amount + 1 WHERE id = ?", balance.ID)
+		assert.Nil(t, err)
+		defer conn.Exec("UPDATE balances SET amount = amount - 1 WHERE id = ?", balance.ID)
+
+		discrepancies, err := services.Reconcile(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, len(discrepancies), 1)
+		assert.Equal(t, discrepancies[0].BalanceID, balance.ID)
+		assert.Equal(t, discrepancies[0].Field, "amount")
+		assert.Equal(t, discrepancies[0].Stored, discrepancies[0].Expected+1)
+	})
+
+	t.Run("Test only given balances are checked", func(t *testing.T) {
+		_, err := conn.Exec("UPDATE balances SET amount = amount + 1 WHERE id = ?", balance.ID)
+		assert.Nil(t, err)
+		defer conn.Exec("UPDATE balances SET amount = amount - 1 WHERE id = ?", balance.ID)
+
+		discrepancies, err := services.ReconcileBalances(ctx, []uint64{other.ID})
+		assert.Nil(t, err)
+		assert.Empty(t, discrepancies)
+
+		discrepancies, err = services.ReconcileBalances(ctx, []uint64{other.ID, balance.ID})
+		assert.Nil(t, err)
+		if assert.Len(t, discrepancies, 1) {
+			assert.Equal(t, balance.ID, discrepancies[0].BalanceID)
+		}
+	})
+
+	t.Run("Test changed held amount is found", func(t *testing.T) {
+		_, err := conn.Exec("UPDATE balances SET held_amount = 0 WHERE id = ?", balance.ID)
+		assert.Nil(t, err)
+		defer conn.Exec("UPDATE balances SET held_amount = 20 WHERE id = ?", balance.ID)
+
+		discrepancies, err := services.Reconcile(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, len(discrepancies), 1)
+		assert.Equal(t, discrepancies[0].BalanceID, balance.ID)
+		assert.Equal(t, discrepancies[0].Field, "held_amount")
+		assert.Equal(t, discrepancies[0].Stored, int64(0))
+		assert.Equal(t, discrepancies[0].Expected, int64(20))
+	})
+}
+
+func TestSchema(t *testing.T) {
+	// migrating down would break tests running on the same database
+	t.Parallel()
+	database := testdb.New(t)
+
+	migrator, err := schema.Open(database.Config)
+	assert.NoError(t, err)
+	defer migrator.Close()
+
+	t.Run("Test database has the version of compiled queries", func(t *testing.T) {
+		status, err := migrator.Status()
+		assert.NoError(t, err)
+		assert.Equal(t, uint(db.SchemaVersion), status.Version)
+		assert.Equal(t, status.Expected, status.Latest)
+		assert.False(t, status.Dirty)
+		assert.Empty(t, status.Pending)
+
+		assert.NoError(t, migrator.Check(false))
+	})
+
+	t.Run("Test older schema is refused or migrated", func(t *testing.T) {
+		err := migrator.Down(1)
+		assert.NoError(t, err)
+
+		err = migrator.Check(false)
+		assert.True(t, errors.Is(err, schema.ErrMismatch))
+
+		status, err := migrator.Status()
+		assert.NoError(t, err)
+		assert.Equal(t, uint(db.SchemaVersion-1), status.Version)
+		assert.Len(t, status.Pending, 1)
+
+		err = migrator.Check(true)
+		assert.NoError(t, err)
+
+		version, dirty, err := migrator.Version()
+		assert.NoError(t, err)
+		assert.Equal(t, uint(db.SchemaVersion), version)
+		assert.False(t, dirty)
+	})
+
+	t.Run("Test every migration is rolled back and reapplied", func(t *testing.T) {
+		// each step is rolled back, reapplied and rolled back again, so the down migration runs on
+		// the schema its own up migration produced
+		for version := uint(db.SchemaVersion); version > 1; version-- {
+			err := migrator.Down(1)
+			assert.NoError(t, err, "down from version %d", version)
+
+			err = migrator.Goto(version)
+			assert.NoError(t, err, "up to version %d", version)
+
+			err = migrator.Down(1)
+			assert.NoError(t, err, "down from reapplied version %d", version)
+		}
+
+		err := migrator.Up()
+		assert.NoError(t, err)
+
+		version, dirty, err := migrator.Version()
+		assert.NoError(t, err)
+		assert.Equal(t, uint(db.SchemaVersion), version)
+		assert.False(t, dirty)
+	})
+}
+
+func TestMetrics(t *testing.T) {
+	// operation counters are shared by the process, parallel tests start only after this one is done
+	_, storage, services := newLedger(t)
+
+	handler := metrics.Handler(metrics.NewRegistry(storage))
+
+	scrape := func(t *testing.T) string {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		return recorder.Body.String()
+	}
+
+	t.Run("Test operations are counted by outcome", func(t *testing.T) {
+		success := metrics.Operations.WithLabelValues(service.OperationDeposit, metrics.OutcomeSuccess)
+		insufficient := metrics.Operations.WithLabelValues(service.OperationWithdraw, metrics.OutcomeInsufficientFunds)
+		invalid := metrics.Operations.WithLabelValues(service.OperationTransfer, metrics.OutcomeInvalidAmount)
+		batch := metrics.Operations.WithLabelValues(service.OperationBatch, metrics.OutcomeSuccess)
+		from := testdb.Balance(t, services, "USD", 100)
+		to := testdb.Balance(t, services, "USD", 0)
+		successBefore, insufficientBefore, invalidBefore := testutil.ToFloat64(success), testutil.ToFloat64(insufficient), testutil.ToFloat64(invalid)
+		batchBefore := testutil.ToFloat64(batch)
+
+		_, err := services.Deposit(ctx, from.ID, amountOf(t, services, from.ID, 10))
+		assert.Nil(t, err)
+		_, err = services.Withdraw(ctx, from.ID, amountOf(t, services, from.ID, 1<<62))
+		assert.ErrorIs(t, err, service.ErrInsufficientFunds)
+		_, _, err = services.Transfer(ctx, from.ID, to.ID, amountOf(t, services, from.ID, 0))
+		assert.ErrorIs(t, err, service.ErrInvalidAmount)
+		_, _, err = services.BatchTransfer(ctx, []service.TransferLeg{{FromID: from.ID, ToID: to.ID, Amount: amountOf(t, services, from.ID, 1)}})
+		assert.Nil(t, err)
+
+		assert.Equal(t, successBefore+1, testutil.ToFloat64(success))
+		assert.Equal(t, insufficientBefore+1, testutil.ToFloat64(insufficient))
+		assert.Equal(t, invalidBefore+1, testutil.ToFloat64(invalid))
+		assert.Equal(t, batchBefore+1, testutil.ToFloat64(batch))
+	})
+
+	t.Run("Test endpoint exposes latency, lock wait, pool stats and totals", func(t *testing.T) {
+		body := scrape(t)
+
+		assert.Contains(t, body, `balances_operation_duration_seconds_count{operation="deposit"}`)
+		assert.Contains(t, body, "balances_balance_lock_wait_seconds_count")
+		assert.Contains(t, body, "go_sql_open_connections{db_name=\"balances\"}")
+		assert.Contains(t, body, `balances_money_total{currency="USD"}`)
+	})
+
+	t.Run("Test totals follow deposits", func(t *testing.T) {
+		balance := testdb.Balance(t, services, "EUR", 1000)
+
+		total := func() float64 {
+			for _, line := range strings.Split(scrape(t), "\n") {
+				value, ok := strings.CutPrefix(line, fmt.Sprintf("balances_money_total{currency=%q} ", balance.Currency.Code))
+				if ok {
+					f, err := strconv.ParseFloat(value, 64)
+					assert.Nil(t, err)
+					return f
+				}
+			}
+			t.Fatal("no total of the currency")
+			return 0
+		}
+
+		before := total()
+		_, err := services.Deposit(ctx, balance.ID, amountOf(t, services, balance.ID, 250))
+		assert.Nil(t, err)
+
+		expected, _ := new(big.Float).SetString(money.New(250, balance.Currency).Decimal())
+		delta, _ := expected.Float64()
+		assert.InDelta(t, before+delta, total(), 1e-6)
+	})
+}
+
+func TestTracing(t *testing.T) {
+	// the tracer provider is global, parallel tests start only after this one is done
+	_, _, services := newLedger(t)
+
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	t.Run("Test transfer span has query and transaction children", func(t *testing.T) {
+		from := testdb.Balance(t, services, "USD", 100)
+		to := testdb.Balance(t, services, "USD", 0)
+
+		_, _, err := services.Transfer(ctx, from.ID, to.ID, amountOf(t, services, from.ID, 3))
+		assert.Nil(t, err)
+
+		var transfer sdktrace.ReadOnlySpan
+		children := make(map[string]int)
+		for _, span := range recorder.Ended() {
+			if span.Name() == "service.Transfer" {
+				transfer = span
+			}
+		}
+		if !assert.NotNil(t, transfer) {
+			return
+		}
+
+		for _, span := range recorder.Ended() {
+			if span.Parent().SpanID() == transfer.SpanContext().SpanID() {
+				children[span.Name()]++
+			}
+		}
+
+		assert.Equal(t, 1, children["db.begin"])
+		assert.Equal(t, 2, children["db.GetBalanceByIDForUpdate"])
+		assert.Equal(t, 1, children["db.CreateTransfer"])
+		assert.Equal(t, 2, children["db.UpdateBalance"])
+		assert.Equal(t, 1, children["db.commit"])
+		assert.Zero(t, children["db.rollback"])
+
+		attrs := make(map[attribute.Key]attribute.Value)
+		for _, attr := range transfer.Attributes() {
+			attrs[attr.Key] = attr.Value
+		}
+		assert.Equal(t, int64(from.ID), attrs["balance.from_id"].AsInt64())
+		assert.Equal(t, int64(to.ID), attrs["balance.to_id"].AsInt64())
+		assert.Equal(t, amountOf(t, services, from.ID, 3).Decimal(), attrs["amount"].AsString())
+	})
+
+	t.Run("Test rejected operation is marked as failed and rolled back", func(t *testing.T) {
+		balance := testdb.Balance(t, services, "USD", 100)
+
+		_, err := services.Withdraw(ctx, balance.ID, amountOf(t, services, balance.ID, 1<<62))
+		assert.ErrorIs(t, err, service.ErrInsufficientFunds)
+
+		var withdraw sdktrace.ReadOnlySpan
+		rollbacks := 0
+		for _, span := range recorder.Ended() {
+			if span.Name() == "service.Withdraw" {
+				withdraw = span
+			}
+		}
+		if !assert.NotNil(t, withdraw) {
+			return
+		}
+		for _, span := range recorder.Ended() {
+			if span.Name() == "db.rollback" && span.Parent().SpanID() == withdraw.SpanContext().SpanID() {
+				rollbacks++
+			}
+		}
+
+		assert.Equal(t, otelcodes.Error, withdraw.Status().Code)
+		assert.Equal(t, 1, rollbacks)
+	})
+}
+
+func TestLogging(t *testing.T) {
+	t.Parallel()
+	conn, storage, services := newLedger(t)
+
+	balance := testdb.Balance(t, services, "USD", 100)
+	var buf bytes.Buffer
+	services.Logger = logging.New(config.Log{Level: "info", Format: "json"}, &buf)
+
+	lastRecord := func(t *testing.T) map[string]any {
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		var record map[string]any
+		assert.Nil(t, json.Unmarshal([]byte(lines[len(lines)-1]), &record))
+		return record
+	}
+
+	t.Run("Test applied operation is logged with request id of the audit record", func(t *testing.T) {
+		ctx := service.WithInitiator(ctx, service.Initiator{Actor: "tester", Client: "test", RequestID: "log-req-1"})
+		_, err := services.Deposit(ctx, balance.ID, amountOf(t, services, balance.ID, 5))
+		assert.Nil(t, err)
+
+		record := lastRecord(t)
+		assert.Equal(t, "operation applied", record["msg"])
+		assert.Equal(t, "INFO", record["level"])
+		assert.Equal(t, "deposit", record["operation"])
+		assert.Equal(t, "log-req-1", record["request_id"])
+		assert.Equal(t, "tester", record["actor"])
+
+		chain, err := storage.GetAuditChain(ctx)
+		assert.Nil(t, err)
+		audit, err := services.GetAuditRecordById(ctx, chain.LastID)
+		assert.Nil(t, err)
+		assert.Equal(t, "log-req-1", audit.RequestID)
+	})
+
+	t.Run("Test rejected operation is logged with outcome", func(t *testing.T) {
+		_, err := services.Withdraw(ctx, balance.ID, amountOf(t, services, balance.ID, 1<<62))
+		assert.ErrorIs(t, err, service.ErrInsufficientFunds)
+
+		record := lastRecord(t)
+		assert.Equal(t, "operation rejected, rolled back", record["msg"])
+		assert.Equal(t, "insufficient_funds", record["outcome"])
+	})
+
+	t.Run("Test slow query is logged", func(t *testing.T) {
+		var slow bytes.Buffer
+		storage := store.New(conn)
+		storage.SlowQuery = time.Nanosecond
+		storage.Logger = logging.New(config.Log{Level: "info", Format: "json"}, &slow)
+
+		_, err := storage.GetBalanceByID(ctx, balance.ID)
+		assert.Nil(t, err)
+		assert.Contains(t, slow.String(), `"msg":"slow query"`)
+		assert.Contains(t, slow.String(), `"query":"GetBalanceByID"`)
+	})
+}
+
+func TestHealth(t *testing.T) {
+	t.Parallel()
+	conn, storage, services := newLedger(t)
+
+	mux := http.NewServeMux()
+	health.New(storage, nil).Register(mux, true)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	getJSON := func(t *testing.T, path string, body any) int {
+		resp, err := http.Get(server.URL + path)
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+		assert.Nil(t, json.NewDecoder(resp.Body).Decode(body))
+		return resp.StatusCode
+	}
+
+	t.Run("Test server is ready with the expected schema", func(t *testing.T) {
+		var report health.Report
+		code := getJSON(t, "/readyz", &report)
+
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, health.StatusOK, report.Status)
+		assert.Equal(t, health.StatusOK, report.Checks["mysql"].Status)
+		assert.Equal(t, health.StatusOK, report.Checks["schema"].Status)
+		assert.NoError(t, schema.Verify(ctx, conn))
+	})
+
+	t.Run("Test diagnostics shows open transactions and lock waits", func(t *testing.T) {
+		balance := testdb.Balance(t, services, "USD", 0)
+
+		holder, err := storage.BeginTx(ctx, nil)
+		assert.Nil(t, err)
+		defer holder.Rollback()
+		_, err = storage.WithTx(holder).GetBalanceByIDForUpdate(ctx, balance.ID)
+		assert.Nil(t, err)
+
+		waiter, err := storage.BeginTx(ctx, nil)
+		assert.Nil(t, err)
+		defer waiter.Rollback()
+
+		waitCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		waited := make(chan struct{})
+		go func() {
+			defer close(waited)
+			storage.WithTx(waiter).GetBalanceByIDForUpdate(waitCtx, balance.ID)
+		}()
+
+		var diagnostics health.Diagnostics
+		assert.Eventually(t, func() bool {
+			getJSON(t, "/debug/diagnostics", &diagnostics)
+			return len(diagnostics.LockWaits) > 0
+		}, 5*time.Second, 50*time.Millisecond, diagnostics.LockWaitsError)
+
+		assert.GreaterOrEqual(t, diagnostics.InFlightTransactions, int64(2))
+		if assert.NotEmpty(t, diagnostics.LockWaits) {
+			assert.Equal(t, "balances", diagnostics.LockWaits[0].Table)
+			assert.Equal(t, strconv.FormatUint(balance.ID, 10), diagnostics.LockWaits[0].LockData)
+		}
+
+		holder.Rollback()
+		<-waited
+	})
+}
+
+func TestStress(t *testing.T) {
+	t.Parallel()
+	conn, _, services := newLedger(t)
+
+	runner := stress.New(services)
+	runner.Balances = 6
+	runner.Workers = 6
+	runner.Duration = 2 * time.Second
+	runner.Currencies = []string{"USD", "EUR"}
+	runner.MaxAmount = 50
+	runner.InitialAmount = 100
+	runner.Seed = 1
+
+	t.Run("Test random workload keeps ledger invariants", func(t *testing.T) {
+		report, err := runner.Run(ctx)
+		assert.Nil(t, err)
+
+		assert.Empty(t, report.Violations)
+		assert.Len(t, report.Balances, 6)
+		assert.Zero(t, report.Failed())
+		assert.Positive(t, report.Throughput)
+		for _, operation := range []string{stress.OperationDeposit, stress.OperationWithdraw, stress.OperationTransfer} {
+			if assert.Contains(t, report.Operations, operation) {
+				assert.Positive(t, report.Operations[operation].Outcomes[metrics.OutcomeSuccess], operation)
+			}
+		}
+	})
+
+	t.Run("Test history of random workload is linearizable", func(t *testing.T) {
+		runner.Mix.Reads = 2
+		runner.CheckHistory = true
+		defer func() {
+			runner.Mix.Reads = 0
+			runner.CheckHistory = false
+		}()
+
+		report, err := runner.Run(ctx)
+		assert.Nil(t, err)
+
+		assert.Empty(t, report.Violations)
+		assert.Equal(t, linearize.Linearizable, report.Linearizability)
+		assert.Empty(t, report.Anomaly)
+		assert.Contains(t, report.Operations, stress.OperationRead)
+	})
+
+	t.Run("Test balances outside the run are not checked", func(t *testing.T) {
+		other := testdb.Balance(t, services, "USD", 100)
+		_, err := conn.Exec("UPDATE balances SET amount = amount + 1 WHERE id = ?", other.ID)
+		assert.Nil(t, err)
+		defer conn.Exec("UPDATE balances SET amount = amount - 1 WHERE id = ?", other.ID)
+
+		runner.Duration = 200 * time.Millisecond
+		defer func() { runner.Duration = 2 * time.Second }()
+
+		report, err := runner.Run(ctx)
+		assert.Nil(t, err)
+		assert.Empty(t, report.Violations)
+	})
+}
+
+func TestStressCommand(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Test command refuses a database not dedicated to stress runs", func(t *testing.T) {
+		cfg := config.Config{}
+		cfg.DB.Name = "balances"
+
+		err := runStress(cfg, nil)
+		assert.ErrorContains(t, err, "-y")
+	})
+}
+
+// lockWaitAfter is how long a statement of an isolation scenario runs before it's taken as waiting for a lock
+const lockWaitAfter = 300 * time.Millisecond
+
+// isolationTx is sql.Tx of a level under test or store.Tx the service begins
+type isolationTx interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	Commit() error
+	Rollback() error
+}
+
+// session is a transaction of an isolation scenario. Its statements run one after another in the background,
+// so a statement waiting for a lock of the other session doesn't stop the scenario, the next statements
+// of the session queue after it.
+type session struct {
+	tx   isolationTx
+	last chan struct{}
+	// first error of the session, mysql rolls back the victim of a deadlock
+	err error
+}
+
+func newSession(t *testing.T, tx isolationTx) *session {
+	last := make(chan struct{})
+	close(last)
+	s := &session{tx: tx, last: last}
+	t.Cleanup(func() {
+		s.wait()
+		tx.Rollback()
+	})
+	return s
+}
+
+// run queues the statement and returns when it's done or waits for a lock
+func (s *session) run(statement func(tx isolationTx) error) {
+	prev := s.last
+	done := make(chan struct{})
+	s.last = done
+	go func() {
+		defer close(done)
+		<-prev
+		if s.err == nil {
+			s.err = statement(s.tx)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(lockWaitAfter):
+	}
+}
+
+func (s *session) exec(query string, args ...any) {
+	s.run(func(tx isolationTx) error {
+		_, err := tx.ExecContext(ctx, query, args...)
+		return err
+	})
+}
+
+func (s *session) query(dest *int64, query string, args ...any) {
+	s.run(func(tx isolationTx) error {
+		return tx.QueryRowContext(ctx, query, args...).Scan(dest)
+	})
+}
+
+func (s *session) commit() {
+	s.run(func(tx isolationTx) error { return tx.Commit() })
+}
+
+func (s *session) rollback() {
+	s.run(func(tx isolationTx) error { return tx.Rollback() })
+}
+
+// wait returns the error of the session when all its statements are done
+func (s *session) wait() error {
+	<-s.last
+	return s.err
+}
+
+// survived waits for the session and tells whether it wasn't rolled back as a victim of a deadlock,
+// it must not fail in any other way
+func survived(t *testing.T, s *session) bool {
+	err := s.wait()
+	var mysqlErr *mysql.MySQLError
+	if err != nil && !(errors.As(err, &mysqlErr) && mysqlErr.Number == 1213) {
+		t.Errorf("session failed: %v", err)
+	}
+	return err == nil
+}
+
+// createIsolationTables makes tables for the isolation scenarios, anomalies they commit would break
+// the invariants of the ledger
+func createIsolationTables(t *testing.T, conn *sql.DB) {
+	for _, statement := range []string{
+		"CREATE TABLE IF NOT EXISTS isolation_balances (id BIGINT UNSIGNED PRIMARY KEY, amount BIGINT NOT NULL)",
+		"CREATE TABLE IF NOT EXISTS isolation_entries (id BIGINT UNSIGNED AUTO_INCREMENT PRIMARY KEY, balance_id BIGINT UNSIGNED NOT NULL, amount BIGINT NOT NULL, INDEX (balance_id))",
+	} {
+		_, err := conn.ExecContext(ctx, statement)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Cleanup(func() {
+		conn.ExecContext(ctx, "DROP TABLE isolation_entries, isolation_balances")
+	})
+}
+
+// resetIsolationTables sets amounts of balances 1 and 2, balance 1 gets one entry
+func resetIsolationTables(t *testing.T, conn *sql.DB, first int64, second int64) {
+	for _, statement := range []string{"DELETE FROM isolation_entries", "DELETE FROM isolation_balances"} {
+		_, err := conn.ExecContext(ctx, statement)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := conn.ExecContext(ctx, "INSERT INTO isolation_balances (id, amount) VALUES (1, ?), (2, ?)", first, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.ExecContext(ctx, "INSERT INTO isolation_entries (balance_id, amount) VALUES (1, ?)", first)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// isolationAmount reads the committed amount of the balance
+func isolationAmount(t *testing.T, conn *sql.DB, id int) int64 {
+	var amount int64
+	err := conn.QueryRowContext(ctx, "SELECT amount FROM isolation_balances WHERE id = ?", id).Scan(&amount)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return amount
+}
+
+// dirtyRead reads the amount a concurrent transaction changed and then rolled back
+func dirtyRead(t *testing.T, conn *sql.DB, begin func(t *testing.T) *session, lock string) bool {
+	resetIsolationTables(t, conn, 100, 100)
+	writer, reader := begin(t), begin(t)
+
+	var seen int64
+	writer.exec("UPDATE isolation_balances SET amount = 150 WHERE id = 1")
+	reader.query(&seen, "SELECT amount FROM isolation_balances WHERE id = 1"+lock)
+	writer.rollback()
+	reader.commit()
+
+	survived(t, writer)
+	survived(t, reader)
+	return seen == 150
+}
+
+// lostUpdate deposits 10 from both sessions by reading the amount and writing the sum back,
+// the anomaly is a committed deposit that isn't in the amount
+func lostUpdate(t *testing.T, conn *sql.DB, begin func(t *testing.T) *session, lock string) bool {
+	resetIsolationTables(t, conn, 100, 100)
+	first, second := begin(t), begin(t)
+
+	var firstRead, secondRead int64
+	first.query(&firstRead, "SELECT amount FROM isolation_balances WHERE id = 1"+lock)
+	second.query(&secondRead, "SELECT amount FROM isolation_balances WHERE id = 1"+lock)
+	first.run(func(tx isolationTx) error {
+		_, err := tx.ExecContext(ctx, "UPDATE isolation_balances SET amount = ? WHERE id = 1", firstRead+10)
+		return err
+	})
+	second.run(func(tx isolationTx) error {
+		_, err := tx.ExecContext(ctx, "UPDATE isolation_balances SET amount = ? WHERE id = 1", secondRead+10)
+		return err
+	})
+	first.commit()
+	second.commit()
+
+	expected := int64(100)
+	for _, s := range []*session{first, second} {
+		if survived(t, s) {
+			expected += 10
+		}
+	}
+	return isolationAmount(t, conn, 1) != expected
+}
+
+// writeSkew withdraws 80 from a different balance in each session, both check that the two balances
+// together have enough, the anomaly is a negative sum
+func writeSkew(t *testing.T, conn *sql.DB, begin func(t *testing.T) *session, lock string) bool {
+	resetIsolationTables(t, conn, 50, 50)
+	first, second := begin(t), begin(t)
+
+	withdraw := func(id int, sum *int64) func(tx isolationTx) error {
+		return func(tx isolationTx) error {
+			if *sum < 80 {
+				return nil
+			}
+			_, err := tx.ExecContext(ctx, "UPDATE isolation_balances SET amount = amount - 80 WHERE id = ?", id)
+			return err
+		}
+	}
+
+	var firstSum, secondSum int64
+	first.query(&firstSum, "SELECT SUM(amount) FROM isolation_balances WHERE id IN (1, 2)"+lock)
+	second.query(&secondSum, "SELECT SUM(amount) FROM isolation_balances WHERE id IN (1, 2)"+lock)
+	first.run(withdraw(1, &firstSum))
+	second.run(withdraw(2, &secondSum))
+	first.commit()
+	second.commit()
+
+	survived(t, first)
+	survived(t, second)
+	return isolationAmount(t, conn, 1)+isolationAmount(t, conn, 2) < 0
+}
+
+// phantom counts entries of a balance twice while a concurrent transaction adds one
+func phantom(t *testing.T, conn *sql.DB, begin func(t *testing.T) *session, lock string) bool {
+	resetIsolationTables(t, conn, 100, 100)
+	reader, writer := begin(t), begin(t)
+
+	var before, after int64
+	reader.query(&before, "SELECT COUNT(*) FROM isolation_entries WHERE balance_id = 1"+lock)
+	writer.exec("INSERT INTO isolation_entries (balance_id, amount) VALUES (1, 10)")
+	writer.commit()
+	reader.query(&after, "SELECT COUNT(*) FROM isolation_entries WHERE balance_id = 1"+lock)
+	reader.commit()
+
+	survived(t, reader)
+	survived(t, writer)
+	return before != after
+}
+
+func TestIsolation(t *testing.T) {
+	t.Parallel()
+	conn, storage, _ := newLedger(t)
+
+	createIsolationTables(t, conn)
+
+	anomalies := []struct {
+		name   string
+		occurs func(t *testing.T, conn *sql.DB, begin func(t *testing.T) *session, lock string) bool
+	}{
+		{"dirty read", dirtyRead},
+		{"lost update", lostUpdate},
+		{"write skew", writeSkew},
+		{"phantom", phantom},
+	}
+
+	levels := []struct {
+		name  string
+		level sql.IsolationLevel
+		// anomalies that occur with plain reads and with reads FOR UPDATE
+		plain   []string
+		locking []string
+	}{
+		{"read uncommitted", sql.LevelReadUncommitted, []string{"dirty read", "lost update", "write skew", "phantom"}, []string{"phantom"}},
+		{"read committed", sql.LevelReadCommitted, []string{"lost update", "write skew", "phantom"}, []string{"phantom"}},
+		// reads see the snapshot of the first read, but writes go to the latest version
+		{"repeatable read", sql.LevelRepeatableRead, []string{"lost update", "write skew"}, nil},
+		// plain reads take shared locks, conflicting writers deadlock and one of them is rolled back
+		{"serializable", sql.LevelSerializable, nil, nil},
+	}
+
+	for _, level := range levels {
+		begin := func(t *testing.T) *session {
+			tx, err := conn.BeginTx(ctx, &sql.TxOptions{Isolation: level.level})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return newSession(t, tx)
+		}
+
+		for _, anomaly := range anomalies {
+			t.Run(fmt.Sprintf("Test %s at %s", anomaly.name, level.name), func(t *testing.T) {
+				assert.Equal(t, slices.Contains(level.plain, anomaly.name), anomaly.occurs(t, conn, begin, ""))
+			})
+
+			t.Run(fmt.Sprintf("Test %s at %s with FOR UPDATE", anomaly.name, level.name), func(t *testing.T) {
+				assert.Equal(t, slices.Contains(level.locking, anomaly.name), anomaly.occurs(t, conn, begin, " FOR UPDATE"))
+			})
+		}
+	}
+
+	t.Run("Test production configuration has no anomalies", func(t *testing.T) {
+		// the service begins transactions with the default level of the server and locks rows it changes
+		begin := func(t *testing.T) *session {
+			tx, err := storage.BeginTx(ctx, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return newSession(t, tx)
+		}
+
+		for _, anomaly := range anomalies {
+			assert.False(t, anomaly.occurs(t, conn, begin, " FOR UPDATE"), anomaly.name)
+		}
+	})
+}
+
+func TestPortfolio(t *testing.T) {
+	t.Parallel()
+	_, _, services := newLedger(t)
+
+	user, err := services.CreateUser(ctx, "portfolio_"+strconv.FormatInt(time.Now().UnixNano(), 10))
+	assert.Nil(t, err)
+	usd, err := services.OpenBalance(ctx, user.ID, 1)
+	assert.Nil(t, err)
+	eur, err := services.OpenBalance(ctx, user.ID, 2)
+	assert.Nil(t, err)
+
+	_, err = services.Deposit(ctx, usd.ID, amountOf(t, services, usd.ID, 10000))
+	assert.Nil(t, err)
+	_, err = services.Deposit(ctx, eur.ID, amountOf(t, services, eur.ID, 5000))
+	assert.Nil(t, err)
+
+	t.Run("Test invalid exchange rates", func(t *testing.T) {
+		assert.ErrorIs(t, services.SetExchangeRate(ctx, "EUR", "USD", "-1"), service.ErrInvalidRate)
+		assert.ErrorIs(t, services.SetExchangeRate(ctx, "EUR", "USD", "0"), service.ErrInvalidRate)
+		assert.ErrorIs(t, services.SetExchangeRate(ctx, "EUR", "EUR", "1"), service.ErrSameCurrency)
+		assert.ErrorIs(t, services.SetExchangeRate(ctx, "EUR", "XXX", "1"), sql.ErrNoRows)
+	})
+
+	t.Run("Test total is converted with direct and inverse rates", func(t *testing.T) {
+		err := services.SetExchangeRate(ctx, "EUR", "USD", "1.0835")
+		assert.Nil(t, err)
+
+		portfolio, err := services.UserPortfolio(ctx, user.ID, "USD")
+		assert.Nil(t, err)
+		assert.Equal(t, portfolio.User.ID, user.ID)
+		assert.Len(t, portfolio.Balances, 2)
+		// 50.00 EUR is 54.175 USD, rounded half away from zero
+		assert.Equal(t, portfolio.Total, money.New(15418, usd.Currency))
+		assert.Equal(t, portfolio.Rates, map[string]string{"EUR": "1.0835"})
+
+		portfolio, err = services.UserPortfolio(ctx, user.ID, "EUR")
+		assert.Nil(t, err)
+		// 100.00 USD is 92.29349... EUR
+		assert.Equal(t, portfolio.Total, money.New(14229, eur.Currency))
+		assert.Equal(t, portfolio.Rates, map[string]string{"USD": "0.922934933087217351"})
+	})
+
+	t.Run("Test rate is updated", func(t *testing.T) {
+		err := services.SetExchangeRate(ctx, "EUR", "USD", "2")
+		assert.Nil(t, err)
+		defer services.SetExchangeRate(ctx, "EUR", "USD", "1.0835")
+
+		portfolio, err := services.UserPortfolio(ctx, user.ID, "USD")
+		assert.Nil(t, err)
+		assert.Equal(t, portfolio.Total.Amount, int64(20000))
+	})
+
+	t.Run("Test rate isn't changed in dry run", func(t *testing.T) {
+		err := services.SetExchangeRate(service.WithDryRun(ctx), "EUR", "USD", "2")
+		assert.Nil(t, err)
+
+		portfolio, err := services.UserPortfolio(ctx, user.ID, "USD")
+		assert.Nil(t, err)
+		assert.Equal(t, portfolio.Rates["EUR"], "1.0835")
+	})
+
+	t.Run("Test missing rate", func(t *testing.T) {
+		_, err := services.OpenBalance(ctx, user.ID, 3)
+		assert.Nil(t, err)
+
+		_, err = services.UserPortfolio(ctx, user.ID, "USD")
+		assert.ErrorIs(t, err, service.ErrNoExchangeRate)
+
+		_, err = services.UserPortfolio(ctx, user.ID, "XXX")
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	})
+
+	t.Run("Test balances are read from one snapshot during transfers", func(t *testing.T) {
+		from := openBalance(t, services, "snapshot_from", 1)
+		to := openBalance(t, services, "snapshot_to", 1)
+		_, err := services.Deposit(ctx, from.ID, amountOf(t, services, from.ID, 1000))
+		assert.Nil(t, err)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 50; i++ {
+				_, _, err := services.Transfer(ctx, from.ID, to.ID, money.New(1, from.Currency))
+				assert.Nil(t, err)
+			}
+		}()
+
+		for running := true; running; {
+			select {
+			case <-done:
+				running = false
+			default:
+			}
+
+			balances, err := services.GetAllBalances(ctx)
+			assert.Nil(t, err)
+			sum := int64(0)
+			for _, balance := range balances {
+				if balance.ID == from.ID || balance.ID == to.ID {
+					sum += balance.Amount
+				}
+			}
+			if !assert.Equal(t, sum, int64(1000)) {
+				<-done
+				break
+			}
+		}
 	})
 }
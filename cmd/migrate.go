@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tredoc/go-balances/internal/config"
+	"github.com/tredoc/go-balances/internal/schema"
+	"os"
+	"strconv"
+)
+
+const migrateUsage = `usage: go-balances migrate <command>
+
+commands:
+  up           apply all pending migrations
+  down [n]     roll back n migrations, 1 by default
+  status       print applied version and pending migrations
+  goto <v>     migrate up or down to version v
+  force <v>    set version v without migrating, after a failed migration was fixed by hand
+`
+
+func runMigrate(cfg config.Config, args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		fmt.Print(migrateUsage)
+		os.Exit(2)
+	}
+
+	migrator, err := schema.Open(cfg.DB)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	switch {
+	case args[0] == "up" && len(args) == 1:
+		err = migrator.Up()
+	case args[0] == "down":
+		steps := 1
+		if len(args) == 2 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+		err = migrator.Down(steps)
+	case (args[0] == "goto" || args[0] == "force") && len(args) == 2:
+		version, parseErr := strconv.ParseUint(args[1], 10, 64)
+		if parseErr != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		if args[0] == "goto" {
+			err = migrator.Goto(uint(version))
+		} else {
+			err = migrator.Force(uint(version))
+		}
+	case args[0] == "status" && len(args) == 1:
+	default:
+		fmt.Print(migrateUsage)
+		os.Exit(2)
+	}
+	if err != nil {
+		return err
+	}
+
+	status, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("version %d, expected %d, latest %d\n", status.Version, status.Expected, status.Latest)
+	if status.Dirty {
+		fmt.Println("dirty: the last migration failed, fix the schema by hand and run force")
+	}
+	for _, migration := range status.Pending {
+		fmt.Printf("pending %06d_%s\n", migration.Version, migration.Name)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"github.com/redis/go-redis/v9"
+	"github.com/tredoc/go-balances/internal/config"
+	"github.com/tredoc/go-balances/internal/health"
+	"github.com/tredoc/go-balances/internal/logging"
+	"github.com/tredoc/go-balances/internal/metrics"
+	"github.com/tredoc/go-balances/internal/schema"
+	"github.com/tredoc/go-balances/internal/store"
+	"github.com/tredoc/go-balances/internal/stream"
+	"github.com/tredoc/go-balances/internal/tracing"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func runServe(cfg config.Config, args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", cfg.Server.HTTPAddr, "http listen address")
+	interval := flags.Duration("interval", stream.DefaultInterval, "pause between outbox polls when there are no events")
+	gapTimeout := flags.Duration("gap-timeout", stream.DefaultGapTimeout, "how long updates wait for an uncommitted smaller event id, events committed later are not streamed")
+	flags.Parse(args)
+
+	err := schema.Ensure(cfg.DB, cfg.Server.AutoMigrate)
+	if err != nil {
+		return err
+	}
+
+	conn, err := store.Open(cfg.DB)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	storage := newStore(cfg, conn)
+	hub := stream.NewHub(storage)
+	hub.Interval = *interval
+	hub.GapTimeout = *gapTimeout
+
+	var redisClient *redis.Client
+	if cfg.Server.ReadyRedis {
+		redisAddr, err := cfg.Redis.Address()
+		if err != nil {
+			return err
+		}
+		redisClient = redis.NewClient(&redis.Options{Addr: redisAddr, Password: cfg.Redis.Pass, DB: cfg.Redis.DB})
+		defer redisClient.Close()
+	}
+
+	mux := http.NewServeMux()
+	health.New(storage, redisClient).Register(mux, cfg.Features.Diagnostics)
+	if cfg.Features.Stream {
+		mux.Handle("GET /stream", hub.Handler())
+	}
+	if cfg.Features.Metrics {
+		mux.Handle("GET /metrics", metrics.Handler(metrics.NewRegistry(storage)))
+	}
+
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           logging.Handler(tracing.Handler(mux)),
+		ReadHeaderTimeout: 10 * time.Second,
+		// streams are long lived, they end on shutdown when the hub closes subscriptions
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	errs := make(chan error, 2)
+	if cfg.Features.Stream {
+		go func() {
+			errs <- hub.Run(ctx)
+		}()
+	}
+	go func() {
+		slog.Info("listening", "addr", *addr)
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err = <-errs:
+		stop()
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	shutdownErr := server.Shutdown(shutdownCtx)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return shutdownErr
+}
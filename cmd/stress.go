@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/tredoc/go-balances/internal/config"
+	"github.com/tredoc/go-balances/internal/store"
+	"github.com/tredoc/go-balances/internal/stress"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// stressDBSuffix marks databases dedicated to stress runs, the command refuses to fill other ones without -y
+const stressDBSuffix = "_stress"
+
+func runStress(cfg config.Config, args []string) error {
+	flags := flag.NewFlagSet("stress", flag.ExitOnError)
+	balances := flags.Int("balances", stress.DefaultBalances, "number of fresh balances the workload runs on")
+	workers := flags.Int("workers", stress.DefaultWorkers, "number of concurrent workers")
+	duration := flags.Duration("duration", stress.DefaultDuration, "how long workers issue operations")
+	mix := flags.String("mix", "1,1,2", "weights of deposits, withdrawals, transfers and optionally reads")
+	maxAmount := flags.Int64("max-amount", stress.DefaultMaxAmount, "operation amounts are random from 1 to this many minor units")
+	initial := flags.Int64("initial", stress.DefaultInitialAmount, "amount deposited to every balance before the run, in minor units")
+	currencies := flags.String("currencies", "USD", "comma separated currencies the balances are spread over")
+	seed := flags.Uint64("seed", 0, "seed of the workload, random by default, the report prints it")
+	checkHistory := flags.Bool("check-history", false, "record every operation and check that the history is linearizable")
+	asJSON := flags.Bool("json", false, "print the report as json")
+	yes := flags.Bool("y", false, "run on a database whose name doesn't end with "+stressDBSuffix+", the run leaves its users and balances there")
+	flags.Parse(args)
+
+	if !strings.HasSuffix(cfg.DB.Name, stressDBSuffix) && !*yes {
+		return fmt.Errorf("database %s isn't dedicated to stress runs (name ending with %s), pass -y to run on it anyway", cfg.DB.Name, stressDBSuffix)
+	}
+
+	weights, err := parseMix(*mix)
+	if err != nil {
+		flags.Usage()
+		return err
+	}
+
+	conn, err := store.Open(cfg.DB)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	services := newServices(cfg, conn)
+	// every rejection and retry is logged otherwise, the report has them all
+	services.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	runner := stress.New(services)
+	runner.Balances = *balances
+	runner.Workers = *workers
+	runner.Duration = *duration
+	runner.Mix = weights
+	runner.MaxAmount = *maxAmount
+	runner.InitialAmount = *initial
+	runner.Currencies = strings.Split(*currencies, ",")
+	runner.Seed = *seed
+	runner.CheckHistory = *checkHistory
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	report, err := runner.Run(ctx)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(report)
+		if err != nil {
+			return err
+		}
+	} else {
+		report.Print(os.Stdout)
+	}
+
+	if len(report.Violations) > 0 {
+		return fmt.Errorf("%d invariant violations", len(report.Violations))
+	}
+	return nil
+}
+
+// parseMix reads "deposits,withdrawals,transfers[,reads]" weights
+func parseMix(s string) (stress.Mix, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 3 && len(parts) != 4 {
+		return stress.Mix{}, errors.New("mix must be three or four comma separated weights")
+	}
+
+	var weights [4]int
+	for i, part := range parts {
+		weight, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return stress.Mix{}, fmt.Errorf("mix: %w", err)
+		}
+		weights[i] = weight
+	}
+
+	return stress.Mix{Deposits: weights[0], Withdrawals: weights[1], Transfers: weights[2], Reads: weights[3]}, nil
+}
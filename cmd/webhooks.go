@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/tredoc/go-balances/internal/config"
+	"github.com/tredoc/go-balances/internal/store"
+	"github.com/tredoc/go-balances/internal/webhook"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+const webhooksUsage = `usage: go-balances webhooks <command> [flags]
+
+commands:
+  subscribe  -url <url> -secret <secret> -events deposit.completed,withdraw.rejected
+  list       print subscriptions
+  disable    -id <subscription id>
+  enable     -id <subscription id>
+  deliver    send due deliveries with retries, -once exits when nothing is due
+  replay     -id <subscription id> -from <RFC3339> -to <RFC3339>
+`
+
+func runWebhooks(cfg config.Config, args []string) error {
+	if len(args) == 0 {
+		fmt.Print(webhooksUsage)
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet("webhooks "+args[0], flag.ExitOnError)
+	id := flags.Uint64("id", 0, "subscription id")
+	url := flags.String("url", "", "url that receives notifications")
+	secret := flags.String("secret", "", "secret that signs notifications, at least 16 characters")
+	events := flags.String("events", "", "comma separated event types")
+	from := flags.String("from", "", "start of the replay window, RFC3339")
+	to := flags.String("to", "", "end of the replay window, RFC3339, now by default")
+	once := flags.Bool("once", false, "send due deliveries and exit")
+	flags.Parse(args[1:])
+
+	if !cfg.Features.Webhooks {
+		return errors.New("webhooks are disabled by FEATURE_WEBHOOKS")
+	}
+
+	conn, err := store.Open(cfg.DB)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	webhooks := webhook.New(newStore(cfg, conn), &http.Client{Timeout: 10 * time.Second})
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch args[0] {
+	case "subscribe":
+		subscription, err := webhooks.Subscribe(ctx, *url, *secret, strings.Split(*events, ","))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("subscription %d created\n", subscription.ID)
+	case "list":
+		subscriptions, err := webhooks.GetSubscriptions(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tURL\tEVENTS\tACTIVE")
+		for _, s := range subscriptions {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%t\n", s.ID, s.Url, s.EventTypes, s.Active)
+		}
+		return w.Flush()
+	case "disable", "enable":
+		if *id == 0 {
+			return errors.New("id is required")
+		}
+		return webhooks.SetActive(ctx, *id, args[0] == "enable")
+	case "deliver":
+		if !*once {
+			return webhooks.Run(ctx)
+		}
+
+		for {
+			attempted, err := webhooks.DeliverOnce(ctx)
+			if err != nil || attempted == 0 {
+				return err
+			}
+		}
+	case "replay":
+		if *id == 0 || *from == "" {
+			return errors.New("id and from are required")
+		}
+
+		start, err := time.Parse(time.RFC3339, *from)
+		if err != nil {
+			return fmt.Errorf("invalid from: %w", err)
+		}
+
+		end := time.Now()
+		if *to != "" {
+			end, err = time.Parse(time.RFC3339, *to)
+			if err != nil {
+				return fmt.Errorf("invalid to: %w", err)
+			}
+		}
+
+		replayed, err := webhooks.Replay(ctx, *id, start, end)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d deliveries scheduled\n", replayed)
+	default:
+		fmt.Print(webhooksUsage)
+		os.Exit(2)
+	}
+
+	return nil
+}
@@ -0,0 +1,15 @@
+ALTER TABLE balances ADD COLUMN held_amount BIGINT NOT NULL DEFAULT 0 COMMENT 'sum of pending holds, can be only positive';
+
+CREATE TABLE IF NOT EXISTS holds (
+    id BIGINT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
+    balance_id BIGINT UNSIGNED NOT NULL,
+    amount BIGINT NOT NULL COMMENT 'can be only positive',
+    captured_amount BIGINT NOT NULL DEFAULT 0,
+    status varchar(20) NOT NULL DEFAULT 'pending' COMMENT 'pending, captured, voided or expired',
+    expires_at DATETIME NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX holds_index_0 ON holds(`balance_id`, `status`, `expires_at`);
+
+ALTER TABLE holds ADD FOREIGN KEY (balance_id) REFERENCES balances(`id`);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS balance_policies (
+    balance_id BIGINT UNSIGNED PRIMARY KEY,
+    overdraft_limit BIGINT NOT NULL DEFAULT 0 COMMENT 'how far below min_balance amount can go, can be only positive',
+    min_balance BIGINT NOT NULL DEFAULT 0,
+    frozen BOOLEAN NOT NULL DEFAULT FALSE,
+    max_transaction_amount BIGINT NOT NULL DEFAULT 0 COMMENT '0 means no limit'
+);
+
+ALTER TABLE balance_policies ADD FOREIGN KEY (balance_id) REFERENCES balances(`id`);
@@ -0,0 +1,10 @@
+ALTER TABLE balance_policies ADD COLUMN frozen BOOLEAN NOT NULL DEFAULT FALSE;
+
+INSERT INTO balance_policies (balance_id, frozen)
+SELECT id, TRUE FROM balances
+WHERE status IN ('frozen_debit', 'frozen_all')
+ON DUPLICATE KEY UPDATE frozen = TRUE;
+
+DROP TABLE IF EXISTS balance_status_changes;
+
+ALTER TABLE balances DROP COLUMN status;
@@ -0,0 +1,22 @@
+ALTER TABLE balances ADD COLUMN status varchar(20) NOT NULL DEFAULT 'active' COMMENT 'active, frozen_debit, frozen_all or closed';
+
+CREATE TABLE IF NOT EXISTS balance_status_changes (
+    id BIGINT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
+    balance_id BIGINT UNSIGNED NOT NULL,
+    from_status varchar(20) NOT NULL,
+    to_status varchar(20) NOT NULL,
+    actor varchar(255) NOT NULL,
+    reason varchar(1000) NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX balance_status_changes_index_0 ON balance_status_changes(`balance_id`);
+
+ALTER TABLE balance_status_changes ADD FOREIGN KEY (balance_id) REFERENCES balances(`id`);
+
+UPDATE balances
+JOIN balance_policies ON balance_policies.balance_id = balances.id
+SET balances.status = 'frozen_all'
+WHERE balance_policies.frozen = TRUE;
+
+ALTER TABLE balance_policies DROP COLUMN frozen;
@@ -0,0 +1,5 @@
+ALTER TABLE transfers DROP FOREIGN KEY transfers_ibfk_3;
+
+ALTER TABLE transfers DROP COLUMN batch_id;
+
+DROP TABLE IF EXISTS transfer_batches;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS transfer_batches (
+    id BIGINT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
+    legs INT UNSIGNED NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE transfers ADD COLUMN batch_id BIGINT UNSIGNED NULL COMMENT 'set only for transfers made by batch';
+
+ALTER TABLE transfers ADD FOREIGN KEY (batch_id) REFERENCES transfer_batches(`id`);
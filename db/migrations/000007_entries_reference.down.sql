@@ -0,0 +1,3 @@
+DROP INDEX entries_index_0 ON entries;
+
+ALTER TABLE entries DROP COLUMN reference;
@@ -0,0 +1,3 @@
+ALTER TABLE entries ADD COLUMN reference varchar(255) NULL COMMENT 'external reference of imported entry';
+
+CREATE UNIQUE INDEX entries_index_0 ON entries(`reference`);
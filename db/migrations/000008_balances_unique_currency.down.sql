@@ -0,0 +1,5 @@
+ALTER TABLE balances DROP FOREIGN KEY balances_ibfk_1;
+
+DROP INDEX balances_index_0 ON balances;
+
+ALTER TABLE balances ADD CONSTRAINT balances_ibfk_1 FOREIGN KEY (user_id) REFERENCES users(`id`);
@@ -0,0 +1 @@
+CREATE UNIQUE INDEX balances_index_0 ON balances(`user_id`, `currency_id`);
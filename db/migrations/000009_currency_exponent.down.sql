@@ -0,0 +1 @@
+ALTER TABLE currencies DROP COLUMN exponent;
@@ -0,0 +1,3 @@
+ALTER TABLE currencies ADD COLUMN exponent TINYINT UNSIGNED NOT NULL DEFAULT 2 COMMENT 'number of minor units digits, amounts are stored in minor units';
+
+UPDATE currencies SET exponent = 6 WHERE name = 'USDT';
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGINT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
+    actor varchar(255) NOT NULL,
+    client varchar(255) NOT NULL,
+    request_id varchar(255) NOT NULL,
+    operation varchar(20) NOT NULL COMMENT 'deposit, withdraw or transfer',
+    params TEXT NOT NULL COMMENT 'operation parameters as json',
+    pre_amounts TEXT NOT NULL COMMENT 'json object of balance id to amount before the operation',
+    post_amounts TEXT NOT NULL COMMENT 'json object of balance id to amount after the operation, same as pre_amounts for rejected attempts',
+    outcome varchar(20) NOT NULL COMMENT 'applied or rejected',
+    error_message varchar(1000) NOT NULL DEFAULT '',
+    prev_hash CHAR(64) NOT NULL,
+    hash CHAR(64) NOT NULL COMMENT 'sha256 of prev_hash and all other columns except id',
+    created_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS audit_chain (
+    id TINYINT UNSIGNED PRIMARY KEY COMMENT 'always 1, locking the row serializes appends to audit_log',
+    last_id BIGINT UNSIGNED NOT NULL,
+    hash CHAR(64) NOT NULL
+);
+
+INSERT INTO audit_chain (id, last_id, hash) VALUES (1, 0, REPEAT('0', 64));
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS outbox_events (
+    id BIGINT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
+    balance_id BIGINT UNSIGNED NOT NULL,
+    event_type varchar(50) NOT NULL,
+    payload TEXT NOT NULL COMMENT 'event as json',
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    delivered_at DATETIME NULL COMMENT 'null while the event is pending',
+    attempts INT UNSIGNED NOT NULL DEFAULT 0,
+    last_error varchar(1000) NOT NULL DEFAULT ''
+);
+
+CREATE INDEX outbox_events_index_0 ON outbox_events(`delivered_at`, `id`);
+
+ALTER TABLE outbox_events ADD FOREIGN KEY (balance_id) REFERENCES balances(`id`);
@@ -0,0 +1,7 @@
+DROP INDEX outbox_events_index_1 ON outbox_events;
+
+DROP TABLE IF EXISTS webhook_attempts;
+
+DROP TABLE IF EXISTS webhook_deliveries;
+
+DROP TABLE IF EXISTS webhook_subscriptions;
@@ -0,0 +1,43 @@
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    id BIGINT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
+    url varchar(2048) NOT NULL,
+    secret varchar(255) NOT NULL COMMENT 'hmac-sha256 key of payload signatures',
+    event_types varchar(1000) NOT NULL COMMENT 'comma separated list like deposit.completed,withdraw.rejected',
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id BIGINT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
+    subscription_id BIGINT UNSIGNED NOT NULL,
+    event_id BIGINT UNSIGNED NOT NULL,
+    event_type varchar(50) NOT NULL,
+    status varchar(20) NOT NULL DEFAULT 'pending' COMMENT 'pending, delivered or failed',
+    attempts INT UNSIGNED NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    delivered_at DATETIME NULL
+);
+
+CREATE UNIQUE INDEX webhook_deliveries_index_0 ON webhook_deliveries(`subscription_id`, `event_id`);
+
+CREATE INDEX webhook_deliveries_index_1 ON webhook_deliveries(`status`, `next_attempt_at`);
+
+ALTER TABLE webhook_deliveries ADD FOREIGN KEY (subscription_id) REFERENCES webhook_subscriptions(`id`);
+
+ALTER TABLE webhook_deliveries ADD FOREIGN KEY (event_id) REFERENCES outbox_events(`id`);
+
+CREATE TABLE IF NOT EXISTS webhook_attempts (
+    id BIGINT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
+    delivery_id BIGINT UNSIGNED NOT NULL,
+    status_code INT NOT NULL COMMENT '0 if there was no response',
+    error_message varchar(1000) NOT NULL DEFAULT '',
+    duration_ms INT UNSIGNED NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX webhook_attempts_index_0 ON webhook_attempts(`delivery_id`);
+
+ALTER TABLE webhook_attempts ADD FOREIGN KEY (delivery_id) REFERENCES webhook_deliveries(`id`);
+
+CREATE INDEX outbox_events_index_1 ON outbox_events(`created_at`);
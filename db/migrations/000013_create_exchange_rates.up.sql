@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS exchange_rates (
+    base_currency_id BIGINT UNSIGNED NOT NULL,
+    quote_currency_id BIGINT UNSIGNED NOT NULL,
+    rate DECIMAL(36, 18) NOT NULL COMMENT 'units of the quote currency for one unit of the base currency',
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (base_currency_id, quote_currency_id)
+);
+
+CREATE INDEX exchange_rates_index_0 ON exchange_rates(`quote_currency_id`);
+
+ALTER TABLE exchange_rates ADD FOREIGN KEY (base_currency_id) REFERENCES currencies(`id`);
+
+ALTER TABLE exchange_rates ADD FOREIGN KEY (quote_currency_id) REFERENCES currencies(`id`);
@@ -0,0 +1 @@
+ALTER TABLE audit_log MODIFY COLUMN operation varchar(20) NOT NULL COMMENT 'deposit, withdraw, transfer, authorize, capture, void, batch, import, status or policy';
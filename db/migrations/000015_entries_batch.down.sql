@@ -0,0 +1,5 @@
+DELETE FROM entries WHERE batch_id IS NOT NULL;
+
+ALTER TABLE entries DROP FOREIGN KEY entries_ibfk_2;
+
+ALTER TABLE entries DROP COLUMN batch_id;
@@ -0,0 +1,11 @@
+ALTER TABLE entries ADD COLUMN batch_id BIGINT UNSIGNED NULL COMMENT 'set only for entries of batch legs';
+
+ALTER TABLE entries ADD FOREIGN KEY (batch_id) REFERENCES transfer_batches(`id`);
+
+INSERT INTO entries (balance_id, amount, batch_id)
+SELECT balance_id, amount, batch_id FROM (
+    SELECT id, 0 AS side, from_balance_id AS balance_id, -amount AS amount, batch_id FROM transfers WHERE batch_id IS NOT NULL
+    UNION ALL
+    SELECT id, 1 AS side, to_balance_id AS balance_id, amount, batch_id FROM transfers WHERE batch_id IS NOT NULL
+) AS legs
+ORDER BY id, side;
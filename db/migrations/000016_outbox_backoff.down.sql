@@ -0,0 +1,3 @@
+DROP INDEX outbox_events_index_2 ON outbox_events;
+
+ALTER TABLE outbox_events DROP COLUMN next_attempt_at;
@@ -0,0 +1,3 @@
+ALTER TABLE outbox_events ADD COLUMN next_attempt_at DATETIME NULL COMMENT 'set after a failed attempt, events of the balance wait for it, null means now';
+
+CREATE INDEX outbox_events_index_2 ON outbox_events(`delivered_at`, `next_attempt_at`);
@@ -0,0 +1 @@
+ALTER TABLE outbox_events ADD COLUMN claimed_until DATETIME NULL COMMENT 'set while a relay publishes the event, other relays skip events of the balance until then';
@@ -0,0 +1,8 @@
+package migrations
+
+import "embed"
+
+// FS holds migration files, so the binary migrates the database without the source tree
+//
+//go:embed *.sql
+var FS embed.FS
@@ -0,0 +1,28 @@
+-- name: GetAuditChain :one
+SELECT * FROM audit_chain
+WHERE id = 1;
+
+-- name: GetAuditChainForUpdate :one
+SELECT * FROM audit_chain
+WHERE id = 1
+FOR UPDATE;
+
+-- name: UpdateAuditChain :exec
+UPDATE audit_chain
+SET last_id = ?, hash = ?
+WHERE id = 1;
+
+-- name: CreateAuditRecord :execlastid
+INSERT INTO audit_log (
+    actor, client, request_id, operation, params, pre_amounts, post_amounts, outcome, error_message, prev_hash, hash, created_at
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+
+-- name: GetAuditRecordByID :one
+SELECT * FROM audit_log
+WHERE id = ?;
+
+-- name: GetAuditRecords :many
+SELECT * FROM audit_log
+WHERE id > ?
+ORDER BY id
+LIMIT ?;
@@ -17,4 +17,73 @@ WHERE id = ?;
 -- name: GetBalanceByIDForUpdate :one
 SELECT * FROM balances
 WHERE id = ?
-FOR UPDATE;
\ No newline at end of file
+FOR UPDATE;
+
+-- name: UpdateBalanceHeldAmount :exec
+UPDATE balances
+SET held_amount = ?
+WHERE id = ?;
+
+-- name: UpdateBalanceStatus :exec
+UPDATE balances
+SET status = ?
+WHERE id = ?;
+
+-- name: CreateBalanceStatusChange :execlastid
+INSERT INTO balance_status_changes (balance_id, from_status, to_status, actor, reason)
+VALUES (?, ?, ?, ?, ?);
+
+-- name: GetBalanceStatusChangesByBalanceID :many
+SELECT * FROM balance_status_changes
+WHERE balance_id = ?
+ORDER BY id;
+
+-- name: GetBalanceByUsernameAndCurrency :one
+SELECT balances.* FROM balances
+JOIN users ON users.id = balances.user_id
+JOIN currencies ON currencies.id = balances.currency_id
+WHERE users.username = ? AND currencies.name = ?;
+
+-- name: GetBalanceByUserIDAndCurrencyID :one
+SELECT * FROM balances
+WHERE user_id = ? AND currency_id = ?;
+
+-- name: CreateBalance :execlastid
+INSERT INTO balances (user_id, currency_id)
+VALUES (?, ?);
+
+-- name: GetTotalsByCurrency :many
+SELECT currencies.name, currencies.exponent, CAST(COALESCE(SUM(balances.amount), 0) AS DOUBLE) AS total
+FROM currencies
+LEFT JOIN balances ON balances.currency_id = currencies.id
+GROUP BY currencies.id, currencies.name, currencies.exponent
+ORDER BY currencies.name;
+
+-- name: GetBalanceDiscrepancies :many
+SELECT id, amount, held_amount,
+    CAST(
+        (SELECT COALESCE(SUM(entries.amount), 0) FROM entries WHERE entries.balance_id = balances.id)
+        + (SELECT COALESCE(SUM(transfers.amount), 0) FROM transfers WHERE transfers.to_balance_id = balances.id AND transfers.batch_id IS NULL)
+        - (SELECT COALESCE(SUM(transfers.amount), 0) FROM transfers WHERE transfers.from_balance_id = balances.id AND transfers.batch_id IS NULL)
+    AS SIGNED) AS expected_amount,
+    CAST(
+        (SELECT COALESCE(SUM(holds.amount), 0) FROM holds WHERE holds.balance_id = balances.id AND holds.status = 'pending')
+    AS SIGNED) AS expected_held_amount
+FROM balances
+HAVING amount <> expected_amount OR held_amount <> expected_held_amount
+ORDER BY id;
+
+-- name: GetBalanceDiscrepanciesByIDs :many
+SELECT id, amount, held_amount,
+    CAST(
+        (SELECT COALESCE(SUM(entries.amount), 0) FROM entries WHERE entries.balance_id = balances.id)
+        + (SELECT COALESCE(SUM(transfers.amount), 0) FROM transfers WHERE transfers.to_balance_id = balances.id AND transfers.batch_id IS NULL)
+        - (SELECT COALESCE(SUM(transfers.amount), 0) FROM transfers WHERE transfers.from_balance_id = balances.id AND transfers.batch_id IS NULL)
+    AS SIGNED) AS expected_amount,
+    CAST(
+        (SELECT COALESCE(SUM(holds.amount), 0) FROM holds WHERE holds.balance_id = balances.id AND holds.status = 'pending')
+    AS SIGNED) AS expected_held_amount
+FROM balances
+WHERE id IN (sqlc.slice('ids'))
+HAVING amount <> expected_amount OR held_amount <> expected_held_amount
+ORDER BY id;
@@ -3,4 +3,12 @@ SELECT * FROM currencies
 WHERE id = ?;
 
 -- name: GetAllCurrencies :many
-SELECT * FROM currencies;
\ No newline at end of file
+SELECT * FROM currencies;
+
+-- name: GetCurrencyByName :one
+SELECT * FROM currencies
+WHERE name = ?;
+
+-- name: CreateCurrency :execlastid
+INSERT INTO currencies (name, exponent)
+VALUES (?, ?);
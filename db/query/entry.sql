@@ -16,4 +16,21 @@ VALUES (?, ?);
 -- name: GetLastEntryID :one
 SELECT id FROM entries
 ORDER BY id DESC
-LIMIT 1;
\ No newline at end of file
+LIMIT 1;
+
+-- name: CreateEntryWithReference :execlastid
+INSERT INTO entries (balance_id, amount, reference)
+VALUES (?, ?, ?);
+
+-- name: GetEntryByReference :one
+SELECT * FROM entries
+WHERE reference = ?;
+
+-- name: CreateBatchEntry :execlastid
+INSERT INTO entries (balance_id, amount, batch_id)
+VALUES (?, ?, ?);
+
+-- name: GetEntriesByBatchID :many
+SELECT * FROM entries
+WHERE batch_id = ?
+ORDER BY id;
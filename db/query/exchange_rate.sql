@@ -0,0 +1,14 @@
+-- name: GetExchangeRate :one
+SELECT * FROM exchange_rates
+WHERE base_currency_id = ? AND quote_currency_id = ?;
+
+-- name: GetExchangeRatesByCurrencyID :many
+SELECT * FROM exchange_rates
+WHERE base_currency_id = sqlc.arg(currency_id) OR quote_currency_id = sqlc.arg(currency_id);
+
+-- name: UpsertExchangeRate :exec
+INSERT INTO exchange_rates (base_currency_id, quote_currency_id, rate)
+VALUES (?, ?, ?)
+ON DUPLICATE KEY UPDATE
+    rate = VALUES(rate),
+    updated_at = CURRENT_TIMESTAMP;
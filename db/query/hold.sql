@@ -0,0 +1,34 @@
+-- name: GetHoldByID :one
+SELECT * FROM holds
+WHERE id = ?;
+
+-- name: GetHoldByIDForUpdate :one
+SELECT * FROM holds
+WHERE id = ?
+FOR UPDATE;
+
+-- name: GetHoldsByBalanceID :many
+SELECT * FROM holds
+WHERE balance_id = ?;
+
+-- name: GetPendingHolds :many
+SELECT * FROM holds
+WHERE status = 'pending';
+
+-- name: GetExpiredHolds :many
+SELECT * FROM holds
+WHERE status = 'pending' AND expires_at <= ?;
+
+-- name: GetExpiredHoldsByBalanceIDForUpdate :many
+SELECT * FROM holds
+WHERE balance_id = ? AND status = 'pending' AND expires_at <= ?
+FOR UPDATE;
+
+-- name: CreateHold :execlastid
+INSERT INTO holds (balance_id, amount, expires_at)
+VALUES (?, ?, ?);
+
+-- name: UpdateHoldStatus :exec
+UPDATE holds
+SET status = ?, captured_amount = ?
+WHERE id = ?;
@@ -0,0 +1,54 @@
+-- name: CreateOutboxEvent :execlastid
+INSERT INTO outbox_events (balance_id, event_type, payload)
+VALUES (?, ?, ?);
+
+-- name: GetOutboxEventByID :one
+SELECT * FROM outbox_events
+WHERE id = ?;
+
+-- name: GetOutboxEventsAfterID :many
+SELECT * FROM outbox_events
+WHERE id > ?
+ORDER BY id
+LIMIT ?;
+
+-- name: GetPendingOutboxEventsForUpdate :many
+SELECT * FROM outbox_events
+WHERE delivered_at IS NULL AND (claimed_until IS NULL OR claimed_until <= sqlc.arg(now)) AND balance_id NOT IN (
+    SELECT balance_id FROM outbox_events
+    WHERE delivered_at IS NULL AND (next_attempt_at > sqlc.arg(now) OR claimed_until > sqlc.arg(now))
+)
+ORDER BY id
+LIMIT ?
+FOR UPDATE;
+
+-- name: ClaimOutboxEvent :exec
+UPDATE outbox_events
+SET claimed_until = ?
+WHERE id = ?;
+
+-- name: ReleaseOutboxEvent :exec
+UPDATE outbox_events
+SET claimed_until = NULL
+WHERE id = ?;
+
+-- name: MarkOutboxEventDelivered :exec
+UPDATE outbox_events
+SET delivered_at = NOW(), attempts = attempts + 1, claimed_until = NULL
+WHERE id = ?;
+
+-- name: MarkOutboxEventFailed :exec
+UPDATE outbox_events
+SET attempts = attempts + 1, last_error = ?, next_attempt_at = ?, claimed_until = NULL
+WHERE id = ?;
+
+-- name: GetOutboxEventsByCreatedAt :many
+SELECT * FROM outbox_events
+WHERE created_at >= sqlc.arg(created_from) AND created_at < sqlc.arg(created_to) AND id > sqlc.arg(after_id)
+ORDER BY id
+LIMIT sqlc.arg(page_size);
+
+-- name: GetLastOutboxEventID :one
+SELECT id FROM outbox_events
+ORDER BY id DESC
+LIMIT 1;
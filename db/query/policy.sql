@@ -0,0 +1,16 @@
+-- name: GetBalancePolicy :one
+SELECT * FROM balance_policies
+WHERE balance_id = ?;
+
+-- name: GetBalancePolicyForShare :one
+SELECT * FROM balance_policies
+WHERE balance_id = ?
+FOR SHARE;
+
+-- name: UpsertBalancePolicy :exec
+INSERT INTO balance_policies (balance_id, overdraft_limit, min_balance, max_transaction_amount)
+VALUES (?, ?, ?, ?)
+ON DUPLICATE KEY UPDATE
+    overdraft_limit = VALUES(overdraft_limit),
+    min_balance = VALUES(min_balance),
+    max_transaction_amount = VALUES(max_transaction_amount);
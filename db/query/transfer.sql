@@ -21,3 +21,20 @@ VALUES (?, ?, ?);
 SELECT id FROM transfers
 ORDER BY id DESC
 LIMIT 1;
+
+-- name: CreateTransferBatch :execlastid
+INSERT INTO transfer_batches (legs)
+VALUES (?);
+
+-- name: GetTransferBatchByID :one
+SELECT * FROM transfer_batches
+WHERE id = ?;
+
+-- name: CreateBatchTransfer :execlastid
+INSERT INTO transfers (from_balance_id, to_balance_id, amount, batch_id)
+VALUES (?, ?, ?, ?);
+
+-- name: GetTransfersByBatchID :many
+SELECT * FROM transfers
+WHERE batch_id = ?
+ORDER BY id;
@@ -3,4 +3,12 @@ SELECT * FROM users
 WHERE id = ?;
 
 -- name: GetAllUsers :many
-SELECT * FROM users;
\ No newline at end of file
+SELECT * FROM users;
+
+-- name: GetUserByUsername :one
+SELECT * FROM users
+WHERE username = ?;
+
+-- name: CreateUser :execlastid
+INSERT INTO users (username)
+VALUES (?);
@@ -0,0 +1,66 @@
+-- name: CreateWebhookSubscription :execlastid
+INSERT INTO webhook_subscriptions (url, secret, event_types)
+VALUES (?, ?, ?);
+
+-- name: GetWebhookSubscriptionByID :one
+SELECT * FROM webhook_subscriptions
+WHERE id = ?;
+
+-- name: GetAllWebhookSubscriptions :many
+SELECT * FROM webhook_subscriptions
+ORDER BY id;
+
+-- name: GetActiveWebhookSubscriptions :many
+SELECT * FROM webhook_subscriptions
+WHERE active = TRUE
+ORDER BY id;
+
+-- name: UpdateWebhookSubscriptionActive :exec
+UPDATE webhook_subscriptions
+SET active = ?
+WHERE id = ?;
+
+-- name: CreateWebhookDelivery :exec
+INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, next_attempt_at)
+VALUES (?, ?, ?, ?)
+ON DUPLICATE KEY UPDATE id = id;
+
+-- name: ReplayWebhookDelivery :exec
+INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, next_attempt_at)
+VALUES (?, ?, ?, ?)
+ON DUPLICATE KEY UPDATE status = 'pending', attempts = 0, next_attempt_at = VALUES(next_attempt_at), delivered_at = NULL;
+
+-- name: GetWebhookDeliveryByID :one
+SELECT * FROM webhook_deliveries
+WHERE id = ?;
+
+-- name: GetWebhookDeliveriesBySubscriptionID :many
+SELECT * FROM webhook_deliveries
+WHERE subscription_id = ?
+ORDER BY id;
+
+-- name: GetDueWebhookDeliveriesForUpdate :many
+SELECT * FROM webhook_deliveries
+WHERE status = 'pending' AND next_attempt_at <= ?
+ORDER BY id
+LIMIT ?
+FOR UPDATE SKIP LOCKED;
+
+-- name: UpdateWebhookDeliveryNextAttempt :exec
+UPDATE webhook_deliveries
+SET next_attempt_at = ?
+WHERE id = ?;
+
+-- name: UpdateWebhookDeliveryResult :exec
+UPDATE webhook_deliveries
+SET status = ?, attempts = ?, next_attempt_at = ?, delivered_at = ?
+WHERE id = ?;
+
+-- name: CreateWebhookAttempt :execlastid
+INSERT INTO webhook_attempts (delivery_id, status_code, error_message, duration_ms)
+VALUES (?, ?, ?, ?);
+
+-- name: GetWebhookAttemptsByDeliveryID :many
+SELECT * FROM webhook_attempts
+WHERE delivery_id = ?
+ORDER BY id;
@@ -7,10 +7,56 @@ package db
 
 import (
 	"context"
+	"strings"
 )
 
+const createBalance = `-- name: CreateBalance :execlastid
+INSERT INTO balances (user_id, currency_id)
+VALUES (?, ?)
+`
+
+type CreateBalanceParams struct {
+	UserID     uint64
+	CurrencyID uint64
+}
+
+func (q *Queries) CreateBalance(ctx context.Context, arg CreateBalanceParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, createBalance, arg.UserID, arg.CurrencyID)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+const createBalanceStatusChange = `-- name: CreateBalanceStatusChange :execlastid
+INSERT INTO balance_status_changes (balance_id, from_status, to_status, actor, reason)
+VALUES (?, ?, ?, ?, ?)
+`
+
+type CreateBalanceStatusChangeParams struct {
+	BalanceID  uint64
+	FromStatus string
+	ToStatus   string
+	Actor      string
+	Reason     string
+}
+
+func (q *Queries) CreateBalanceStatusChange(ctx context.Context, arg CreateBalanceStatusChangeParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, createBalanceStatusChange,
+		arg.BalanceID,
+		arg.FromStatus,
+		arg.ToStatus,
+		arg.Actor,
+		arg.Reason,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
 const getAllBalances = `-- name: GetAllBalances :many
-SELECT id, user_id, currency_id, amount FROM balances
+SELECT id, user_id, currency_id, amount, held_amount, status FROM balances
 `
 
 func (q *Queries) GetAllBalances(ctx context.Context) ([]Balance, error) {
@@ -27,6 +73,8 @@ func (q *Queries) GetAllBalances(ctx context.Context) ([]Balance, error) {
 			&i.UserID,
 			&i.CurrencyID,
 			&i.Amount,
+			&i.HeldAmount,
+			&i.Status,
 		); err != nil {
 			return nil, err
 		}
@@ -42,7 +90,7 @@ func (q *Queries) GetAllBalances(ctx context.Context) ([]Balance, error) {
 }
 
 const getBalanceByID = `-- name: GetBalanceByID :one
-SELECT id, user_id, currency_id, amount FROM balances
+SELECT id, user_id, currency_id, amount, held_amount, status FROM balances
 WHERE id = ?
 `
 
@@ -54,12 +102,14 @@ func (q *Queries) GetBalanceByID(ctx context.Context, id uint64) (Balance, error
 		&i.UserID,
 		&i.CurrencyID,
 		&i.Amount,
+		&i.HeldAmount,
+		&i.Status,
 	)
 	return i, err
 }
 
 const getBalanceByIDForUpdate = `-- name: GetBalanceByIDForUpdate :one
-SELECT id, user_id, currency_id, amount FROM balances
+SELECT id, user_id, currency_id, amount, held_amount, status FROM balances
 WHERE id = ?
 FOR UPDATE
 `
@@ -72,12 +122,216 @@ func (q *Queries) GetBalanceByIDForUpdate(ctx context.Context, id uint64) (Balan
 		&i.UserID,
 		&i.CurrencyID,
 		&i.Amount,
+		&i.HeldAmount,
+		&i.Status,
+	)
+	return i, err
+}
+
+const getBalanceByUserIDAndCurrencyID = `-- name: GetBalanceByUserIDAndCurrencyID :one
+SELECT id, user_id, currency_id, amount, held_amount, status FROM balances
+WHERE user_id = ? AND currency_id = ?
+`
+
+type GetBalanceByUserIDAndCurrencyIDParams struct {
+	UserID     uint64
+	CurrencyID uint64
+}
+
+func (q *Queries) GetBalanceByUserIDAndCurrencyID(ctx context.Context, arg GetBalanceByUserIDAndCurrencyIDParams) (Balance, error) {
+	row := q.db.QueryRowContext(ctx, getBalanceByUserIDAndCurrencyID, arg.UserID, arg.CurrencyID)
+	var i Balance
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.CurrencyID,
+		&i.Amount,
+		&i.HeldAmount,
+		&i.Status,
+	)
+	return i, err
+}
+
+const getBalanceByUsernameAndCurrency = `-- name: GetBalanceByUsernameAndCurrency :one
+SELECT balances.id, balances.user_id, balances.currency_id, balances.amount, balances.held_amount, balances.status FROM balances
+JOIN users ON users.id = balances.user_id
+JOIN currencies ON currencies.id = balances.currency_id
+WHERE users.username = ? AND currencies.name = ?
+`
+
+type GetBalanceByUsernameAndCurrencyParams struct {
+	Username string
+	Name     string
+}
+
+func (q *Queries) GetBalanceByUsernameAndCurrency(ctx context.Context, arg GetBalanceByUsernameAndCurrencyParams) (Balance, error) {
+	row := q.db.QueryRowContext(ctx, getBalanceByUsernameAndCurrency, arg.Username, arg.Name)
+	var i Balance
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.CurrencyID,
+		&i.Amount,
+		&i.HeldAmount,
+		&i.Status,
 	)
 	return i, err
 }
 
+const getBalanceDiscrepancies = `-- name: GetBalanceDiscrepancies :many
+SELECT id, amount, held_amount,
+    CAST(
+        (SELECT COALESCE(SUM(entries.amount), 0) FROM entries WHERE entries.balance_id = balances.id)
+        + (SELECT COALESCE(SUM(transfers.amount), 0) FROM transfers WHERE transfers.to_balance_id = balances.id AND transfers.batch_id IS NULL)
+        - (SELECT COALESCE(SUM(transfers.amount), 0) FROM transfers WHERE transfers.from_balance_id = balances.id AND transfers.batch_id IS NULL)
+    AS SIGNED) AS expected_amount,
+    CAST(
+        (SELECT COALESCE(SUM(holds.amount), 0) FROM holds WHERE holds.balance_id = balances.id AND holds.status = 'pending')
+    AS SIGNED) AS expected_held_amount
+FROM balances
+HAVING amount <> expected_amount OR held_amount <> expected_held_amount
+ORDER BY id
+`
+
+type GetBalanceDiscrepanciesRow struct {
+	ID                 uint64
+	Amount             int64
+	HeldAmount         int64
+	ExpectedAmount     int64
+	ExpectedHeldAmount int64
+}
+
+func (q *Queries) GetBalanceDiscrepancies(ctx context.Context) ([]GetBalanceDiscrepanciesRow, error) {
+	rows, err := q.db.QueryContext(ctx, getBalanceDiscrepancies)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetBalanceDiscrepanciesRow
+	for rows.Next() {
+		var i GetBalanceDiscrepanciesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Amount,
+			&i.HeldAmount,
+			&i.ExpectedAmount,
+			&i.ExpectedHeldAmount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getBalanceDiscrepanciesByIDs = `-- name: GetBalanceDiscrepanciesByIDs :many
+SELECT id, amount, held_amount,
+    CAST(
+        (SELECT COALESCE(SUM(entries.amount), 0) FROM entries WHERE entries.balance_id = balances.id)
+        + (SELECT COALESCE(SUM(transfers.amount), 0) FROM transfers WHERE transfers.to_balance_id = balances.id AND transfers.batch_id IS NULL)
+        - (SELECT COALESCE(SUM(transfers.amount), 0) FROM transfers WHERE transfers.from_balance_id = balances.id AND transfers.batch_id IS NULL)
+    AS SIGNED) AS expected_amount,
+    CAST(
+        (SELECT COALESCE(SUM(holds.amount), 0) FROM holds WHERE holds.balance_id = balances.id AND holds.status = 'pending')
+    AS SIGNED) AS expected_held_amount
+FROM balances
+WHERE id IN (/*SLICE:ids*/?)
+HAVING amount <> expected_amount OR held_amount <> expected_held_amount
+ORDER BY id
+`
+
+type GetBalanceDiscrepanciesByIDsRow struct {
+	ID                 uint64
+	Amount             int64
+	HeldAmount         int64
+	ExpectedAmount     int64
+	ExpectedHeldAmount int64
+}
+
+func (q *Queries) GetBalanceDiscrepanciesByIDs(ctx context.Context, ids []uint64) ([]GetBalanceDiscrepanciesByIDsRow, error) {
+	query := getBalanceDiscrepanciesByIDs
+	var queryParams []interface{}
+	if len(ids) > 0 {
+		for _, v := range ids {
+			queryParams = append(queryParams, v)
+		}
+		query = strings.Replace(query, "/*SLICE:ids*/?", strings.Repeat(",?", len(ids))[1:], 1)
+	} else {
+		query = strings.Replace(query, "/*SLICE:ids*/?", "NULL", 1)
+	}
+	rows, err := q.db.QueryContext(ctx, query, queryParams...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetBalanceDiscrepanciesByIDsRow
+	for rows.Next() {
+		var i GetBalanceDiscrepanciesByIDsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Amount,
+			&i.HeldAmount,
+			&i.ExpectedAmount,
+			&i.ExpectedHeldAmount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getBalanceStatusChangesByBalanceID = `-- name: GetBalanceStatusChangesByBalanceID :many
+SELECT id, balance_id, from_status, to_status, actor, reason, created_at FROM balance_status_changes
+WHERE balance_id = ?
+ORDER BY id
+`
+
+func (q *Queries) GetBalanceStatusChangesByBalanceID(ctx context.Context, balanceID uint64) ([]BalanceStatusChange, error) {
+	rows, err := q.db.QueryContext(ctx, getBalanceStatusChangesByBalanceID, balanceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []BalanceStatusChange
+	for rows.Next() {
+		var i BalanceStatusChange
+		if err := rows.Scan(
+			&i.ID,
+			&i.BalanceID,
+			&i.FromStatus,
+			&i.ToStatus,
+			&i.Actor,
+			&i.Reason,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getBalancesByUserID = `-- name: GetBalancesByUserID :many
-SELECT id, user_id, currency_id, amount FROM balances
+SELECT id, user_id, currency_id, amount, held_amount, status FROM balances
 WHERE user_id = ?
 `
 
@@ -95,6 +349,8 @@ func (q *Queries) GetBalancesByUserID(ctx context.Context, userID uint64) ([]Bal
 			&i.UserID,
 			&i.CurrencyID,
 			&i.Amount,
+			&i.HeldAmount,
+			&i.Status,
 		); err != nil {
 			return nil, err
 		}
@@ -109,6 +365,43 @@ func (q *Queries) GetBalancesByUserID(ctx context.Context, userID uint64) ([]Bal
 	return items, nil
 }
 
+const getTotalsByCurrency = `-- name: GetTotalsByCurrency :many
+SELECT currencies.name, currencies.exponent, CAST(COALESCE(SUM(balances.amount), 0) AS DOUBLE) AS total
+FROM currencies
+LEFT JOIN balances ON balances.currency_id = currencies.id
+GROUP BY currencies.id, currencies.name, currencies.exponent
+ORDER BY currencies.name
+`
+
+type GetTotalsByCurrencyRow struct {
+	Name     string
+	Exponent uint8
+	Total    float64
+}
+
+func (q *Queries) GetTotalsByCurrency(ctx context.Context) ([]GetTotalsByCurrencyRow, error) {
+	rows, err := q.db.QueryContext(ctx, getTotalsByCurrency)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetTotalsByCurrencyRow
+	for rows.Next() {
+		var i GetTotalsByCurrencyRow
+		if err := rows.Scan(&i.Name, &i.Exponent, &i.Total); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const updateBalance = `-- name: UpdateBalance :exec
 UPDATE balances
 SET amount = ?
@@ -124,3 +417,35 @@ func (q *Queries) UpdateBalance(ctx context.Context, arg UpdateBalanceParams) er
 	_, err := q.db.ExecContext(ctx, updateBalance, arg.Amount, arg.ID)
 	return err
 }
+
+const updateBalanceHeldAmount = `-- name: UpdateBalanceHeldAmount :exec
+UPDATE balances
+SET held_amount = ?
+WHERE id = ?
+`
+
+type UpdateBalanceHeldAmountParams struct {
+	HeldAmount int64
+	ID         uint64
+}
+
+func (q *Queries) UpdateBalanceHeldAmount(ctx context.Context, arg UpdateBalanceHeldAmountParams) error {
+	_, err := q.db.ExecContext(ctx, updateBalanceHeldAmount, arg.HeldAmount, arg.ID)
+	return err
+}
+
+const updateBalanceStatus = `-- name: UpdateBalanceStatus :exec
+UPDATE balances
+SET status = ?
+WHERE id = ?
+`
+
+type UpdateBalanceStatusParams struct {
+	Status string
+	ID     uint64
+}
+
+func (q *Queries) UpdateBalanceStatus(ctx context.Context, arg UpdateBalanceStatusParams) error {
+	_, err := q.db.ExecContext(ctx, updateBalanceStatus, arg.Status, arg.ID)
+	return err
+}
@@ -9,8 +9,26 @@ import (
 	"context"
 )
 
+const createCurrency = `-- name: CreateCurrency :execlastid
+INSERT INTO currencies (name, exponent)
+VALUES (?, ?)
+`
+
+type CreateCurrencyParams struct {
+	Name     string
+	Exponent uint8
+}
+
+func (q *Queries) CreateCurrency(ctx context.Context, arg CreateCurrencyParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, createCurrency, arg.Name, arg.Exponent)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
 const getAllCurrencies = `-- name: GetAllCurrencies :many
-SELECT id, name FROM currencies
+SELECT id, name, exponent FROM currencies
 `
 
 func (q *Queries) GetAllCurrencies(ctx context.Context) ([]Currency, error) {
@@ -22,7 +40,7 @@ func (q *Queries) GetAllCurrencies(ctx context.Context) ([]Currency, error) {
 	var items []Currency
 	for rows.Next() {
 		var i Currency
-		if err := rows.Scan(&i.ID, &i.Name); err != nil {
+		if err := rows.Scan(&i.ID, &i.Name, &i.Exponent); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -37,13 +55,25 @@ func (q *Queries) GetAllCurrencies(ctx context.Context) ([]Currency, error) {
 }
 
 const getCurrencyByID = `-- name: GetCurrencyByID :one
-SELECT id, name FROM currencies
+SELECT id, name, exponent FROM currencies
 WHERE id = ?
 `
 
 func (q *Queries) GetCurrencyByID(ctx context.Context, id uint64) (Currency, error) {
 	row := q.db.QueryRowContext(ctx, getCurrencyByID, id)
 	var i Currency
-	err := row.Scan(&i.ID, &i.Name)
+	err := row.Scan(&i.ID, &i.Name, &i.Exponent)
+	return i, err
+}
+
+const getCurrencyByName = `-- name: GetCurrencyByName :one
+SELECT id, name, exponent FROM currencies
+WHERE name = ?
+`
+
+func (q *Queries) GetCurrencyByName(ctx context.Context, name string) (Currency, error) {
+	row := q.db.QueryRowContext(ctx, getCurrencyByName, name)
+	var i Currency
+	err := row.Scan(&i.ID, &i.Name, &i.Exponent)
 	return i, err
 }
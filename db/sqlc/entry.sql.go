@@ -7,8 +7,28 @@ package db
 
 import (
 	"context"
+	"database/sql"
 )
 
+const createBatchEntry = `-- name: CreateBatchEntry :execlastid
+INSERT INTO entries (balance_id, amount, batch_id)
+VALUES (?, ?, ?)
+`
+
+type CreateBatchEntryParams struct {
+	BalanceID uint64
+	Amount    int64
+	BatchID   sql.NullInt64
+}
+
+func (q *Queries) CreateBatchEntry(ctx context.Context, arg CreateBatchEntryParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, createBatchEntry, arg.BalanceID, arg.Amount, arg.BatchID)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
 const createEntry = `-- name: CreateEntry :execlastid
 INSERT INTO entries (balance_id, amount)
 VALUES (?, ?)
@@ -27,8 +47,27 @@ func (q *Queries) CreateEntry(ctx context.Context, arg CreateEntryParams) (int64
 	return result.LastInsertId()
 }
 
+const createEntryWithReference = `-- name: CreateEntryWithReference :execlastid
+INSERT INTO entries (balance_id, amount, reference)
+VALUES (?, ?, ?)
+`
+
+type CreateEntryWithReferenceParams struct {
+	BalanceID uint64
+	Amount    int64
+	Reference sql.NullString
+}
+
+func (q *Queries) CreateEntryWithReference(ctx context.Context, arg CreateEntryWithReferenceParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, createEntryWithReference, arg.BalanceID, arg.Amount, arg.Reference)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
 const getAllEntries = `-- name: GetAllEntries :many
-SELECT id, balance_id, amount FROM entries
+SELECT id, balance_id, amount, reference, batch_id FROM entries
 `
 
 func (q *Queries) GetAllEntries(ctx context.Context) ([]Entry, error) {
@@ -40,7 +79,13 @@ func (q *Queries) GetAllEntries(ctx context.Context) ([]Entry, error) {
 	var items []Entry
 	for rows.Next() {
 		var i Entry
-		if err := rows.Scan(&i.ID, &i.BalanceID, &i.Amount); err != nil {
+		if err := rows.Scan(
+			&i.ID,
+			&i.BalanceID,
+			&i.Amount,
+			&i.Reference,
+			&i.BatchID,
+		); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -55,7 +100,7 @@ func (q *Queries) GetAllEntries(ctx context.Context) ([]Entry, error) {
 }
 
 const getEntriesByBalanceID = `-- name: GetEntriesByBalanceID :many
-SELECT id, balance_id, amount FROM entries
+SELECT id, balance_id, amount, reference, batch_id FROM entries
 WHERE balance_id = ?
 `
 
@@ -68,7 +113,48 @@ func (q *Queries) GetEntriesByBalanceID(ctx context.Context, balanceID uint64) (
 	var items []Entry
 	for rows.Next() {
 		var i Entry
-		if err := rows.Scan(&i.ID, &i.BalanceID, &i.Amount); err != nil {
+		if err := rows.Scan(
+			&i.ID,
+			&i.BalanceID,
+			&i.Amount,
+			&i.Reference,
+			&i.BatchID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getEntriesByBatchID = `-- name: GetEntriesByBatchID :many
+SELECT id, balance_id, amount, reference, batch_id FROM entries
+WHERE batch_id = ?
+ORDER BY id
+`
+
+func (q *Queries) GetEntriesByBatchID(ctx context.Context, batchID sql.NullInt64) ([]Entry, error) {
+	rows, err := q.db.QueryContext(ctx, getEntriesByBatchID, batchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Entry
+	for rows.Next() {
+		var i Entry
+		if err := rows.Scan(
+			&i.ID,
+			&i.BalanceID,
+			&i.Amount,
+			&i.Reference,
+			&i.BatchID,
+		); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -83,14 +169,38 @@ func (q *Queries) GetEntriesByBalanceID(ctx context.Context, balanceID uint64) (
 }
 
 const getEntryByID = `-- name: GetEntryByID :one
-SELECT id, balance_id, amount FROM entries
+SELECT id, balance_id, amount, reference, batch_id FROM entries
 WHERE id = ?
 `
 
 func (q *Queries) GetEntryByID(ctx context.Context, id uint64) (Entry, error) {
 	row := q.db.QueryRowContext(ctx, getEntryByID, id)
 	var i Entry
-	err := row.Scan(&i.ID, &i.BalanceID, &i.Amount)
+	err := row.Scan(
+		&i.ID,
+		&i.BalanceID,
+		&i.Amount,
+		&i.Reference,
+		&i.BatchID,
+	)
+	return i, err
+}
+
+const getEntryByReference = `-- name: GetEntryByReference :one
+SELECT id, balance_id, amount, reference, batch_id FROM entries
+WHERE reference = ?
+`
+
+func (q *Queries) GetEntryByReference(ctx context.Context, reference sql.NullString) (Entry, error) {
+	row := q.db.QueryRowContext(ctx, getEntryByReference, reference)
+	var i Entry
+	err := row.Scan(
+		&i.ID,
+		&i.BalanceID,
+		&i.Amount,
+		&i.Reference,
+		&i.BatchID,
+	)
 	return i, err
 }
 
@@ -4,18 +4,75 @@
 
 package db
 
-import ()
+import (
+	"database/sql"
+	"time"
+)
+
+type AuditChain struct {
+	// always 1, locking the row serializes appends to audit_log
+	ID     uint8
+	LastID uint64
+	Hash   string
+}
+
+type AuditLog struct {
+	ID        uint64
+	Actor     string
+	Client    string
+	RequestID string
+	// deposit, withdraw, transfer, authorize, capture, void, batch, import, status or policy
+	Operation string
+	// operation parameters as json
+	Params string
+	// json object of balance id to amount before the operation
+	PreAmounts string
+	// json object of balance id to amount after the operation, same as pre_amounts for rejected attempts
+	PostAmounts string
+	// applied or rejected
+	Outcome      string
+	ErrorMessage string
+	PrevHash     string
+	// sha256 of prev_hash and all other columns except id
+	Hash      string
+	CreatedAt time.Time
+}
 
 type Balance struct {
 	ID         uint64
 	UserID     uint64
 	CurrencyID uint64
 	Amount     int64
+	// sum of pending holds, can be only positive
+	HeldAmount int64
+	// active, frozen_debit, frozen_all or closed
+	Status string
+}
+
+type BalancePolicy struct {
+	BalanceID uint64
+	// how far below min_balance amount can go, can be only positive
+	OverdraftLimit int64
+	MinBalance     int64
+	// 0 means no limit
+	MaxTransactionAmount int64
+}
+
+type BalanceStatusChange struct {
+	ID         uint64
+	BalanceID  uint64
+	FromStatus string
+	ToStatus   string
+	Actor      string
+	Reason     string
+	CreatedAt  time.Time
 }
 
 type Currency struct {
 	ID   uint64
 	Name string
+	// number of minor units digits, amounts are stored in minor units
+	Exponent uint8
 }
 
 type Entry struct {
@@ -23,6 +80,47 @@ type Entry struct {
 	BalanceID uint64
 	// can be negative or positive
 	Amount int64
+	// external reference of imported entry
+	Reference sql.NullString
+	// set only for entries of batch legs
+	BatchID sql.NullInt64
+}
+
+type ExchangeRate struct {
+	BaseCurrencyID  uint64
+	QuoteCurrencyID uint64
+	// units of the quote currency for one unit of the base currency
+	Rate      string
+	UpdatedAt time.Time
+}
+
+type Hold struct {
+	ID        uint64
+	BalanceID uint64
+	// can be only positive
+	Amount         int64
+	CapturedAmount int64
+	// pending, captured, voided or expired
+	Status    string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+type OutboxEvent struct {
+	ID        uint64
+	BalanceID uint64
+	EventType string
+	// event as json
+	Payload   string
+	CreatedAt time.Time
+	// null while the event is pending
+	DeliveredAt sql.NullTime
+	Attempts    uint32
+	LastError   string
+	// set after a failed attempt, events of the balance wait for it, null means now
+	NextAttemptAt sql.NullTime
+	// set while a relay publishes the event, other relays skip events of the balance until then
+	ClaimedUntil sql.NullTime
 }
 
 type Transfer struct {
@@ -31,9 +129,51 @@ type Transfer struct {
 	ToBalanceID   uint64
 	// can be only positive
 	Amount int64
+	// set only for transfers made by batch
+	BatchID sql.NullInt64
+}
+
+type TransferBatch struct {
+	ID        uint64
+	Legs      uint32
+	CreatedAt time.Time
 }
 
 type User struct {
 	ID       uint64
 	Username string
 }
+
+type WebhookAttempt struct {
+	ID         uint64
+	DeliveryID uint64
+	// 0 if there was no response
+	StatusCode   int32
+	ErrorMessage string
+	DurationMs   uint32
+	CreatedAt    time.Time
+}
+
+type WebhookDelivery struct {
+	ID             uint64
+	SubscriptionID uint64
+	EventID        uint64
+	EventType      string
+	// pending, delivered or failed
+	Status        string
+	Attempts      uint32
+	NextAttemptAt time.Time
+	CreatedAt     time.Time
+	DeliveredAt   sql.NullTime
+}
+
+type WebhookSubscription struct {
+	ID  uint64
+	Url string
+	// hmac-sha256 key of payload signatures
+	Secret string
+	// comma separated list like deposit.completed,withdraw.rejected
+	EventTypes string
+	Active     bool
+	CreatedAt  time.Time
+}
@@ -0,0 +1,5 @@
+package db
+
+// SchemaVersion is the last migration the queries of this package are generated against,
+// bump it together with every new migration
+const SchemaVersion = 17
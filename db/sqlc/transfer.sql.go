@@ -7,8 +7,34 @@ package db
 
 import (
 	"context"
+	"database/sql"
 )
 
+const createBatchTransfer = `-- name: CreateBatchTransfer :execlastid
+INSERT INTO transfers (from_balance_id, to_balance_id, amount, batch_id)
+VALUES (?, ?, ?, ?)
+`
+
+type CreateBatchTransferParams struct {
+	FromBalanceID uint64
+	ToBalanceID   uint64
+	Amount        int64
+	BatchID       sql.NullInt64
+}
+
+func (q *Queries) CreateBatchTransfer(ctx context.Context, arg CreateBatchTransferParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, createBatchTransfer,
+		arg.FromBalanceID,
+		arg.ToBalanceID,
+		arg.Amount,
+		arg.BatchID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
 const createTransfer = `-- name: CreateTransfer :execlastid
 INSERT INTO transfers (from_balance_id, to_balance_id, amount)
 VALUES (?, ?, ?)
@@ -28,8 +54,21 @@ func (q *Queries) CreateTransfer(ctx context.Context, arg CreateTransferParams)
 	return result.LastInsertId()
 }
 
+const createTransferBatch = `-- name: CreateTransferBatch :execlastid
+INSERT INTO transfer_batches (legs)
+VALUES (?)
+`
+
+func (q *Queries) CreateTransferBatch(ctx context.Context, legs uint32) (int64, error) {
+	result, err := q.db.ExecContext(ctx, createTransferBatch, legs)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
 const getAllTransfers = `-- name: GetAllTransfers :many
-SELECT id, from_balance_id, to_balance_id, amount FROM transfers
+SELECT id, from_balance_id, to_balance_id, amount, batch_id FROM transfers
 `
 
 func (q *Queries) GetAllTransfers(ctx context.Context) ([]Transfer, error) {
@@ -46,6 +85,7 @@ func (q *Queries) GetAllTransfers(ctx context.Context) ([]Transfer, error) {
 			&i.FromBalanceID,
 			&i.ToBalanceID,
 			&i.Amount,
+			&i.BatchID,
 		); err != nil {
 			return nil, err
 		}
@@ -73,8 +113,20 @@ func (q *Queries) GetLastTransferID(ctx context.Context) (uint64, error) {
 	return id, err
 }
 
+const getTransferBatchByID = `-- name: GetTransferBatchByID :one
+SELECT id, legs, created_at FROM transfer_batches
+WHERE id = ?
+`
+
+func (q *Queries) GetTransferBatchByID(ctx context.Context, id uint64) (TransferBatch, error) {
+	row := q.db.QueryRowContext(ctx, getTransferBatchByID, id)
+	var i TransferBatch
+	err := row.Scan(&i.ID, &i.Legs, &i.CreatedAt)
+	return i, err
+}
+
 const getTransferByID = `-- name: GetTransferByID :one
-SELECT id, from_balance_id, to_balance_id, amount FROM transfers
+SELECT id, from_balance_id, to_balance_id, amount, batch_id FROM transfers
 WHERE id = ?
 `
 
@@ -86,12 +138,13 @@ func (q *Queries) GetTransferByID(ctx context.Context, id uint64) (Transfer, err
 		&i.FromBalanceID,
 		&i.ToBalanceID,
 		&i.Amount,
+		&i.BatchID,
 	)
 	return i, err
 }
 
 const getTransfersByAccountID = `-- name: GetTransfersByAccountID :many
-SELECT id, from_balance_id, to_balance_id, amount FROM transfers
+SELECT id, from_balance_id, to_balance_id, amount, batch_id FROM transfers
 WHERE from_balance_id = ? OR to_balance_id = ?
 `
 
@@ -114,6 +167,42 @@ func (q *Queries) GetTransfersByAccountID(ctx context.Context, arg GetTransfersB
 			&i.FromBalanceID,
 			&i.ToBalanceID,
 			&i.Amount,
+			&i.BatchID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTransfersByBatchID = `-- name: GetTransfersByBatchID :many
+SELECT id, from_balance_id, to_balance_id, amount, batch_id FROM transfers
+WHERE batch_id = ?
+ORDER BY id
+`
+
+func (q *Queries) GetTransfersByBatchID(ctx context.Context, batchID sql.NullInt64) ([]Transfer, error) {
+	rows, err := q.db.QueryContext(ctx, getTransfersByBatchID, batchID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Transfer
+	for rows.Next() {
+		var i Transfer
+		if err := rows.Scan(
+			&i.ID,
+			&i.FromBalanceID,
+			&i.ToBalanceID,
+			&i.Amount,
+			&i.BatchID,
 		); err != nil {
 			return nil, err
 		}
@@ -129,7 +218,7 @@ func (q *Queries) GetTransfersByAccountID(ctx context.Context, arg GetTransfersB
 }
 
 const getTransfersByInAndOutAccountIDs = `-- name: GetTransfersByInAndOutAccountIDs :many
-SELECT id, from_balance_id, to_balance_id, amount FROM transfers
+SELECT id, from_balance_id, to_balance_id, amount, batch_id FROM transfers
 WHERE from_balance_id = ? AND to_balance_id = ?
 `
 
@@ -152,6 +241,7 @@ func (q *Queries) GetTransfersByInAndOutAccountIDs(ctx context.Context, arg GetT
 			&i.FromBalanceID,
 			&i.ToBalanceID,
 			&i.Amount,
+			&i.BatchID,
 		); err != nil {
 			return nil, err
 		}
@@ -9,6 +9,19 @@ import (
 	"context"
 )
 
+const createUser = `-- name: CreateUser :execlastid
+INSERT INTO users (username)
+VALUES (?)
+`
+
+func (q *Queries) CreateUser(ctx context.Context, username string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, createUser, username)
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
 const getAllUsers = `-- name: GetAllUsers :many
 SELECT id, username FROM users
 `
@@ -47,3 +60,15 @@ func (q *Queries) GetUserByID(ctx context.Context, id uint64) (User, error) {
 	err := row.Scan(&i.ID, &i.Username)
 	return i, err
 }
+
+const getUserByUsername = `-- name: GetUserByUsername :one
+SELECT id, username FROM users
+WHERE username = ?
+`
+
+func (q *Queries) GetUserByUsername(ctx context.Context, username string) (User, error) {
+	row := q.db.QueryRowContext(ctx, getUserByUsername, username)
+	var i User
+	err := row.Scan(&i.ID, &i.Username)
+	return i, err
+}
@@ -0,0 +1,396 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"gopkg.in/yaml.v3"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the effective configuration of all commands. Values are taken from, in order of precedence:
+// environment variables, the .env file, the yaml file, defaults. Field tags name the env variable,
+// the yaml key and whether the value is a secret that's hidden by Redacted.
+type Config struct {
+	DB       DB       `yaml:"db"`
+	Redis    Redis    `yaml:"redis"`
+	Server   Server   `yaml:"server"`
+	Retry    Retry    `yaml:"retry"`
+	Tracing  Tracing  `yaml:"tracing"`
+	Log      Log      `yaml:"log"`
+	Features Features `yaml:"features"`
+}
+
+type DB struct {
+	User string `yaml:"user" env:"DB_USER"`
+	Pass string `yaml:"pass" env:"DB_PASS" secret:"true"`
+	Host string `yaml:"host" env:"DB_HOST"`
+	Port int    `yaml:"port" env:"DB_PORT"`
+	Name string `yaml:"name" env:"DB_NAME"`
+
+	MaxOpenConns    int           `yaml:"max_open_conns" env:"DB_MAX_OPEN_CONNS"`
+	MaxIdleConns    int           `yaml:"max_idle_conns" env:"DB_MAX_IDLE_CONNS"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time" env:"DB_CONN_MAX_IDLE_TIME"`
+
+	// dial, read and write timeouts of the driver, 0 means no timeout
+	DialTimeout  time.Duration `yaml:"dial_timeout" env:"DB_DIAL_TIMEOUT"`
+	ReadTimeout  time.Duration `yaml:"read_timeout" env:"DB_READ_TIMEOUT"`
+	WriteTimeout time.Duration `yaml:"write_timeout" env:"DB_WRITE_TIMEOUT"`
+}
+
+type Redis struct {
+	// host:port, tcp:// and redis:// urls are accepted too
+	Addr   string `yaml:"addr" env:"REDIS_HOST"`
+	Pass   string `yaml:"pass" env:"REDIS_PASS" secret:"true"`
+	DB     int    `yaml:"db" env:"REDIS_DB"`
+	Stream string `yaml:"stream" env:"REDIS_STREAM"`
+}
+
+type Server struct {
+	HTTPAddr string `yaml:"http_addr" env:"HTTP_ADDR"`
+	GRPCAddr string `yaml:"grpc_addr" env:"GRPC_ADDR"`
+	// /metrics and probes of the grpc server, the http server serves them on HTTPAddr
+	MetricsAddr string `yaml:"metrics_addr" env:"METRICS_ADDR"`
+	// deadline of requests that came without one
+	RequestTimeout  time.Duration `yaml:"request_timeout" env:"REQUEST_TIMEOUT"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"`
+	// apply pending migrations on start instead of refusing to serve an older schema
+	AutoMigrate bool `yaml:"auto_migrate" env:"AUTO_MIGRATE"`
+	// readiness pings redis too, for deployments where the relay publishes to it
+	ReadyRedis bool `yaml:"ready_redis" env:"READY_REDIS"`
+}
+
+// Retry limits how operations are repeated after a deadlock or lock wait timeout
+type Retry struct {
+	MaxAttempts int           `yaml:"max_attempts" env:"RETRY_MAX_ATTEMPTS"`
+	BaseDelay   time.Duration `yaml:"base_delay" env:"RETRY_BASE_DELAY"`
+	MaxDelay    time.Duration `yaml:"max_delay" env:"RETRY_MAX_DELAY"`
+}
+
+type Tracing struct {
+	// none, stdout or otlp
+	Exporter string `yaml:"exporter" env:"TRACING_EXPORTER"`
+	// host:port of the otlp grpc collector
+	Endpoint    string `yaml:"endpoint" env:"TRACING_ENDPOINT"`
+	Insecure    bool   `yaml:"insecure" env:"TRACING_INSECURE"`
+	ServiceName string `yaml:"service_name" env:"TRACING_SERVICE_NAME"`
+}
+
+type Log struct {
+	// debug, info, warn or error
+	Level string `yaml:"level" env:"LOG_LEVEL"`
+	// text or json
+	Format string `yaml:"format" env:"LOG_FORMAT"`
+	// queries slower than this are logged as warnings, 0 turns it off
+	SlowQuery time.Duration `yaml:"slow_query" env:"LOG_SLOW_QUERY"`
+}
+
+type Features struct {
+	// webhooks sink of the relay
+	Webhooks bool `yaml:"webhooks" env:"FEATURE_WEBHOOKS"`
+	// stream of live balance updates
+	Stream bool `yaml:"stream" env:"FEATURE_STREAM"`
+	// grpc server reflection
+	GRPCReflection bool `yaml:"grpc_reflection" env:"FEATURE_GRPC_REFLECTION"`
+	// prometheus metrics endpoint
+	Metrics bool `yaml:"metrics" env:"FEATURE_METRICS"`
+	// pool stats, transactions and lock waits on /debug/diagnostics, it isn't authenticated so it's off by default
+	Diagnostics bool `yaml:"diagnostics" env:"FEATURE_DIAGNOSTICS"`
+}
+
+var ErrInvalid = errors.New("invalid config")
+
+func Default() Config {
+	return Config{
+		DB: DB{
+			Port:            3306,
+			MaxOpenConns:    25,
+			MaxIdleConns:    25,
+			ConnMaxLifetime: 5 * time.Minute,
+			ConnMaxIdleTime: time.Minute,
+			DialTimeout:     5 * time.Second,
+		},
+		Redis: Redis{
+			Addr:   "localhost:6379",
+			Stream: "balance-events",
+		},
+		Server: Server{
+			HTTPAddr:        ":8080",
+			GRPCAddr:        ":9090",
+			MetricsAddr:     ":9091",
+			RequestTimeout:  30 * time.Second,
+			ShutdownTimeout: 10 * time.Second,
+		},
+		Retry: Retry{
+			MaxAttempts: 3,
+			BaseDelay:   10 * time.Millisecond,
+			MaxDelay:    200 * time.Millisecond,
+		},
+		Tracing: Tracing{
+			Exporter:    "none",
+			Endpoint:    "localhost:4317",
+			Insecure:    true,
+			ServiceName: "go-balances",
+		},
+		Log: Log{
+			Level:     "info",
+			Format:    "text",
+			SlowQuery: 200 * time.Millisecond,
+		},
+		Features: Features{
+			Webhooks:       true,
+			Stream:         true,
+			GRPCReflection: true,
+			Metrics:        true,
+			Diagnostics:    false,
+		},
+	}
+}
+
+// Load reads the yaml file, if path isn't empty, .env file of the working directory, if it exists,
+// and the environment. CONFIG_FILE variable is used when path is empty.
+func Load(path string) (Config, error) {
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+
+	var yamlData []byte
+	if path != "" {
+		var err error
+		yamlData, err = os.ReadFile(path)
+		if err != nil {
+			return Config{}, err
+		}
+	}
+
+	dotenv, err := os.ReadFile(".env")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return Config{}, err
+	}
+
+	return load(yamlData, dotenv, os.Environ())
+}
+
+func load(yamlData []byte, dotenv []byte, environ []string) (Config, error) {
+	cfg := Default()
+
+	err := yaml.Unmarshal(yamlData, &cfg)
+	if err != nil {
+		return Config{}, fmt.Errorf("yaml: %w", err)
+	}
+
+	vars, err := parseDotenv(dotenv)
+	if err != nil {
+		return Config{}, fmt.Errorf(".env: %w", err)
+	}
+
+	for _, kv := range environ {
+		key, value, _ := strings.Cut(kv, "=")
+		vars[key] = value
+	}
+
+	err = applyEnv(reflect.ValueOf(&cfg).Elem(), vars)
+	if err != nil {
+		return Config{}, err
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// parseDotenv reads KEY=value lines, blank lines and # comments are skipped, values can be quoted
+func parseDotenv(data []byte) (map[string]string, error) {
+	vars := make(map[string]string)
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected KEY=value", i+1)
+		}
+
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		vars[strings.TrimSpace(key)] = value
+	}
+	return vars, nil
+}
+
+// applyEnv sets fields that have env tag from vars
+func applyEnv(v reflect.Value, vars map[string]string) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		tag := v.Type().Field(i).Tag
+
+		if field.Kind() == reflect.Struct {
+			err := applyEnv(field, vars)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		name := tag.Get("env")
+		value, ok := vars[name]
+		if name == "" || !ok {
+			continue
+		}
+
+		err := setValue(field, value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func setValue(field reflect.Value, value string) error {
+	switch {
+	case field.Type() == reflect.TypeOf(time.Duration(0)):
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+	case field.Kind() == reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case field.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
+
+// Validate checks required fields and ranges, all problems are reported at once
+func (c Config) Validate() error {
+	var problems []string
+	check := func(ok bool, problem string) {
+		if !ok {
+			problems = append(problems, problem)
+		}
+	}
+
+	check(c.DB.User != "", "DB_USER is required")
+	check(c.DB.Pass != "", "DB_PASS is required")
+	check(c.DB.Host != "", "DB_HOST is required")
+	check(c.DB.Name != "", "DB_NAME is required")
+	check(c.DB.Port > 0 && c.DB.Port < 65536, "DB_PORT must be between 1 and 65535")
+	check(c.DB.MaxOpenConns >= 0, "DB_MAX_OPEN_CONNS must not be negative")
+	check(c.DB.MaxIdleConns >= 0, "DB_MAX_IDLE_CONNS must not be negative")
+	check(c.DB.MaxOpenConns == 0 || c.DB.MaxIdleConns <= c.DB.MaxOpenConns, "DB_MAX_IDLE_CONNS must not be greater than DB_MAX_OPEN_CONNS")
+	check(c.DB.ConnMaxLifetime >= 0 && c.DB.ConnMaxIdleTime >= 0, "DB connection lifetimes must not be negative")
+	check(c.DB.DialTimeout >= 0 && c.DB.ReadTimeout >= 0 && c.DB.WriteTimeout >= 0, "DB timeouts must not be negative")
+
+	_, err := c.Redis.Address()
+	check(err == nil, "REDIS_HOST must be host:port or tcp:// or redis:// url")
+	check(c.Redis.DB >= 0, "REDIS_DB must not be negative")
+
+	_, _, err = net.SplitHostPort(c.Server.HTTPAddr)
+	check(err == nil, "HTTP_ADDR must be host:port")
+	_, _, err = net.SplitHostPort(c.Server.GRPCAddr)
+	check(err == nil, "GRPC_ADDR must be host:port")
+	_, _, err = net.SplitHostPort(c.Server.MetricsAddr)
+	check(err == nil, "METRICS_ADDR must be host:port")
+	check(c.Server.RequestTimeout >= 0 && c.Server.ShutdownTimeout >= 0, "server timeouts must not be negative")
+
+	check(c.Tracing.Exporter == "none" || c.Tracing.Exporter == "stdout" || c.Tracing.Exporter == "otlp", "TRACING_EXPORTER must be none, stdout or otlp")
+	_, _, err = net.SplitHostPort(c.Tracing.Endpoint)
+	check(c.Tracing.Exporter != "otlp" || err == nil, "TRACING_ENDPOINT must be host:port")
+
+	var level slog.Level
+	check(level.UnmarshalText([]byte(c.Log.Level)) == nil, "LOG_LEVEL must be debug, info, warn or error")
+	check(c.Log.Format == "text" || c.Log.Format == "json", "LOG_FORMAT must be text or json")
+	check(c.Log.SlowQuery >= 0, "LOG_SLOW_QUERY must not be negative")
+
+	check(c.Retry.MaxAttempts >= 1, "RETRY_MAX_ATTEMPTS must be at least 1")
+	check(c.Retry.BaseDelay >= 0 && c.Retry.MaxDelay >= c.Retry.BaseDelay, "RETRY_MAX_DELAY must not be less than RETRY_BASE_DELAY")
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalid, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// DSN returns data source name of the mysql driver, times are parsed into time.Time
+func (d DB) DSN() string {
+	cfg := mysql.NewConfig()
+	cfg.User = d.User
+	cfg.Passwd = d.Pass
+	cfg.Net = "tcp"
+	cfg.Addr = net.JoinHostPort(d.Host, strconv.Itoa(d.Port))
+	cfg.DBName = d.Name
+	cfg.ParseTime = true
+	cfg.Timeout = d.DialTimeout
+	cfg.ReadTimeout = d.ReadTimeout
+	cfg.WriteTimeout = d.WriteTimeout
+	return cfg.FormatDSN()
+}
+
+// Address returns host:port of the redis server
+func (r Redis) Address() (string, error) {
+	addr := r.Addr
+	if strings.Contains(addr, "://") {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return "", err
+		}
+		if u.Scheme != "tcp" && u.Scheme != "redis" {
+			return "", fmt.Errorf("unsupported scheme %q", u.Scheme)
+		}
+		addr = u.Host
+	}
+
+	_, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	return addr, nil
+}
+
+// Redacted returns the config as yaml with secret values replaced, it's safe to print or log
+func (c Config) Redacted() string {
+	redacted := c
+	redact(reflect.ValueOf(&redacted).Elem())
+
+	out, err := yaml.Marshal(redacted)
+	if err != nil {
+		return err.Error()
+	}
+	return string(out)
+}
+
+func redact(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			redact(field)
+			continue
+		}
+		if v.Type().Field(i).Tag.Get("secret") == "true" && field.String() != "" {
+			field.SetString("[redacted]")
+		}
+	}
+}
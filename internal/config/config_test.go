@@ -0,0 +1,105 @@
+package config
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+var requiredEnv = []string{"DB_USER=app", "DB_PASS=secret", "DB_HOST=localhost", "DB_NAME=balances"}
+
+func TestLoad(t *testing.T) {
+	t.Run("Test defaults are used when nothing is set", func(t *testing.T) {
+		cfg, err := load(nil, nil, requiredEnv)
+		require.NoError(t, err)
+
+		assert.Equal(t, 3306, cfg.DB.Port)
+		assert.Equal(t, ":9090", cfg.Server.GRPCAddr)
+		assert.Equal(t, 3, cfg.Retry.MaxAttempts)
+		assert.True(t, cfg.Features.Stream)
+		assert.False(t, cfg.Features.Diagnostics)
+	})
+
+	t.Run("Test env overrides .env and .env overrides yaml", func(t *testing.T) {
+		yamlData := []byte("db:\n  port: 3307\n  name: from_yaml\nserver:\n  http_addr: \":8081\"\n  request_timeout: 5s\n")
+		dotenv := []byte("# local overrides\nDB_PORT=3308\nexport HTTP_ADDR=\":8082\"\n\nREDIS_HOST='redis://cache:6380'\n")
+		environ := append([]string{"DB_PORT=3309"}, requiredEnv[:3]...)
+
+		cfg, err := load(yamlData, dotenv, environ)
+		require.NoError(t, err)
+
+		assert.Equal(t, 3309, cfg.DB.Port)
+		assert.Equal(t, "from_yaml", cfg.DB.Name)
+		assert.Equal(t, ":8082", cfg.Server.HTTPAddr)
+		assert.Equal(t, 5*time.Second, cfg.Server.RequestTimeout)
+		assert.Equal(t, "redis://cache:6380", cfg.Redis.Addr)
+	})
+
+	t.Run("Test malformed values are rejected", func(t *testing.T) {
+		_, err := load(nil, nil, append([]string{"RETRY_BASE_DELAY=10"}, requiredEnv...))
+		assert.ErrorContains(t, err, "RETRY_BASE_DELAY")
+
+		_, err = load(nil, []byte("DB_PORT"), requiredEnv)
+		assert.ErrorContains(t, err, "line 1")
+
+		_, err = load([]byte("db: ["), nil, requiredEnv)
+		assert.ErrorContains(t, err, "yaml")
+	})
+
+	t.Run("Test all validation problems are reported", func(t *testing.T) {
+		_, err := load(nil, nil, []string{"DB_PORT=0", "RETRY_MAX_ATTEMPTS=0", "REDIS_HOST=http://cache:6379"})
+		require.True(t, errors.Is(err, ErrInvalid))
+
+		for _, problem := range []string{"DB_USER", "DB_PASS", "DB_HOST", "DB_NAME", "DB_PORT", "RETRY_MAX_ATTEMPTS", "REDIS_HOST"} {
+			assert.Contains(t, err.Error(), problem)
+		}
+	})
+}
+
+func TestRedisAddress(t *testing.T) {
+	t.Run("Test host:port and urls are accepted", func(t *testing.T) {
+		for addr, expected := range map[string]string{
+			"localhost:6379":       "localhost:6379",
+			"tcp://redis:6379":     "redis:6379",
+			"redis://cache:6380/0": "cache:6380",
+		} {
+			got, err := Redis{Addr: addr}.Address()
+			assert.NoError(t, err, addr)
+			assert.Equal(t, expected, got)
+		}
+	})
+
+	t.Run("Test other addresses are rejected", func(t *testing.T) {
+		for _, addr := range []string{"localhost", "http://cache:6379", ""} {
+			_, err := Redis{Addr: addr}.Address()
+			assert.Error(t, err, addr)
+		}
+	})
+}
+
+func TestDSN(t *testing.T) {
+	t.Run("Test dsn has credentials, timeouts and parses time", func(t *testing.T) {
+		db := DB{User: "app", Pass: "secret", Host: "db", Port: 3306, Name: "balances", DialTimeout: 5 * time.Second}
+
+		dsn := db.DSN()
+		assert.True(t, strings.HasPrefix(dsn, "app:secret@tcp(db:3306)/balances?"), dsn)
+		assert.Contains(t, dsn, "parseTime=true")
+		assert.Contains(t, dsn, "timeout=5s")
+	})
+}
+
+func TestRedacted(t *testing.T) {
+	t.Run("Test secrets are hidden", func(t *testing.T) {
+		cfg, err := load(nil, nil, append([]string{"REDIS_PASS=redis-secret"}, requiredEnv...))
+		require.NoError(t, err)
+
+		out := cfg.Redacted()
+		assert.NotContains(t, out, "secret")
+		assert.Contains(t, out, "[redacted]")
+		assert.Contains(t, out, "user: app")
+		assert.Equal(t, "secret", cfg.DB.Pass)
+	})
+}
@@ -0,0 +1,322 @@
+package grpcserver
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	ledgerv1 "github.com/tredoc/go-balances/api/ledger/v1"
+	db "github.com/tredoc/go-balances/db/sqlc"
+	"github.com/tredoc/go-balances/internal/logging"
+	"github.com/tredoc/go-balances/internal/money"
+	"github.com/tredoc/go-balances/internal/service"
+	"github.com/tredoc/go-balances/internal/tracing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"log/slog"
+	"time"
+)
+
+// DefaultTimeout bounds requests that came without a deadline
+const DefaultTimeout = 30 * time.Second
+
+// AssertedActorPrefix marks actors the audit log got from x-actor metadata. The server doesn't authenticate
+// callers, so anyone can send any name there, the prefix keeps it apart from verified actors like balancectl ones.
+const AssertedActorPrefix = "asserted:"
+
+// Server implements ledgerv1.LedgerServer on top of service.Service
+type Server struct {
+	ledgerv1.UnimplementedLedgerServer
+	services *service.Service
+}
+
+func New(services *service.Service) *Server {
+	return &Server{services: services}
+}
+
+// NewGRPCServer creates grpc server with the ledger service and interceptors that continue the trace of the caller,
+// bound request deadlines, pass the caller to the audit log and map service errors to status codes
+func NewGRPCServer(services *service.Service, timeout time.Duration, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts, grpc.ChainUnaryInterceptor(tracing.UnaryServerInterceptor(), deadlineInterceptor(timeout), initiatorInterceptor, errorInterceptor))
+	server := grpc.NewServer(opts...)
+	ledgerv1.RegisterLedgerServer(server, New(services))
+	return server
+}
+
+// deadlineInterceptor sets timeout to requests without deadline, the deadline of the client is kept
+// as is and reaches the database through ctx, so a cancelled call rolls its transaction back
+func deadlineInterceptor(timeout time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if _, ok := ctx.Deadline(); !ok && timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		return handler(ctx, req)
+	}
+}
+
+// initiatorInterceptor reads x-actor and x-request-id metadata, the actor is only what the caller claims,
+// so it's written with AssertedActorPrefix, the client is the peer address, calls without request id
+// get a generated one
+func initiatorInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	var initiator service.Initiator
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("x-actor"); len(values) > 0 {
+			initiator.Actor = AssertedActorPrefix + values[0]
+		}
+		if values := md.Get("x-request-id"); len(values) > 0 {
+			initiator.RequestID = values[0]
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		initiator.Client = p.Addr.String()
+	}
+	if initiator.RequestID == "" {
+		initiator.RequestID = logging.NewRequestID()
+	}
+
+	return handler(service.WithInitiator(ctx, initiator), req)
+}
+
+func errorInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	resp, err := handler(ctx, req)
+	if err != nil {
+		st := toStatus(err)
+		if st.Code() == codes.Internal {
+			slog.ErrorContext(ctx, "internal error", "method", info.FullMethod, "error", err)
+		}
+		return nil, st.Err()
+	}
+	return resp, nil
+}
+
+// statusCodes maps errors of the service to status codes, the first matching one is used
+var statusCodes = []struct {
+	err  error
+	code codes.Code
+}{
+	{sql.ErrNoRows, codes.NotFound},
+	{service.ErrInvalidAmount, codes.InvalidArgument},
+	{service.ErrSameBalance, codes.InvalidArgument},
+	{service.ErrCurrencyMismatch, codes.InvalidArgument},
+	{money.ErrInvalidFormat, codes.InvalidArgument},
+	{money.ErrPrecision, codes.InvalidArgument},
+	{service.ErrInsufficientFunds, codes.FailedPrecondition},
+	{service.ErrOverdraftLimit, codes.FailedPrecondition},
+	{service.ErrMinBalance, codes.FailedPrecondition},
+	{service.ErrMaxTransactionAmount, codes.FailedPrecondition},
+	{service.ErrBalanceFrozen, codes.FailedPrecondition},
+	{service.ErrBalanceClosed, codes.FailedPrecondition},
+	{service.ErrHoldNotPending, codes.FailedPrecondition},
+	{service.ErrHoldExpired, codes.FailedPrecondition},
+	{service.ErrOverflow, codes.OutOfRange},
+	{context.DeadlineExceeded, codes.DeadlineExceeded},
+	{context.Canceled, codes.Canceled},
+}
+
+// toStatus converts service error to status, policy errors not listed in statusCodes become FailedPrecondition,
+// so new balance rules don't surface as Internal, unknown errors become Internal without details
+func toStatus(err error) *status.Status {
+	if st, ok := status.FromError(err); ok {
+		return st
+	}
+
+	for _, c := range statusCodes {
+		if errors.Is(err, c.err) {
+			return status.New(c.code, err.Error())
+		}
+	}
+
+	var policyErr *service.PolicyError
+	if errors.As(err, &policyErr) {
+		return status.New(codes.FailedPrecondition, err.Error())
+	}
+
+	return status.New(codes.Internal, "internal error")
+}
+
+func (s *Server) GetBalance(ctx context.Context, req *ledgerv1.GetBalanceRequest) (*ledgerv1.Balance, error) {
+	balance, err := s.services.GetBalanceById(ctx, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	return toBalance(balance), nil
+}
+
+func (s *Server) ListBalances(ctx context.Context, req *ledgerv1.ListBalancesRequest) (*ledgerv1.ListBalancesResponse, error) {
+	balances, err := s.services.GetAllBalances(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &ledgerv1.ListBalancesResponse{Balances: make([]*ledgerv1.Balance, 0, len(balances))}
+	for _, balance := range balances {
+		resp.Balances = append(resp.Balances, toBalance(balance))
+	}
+	return resp, nil
+}
+
+func (s *Server) Deposit(ctx context.Context, req *ledgerv1.DepositRequest) (*ledgerv1.Balance, error) {
+	amount, err := s.parseMoney(ctx, req.GetAmount())
+	if err != nil {
+		return nil, err
+	}
+
+	balance, err := s.services.Deposit(ctx, req.GetBalanceId(), amount)
+	if err != nil {
+		return nil, err
+	}
+	return toBalance(*balance), nil
+}
+
+func (s *Server) Withdraw(ctx context.Context, req *ledgerv1.WithdrawRequest) (*ledgerv1.Balance, error) {
+	amount, err := s.parseMoney(ctx, req.GetAmount())
+	if err != nil {
+		return nil, err
+	}
+
+	balance, err := s.services.Withdraw(ctx, req.GetBalanceId(), amount)
+	if err != nil {
+		return nil, err
+	}
+	return toBalance(*balance), nil
+}
+
+func (s *Server) Transfer(ctx context.Context, req *ledgerv1.TransferRequest) (*ledgerv1.TransferResponse, error) {
+	amount, err := s.parseMoney(ctx, req.GetAmount())
+	if err != nil {
+		return nil, err
+	}
+
+	balanceFrom, balanceTo, err := s.services.Transfer(ctx, req.GetFromBalanceId(), req.GetToBalanceId(), amount)
+	if err != nil {
+		return nil, err
+	}
+	return &ledgerv1.TransferResponse{From: toBalance(*balanceFrom), To: toBalance(*balanceTo)}, nil
+}
+
+func (s *Server) ListEntries(ctx context.Context, req *ledgerv1.ListEntriesRequest) (*ledgerv1.ListEntriesResponse, error) {
+	entries, err := s.services.GetAllEntries(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	currencies, err := s.balanceCurrencies(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &ledgerv1.ListEntriesResponse{Entries: make([]*ledgerv1.Entry, 0, len(entries))}
+	for _, entry := range entries {
+		resp.Entries = append(resp.Entries, &ledgerv1.Entry{
+			Id:        entry.ID,
+			BalanceId: entry.BalanceID,
+			Amount:    toMoney(money.New(entry.Amount, currencies[entry.BalanceID])),
+			Reference: entry.Reference.String,
+		})
+	}
+	return resp, nil
+}
+
+func (s *Server) ListTransfers(ctx context.Context, req *ledgerv1.ListTransfersRequest) (*ledgerv1.ListTransfersResponse, error) {
+	transfers, err := s.services.GetAllTransfers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	currencies, err := s.balanceCurrencies(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &ledgerv1.ListTransfersResponse{Transfers: make([]*ledgerv1.Transfer, 0, len(transfers))}
+	for _, transfer := range transfers {
+		resp.Transfers = append(resp.Transfers, &ledgerv1.Transfer{
+			Id:            transfer.ID,
+			FromBalanceId: transfer.FromBalanceID,
+			ToBalanceId:   transfer.ToBalanceID,
+			Amount:        toMoney(money.New(transfer.Amount, currencies[transfer.ToBalanceID])),
+			BatchId:       uint64(transfer.BatchID.Int64),
+		})
+	}
+	return resp, nil
+}
+
+func (s *Server) ListUsers(ctx context.Context, req *ledgerv1.ListUsersRequest) (*ledgerv1.ListUsersResponse, error) {
+	users, err := s.services.GetAllUsers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &ledgerv1.ListUsersResponse{Users: make([]*ledgerv1.User, 0, len(users))}
+	for _, user := range users {
+		resp.Users = append(resp.Users, &ledgerv1.User{Id: user.ID, Username: user.Username})
+	}
+	return resp, nil
+}
+
+func (s *Server) ListCurrencies(ctx context.Context, req *ledgerv1.ListCurrenciesRequest) (*ledgerv1.ListCurrenciesResponse, error) {
+	currencies, err := s.services.GetAllCurrencies(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &ledgerv1.ListCurrenciesResponse{Currencies: make([]*ledgerv1.Currency, 0, len(currencies))}
+	for _, currency := range currencies {
+		resp.Currencies = append(resp.Currencies, toCurrency(currency))
+	}
+	return resp, nil
+}
+
+// parseMoney reads the decimal amount in the currency given by code, unknown currency is a bad argument
+func (s *Server) parseMoney(ctx context.Context, m *ledgerv1.Money) (money.Money, error) {
+	if m.GetCurrency() == "" {
+		return money.Money{}, status.Error(codes.InvalidArgument, "amount currency is required")
+	}
+
+	currency, err := s.services.GetCurrencyByCode(ctx, m.GetCurrency())
+	if errors.Is(err, sql.ErrNoRows) {
+		return money.Money{}, status.Errorf(codes.InvalidArgument, "unknown currency %q", m.GetCurrency())
+	}
+	if err != nil {
+		return money.Money{}, err
+	}
+
+	return money.Parse(m.GetAmount(), money.Currency{Code: currency.Name, Exponent: currency.Exponent})
+}
+
+// balanceCurrencies returns currencies of all balances by balance id
+func (s *Server) balanceCurrencies(ctx context.Context) (map[uint64]money.Currency, error) {
+	balances, err := s.services.GetAllBalances(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	currencies := make(map[uint64]money.Currency, len(balances))
+	for _, balance := range balances {
+		currencies[balance.ID] = balance.Currency
+	}
+	return currencies, nil
+}
+
+func toBalance(balance service.Balance) *ledgerv1.Balance {
+	return &ledgerv1.Balance{
+		Id:        balance.ID,
+		UserId:    balance.UserID,
+		Amount:    toMoney(balance.Total()),
+		Held:      toMoney(balance.Held()),
+		Available: toMoney(balance.Available()),
+		Status:    balance.Status,
+	}
+}
+
+func toMoney(m money.Money) *ledgerv1.Money {
+	return &ledgerv1.Money{Amount: m.Decimal(), Currency: m.Currency.Code}
+}
+
+func toCurrency(currency db.Currency) *ledgerv1.Currency {
+	return &ledgerv1.Currency{Id: currency.ID, Code: currency.Name, Exponent: uint32(currency.Exponent)}
+}
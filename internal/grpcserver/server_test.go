@@ -0,0 +1,107 @@
+package grpcserver
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/tredoc/go-balances/internal/money"
+	"github.com/tredoc/go-balances/internal/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestToStatus(t *testing.T) {
+	t.Run("Test service errors are mapped to codes", func(t *testing.T) {
+		cases := []struct {
+			err  error
+			code codes.Code
+		}{
+			{sql.ErrNoRows, codes.NotFound},
+			{fmt.Errorf("get balance: %w", sql.ErrNoRows), codes.NotFound},
+			{service.ErrInvalidAmount, codes.InvalidArgument},
+			{money.ErrPrecision, codes.InvalidArgument},
+			{&service.PolicyError{BalanceID: 1, Err: service.ErrInsufficientFunds}, codes.FailedPrecondition},
+			{&service.PolicyError{BalanceID: 1, Err: service.ErrBalanceFrozen}, codes.FailedPrecondition},
+			{&service.PolicyError{BalanceID: 1, Err: service.ErrOverflow}, codes.OutOfRange},
+			{&service.PolicyError{BalanceID: 1, Err: service.ErrInvalidTransition}, codes.FailedPrecondition},
+			{&service.PolicyError{BalanceID: 1, Err: service.ErrInvalidStatus}, codes.FailedPrecondition},
+			{&service.PolicyError{BalanceID: 1, Err: service.ErrPendingHolds}, codes.FailedPrecondition},
+			{&service.PolicyError{BalanceID: 1, Err: service.ErrBalanceNotEmpty}, codes.FailedPrecondition},
+			{&service.PolicyError{BalanceID: 1, Err: errors.New("new rule")}, codes.FailedPrecondition},
+			{service.ErrHoldExpired, codes.FailedPrecondition},
+			{service.ErrHoldNotPending, codes.FailedPrecondition},
+			{context.DeadlineExceeded, codes.DeadlineExceeded},
+			{status.Error(codes.InvalidArgument, "unknown currency"), codes.InvalidArgument},
+		}
+
+		for _, c := range cases {
+			assert.Equal(t, toStatus(c.err).Code(), c.code, c.err.Error())
+		}
+	})
+
+	t.Run("Test unknown error details are hidden", func(t *testing.T) {
+		st := toStatus(errors.New("dial tcp 10.0.0.1:3306: connection refused"))
+		assert.Equal(t, st.Code(), codes.Internal)
+		assert.Equal(t, st.Message(), "internal error")
+	})
+}
+
+func TestDeadlineInterceptor(t *testing.T) {
+	handler := func(ctx context.Context, req any) (any, error) {
+		deadline, ok := ctx.Deadline()
+		assert.True(t, ok)
+		return deadline, nil
+	}
+	interceptor := deadlineInterceptor(time.Minute)
+
+	t.Run("Test default deadline is set", func(t *testing.T) {
+		resp, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{}, handler)
+		assert.Nil(t, err)
+		assert.WithinDuration(t, resp.(time.Time), time.Now().Add(time.Minute), time.Second)
+	})
+
+	t.Run("Test deadline of the client is kept", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+		defer cancel()
+
+		resp, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+		assert.Nil(t, err)
+		assert.WithinDuration(t, resp.(time.Time), time.Now().Add(time.Hour), time.Second)
+	})
+}
+
+func TestInitiatorInterceptor(t *testing.T) {
+	handler := func(ctx context.Context, req any) (any, error) {
+		return service.InitiatorFrom(ctx), nil
+	}
+
+	t.Run("Test actor from metadata is marked as asserted by the client", func(t *testing.T) {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-actor", "alice", "x-request-id", "req-1"))
+		ctx = peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5000}})
+
+		resp, err := initiatorInterceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+		assert.Nil(t, err)
+
+		initiator := resp.(service.Initiator)
+		assert.Equal(t, initiator.Actor, "asserted:alice")
+		assert.Equal(t, initiator.Client, "10.0.0.1:5000")
+		assert.Equal(t, initiator.RequestID, "req-1")
+	})
+
+	t.Run("Test call without actor has none", func(t *testing.T) {
+		resp, err := initiatorInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{}, handler)
+		assert.Nil(t, err)
+
+		initiator := resp.(service.Initiator)
+		assert.Empty(t, initiator.Actor)
+		assert.NotEmpty(t, initiator.RequestID)
+	})
+}
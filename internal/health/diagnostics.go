@@ -0,0 +1,109 @@
+package health
+
+import (
+	"context"
+	"time"
+)
+
+// Diagnostics is a snapshot for an operator looking into slow or stuck requests
+type Diagnostics struct {
+	Build Build `json:"build"`
+	Pool  Pool  `json:"pool"`
+	// transactions of this process that are begun and not yet committed or rolled back
+	InFlightTransactions int64      `json:"in_flight_transactions"`
+	LockWaits            []LockWait `json:"lock_waits"`
+	// performance_schema can be off or not granted to the user, the rest of the snapshot is still useful
+	LockWaitsError string `json:"lock_waits_error,omitempty"`
+}
+
+// Pool is sql.DBStats of the connection pool
+type Pool struct {
+	MaxOpen           int    `json:"max_open"`
+	Open              int    `json:"open"`
+	InUse             int    `json:"in_use"`
+	Idle              int    `json:"idle"`
+	WaitCount         int64  `json:"wait_count"`
+	WaitDuration      string `json:"wait_duration"`
+	MaxIdleClosed     int64  `json:"max_idle_closed"`
+	MaxIdleTimeClosed int64  `json:"max_idle_time_closed"`
+	MaxLifetimeClosed int64  `json:"max_lifetime_closed"`
+}
+
+// LockWait is a transaction waiting for a row lock held by another one
+type LockWait struct {
+	WaitingTrxID  uint64 `json:"waiting_trx_id"`
+	BlockingTrxID uint64 `json:"blocking_trx_id"`
+	Table         string `json:"table"`
+	Index         string `json:"index"`
+	// primary key of the locked row, like "1" for a balance
+	LockData     string `json:"lock_data"`
+	WaitingMode  string `json:"waiting_mode"`
+	BlockingMode string `json:"blocking_mode"`
+	Waiting      string `json:"waiting"`
+}
+
+// lockWaitsQuery lists lock waits on tables of the current database, longest first. It's not an sqlc
+// query as sqlc knows only the tables of the migrations.
+const lockWaitsQuery = `SELECT w.REQUESTING_ENGINE_TRANSACTION_ID, w.BLOCKING_ENGINE_TRANSACTION_ID,
+       r.OBJECT_NAME, COALESCE(r.INDEX_NAME, ''), COALESCE(r.LOCK_DATA, ''), r.LOCK_MODE, b.LOCK_MODE,
+       COALESCE(TIMESTAMPDIFF(MICROSECOND, t.trx_wait_started, NOW(6)), 0)
+FROM performance_schema.data_lock_waits w
+JOIN performance_schema.data_locks r ON r.ENGINE_LOCK_ID = w.REQUESTING_ENGINE_LOCK_ID
+JOIN performance_schema.data_locks b ON b.ENGINE_LOCK_ID = w.BLOCKING_ENGINE_LOCK_ID
+LEFT JOIN information_schema.innodb_trx t ON t.trx_id = w.REQUESTING_ENGINE_TRANSACTION_ID
+WHERE r.OBJECT_SCHEMA = DATABASE()
+ORDER BY 8 DESC`
+
+func (c *Checker) Diagnostics(ctx context.Context) Diagnostics {
+	stats := c.storage.DB.Stats()
+	diagnostics := Diagnostics{
+		Build: ReadBuild(),
+		Pool: Pool{
+			MaxOpen:           stats.MaxOpenConnections,
+			Open:              stats.OpenConnections,
+			InUse:             stats.InUse,
+			Idle:              stats.Idle,
+			WaitCount:         stats.WaitCount,
+			WaitDuration:      stats.WaitDuration.String(),
+			MaxIdleClosed:     stats.MaxIdleClosed,
+			MaxIdleTimeClosed: stats.MaxIdleTimeClosed,
+			MaxLifetimeClosed: stats.MaxLifetimeClosed,
+		},
+		InFlightTransactions: c.storage.InFlight(),
+		LockWaits:            []LockWait{},
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	lockWaits, err := c.lockWaits(ctx)
+	if err != nil {
+		diagnostics.LockWaitsError = err.Error()
+	} else {
+		diagnostics.LockWaits = lockWaits
+	}
+
+	return diagnostics
+}
+
+func (c *Checker) lockWaits(ctx context.Context) ([]LockWait, error) {
+	rows, err := c.storage.DB.QueryContext(ctx, lockWaitsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lockWaits := []LockWait{}
+	for rows.Next() {
+		var wait LockWait
+		var waiting int64
+		err = rows.Scan(&wait.WaitingTrxID, &wait.BlockingTrxID, &wait.Table, &wait.Index, &wait.LockData, &wait.WaitingMode, &wait.BlockingMode, &waiting)
+		if err != nil {
+			return nil, err
+		}
+		wait.Waiting = (time.Duration(waiting) * time.Microsecond).String()
+		lockWaits = append(lockWaits, wait)
+	}
+
+	return lockWaits, rows.Err()
+}
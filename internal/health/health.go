@@ -0,0 +1,164 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/redis/go-redis/v9"
+	"github.com/tredoc/go-balances/internal/schema"
+	"github.com/tredoc/go-balances/internal/store"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout bounds every readiness check, a probe must answer before the orchestrator gives up
+const DefaultTimeout = 2 * time.Second
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Check is the result of one readiness check
+type Check struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// Report is ok only when all of its checks are ok
+type Report struct {
+	Status string           `json:"status"`
+	Checks map[string]Check `json:"checks"`
+}
+
+// Checker answers liveness, readiness and diagnostics requests of a server
+type Checker struct {
+	storage *store.Store
+	// nil when readiness doesn't depend on redis
+	redis   *redis.Client
+	Timeout time.Duration
+}
+
+func New(storage *store.Store, client *redis.Client) *Checker {
+	return &Checker{
+		storage: storage,
+		redis:   client,
+		Timeout: DefaultTimeout,
+	}
+}
+
+// Ready runs all checks at once: mysql connectivity, the schema version the queries expect and redis
+func (c *Checker) Ready(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	checks := map[string]func(context.Context) error{
+		"mysql": c.storage.DB.PingContext,
+		"schema": func(ctx context.Context) error {
+			return schema.Verify(ctx, c.storage.DB)
+		},
+	}
+	if c.redis != nil {
+		checks["redis"] = func(ctx context.Context) error {
+			return c.redis.Ping(ctx).Err()
+		}
+	}
+
+	report := Report{Status: StatusOK, Checks: make(map[string]Check, len(checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			start := time.Now()
+			err := check(ctx)
+			result := Check{Status: StatusOK, Duration: time.Since(start).String()}
+			if err != nil {
+				result.Status = StatusFail
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = result
+			if err != nil {
+				report.Status = StatusFail
+			}
+		}()
+	}
+	wg.Wait()
+
+	return report
+}
+
+// Register mounts the probes on the mux:
+//
+//	GET /healthz            the process serves requests, nothing else is checked
+//	GET /readyz             503 until every readiness check passes
+//	GET /debug/diagnostics  pool stats, open transactions, lock waits and build info, when diagnostics is set
+func (c *Checker) Register(mux *http.ServeMux, diagnostics bool) {
+	mux.HandleFunc("GET /healthz", c.serveLive)
+	mux.HandleFunc("GET /readyz", c.serveReady)
+	if diagnostics {
+		mux.HandleFunc("GET /debug/diagnostics", c.serveDiagnostics)
+	}
+}
+
+func (c *Checker) serveLive(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": StatusOK})
+}
+
+func (c *Checker) serveReady(w http.ResponseWriter, r *http.Request) {
+	report := c.Ready(r.Context())
+
+	code := http.StatusOK
+	if report.Status != StatusOK {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, report)
+}
+
+func (c *Checker) serveDiagnostics(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, c.Diagnostics(r.Context()))
+}
+
+func writeJSON(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+// Build describes the running binary, vcs fields are empty when it was built outside of the repository
+type Build struct {
+	GoVersion string `json:"go_version"`
+	Path      string `json:"path"`
+	Version   string `json:"version"`
+	Revision  string `json:"revision,omitempty"`
+	Time      string `json:"time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+}
+
+func ReadBuild() Build {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return Build{}
+	}
+
+	build := Build{GoVersion: info.GoVersion, Path: info.Main.Path, Version: info.Main.Version}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			build.Revision = setting.Value
+		case "vcs.time":
+			build.Time = setting.Value
+		case "vcs.modified":
+			build.Modified = setting.Value == "true"
+		}
+	}
+	return build
+}
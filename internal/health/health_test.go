@@ -0,0 +1,100 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tredoc/go-balances/internal/store"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// unreachable returns a checker whose mysql and redis refuse connections
+func unreachable(t *testing.T) *Checker {
+	conn, err := sql.Open("mysql", "app:secret@tcp(127.0.0.1:1)/balances?timeout=200ms")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	client := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", DialTimeout: 200 * time.Millisecond, MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+
+	return New(store.New(conn), client)
+}
+
+func serve(t *testing.T, checker *Checker, path string) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	checker.Register(mux, true)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	return rec
+}
+
+func TestProbes(t *testing.T) {
+	checker := unreachable(t)
+
+	t.Run("Test liveness doesn't depend on dependencies", func(t *testing.T) {
+		rec := serve(t, checker, "/healthz")
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"status":"ok"}`, rec.Body.String())
+	})
+
+	t.Run("Test readiness fails with every failed check", func(t *testing.T) {
+		rec := serve(t, checker, "/readyz")
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+		var report Report
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+		assert.Equal(t, StatusFail, report.Status)
+		for _, name := range []string{"mysql", "schema", "redis"} {
+			assert.Equal(t, StatusFail, report.Checks[name].Status, name)
+			assert.NotEmpty(t, report.Checks[name].Error, name)
+		}
+	})
+
+	t.Run("Test redis is checked only when configured", func(t *testing.T) {
+		report := New(checker.storage, nil).Ready(context.Background())
+
+		assert.NotContains(t, report.Checks, "redis")
+		assert.Contains(t, report.Checks, "mysql")
+	})
+
+	t.Run("Test readiness is bounded by timeout", func(t *testing.T) {
+		slow := New(checker.storage, nil)
+		slow.Timeout = time.Nanosecond
+
+		start := time.Now()
+		report := slow.Ready(context.Background())
+		assert.Equal(t, StatusFail, report.Status)
+		assert.Less(t, time.Since(start), time.Second)
+	})
+
+	t.Run("Test diagnostics reports lock waits error and the rest", func(t *testing.T) {
+		rec := serve(t, checker, "/debug/diagnostics")
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var diagnostics Diagnostics
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &diagnostics))
+		assert.NotEmpty(t, diagnostics.LockWaitsError)
+		assert.Empty(t, diagnostics.LockWaits)
+		assert.Zero(t, diagnostics.InFlightTransactions)
+		assert.NotEmpty(t, diagnostics.Build.GoVersion)
+		assert.NotEmpty(t, diagnostics.Pool.WaitDuration)
+	})
+
+	t.Run("Test diagnostics can be left out", func(t *testing.T) {
+		mux := http.NewServeMux()
+		checker.Register(mux, false)
+
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/diagnostics", nil))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
@@ -0,0 +1,252 @@
+package linearize
+
+import (
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sort"
+)
+
+// DefaultMaxSteps bounds one search, it takes a few seconds
+const DefaultMaxSteps = 10_000_000
+
+type Verdict string
+
+const (
+	Linearizable    Verdict = "linearizable"
+	NotLinearizable Verdict = "not linearizable"
+	// the search ran out of steps
+	Unknown Verdict = "unknown"
+)
+
+type Result struct {
+	Verdict Verdict `json:"verdict"`
+	// operations of a history that is not linearizable, none of them can be removed and keep it so.
+	// Operations running when the history was cut are unknown in it.
+	Anomaly []Operation `json:"anomaly,omitempty"`
+	// Anomaly has no linearization whatever amounts its balances start with, otherwise it has none
+	// only from the initial amounts
+	AnyInitial bool `json:"any_initial,omitempty"`
+}
+
+// Checker searches for a linearization of a ledger history with the algorithm of Wing and Gong
+// improved by Lowe: operations are tried in the order of calls, states already seen with the same set
+// of linearized operations are skipped.
+type Checker struct {
+	// bounds every search, the verdict is unknown when one runs out, 0 is unbounded
+	MaxSteps int
+}
+
+func NewChecker() *Checker {
+	return &Checker{MaxSteps: DefaultMaxSteps}
+}
+
+// Check runs the default checker
+func Check(history []Operation, initial map[uint64]int64) Result {
+	return NewChecker().Check(history, initial)
+}
+
+// Check looks for an order of the operations that the bank account model accepts and that keeps real time:
+// the operation that returned before the other one was called goes first. Initial has amounts of the balances
+// before the history, balances it lacks start with any amount. Balances that are never in one transfer
+// together are independent, each group of them is checked on its own. A history that is not linearizable
+// gets the smallest anomaly of its first group that is not.
+func (c *Checker) Check(history []Operation, initial map[uint64]int64) Result {
+	result := Result{Verdict: Linearizable}
+	for _, part := range partition(history) {
+		switch c.search(part, initial) {
+		case Unknown:
+			result.Verdict = Unknown
+		case NotLinearizable:
+			return c.minimize(part, initial)
+		}
+	}
+	return result
+}
+
+// partition groups operations by connected balances, groups and their operations are in the order of calls
+func partition(history []Operation) [][]Operation {
+	parent := make(map[uint64]uint64)
+	var find func(id uint64) uint64
+	find = func(id uint64) uint64 {
+		p, ok := parent[id]
+		if !ok || p == id {
+			parent[id] = id
+			return id
+		}
+		root := find(p)
+		parent[id] = root
+		return root
+	}
+
+	for _, operation := range history {
+		ids := operation.balances()
+		for _, id := range ids[1:] {
+			parent[find(id)] = find(ids[0])
+		}
+	}
+
+	sorted := slices.Clone(history)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Call < sorted[j].Call })
+
+	groups := make(map[uint64]int)
+	var parts [][]Operation
+	for _, operation := range sorted {
+		root := find(operation.Input.BalanceID)
+		i, ok := groups[root]
+		if !ok {
+			i = len(parts)
+			groups[root] = i
+			parts = append(parts, nil)
+		}
+		parts[i] = append(parts[i], operation)
+	}
+	return parts
+}
+
+type entry struct {
+	op   *op
+	call bool
+	time int64
+	// return entry of the call
+	match      *entry
+	prev, next *entry
+}
+
+// lift takes the linearized operation out of the list
+func lift(e *entry) {
+	e.prev.next = e.next
+	if e.next != nil {
+		e.next.prev = e.prev
+	}
+	m := e.match
+	m.prev.next = m.next
+	if m.next != nil {
+		m.next.prev = m.prev
+	}
+}
+
+// unlift puts the operation back in reverse order of lift
+func unlift(e *entry) {
+	m := e.match
+	m.prev.next = m
+	if m.next != nil {
+		m.next.prev = m
+	}
+	e.prev.next = e
+	if e.next != nil {
+		e.next.prev = e
+	}
+}
+
+type cacheKey struct {
+	linearized [2]uint64
+	state      uint64
+}
+
+type frame struct {
+	entry      *entry
+	state      state
+	linearized [2]uint64
+}
+
+func (c *Checker) search(history []Operation, initial map[uint64]int64) Verdict {
+	index := make(map[uint64]int)
+	var s state
+	for _, operation := range history {
+		for _, id := range operation.balances() {
+			if _, ok := index[id]; ok {
+				continue
+			}
+			index[id] = len(s)
+			if amount, ok := initial[id]; ok {
+				s = append(s, value{known: true, amount: amount})
+			} else {
+				s = append(s, unknownValue)
+			}
+		}
+	}
+
+	// fixed seed keeps searches repeatable
+	random := rand.New(rand.NewPCG(1, 2))
+	ops := make([]op, len(history))
+	entries := make([]*entry, 0, 2*len(history))
+	for i, operation := range history {
+		ops[i] = op{
+			Operation: operation,
+			from:      index[operation.Input.BalanceID],
+			to:        index[operation.Input.ToID],
+			bits:      [2]uint64{random.Uint64(), random.Uint64()},
+		}
+
+		ret := operation.Return
+		if operation.Status == StatusUnknown {
+			ret = math.MaxInt64
+		}
+		call := &entry{op: &ops[i], call: true, time: operation.Call}
+		call.match = &entry{op: &ops[i], time: ret}
+		entries = append(entries, call, call.match)
+	}
+	// calls go first at the same time, so such operations are concurrent
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].time != entries[j].time {
+			return entries[i].time < entries[j].time
+		}
+		return entries[i].call && !entries[j].call
+	})
+
+	head := &entry{}
+	prev := head
+	for _, e := range entries {
+		prev.next = e
+		e.prev = prev
+		prev = e
+	}
+
+	cache := make(map[cacheKey]bool)
+	var stack []frame
+	var linearized [2]uint64
+	steps := 0
+	e := head.next
+	for head.next != nil {
+		if c.MaxSteps > 0 && steps >= c.MaxSteps {
+			return Unknown
+		}
+		steps++
+
+		if e.call {
+			next, ok := step(s, e.op)
+			if ok {
+				bits := [2]uint64{linearized[0] ^ e.op.bits[0], linearized[1] ^ e.op.bits[1]}
+				key := cacheKey{linearized: bits, state: next.hash()}
+				if !cache[key] {
+					cache[key] = true
+					stack = append(stack, frame{entry: e, state: s, linearized: linearized})
+					s, linearized = next, bits
+					lift(e)
+					e = head.next
+					continue
+				}
+			}
+			e = e.next
+			continue
+		}
+
+		// every operation that returned by now must be linearized, unknown ones return after all others
+		// and may never take effect
+		if e.op.Status == StatusUnknown {
+			return Linearizable
+		}
+		if len(stack) == 0 {
+			return NotLinearizable
+		}
+
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		s, linearized = top.state, top.linearized
+		unlift(top.entry)
+		e = top.entry.next
+	}
+
+	return Linearizable
+}
@@ -0,0 +1,88 @@
+package linearize
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrRejected is wrapped by Ledger errors of operations refused for lack of funds
+	ErrRejected = errors.New("rejected for insufficient funds")
+	// ErrNotApplied is wrapped by Ledger errors of operations that certainly changed nothing,
+	// every other error leaves the outcome unknown
+	ErrNotApplied = errors.New("not applied")
+)
+
+// Ledger is the storage under test, amounts are minor units and results are amounts of the balances
+// right after the operation
+type Ledger interface {
+	Deposit(ctx context.Context, balanceID uint64, amount int64) (int64, error)
+	Withdraw(ctx context.Context, balanceID uint64, amount int64) (int64, error)
+	Transfer(ctx context.Context, fromID uint64, toID uint64, amount int64) (int64, int64, error)
+	Read(ctx context.Context, balanceID uint64) (int64, error)
+}
+
+// Client is one process of the history, it calls the ledger and records every call. Calls of one client
+// must not overlap, use a client per goroutine.
+type Client struct {
+	ledger   Ledger
+	recorder *Recorder
+	process  int
+}
+
+// NewClient records to recorder as the process, nil recorder records nothing
+func NewClient(ledger Ledger, recorder *Recorder, process int) *Client {
+	return &Client{ledger: ledger, recorder: recorder, process: process}
+}
+
+func (c *Client) Deposit(ctx context.Context, balanceID uint64, amount int64) (int64, error) {
+	id := c.invoke(Input{Kind: KindDeposit, BalanceID: balanceID, Amount: amount})
+	result, err := c.ledger.Deposit(ctx, balanceID, amount)
+	c.complete(id, err, Output{Amount: result})
+	return result, err
+}
+
+func (c *Client) Withdraw(ctx context.Context, balanceID uint64, amount int64) (int64, error) {
+	id := c.invoke(Input{Kind: KindWithdraw, BalanceID: balanceID, Amount: amount})
+	result, err := c.ledger.Withdraw(ctx, balanceID, amount)
+	c.complete(id, err, Output{Amount: result})
+	return result, err
+}
+
+func (c *Client) Transfer(ctx context.Context, fromID uint64, toID uint64, amount int64) (int64, int64, error) {
+	id := c.invoke(Input{Kind: KindTransfer, BalanceID: fromID, ToID: toID, Amount: amount})
+	from, to, err := c.ledger.Transfer(ctx, fromID, toID, amount)
+	c.complete(id, err, Output{Amount: from, ToAmount: to})
+	return from, to, err
+}
+
+func (c *Client) Read(ctx context.Context, balanceID uint64) (int64, error) {
+	id := c.invoke(Input{Kind: KindRead, BalanceID: balanceID})
+	result, err := c.ledger.Read(ctx, balanceID)
+	c.complete(id, err, Output{Amount: result})
+	return result, err
+}
+
+func (c *Client) invoke(input Input) int {
+	if c.recorder == nil {
+		return -1
+	}
+	return c.recorder.Invoke(c.process, input)
+}
+
+func (c *Client) complete(id int, err error, output Output) {
+	if c.recorder == nil {
+		return
+	}
+
+	switch {
+	case err == nil:
+		c.recorder.Complete(id, StatusOK, output)
+	case errors.Is(err, ErrRejected):
+		c.recorder.Complete(id, StatusRejected, Output{})
+	case errors.Is(err, ErrNotApplied):
+		c.recorder.Fail(id)
+	default:
+		c.recorder.Complete(id, StatusUnknown, Output{})
+	}
+}
@@ -0,0 +1,151 @@
+package linearize
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+type Kind string
+
+const (
+	KindDeposit  Kind = "deposit"
+	KindWithdraw Kind = "withdraw"
+	KindTransfer Kind = "transfer"
+	KindRead     Kind = "read"
+)
+
+type Status string
+
+const (
+	// the operation took effect, Output is what it returned
+	StatusOK Status = "ok"
+	// the operation was refused for lack of funds and changed nothing
+	StatusRejected Status = "rejected"
+	// the outcome was never seen, like after a timeout, the operation could take effect at any time after its call
+	StatusUnknown Status = "unknown"
+)
+
+// Input is what the operation was called with
+type Input struct {
+	Kind Kind `json:"kind"`
+	// the balance of deposit, withdraw and read, the sender of transfer
+	BalanceID uint64 `json:"balance_id"`
+	ToID      uint64 `json:"to_id,omitempty"`
+	Amount    int64  `json:"amount,omitempty"`
+}
+
+// Output is the amounts of the balances right after the operation, read returns the amount it saw
+type Output struct {
+	Amount   int64 `json:"amount"`
+	ToAmount int64 `json:"to_amount,omitempty"`
+}
+
+// Operation is a call of the ledger from the invocation to the completion. Call and Return are logical times
+// of a history, Return of an unknown operation is math.MaxInt64 as it may take effect at any time later.
+type Operation struct {
+	ID      int    `json:"id"`
+	Process int    `json:"process"`
+	Input   Input  `json:"input"`
+	Output  Output `json:"output"`
+	Status  Status `json:"status"`
+	Call    int64  `json:"call"`
+	Return  int64  `json:"return"`
+}
+
+func (op Operation) String() string {
+	in := op.Input
+	var call string
+	switch in.Kind {
+	case KindTransfer:
+		call = fmt.Sprintf("transfer %d %d->%d", in.Amount, in.BalanceID, in.ToID)
+	case KindRead:
+		call = fmt.Sprintf("read %d", in.BalanceID)
+	default:
+		call = fmt.Sprintf("%s %d %d", in.Kind, in.Amount, in.BalanceID)
+	}
+
+	result := string(op.Status)
+	switch {
+	case op.Status == StatusOK && in.Kind == KindTransfer:
+		result = fmt.Sprintf("ok %d/%d", op.Output.Amount, op.Output.ToAmount)
+	case op.Status == StatusOK:
+		result = fmt.Sprintf("ok %d", op.Output.Amount)
+	}
+
+	ret := "..."
+	if op.Return != math.MaxInt64 {
+		ret = fmt.Sprint(op.Return)
+	}
+	return fmt.Sprintf("#%d p%d %s: %s [%d, %s]", op.ID, op.Process, call, result, op.Call, ret)
+}
+
+// balances returns ids of the balances the operation touches
+func (op Operation) balances() []uint64 {
+	if op.Input.Kind == KindTransfer {
+		return []uint64{op.Input.BalanceID, op.Input.ToID}
+	}
+	return []uint64{op.Input.BalanceID}
+}
+
+// Recorder collects the history of concurrent clients, it's safe for concurrent use
+type Recorder struct {
+	mu    sync.Mutex
+	clock int64
+	ops   []Operation
+	// failed operations are left out of the history
+	failed []bool
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Invoke records the call of the operation right before it's sent to the ledger and returns its id
+func (r *Recorder) Invoke(process int, input Input) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.clock++
+	id := len(r.ops)
+	r.ops = append(r.ops, Operation{ID: id, Process: process, Input: input, Status: StatusUnknown, Call: r.clock, Return: math.MaxInt64})
+	r.failed = append(r.failed, false)
+	return id
+}
+
+// Complete records the outcome of the operation right after the ledger returned it
+func (r *Recorder) Complete(id int, status Status, output Output) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.clock++
+	if status == StatusUnknown {
+		return
+	}
+	r.ops[id].Status = status
+	r.ops[id].Output = output
+	r.ops[id].Return = r.clock
+}
+
+// Fail records the operation that certainly took no effect, like one rolled back after a deadlock
+func (r *Recorder) Fail(id int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.clock++
+	r.failed[id] = true
+}
+
+// History returns recorded operations in the order of calls, the ones that haven't completed yet are unknown
+func (r *Recorder) History() []Operation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	history := make([]Operation, 0, len(r.ops))
+	for id, op := range r.ops {
+		if !r.failed[id] {
+			history = append(history, op)
+		}
+	}
+	return history
+}
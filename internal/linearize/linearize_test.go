@@ -0,0 +1,328 @@
+package linearize
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math"
+	"sync"
+	"testing"
+)
+
+// history builds operations with ids in the order they are given
+type history []Operation
+
+func (h *history) add(status Status, in Input, out Output, call int64, ret int64) *history {
+	if status == StatusUnknown {
+		ret = math.MaxInt64
+	}
+	*h = append(*h, Operation{ID: len(*h), Process: len(*h), Input: in, Output: out, Status: status, Call: call, Return: ret})
+	return h
+}
+
+func deposit(id uint64, amount int64) Input {
+	return Input{Kind: KindDeposit, BalanceID: id, Amount: amount}
+}
+
+func withdraw(id uint64, amount int64) Input {
+	return Input{Kind: KindWithdraw, BalanceID: id, Amount: amount}
+}
+
+func transfer(from uint64, to uint64, amount int64) Input {
+	return Input{Kind: KindTransfer, BalanceID: from, ToID: to, Amount: amount}
+}
+
+func read(id uint64) Input {
+	return Input{Kind: KindRead, BalanceID: id}
+}
+
+func ids(ops []Operation) []int {
+	var list []int
+	for _, op := range ops {
+		list = append(list, op.ID)
+	}
+	return list
+}
+
+func TestCheck(t *testing.T) {
+	initial := map[uint64]int64{1: 100, 2: 100, 3: 100}
+
+	t.Run("Test concurrent deposits in any order", func(t *testing.T) {
+		var h history
+		h.add(StatusOK, deposit(1, 5), Output{Amount: 115}, 1, 4)
+		h.add(StatusOK, deposit(1, 10), Output{Amount: 110}, 2, 3)
+
+		assert.Equal(t, Linearizable, Check(h, initial).Verdict)
+	})
+
+	t.Run("Test lost update is an anomaly with any initial amount", func(t *testing.T) {
+		var h history
+		h.add(StatusOK, deposit(1, 5), Output{Amount: 105}, 1, 3)
+		h.add(StatusOK, deposit(1, 5), Output{Amount: 105}, 2, 4)
+
+		result := Check(h, initial)
+		assert.Equal(t, NotLinearizable, result.Verdict)
+		assert.True(t, result.AnyInitial)
+		assert.Equal(t, []int{0, 1}, ids(result.Anomaly))
+	})
+
+	t.Run("Test real time order is kept", func(t *testing.T) {
+		var h history
+		h.add(StatusOK, deposit(1, 5), Output{Amount: 110}, 1, 2)
+		h.add(StatusOK, deposit(1, 5), Output{Amount: 105}, 3, 4)
+
+		assert.Equal(t, NotLinearizable, Check(h, initial).Verdict)
+	})
+
+	t.Run("Test dirty read of a rolled back deposit", func(t *testing.T) {
+		// the deposit failed, so it's not in the history, but the read saw it
+		var h history
+		h.add(StatusOK, read(1), Output{Amount: 150}, 1, 2)
+
+		result := Check(h, initial)
+		assert.Equal(t, NotLinearizable, result.Verdict)
+		assert.False(t, result.AnyInitial)
+		assert.Equal(t, []int{0}, ids(result.Anomaly))
+
+		assert.Equal(t, Linearizable, Check(h, nil).Verdict)
+	})
+
+	t.Run("Test unknown operation may or may not take effect", func(t *testing.T) {
+		for _, seen := range []int64{100, 150} {
+			var h history
+			h.add(StatusUnknown, deposit(1, 50), Output{}, 1, 0)
+			h.add(StatusOK, read(1), Output{Amount: seen}, 2, 3)
+
+			assert.Equal(t, Linearizable, Check(h, initial).Verdict, seen)
+		}
+
+		var h history
+		h.add(StatusUnknown, deposit(1, 50), Output{}, 1, 0)
+		h.add(StatusOK, read(1), Output{Amount: 120}, 2, 3)
+		assert.Equal(t, NotLinearizable, Check(h, initial).Verdict)
+	})
+
+	t.Run("Test rejection must lack funds", func(t *testing.T) {
+		var h history
+		h.add(StatusRejected, withdraw(1, 200), Output{}, 1, 2)
+		h.add(StatusRejected, transfer(1, 2, 101), Output{}, 3, 4)
+		assert.Equal(t, Linearizable, Check(h, initial).Verdict)
+
+		h = nil
+		h.add(StatusRejected, withdraw(1, 50), Output{}, 1, 2)
+		assert.Equal(t, NotLinearizable, Check(h, initial).Verdict)
+
+		// concurrent withdrawal could take the funds first
+		h = nil
+		h.add(StatusOK, withdraw(1, 80), Output{Amount: 20}, 1, 4)
+		h.add(StatusRejected, withdraw(1, 50), Output{}, 2, 3)
+		assert.Equal(t, Linearizable, Check(h, initial).Verdict)
+	})
+
+	t.Run("Test balance never goes negative", func(t *testing.T) {
+		var h history
+		h.add(StatusOK, withdraw(1, 150), Output{Amount: -50}, 1, 2)
+
+		assert.Equal(t, NotLinearizable, Check(h, initial).Verdict)
+	})
+
+	t.Run("Test anomaly is cut out of a long history", func(t *testing.T) {
+		var h history
+		amount := int64(100)
+		time := int64(0)
+		for i := 0; i < 50; i++ {
+			amount += 10
+			h.add(StatusOK, deposit(1, 10), Output{Amount: amount}, time+1, time+2)
+			h.add(StatusOK, transfer(2, 3, 1), Output{Amount: 100 - int64(i) - 1, ToAmount: 100 + int64(i) + 1}, time+1, time+2)
+			time += 2
+		}
+		// the second deposit overwrites the first one
+		lost := len(h)
+		h.add(StatusOK, deposit(1, 7), Output{Amount: amount + 7}, time+1, time+3)
+		h.add(StatusOK, deposit(1, 3), Output{Amount: amount + 3}, time+2, time+4)
+		h.add(StatusOK, read(1), Output{Amount: amount + 3}, time+5, time+6)
+
+		result := Check(h, initial)
+		assert.Equal(t, NotLinearizable, result.Verdict)
+		assert.True(t, result.AnyInitial)
+		assert.ElementsMatch(t, []int{lost, lost + 1}, ids(result.Anomaly))
+	})
+
+	t.Run("Test independent balances are checked apart", func(t *testing.T) {
+		var h history
+		h.add(StatusOK, transfer(1, 2, 50), Output{Amount: 50, ToAmount: 150}, 1, 2)
+		h.add(StatusOK, deposit(3, 1), Output{Amount: 101}, 1, 2)
+		h.add(StatusOK, deposit(3, 1), Output{Amount: 101}, 3, 4)
+
+		parts := partition(h)
+		assert.Len(t, parts, 2)
+
+		result := Check(h, initial)
+		assert.Equal(t, NotLinearizable, result.Verdict)
+		for _, op := range result.Anomaly {
+			assert.Equal(t, uint64(3), op.Input.BalanceID)
+		}
+	})
+
+	t.Run("Test search out of steps is unknown", func(t *testing.T) {
+		var h history
+		for i := int64(0); i < 10; i++ {
+			h.add(StatusOK, deposit(1, 1), Output{Amount: 101 + i}, 1+i, 100+i)
+		}
+
+		checker := NewChecker()
+		checker.MaxSteps = 3
+		assert.Equal(t, Unknown, checker.Check(h, initial).Verdict)
+		assert.Equal(t, Linearizable, Check(h, initial).Verdict)
+	})
+}
+
+// memoryLedger is a ledger under one mutex, lostUpdates makes the first deposits read the amount
+// before all of them write it back
+type memoryLedger struct {
+	mu          sync.Mutex
+	amounts     map[uint64]int64
+	lostUpdates *sync.WaitGroup
+}
+
+func (l *memoryLedger) Deposit(ctx context.Context, id uint64, amount int64) (int64, error) {
+	if l.lostUpdates != nil {
+		l.mu.Lock()
+		before := l.amounts[id]
+		l.mu.Unlock()
+
+		l.lostUpdates.Done()
+		l.lostUpdates.Wait()
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.amounts[id] = before + amount
+		return before + amount, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.amounts[id] += amount
+	return l.amounts[id], nil
+}
+
+func (l *memoryLedger) Withdraw(ctx context.Context, id uint64, amount int64) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.amounts[id] < amount {
+		return 0, ErrRejected
+	}
+	l.amounts[id] -= amount
+	return l.amounts[id], nil
+}
+
+func (l *memoryLedger) Transfer(ctx context.Context, from uint64, to uint64, amount int64) (int64, int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if from == to {
+		return 0, 0, fmt.Errorf("%w: same balance", ErrNotApplied)
+	}
+	if l.amounts[from] < amount {
+		return 0, 0, fmt.Errorf("balance %d: %w", from, ErrRejected)
+	}
+	l.amounts[from] -= amount
+	l.amounts[to] += amount
+	return l.amounts[from], l.amounts[to], nil
+}
+
+func (l *memoryLedger) Read(ctx context.Context, id uint64) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.amounts[id], nil
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	initial := map[uint64]int64{1: 100, 2: 100, 3: 100}
+
+	t.Run("Test history of a ledger with one lock is linearizable", func(t *testing.T) {
+		ledger := &memoryLedger{amounts: map[uint64]int64{1: 100, 2: 100, 3: 100}}
+		recorder := NewRecorder()
+
+		var wg sync.WaitGroup
+		for process := 0; process < 8; process++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				client := NewClient(ledger, recorder, process)
+				for i := 0; i < 50; i++ {
+					id := uint64(1 + (process+i)%3)
+					switch i % 5 {
+					case 0:
+						client.Deposit(ctx, id, int64(i))
+					case 1:
+						client.Withdraw(ctx, id, int64(3*i))
+					case 2:
+						client.Transfer(ctx, id, 1+id%3, int64(i))
+					case 3:
+						client.Transfer(ctx, id, id, 1)
+					default:
+						client.Read(ctx, id)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		history := recorder.History()
+		// transfers to the same balance certainly failed and are left out
+		assert.Len(t, history, 8*40)
+		assert.Equal(t, Linearizable, Check(history, initial).Verdict)
+	})
+
+	t.Run("Test lost update of a racy ledger is found", func(t *testing.T) {
+		var lostUpdates sync.WaitGroup
+		lostUpdates.Add(2)
+		ledger := &memoryLedger{amounts: map[uint64]int64{1: 100}, lostUpdates: &lostUpdates}
+		recorder := NewRecorder()
+
+		var wg sync.WaitGroup
+		for process := 0; process < 2; process++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				NewClient(ledger, recorder, process).Deposit(ctx, 1, 10)
+			}()
+		}
+		wg.Wait()
+
+		ledger.lostUpdates = nil
+		client := NewClient(ledger, recorder, 2)
+		_, err := client.Read(ctx, 1)
+		require.NoError(t, err)
+
+		result := Check(recorder.History(), initial)
+		assert.Equal(t, NotLinearizable, result.Verdict)
+		assert.Len(t, result.Anomaly, 2)
+		for _, op := range result.Anomaly {
+			assert.Equal(t, KindDeposit, op.Input.Kind)
+		}
+	})
+
+	t.Run("Test errors are recorded by what they say about the outcome", func(t *testing.T) {
+		recorder := NewRecorder()
+		id := recorder.Invoke(0, deposit(1, 1))
+		recorder.Complete(id, StatusUnknown, Output{})
+		id = recorder.Invoke(0, deposit(1, 1))
+		recorder.Fail(id)
+		recorder.Invoke(1, read(1))
+
+		history := recorder.History()
+		require.Len(t, history, 2)
+		for _, op := range history {
+			assert.Equal(t, StatusUnknown, op.Status)
+			assert.Equal(t, int64(math.MaxInt64), op.Return)
+		}
+
+		client := NewClient(&memoryLedger{amounts: map[uint64]int64{}}, nil, 0)
+		_, err := client.Withdraw(ctx, 1, 1)
+		assert.True(t, errors.Is(err, ErrRejected))
+	})
+}
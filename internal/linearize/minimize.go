@@ -0,0 +1,88 @@
+package linearize
+
+import (
+	"math"
+	"slices"
+	"sort"
+)
+
+// minimize cuts the history that is not linearizable down to an anomaly:
+//  1. the history is cut at the earliest return it already fails at, linearizability is prefix closed
+//     as long as operations running at the cut are taken as unknown
+//  2. if the cut fails with any initial amounts, the earliest operations that aren't needed are dropped
+//  3. the rest is shrunk by delta debugging until no operation can be removed
+func (c *Checker) minimize(history []Operation, initial map[uint64]int64) Result {
+	var returns []int64
+	for _, operation := range history {
+		if operation.Status != StatusUnknown {
+			returns = append(returns, operation.Return)
+		}
+	}
+	slices.Sort(returns)
+
+	ops := history
+	first := sort.Search(len(returns), func(i int) bool {
+		return c.search(cut(history, returns[i]), initial) == NotLinearizable
+	})
+	if first < len(returns) {
+		ops = cut(history, returns[first])
+	}
+
+	anyInitial := c.search(ops, nil) == NotLinearizable
+	if anyInitial {
+		initial = nil
+		start := sort.Search(len(ops), func(i int) bool {
+			return c.search(ops[i:], nil) != NotLinearizable
+		})
+		if start > 0 {
+			ops = ops[start-1:]
+		}
+	}
+
+	return Result{Verdict: NotLinearizable, Anomaly: c.shrink(ops, initial), AnyInitial: anyInitial}
+}
+
+// cut returns operations called before the time, the ones that returned after it are unknown
+func cut(history []Operation, time int64) []Operation {
+	var ops []Operation
+	for _, operation := range history {
+		if operation.Call > time {
+			continue
+		}
+		if operation.Return > time {
+			operation.Status = StatusUnknown
+			operation.Output = Output{}
+			operation.Return = math.MaxInt64
+		}
+		ops = append(ops, operation)
+	}
+	return ops
+}
+
+// shrink removes chunks of operations while the rest stays not linearizable, chunks are halved
+// when none can be removed, down to single operations
+func (c *Checker) shrink(ops []Operation, initial map[uint64]int64) []Operation {
+	chunks := 2
+	for len(ops) >= 2 {
+		size := (len(ops) + chunks - 1) / chunks
+		reduced := false
+		for start := 0; start < len(ops); start += size {
+			candidate := append(slices.Clone(ops[:start]), ops[min(start+size, len(ops)):]...)
+			if c.search(candidate, initial) == NotLinearizable {
+				ops = candidate
+				chunks = max(chunks-1, 2)
+				reduced = true
+				break
+			}
+		}
+
+		if reduced {
+			continue
+		}
+		if size == 1 {
+			break
+		}
+		chunks = min(chunks*2, len(ops))
+	}
+	return ops
+}
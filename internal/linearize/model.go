@@ -0,0 +1,170 @@
+package linearize
+
+import "math"
+
+// value is the amount of a balance in the bank account model. Amounts the checker isn't given are unknown
+// until an operation reveals them, till then only an upper bound from rejected debits is kept.
+type value struct {
+	known  bool
+	amount int64
+	// unknown amount is less than below
+	below int64
+}
+
+var unknownValue = value{below: math.MaxInt64}
+
+// state holds amounts of the balances of a partition by their index
+type state []value
+
+// op is the operation with its balances resolved to indexes of the state
+type op struct {
+	Operation
+	from, to int
+	// random bits of the operation, a set of linearized operations is hashed by xor of them
+	bits [2]uint64
+}
+
+// step applies the operation to a copy of the state, false means the operation can't take effect in it.
+// Amounts never go negative, debits that would overdraw are rejected.
+func step(s state, o *op) (state, bool) {
+	next := make(state, len(s))
+	copy(next, s)
+
+	switch o.Status {
+	case StatusOK:
+		return next, next.apply(o)
+	case StatusRejected:
+		return next, next.reject(o)
+	default:
+		return next, next.applyUnknown(o)
+	}
+}
+
+// apply checks that the results of the operation follow from the amounts before it
+func (s state) apply(o *op) bool {
+	amount := o.Input.Amount
+	out := o.Output
+
+	switch o.Input.Kind {
+	case KindDeposit:
+		if !s.reveal(o.from, out.Amount-amount) {
+			return false
+		}
+		s[o.from] = value{known: true, amount: out.Amount}
+	case KindWithdraw:
+		if out.Amount < 0 || !s.reveal(o.from, out.Amount+amount) {
+			return false
+		}
+		s[o.from] = value{known: true, amount: out.Amount}
+	case KindTransfer:
+		if out.Amount < 0 || !s.reveal(o.from, out.Amount+amount) || !s.reveal(o.to, out.ToAmount-amount) {
+			return false
+		}
+		s[o.from] = value{known: true, amount: out.Amount}
+		s[o.to] = value{known: true, amount: out.ToAmount}
+	case KindRead:
+		return s.reveal(o.from, out.Amount)
+	default:
+		return false
+	}
+	return true
+}
+
+// reject checks that the debit couldn't be paid
+func (s state) reject(o *op) bool {
+	if o.Input.Kind != KindWithdraw && o.Input.Kind != KindTransfer {
+		return false
+	}
+
+	v := s[o.from]
+	if v.known {
+		return v.amount < o.Input.Amount
+	}
+	if o.Input.Amount <= 0 {
+		return false
+	}
+	s[o.from].below = min(v.below, o.Input.Amount)
+	return true
+}
+
+// applyUnknown takes the effect of the operation whose results were never seen. Unknown read changes
+// nothing, so it's never linearized, the same as if it never happened.
+func (s state) applyUnknown(o *op) bool {
+	amount := o.Input.Amount
+
+	switch o.Input.Kind {
+	case KindDeposit:
+		s.add(o.from, amount)
+	case KindWithdraw:
+		return s.take(o.from, amount)
+	case KindTransfer:
+		if !s.take(o.from, amount) {
+			return false
+		}
+		s.add(o.to, amount)
+	default:
+		return false
+	}
+	return true
+}
+
+// reveal settles the amount of the balance, false when it contradicts what's known
+func (s state) reveal(i int, amount int64) bool {
+	v := s[i]
+	switch {
+	case amount < 0:
+		return false
+	case v.known:
+		return v.amount == amount
+	case amount >= v.below:
+		return false
+	}
+	s[i] = value{known: true, amount: amount}
+	return true
+}
+
+func (s state) add(i int, amount int64) {
+	v := s[i]
+	if v.known {
+		v.amount += amount
+	} else if v.below != math.MaxInt64 {
+		v.below += amount
+	}
+	s[i] = v
+}
+
+// take debits the balance, false when it certainly has less
+func (s state) take(i int, amount int64) bool {
+	v := s[i]
+	switch {
+	case v.known && v.amount < amount:
+		return false
+	case v.known:
+		v.amount -= amount
+	case v.below <= amount:
+		return false
+	case v.below != math.MaxInt64:
+		v.below -= amount
+	}
+	s[i] = v
+	return true
+}
+
+// hash is fnv-1a of the state
+func (s state) hash() uint64 {
+	h := uint64(14695981039346656037)
+	mix := func(x uint64) {
+		h ^= x
+		h *= 1099511628211
+	}
+	for _, v := range s {
+		if v.known {
+			mix(1)
+			mix(uint64(v.amount))
+		} else {
+			mix(2)
+			mix(uint64(v.below))
+		}
+	}
+	return h
+}
@@ -0,0 +1,120 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tredoc/go-balances/internal/config"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func decode(t *testing.T, buf *bytes.Buffer) map[string]any {
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	return record
+}
+
+func TestNew(t *testing.T) {
+	t.Run("Test json format", func(t *testing.T) {
+		var buf bytes.Buffer
+		New(config.Log{Level: "info", Format: "json"}, &buf).Info("applied", "balance_id", 1)
+
+		record := decode(t, &buf)
+		assert.Equal(t, "applied", record["msg"])
+		assert.Equal(t, float64(1), record["balance_id"])
+	})
+
+	t.Run("Test text format", func(t *testing.T) {
+		var buf bytes.Buffer
+		New(config.Log{Level: "info", Format: "text"}, &buf).Info("applied", "balance_id", 1)
+
+		assert.Contains(t, buf.String(), "msg=applied balance_id=1")
+	})
+
+	t.Run("Test level filters records", func(t *testing.T) {
+		var buf bytes.Buffer
+		logger := New(config.Log{Level: "warn", Format: "json"}, &buf)
+
+		logger.Info("skipped")
+		assert.Empty(t, buf.String())
+
+		logger.Warn("kept")
+		assert.Equal(t, "kept", decode(t, &buf)["msg"])
+	})
+
+	t.Run("Test secrets are redacted", func(t *testing.T) {
+		var buf bytes.Buffer
+		logger := New(config.Log{Level: "info", Format: "json"}, &buf)
+
+		logger.Info("config", "password", "hunter2", "DB_PASS", "hunter2", "api_token", "abc", slog.Group("db", "dsn", "root:hunter2@/balances"), "user", "root")
+
+		assert.NotContains(t, buf.String(), "hunter2")
+		assert.NotContains(t, buf.String(), "abc")
+
+		record := decode(t, &buf)
+		assert.Equal(t, redacted, record["password"])
+		assert.Equal(t, redacted, record["DB_PASS"])
+		assert.Equal(t, redacted, record["api_token"])
+		assert.Equal(t, redacted, record["db"].(map[string]any)["dsn"])
+		assert.Equal(t, "root", record["user"])
+	})
+
+	t.Run("Test request id and trace id are added from context", func(t *testing.T) {
+		var buf bytes.Buffer
+		logger := New(config.Log{Level: "info", Format: "json"}, &buf).With("component", "test")
+
+		traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+		spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+		ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID}))
+		ctx = WithRequestID(ctx, "req-1")
+
+		logger.InfoContext(ctx, "applied")
+
+		record := decode(t, &buf)
+		assert.Equal(t, "req-1", record["request_id"])
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", record["trace_id"])
+		assert.Equal(t, "test", record["component"])
+	})
+
+	t.Run("Test record without context has no correlation", func(t *testing.T) {
+		var buf bytes.Buffer
+		New(config.Log{Level: "info", Format: "json"}, &buf).Info("applied")
+
+		record := decode(t, &buf)
+		assert.NotContains(t, record, "request_id")
+		assert.NotContains(t, record, "trace_id")
+	})
+}
+
+func TestHandler(t *testing.T) {
+	var got string
+	handler := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = RequestID(r.Context())
+	}))
+
+	t.Run("Test request id is taken from header", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Request-Id", "req-1")
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		assert.Equal(t, "req-1", got)
+		assert.Equal(t, "req-1", rec.Header().Get("X-Request-Id"))
+	})
+
+	t.Run("Test request id is generated", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		assert.Len(t, got, 32)
+		assert.Equal(t, got, rec.Header().Get("X-Request-Id"))
+	})
+}
@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/tredoc/go-balances/internal/store"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const namespace = "balances"
+
+// Outcomes of ledger operations
+const (
+	OutcomeSuccess           = "success"
+	OutcomeInsufficientFunds = "insufficient_funds"
+	OutcomeInvalidAmount     = "invalid_amount"
+	// other policy or status rule of the balance
+	OutcomeRejected = "rejected"
+	OutcomeError    = "error"
+)
+
+var (
+	Operations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "operations_total",
+		Help:      "Deposits, withdrawals and transfers by outcome.",
+	}, []string{"operation", "outcome"})
+
+	OperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "operation_duration_seconds",
+		Help:      "End-to-end latency of operations including retries.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"operation"})
+
+	LockWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "balance_lock_wait_seconds",
+		Help:      "Time spent waiting for balance row locks.",
+		Buckets:   []float64{.0005, .001, .005, .01, .025, .05, .1, .25, .5, 1, 5},
+	})
+
+	LockConflicts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "lock_conflicts_total",
+		Help:      "Deadlocks and lock wait timeouts hit by operations.",
+	}, []string{"operation", "reason"})
+
+	Retries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "retries_total",
+		Help:      "Operations repeated after a deadlock or lock wait timeout.",
+	}, []string{"operation"})
+)
+
+// NewRegistry registers operation metrics with go runtime, process, connection pool stats and
+// money totals per currency, the last two are read from the database on every scrape
+func NewRegistry(storage *store.Store) *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		Operations,
+		OperationDuration,
+		LockWait,
+		LockConflicts,
+		Retries,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewDBStatsCollector(storage.DB, "balances"),
+		newTotalsCollector(storage),
+	)
+	return registry
+}
+
+// Sum adds up the counter over all its label values, tools running in the process read their own counters with it
+func Sum(counter *prometheus.CounterVec) float64 {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		counter.Collect(ch)
+		close(ch)
+	}()
+
+	var sum float64
+	for metric := range ch {
+		var m dto.Metric
+		if metric.Write(&m) == nil {
+			sum += m.GetCounter().GetValue()
+		}
+	}
+	return sum
+}
+
+// Handler serves metrics of the registry in text exposition format
+func Handler(registry *prometheus.Registry) http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{Registry: registry})
+}
+
+// totalsCollector reports money of all balances per currency in major units
+type totalsCollector struct {
+	storage *store.Store
+	total   *prometheus.Desc
+}
+
+func newTotalsCollector(storage *store.Store) *totalsCollector {
+	return &totalsCollector{
+		storage: storage,
+		total: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "money_total"),
+			"Sum of balance amounts per currency in major units.",
+			[]string{"currency"}, nil,
+		),
+	}
+}
+
+func (c *totalsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.total
+}
+
+func (c *totalsCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	totals, err := c.storage.GetTotalsByCurrency(ctx)
+	if err != nil {
+		slog.Error("metrics: money totals", "error", err)
+		ch <- prometheus.NewInvalidMetric(c.total, err)
+		return
+	}
+
+	for _, total := range totals {
+		value := total.Total
+		for i := uint8(0); i < total.Exponent; i++ {
+			value /= 10
+		}
+		ch <- prometheus.MustNewConstMetric(c.total, prometheus.GaugeValue, value, total.Name)
+	}
+}
@@ -0,0 +1,191 @@
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// MaxExponent keeps 10^exponent inside of int64
+const MaxExponent = 18
+
+// RateScale is the number of decimal places of exchange rates, the whole part has at most as many digits
+const RateScale = 18
+
+var (
+	ErrInvalidFormat = errors.New("invalid money format")
+	ErrPrecision     = errors.New("amount has more decimal places than currency allows")
+	ErrOverflow      = errors.New("amount is out of range")
+	ErrExponent      = errors.New("currency exponent is out of range")
+	ErrInvalidRate   = errors.New("exchange rate must be a positive decimal with at most 18 decimal places")
+)
+
+// Currency describes how amounts are stored, exponent is the number of minor units digits,
+// 2 for USD where 100 means $1.00 and 6 for USDT
+type Currency struct {
+	Code     string
+	Exponent uint8
+}
+
+// Money is an amount in minor units of the currency, it's never converted to float
+type Money struct {
+	Amount   int64
+	Currency Currency
+}
+
+func New(amount int64, currency Currency) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// Parse converts decimal string like "-12.34" to money, amount can't have more decimal places than currency allows
+func Parse(s string, currency Currency) (Money, error) {
+	if currency.Exponent > MaxExponent {
+		return Money{}, ErrExponent
+	}
+
+	negative := false
+	switch {
+	case strings.HasPrefix(s, "-"):
+		negative = true
+		s = s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+
+	whole, fraction, hasPoint := strings.Cut(s, ".")
+	if whole == "" || (hasPoint && fraction == "") || !digits(whole) || !digits(fraction) {
+		return Money{}, ErrInvalidFormat
+	}
+
+	fraction = strings.TrimRight(fraction, "0")
+	if len(fraction) > int(currency.Exponent) {
+		return Money{}, ErrPrecision
+	}
+	fraction += strings.Repeat("0", int(currency.Exponent)-len(fraction))
+
+	// accumulate as negative number, so the minimal int64 can be parsed too
+	var amount int64
+	for _, r := range strings.TrimLeft(whole, "0") + fraction {
+		digit := int64(r - '0')
+		if amount < (minInt64+digit)/10 {
+			return Money{}, ErrOverflow
+		}
+		amount = amount*10 - digit
+	}
+
+	if !negative {
+		if amount == minInt64 {
+			return Money{}, ErrOverflow
+		}
+		amount = -amount
+	}
+
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+const minInt64 = -1 << 63
+
+func digits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Decimal formats amount with exactly currency exponent decimal places, like "-12.30"
+func (m Money) Decimal() string {
+	sign := ""
+	// uint64 is used to format the minimal int64 without overflow
+	units := uint64(m.Amount)
+	if m.Amount < 0 {
+		sign = "-"
+		units = -units
+	}
+
+	s := fmt.Sprintf("%0*d", int(m.Currency.Exponent)+1, units)
+	if m.Currency.Exponent == 0 {
+		return sign + s
+	}
+
+	point := len(s) - int(m.Currency.Exponent)
+	return sign + s[:point] + "." + s[point:]
+}
+
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency.Code
+}
+
+func (m Money) IsPositive() bool {
+	return m.Amount > 0
+}
+
+// Add returns a + b or ErrOverflow if the sum doesn't fit into int64
+func Add(a int64, b int64) (int64, error) {
+	sum := a + b
+	if (b > 0 && sum < a) || (b < 0 && sum > a) {
+		return 0, ErrOverflow
+	}
+	return sum, nil
+}
+
+// Sub returns a - b or ErrOverflow if the difference doesn't fit into int64
+func Sub(a int64, b int64) (int64, error) {
+	diff := a - b
+	if (b > 0 && diff > a) || (b < 0 && diff < a) {
+		return 0, ErrOverflow
+	}
+	return diff, nil
+}
+
+// ParseRate reads a positive decimal exchange rate like "1.0835", it's kept exact as a fraction
+func ParseRate(s string) (*big.Rat, error) {
+	whole, fraction, hasPoint := strings.Cut(s, ".")
+	if whole == "" || (hasPoint && fraction == "") || !digits(whole) || !digits(fraction) ||
+		len(strings.TrimLeft(whole, "0")) > RateScale || len(fraction) > RateScale {
+		return nil, ErrInvalidRate
+	}
+
+	rate, ok := new(big.Rat).SetString(s)
+	if !ok || rate.Sign() <= 0 {
+		return nil, ErrInvalidRate
+	}
+	return rate, nil
+}
+
+// FormatRate writes the rate rounded to RateScale decimal places without trailing zeros
+func FormatRate(rate *big.Rat) string {
+	s := rate.FloatString(RateScale)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// Convert returns the amount in the currency, rate is units of the currency for one unit of the currency of m.
+// The result is rounded half away from zero to minor units, ErrOverflow if it doesn't fit into int64.
+func Convert(m Money, rate *big.Rat, to Currency) (Money, error) {
+	if m.Currency.Exponent > MaxExponent || to.Exponent > MaxExponent {
+		return Money{}, ErrExponent
+	}
+
+	value := new(big.Rat).SetInt64(m.Amount)
+	value.Mul(value, rate)
+	value.Mul(value, new(big.Rat).SetFrac(pow10(to.Exponent), pow10(m.Currency.Exponent)))
+
+	// quotient is truncated toward zero, the remainder has the sign of the amount
+	quo, rem := new(big.Int).QuoRem(value.Num(), value.Denom(), new(big.Int))
+	rem.Abs(rem).Lsh(rem, 1)
+	if rem.Cmp(value.Denom()) >= 0 {
+		quo.Add(quo, big.NewInt(int64(value.Sign())))
+	}
+
+	if !quo.IsInt64() {
+		return Money{}, ErrOverflow
+	}
+	return Money{Amount: quo.Int64(), Currency: to}, nil
+}
+
+func pow10(exponent uint8) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exponent)), nil)
+}
@@ -0,0 +1,201 @@
+package money
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math/big"
+	"testing"
+)
+
+var usd = Currency{Code: "USD", Exponent: 2}
+var usdt = Currency{Code: "USDT", Exponent: 6}
+var jpy = Currency{Code: "JPY", Exponent: 0}
+
+func TestParse(t *testing.T) {
+	t.Run("Test valid amounts", func(t *testing.T) {
+		cases := []struct {
+			in       string
+			currency Currency
+			amount   int64
+		}{
+			{"100", usd, 10000},
+			{"1.5", usd, 150},
+			{"1.50", usd, 150},
+			{"0.01", usd, 1},
+			{"-12.34", usd, -1234},
+			{"+7", usd, 700},
+			{"1.123456", usdt, 1123456},
+			{"1.1000000", usdt, 1100000},
+			{"42", jpy, 42},
+			{"9223372036854775807", jpy, 1<<63 - 1},
+			{"-9223372036854775808", jpy, -1 << 63},
+			{"92233720368547758.07", usd, 1<<63 - 1},
+		}
+
+		for _, c := range cases {
+			m, err := Parse(c.in, c.currency)
+			assert.Nil(t, err, c.in)
+			assert.Equal(t, m, New(c.amount, c.currency), c.in)
+		}
+	})
+
+	t.Run("Test invalid amounts", func(t *testing.T) {
+		cases := []struct {
+			in       string
+			currency Currency
+			err      error
+		}{
+			{"", usd, ErrInvalidFormat},
+			{"1.", usd, ErrInvalidFormat},
+			{".5", usd, ErrInvalidFormat},
+			{"1,5", usd, ErrInvalidFormat},
+			{"1e3", usd, ErrInvalidFormat},
+			{"--1", usd, ErrInvalidFormat},
+			{"1.001", usd, ErrPrecision},
+			{"1.1234567", usdt, ErrPrecision},
+			{"1.5", jpy, ErrPrecision},
+			{"9223372036854775808", jpy, ErrOverflow},
+			{"92233720368547758.08", usd, ErrOverflow},
+			{"1", Currency{Code: "XXX", Exponent: 19}, ErrExponent},
+		}
+
+		for _, c := range cases {
+			_, err := Parse(c.in, c.currency)
+			assert.ErrorIs(t, err, c.err, c.in)
+		}
+	})
+}
+
+func TestDecimal(t *testing.T) {
+	t.Run("Test formatting", func(t *testing.T) {
+		assert.Equal(t, New(1234, usd).Decimal(), "12.34")
+		assert.Equal(t, New(5, usd).Decimal(), "0.05")
+		assert.Equal(t, New(-5, usd).Decimal(), "-0.05")
+		assert.Equal(t, New(0, usd).Decimal(), "0.00")
+		assert.Equal(t, New(1, usdt).Decimal(), "0.000001")
+		assert.Equal(t, New(-42, jpy).Decimal(), "-42")
+		assert.Equal(t, New(-1<<63, usd).Decimal(), "-92233720368547758.08")
+		assert.Equal(t, New(1234, usd).String(), "12.34 USD")
+	})
+
+	t.Run("Test round trip", func(t *testing.T) {
+		for _, amount := range []int64{0, 1, -1, 99, 100, 123456789, 1<<63 - 1, -1 << 63} {
+			for _, currency := range []Currency{usd, usdt, jpy} {
+				m, err := Parse(New(amount, currency).Decimal(), currency)
+				assert.Nil(t, err)
+				assert.Equal(t, m.Amount, amount)
+			}
+		}
+	})
+}
+
+func TestArithmetic(t *testing.T) {
+	t.Run("Test checked add and sub", func(t *testing.T) {
+		sum, err := Add(1, 2)
+		assert.Nil(t, err)
+		assert.Equal(t, sum, int64(3))
+
+		_, err = Add(1<<63-1, 1)
+		assert.ErrorIs(t, err, ErrOverflow)
+
+		_, err = Add(-1<<63, -1)
+		assert.ErrorIs(t, err, ErrOverflow)
+
+		diff, err := Sub(-1, 1<<63-1)
+		assert.Nil(t, err)
+		assert.Equal(t, diff, int64(-1<<63))
+
+		_, err = Sub(-2, 1<<63-1)
+		assert.ErrorIs(t, err, ErrOverflow)
+
+		_, err = Sub(0, -1<<63)
+		assert.ErrorIs(t, err, ErrOverflow)
+	})
+}
+
+func TestConvert(t *testing.T) {
+	eur := Currency{Code: "EUR", Exponent: 2}
+
+	t.Run("Test rate parsing", func(t *testing.T) {
+		rate, err := ParseRate("1.0835")
+		assert.Nil(t, err)
+		assert.Equal(t, rate, big.NewRat(10835, 10000))
+		assert.Equal(t, FormatRate(rate), "1.0835")
+		assert.Equal(t, FormatRate(new(big.Rat).Inv(big.NewRat(3, 1))), "0.333333333333333333")
+		assert.Equal(t, FormatRate(big.NewRat(150, 1)), "150")
+
+		for _, s := range []string{"", "0", "0.000", "-1", "1.", ".5", "1e3", "1/3", "1.0000000000000000001", "1000000000000000000"} {
+			_, err := ParseRate(s)
+			assert.ErrorIs(t, err, ErrInvalidRate, s)
+		}
+	})
+
+	t.Run("Test amounts are converted between exponents", func(t *testing.T) {
+		converted, err := Convert(New(1000, eur), big.NewRat(10835, 10000), usd)
+		assert.Nil(t, err)
+		assert.Equal(t, converted, New(1084, usd))
+
+		converted, err = Convert(New(12345, usd), big.NewRat(150, 1), jpy)
+		assert.Nil(t, err)
+		assert.Equal(t, converted, New(18518, jpy))
+
+		converted, err = Convert(New(1, usd), big.NewRat(1, 1), usdt)
+		assert.Nil(t, err)
+		assert.Equal(t, converted, New(10000, usdt))
+	})
+
+	t.Run("Test halves are rounded away from zero", func(t *testing.T) {
+		converted, err := Convert(New(5, usd), big.NewRat(1, 10), usd)
+		assert.Nil(t, err)
+		assert.Equal(t, converted.Amount, int64(1))
+
+		converted, err = Convert(New(-5, usd), big.NewRat(1, 10), usd)
+		assert.Nil(t, err)
+		assert.Equal(t, converted.Amount, int64(-1))
+
+		converted, err = Convert(New(4, usd), big.NewRat(1, 10), usd)
+		assert.Nil(t, err)
+		assert.Equal(t, converted.Amount, int64(0))
+	})
+
+	t.Run("Test converted amount out of range", func(t *testing.T) {
+		_, err := Convert(New(1<<62, usd), big.NewRat(2, 1), usd)
+		assert.ErrorIs(t, err, ErrOverflow)
+
+		_, err = Convert(New(1, usd), big.NewRat(1, 1), Currency{Code: "BAD", Exponent: 19})
+		assert.ErrorIs(t, err, ErrExponent)
+	})
+}
+
+// checkedResult compares checked arithmetic with arbitrary precision one
+func checkedResult(t *testing.T, result int64, err error, exact *big.Int) {
+	if exact.IsInt64() {
+		assert.Nil(t, err)
+		assert.Equal(t, result, exact.Int64())
+	} else {
+		assert.ErrorIs(t, err, ErrOverflow)
+	}
+}
+
+func FuzzAdd(f *testing.F) {
+	f.Add(int64(1), int64(2))
+	f.Add(int64(1<<63-1), int64(1))
+	f.Add(int64(-1<<63), int64(-1))
+	f.Add(int64(-1<<63), int64(1<<63-1))
+
+	f.Fuzz(func(t *testing.T, a int64, b int64) {
+		sum, err := Add(a, b)
+		checkedResult(t, sum, err, new(big.Int).Add(big.NewInt(a), big.NewInt(b)))
+	})
+}
+
+func FuzzSub(f *testing.F) {
+	f.Add(int64(1), int64(2))
+	f.Add(int64(-1), int64(1<<63-1))
+	f.Add(int64(0), int64(-1<<63))
+	f.Add(int64(1<<63-1), int64(-1))
+
+	f.Fuzz(func(t *testing.T, a int64, b int64) {
+		diff, err := Sub(a, b)
+		checkedResult(t, diff, err, new(big.Int).Sub(big.NewInt(a), big.NewInt(b)))
+	})
+}
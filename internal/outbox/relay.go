@@ -0,0 +1,199 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	db "github.com/tredoc/go-balances/db/sqlc"
+	"github.com/tredoc/go-balances/internal/store"
+	"time"
+)
+
+const (
+	DefaultBatchSize = 100
+	DefaultInterval  = time.Second
+	DefaultBaseDelay = time.Second
+	DefaultMaxDelay  = 10 * time.Minute
+	// longer than a batch of slow sink calls takes, a relay that dies keeps its claims until then
+	DefaultClaim = 30 * time.Minute
+)
+
+// Event is an outbox row as it's published to sinks, id grows in the order of changes of one balance
+type Event struct {
+	ID        uint64          `json:"id"`
+	BalanceID uint64          `json:"balance_id"`
+	Type      string          `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+func newEvent(row db.OutboxEvent) Event {
+	return Event{
+		ID:        row.ID,
+		BalanceID: row.BalanceID,
+		Type:      row.EventType,
+		Payload:   json.RawMessage(row.Payload),
+		CreatedAt: row.CreatedAt,
+	}
+}
+
+// Sink publishes events to consumers, Publish must return nil only after the sink accepted the event.
+// The same event can be published more than once, consumers dedupe by event id. The relay holds no locks on
+// the event row during Publish, so sinks can write rows referencing it, like webhook deliveries.
+type Sink interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// Relay moves pending outbox events to the sink
+type Relay struct {
+	store     *store.Store
+	sink      Sink
+	BatchSize int
+	Interval  time.Duration
+	// failed event is retried after BaseDelay, the delay doubles after every failure up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// how long a claimed batch is hidden from other relays
+	Claim time.Duration
+	now   func() time.Time
+}
+
+func NewRelay(store *store.Store, sink Sink) *Relay {
+	return &Relay{
+		store:     store,
+		sink:      sink,
+		BatchSize: DefaultBatchSize,
+		Interval:  DefaultInterval,
+		BaseDelay: DefaultBaseDelay,
+		MaxDelay:  DefaultMaxDelay,
+		Claim:     DefaultClaim,
+		now:       time.Now,
+	}
+}
+
+// clock returns current time in UTC with seconds precision, the one mysql DATETIME keeps
+func (r *Relay) clock() time.Time {
+	return r.now().UTC().Truncate(time.Second)
+}
+
+// backoff returns the delay before the next attempt of the event that failed attempts times
+func (r *Relay) backoff(attempts int) time.Duration {
+	delay := r.BaseDelay
+	for i := 1; i < attempts && delay < r.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, r.MaxDelay)
+}
+
+// Run relays events until ctx is done, it waits for Interval only when there is nothing to relay
+func (r *Relay) Run(ctx context.Context) error {
+	for {
+		delivered, err := r.RelayOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			return err
+		}
+
+		if delivered > 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(r.Interval):
+		}
+	}
+}
+
+// RelayOnce publishes up to BatchSize pending events in id order and returns the number of delivered ones.
+// Once an event of a balance fails, later events of that balance wait until it's retried with backoff, so consumers
+// always get events of one balance in order. Balances waiting for a retry are left out of the batch, so they don't
+// hold up events of other balances.
+//
+// The batch is claimed for Claim in a short transaction and published without holding row locks, so slow sinks
+// don't block writers or other relays, which skip balances with claimed events. Events are marked delivered only
+// after the sink accepted them, a crash between both steps publishes the event again once the claim expires.
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	rows, err := r.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	failed := make(map[uint64]bool)
+	for _, row := range rows {
+		if failed[row.BalanceID] {
+			// the event waits for the failed one, the next run picks it up
+			err = r.store.ReleaseOutboxEvent(ctx, row.ID)
+			if err != nil {
+				return delivered, err
+			}
+			continue
+		}
+
+		err = r.sink.Publish(ctx, newEvent(row))
+		if err != nil {
+			failed[row.BalanceID] = true
+
+			errText := err.Error()
+			if runes := []rune(errText); len(runes) > 1000 {
+				errText = string(runes[:1000])
+			}
+
+			err = r.store.MarkOutboxEventFailed(ctx, db.MarkOutboxEventFailedParams{
+				ID:            row.ID,
+				LastError:     errText,
+				NextAttemptAt: sql.NullTime{Time: r.clock().Add(r.backoff(int(row.Attempts) + 1)), Valid: true},
+			})
+			if err != nil {
+				return delivered, err
+			}
+			continue
+		}
+
+		err = r.store.MarkOutboxEventDelivered(ctx, row.ID)
+		if err != nil {
+			return delivered, err
+		}
+		delivered++
+	}
+
+	return delivered, nil
+}
+
+// claim picks the batch and hides it from other relays until the claim expires
+func (r *Relay) claim(ctx context.Context) ([]db.OutboxEvent, error) {
+	// read committed takes no gap locks, so services can add events while the batch is claimed
+	tx, err := r.store.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	qtx := r.store.WithTx(tx)
+
+	// locked rows keep concurrent relays from claiming the same batch
+	now := r.clock()
+	rows, err := qtx.GetPendingOutboxEventsForUpdate(ctx, db.GetPendingOutboxEventsForUpdateParams{
+		Now:   sql.NullTime{Time: now, Valid: true},
+		Limit: int32(r.BatchSize),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claimedUntil := sql.NullTime{Time: now.Add(r.Claim), Valid: true}
+	for _, row := range rows {
+		err = qtx.ClaimOutboxEvent(ctx, db.ClaimOutboxEventParams{ID: row.ID, ClaimedUntil: claimedUntil})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return rows, nil
+}
@@ -0,0 +1,19 @@
+package outbox
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	t.Run("Test delay doubles after every failure up to max", func(t *testing.T) {
+		relay := &Relay{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+		assert.Equal(t, relay.backoff(1), time.Second)
+		assert.Equal(t, relay.backoff(2), 2*time.Second)
+		assert.Equal(t, relay.backoff(4), 8*time.Second)
+		assert.Equal(t, relay.backoff(5), 10*time.Second)
+		assert.Equal(t, relay.backoff(1000), 10*time.Second)
+	})
+}
@@ -0,0 +1,63 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var event = Event{
+	ID:        42,
+	BalanceID: 1,
+	Type:      "balance.changed",
+	Payload:   json.RawMessage(`{"balance_id":1,"delta":150}`),
+	CreatedAt: time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC),
+}
+
+func TestWriterSink(t *testing.T) {
+	t.Run("Test events are written as json lines", func(t *testing.T) {
+		var buf bytes.Buffer
+		sink := NewWriterSink(&buf)
+
+		assert.Nil(t, sink.Publish(context.Background(), event))
+		assert.Nil(t, sink.Publish(context.Background(), event))
+
+		line := `{"id":42,"balance_id":1,"type":"balance.changed","payload":{"balance_id":1,"delta":150},"created_at":"2024-03-01T10:00:00Z"}` + "\n"
+		assert.Equal(t, buf.String(), line+line)
+	})
+}
+
+func TestWebhookSink(t *testing.T) {
+	t.Run("Test event is posted", func(t *testing.T) {
+		var got Event
+		var key string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key = r.Header.Get("Idempotency-Key")
+			body, _ := io.ReadAll(r.Body)
+			json.Unmarshal(body, &got)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+
+		err := NewWebhookSink(server.URL, server.Client()).Publish(context.Background(), event)
+		assert.Nil(t, err)
+		assert.Equal(t, got, event)
+		assert.Equal(t, key, "42")
+	})
+
+	t.Run("Test non 2xx status is a failure", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		err := NewWebhookSink(server.URL, server.Client()).Publish(context.Background(), event)
+		assert.ErrorContains(t, err, "503")
+	})
+}
@@ -0,0 +1,221 @@
+package schema
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/golang-migrate/migrate/v4"
+	migratemysql "github.com/golang-migrate/migrate/v4/database/mysql"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/tredoc/go-balances/db/migrations"
+	db "github.com/tredoc/go-balances/db/sqlc"
+	"github.com/tredoc/go-balances/internal/config"
+	"io/fs"
+	"sort"
+)
+
+var (
+	// ErrMismatch means the database schema isn't the one the compiled queries expect
+	ErrMismatch = errors.New("schema version mismatch")
+	// ErrDirty means a migration failed half way, the schema has to be fixed by hand and forced to a version
+	ErrDirty = errors.New("schema is dirty")
+)
+
+// Migration is one embedded migration file pair
+type Migration struct {
+	Version uint
+	Name    string
+}
+
+// Status is the version of the database against embedded migrations
+type Status struct {
+	// 0 when no migration was applied
+	Version uint
+	Dirty   bool
+	// version the compiled queries expect
+	Expected uint
+	Latest   uint
+	Pending  []Migration
+}
+
+// Migrator applies embedded migrations, it holds its own connection as migration files
+// have several statements each
+type Migrator struct {
+	m *migrate.Migrate
+}
+
+// Open connects to the database of the config with multi statements enabled
+func Open(cfg config.DB) (*Migrator, error) {
+	dsn, err := mysql.ParseDSN(cfg.DSN())
+	if err != nil {
+		return nil, err
+	}
+	dsn.MultiStatements = true
+
+	conn, err := sql.Open("mysql", dsn.FormatDSN())
+	if err != nil {
+		return nil, err
+	}
+
+	driver, err := migratemysql.WithInstance(conn, &migratemysql.Config{})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	src, err := iofs.New(migrations.FS, ".")
+	if err != nil {
+		driver.Close()
+		return nil, err
+	}
+
+	m, err := migrate.NewWithInstance("iofs", src, "mysql", driver)
+	if err != nil {
+		driver.Close()
+		return nil, err
+	}
+
+	return &Migrator{m: m}, nil
+}
+
+func (m *Migrator) Close() error {
+	srcErr, dbErr := m.m.Close()
+	return errors.Join(srcErr, dbErr)
+}
+
+// Up applies all pending migrations
+func (m *Migrator) Up() error {
+	return ignoreNoChange(m.m.Up())
+}
+
+// Down rolls back the given number of applied migrations
+func (m *Migrator) Down(steps int) error {
+	if steps < 1 {
+		return fmt.Errorf("invalid number of steps %d", steps)
+	}
+	return ignoreNoChange(m.m.Steps(-steps))
+}
+
+// Goto migrates up or down to the version
+func (m *Migrator) Goto(version uint) error {
+	return ignoreNoChange(m.m.Migrate(version))
+}
+
+// Force sets the version without running migrations, it clears the dirty flag after the schema was fixed by hand
+func (m *Migrator) Force(version uint) error {
+	return m.m.Force(int(version))
+}
+
+// Version returns the applied version, 0 when the database has none
+func (m *Migrator) Version() (uint, bool, error) {
+	version, dirty, err := m.m.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
+
+func (m *Migrator) Status() (Status, error) {
+	version, dirty, err := m.Version()
+	if err != nil {
+		return Status{}, err
+	}
+
+	all, err := List()
+	if err != nil {
+		return Status{}, err
+	}
+
+	status := Status{Version: version, Dirty: dirty, Expected: db.SchemaVersion}
+	for _, migration := range all {
+		status.Latest = migration.Version
+		if migration.Version > version {
+			status.Pending = append(status.Pending, migration)
+		}
+	}
+	return status, nil
+}
+
+// Check makes sure the database has the version the compiled queries expect, older schema is migrated
+// when autoMigrate is set, newer schema is always refused as queries could miss its columns
+func (m *Migrator) Check(autoMigrate bool) error {
+	version, dirty, err := m.Version()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case dirty:
+		return fmt.Errorf("%w: version %d", ErrDirty, version)
+	case version == db.SchemaVersion:
+		return nil
+	case version < db.SchemaVersion && autoMigrate:
+		return m.Goto(db.SchemaVersion)
+	default:
+		return fmt.Errorf("%w: database has %d, expected %d", ErrMismatch, version, db.SchemaVersion)
+	}
+}
+
+// Ensure opens the migrator of the config and runs Check, servers call it before they start serving
+func Ensure(cfg config.DB, autoMigrate bool) error {
+	m, err := Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	return m.Check(autoMigrate)
+}
+
+// Verify reads the version with conn of the server, it never migrates, readiness probes call it
+func Verify(ctx context.Context, conn *sql.DB) error {
+	var version uint
+	var dirty bool
+	err := conn.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+
+	var mysqlErr *mysql.MySQLError
+	switch {
+	case errors.Is(err, sql.ErrNoRows), errors.As(err, &mysqlErr) && mysqlErr.Number == 1146:
+		// no migration was applied, the table is created by the first one
+	case err != nil:
+		return err
+	}
+
+	switch {
+	case dirty:
+		return fmt.Errorf("%w: version %d", ErrDirty, version)
+	case version != db.SchemaVersion:
+		return fmt.Errorf("%w: database has %d, expected %d", ErrMismatch, version, db.SchemaVersion)
+	}
+	return nil
+}
+
+// List returns embedded migrations ordered by version
+func List() ([]Migration, error) {
+	files, err := fs.ReadDir(migrations.FS, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	var list []Migration
+	for _, file := range files {
+		parsed, err := source.DefaultParse(file.Name())
+		if err != nil || parsed.Direction != source.Up {
+			continue
+		}
+		list = append(list, Migration{Version: parsed.Version, Name: parsed.Identifier})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Version < list[j].Version })
+
+	return list, nil
+}
+
+func ignoreNoChange(err error) error {
+	if errors.Is(err, migrate.ErrNoChange) {
+		return nil
+	}
+	return err
+}
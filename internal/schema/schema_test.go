@@ -0,0 +1,32 @@
+package schema
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tredoc/go-balances/db/migrations"
+	db "github.com/tredoc/go-balances/db/sqlc"
+	"io/fs"
+	"testing"
+)
+
+func TestList(t *testing.T) {
+	t.Run("Test migrations are consecutive and end at the expected version", func(t *testing.T) {
+		list, err := List()
+		require.NoError(t, err)
+		require.NotEmpty(t, list)
+
+		for i, migration := range list {
+			assert.Equal(t, uint(i+1), migration.Version, migration.Name)
+		}
+		assert.Equal(t, uint(db.SchemaVersion), list[len(list)-1].Version)
+	})
+
+	t.Run("Test every migration can be rolled back", func(t *testing.T) {
+		ups, err := fs.Glob(migrations.FS, "*.up.sql")
+		require.NoError(t, err)
+		downs, err := fs.Glob(migrations.FS, "*.down.sql")
+		require.NoError(t, err)
+
+		assert.Equal(t, len(ups), len(downs))
+	})
+}
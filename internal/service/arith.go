@@ -0,0 +1,24 @@
+package service
+
+import (
+	"github.com/tredoc/go-balances/internal/money"
+)
+
+// addAmount returns amount of the balance increased by delta, all balance arithmetic is checked
+// before anything is written, so an amount can never wrap around int64
+func addAmount(balanceID uint64, amount int64, delta int64) (int64, error) {
+	result, err := money.Add(amount, delta)
+	if err != nil {
+		return 0, &PolicyError{BalanceID: balanceID, Err: ErrOverflow}
+	}
+	return result, nil
+}
+
+// subAmount returns amount of the balance decreased by delta, see addAmount
+func subAmount(balanceID uint64, amount int64, delta int64) (int64, error) {
+	result, err := money.Sub(amount, delta)
+	if err != nil {
+		return 0, &PolicyError{BalanceID: balanceID, Err: ErrOverflow}
+	}
+	return result, nil
+}
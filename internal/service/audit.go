@@ -0,0 +1,340 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	db "github.com/tredoc/go-balances/db/sqlc"
+	"github.com/tredoc/go-balances/internal/logging"
+	"github.com/tredoc/go-balances/internal/money"
+	"strings"
+	"time"
+)
+
+const (
+	AuditDeposit   = "deposit"
+	AuditWithdraw  = "withdraw"
+	AuditTransfer  = "transfer"
+	AuditAuthorize = "authorize"
+	AuditCapture   = "capture"
+	AuditVoid      = "void"
+	AuditBatch     = "batch"
+	AuditImport    = "import"
+	AuditStatus    = "status"
+	AuditPolicy    = "policy"
+)
+
+const (
+	AuditApplied  = "applied"
+	AuditRejected = "rejected"
+)
+
+// auditGenesisHash is prev_hash of the first record
+var auditGenesisHash = strings.Repeat("0", 64)
+
+// auditVerifyPage is the number of records read at once by VerifyAudit
+const auditVerifyPage = 1000
+
+// Initiator describes who started the operation, it travels in the context of the request
+type Initiator struct {
+	Actor     string
+	Client    string
+	RequestID string
+}
+
+type initiatorKey struct{}
+
+// WithInitiator sets the initiator of the request, its request id correlates logs of the request too
+func WithInitiator(ctx context.Context, initiator Initiator) context.Context {
+	if initiator.RequestID != "" {
+		ctx = logging.WithRequestID(ctx, initiator.RequestID)
+	}
+	return context.WithValue(ctx, initiatorKey{}, initiator)
+}
+
+// InitiatorFrom returns initiator of the request, it's empty if the context has none,
+// request id falls back to the correlation id of the request
+func InitiatorFrom(ctx context.Context) Initiator {
+	initiator, _ := ctx.Value(initiatorKey{}).(Initiator)
+	if initiator.RequestID == "" {
+		initiator.RequestID = logging.RequestID(ctx)
+	}
+	return initiator
+}
+
+type auditParams struct {
+	BalanceID uint64       `json:"balance_id,omitempty"`
+	FromID    uint64       `json:"from_id,omitempty"`
+	ToID      uint64       `json:"to_id,omitempty"`
+	HoldID    uint64       `json:"hold_id,omitempty"`
+	BatchID   uint64       `json:"batch_id,omitempty"`
+	Amount    string       `json:"amount,omitempty"`
+	Currency  string       `json:"currency,omitempty"`
+	Legs      []auditLeg   `json:"legs,omitempty"`
+	Lines     []auditLine  `json:"lines,omitempty"`
+	Status    string       `json:"status,omitempty"`
+	Reason    string       `json:"reason,omitempty"`
+	Policy    *auditPolicy `json:"policy,omitempty"`
+}
+
+// auditLeg is a leg of the audited batch
+type auditLeg struct {
+	FromID   uint64 `json:"from_id"`
+	ToID     uint64 `json:"to_id"`
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// auditLine is an applied line of the audited import chunk, skipped and failed lines change nothing
+type auditLine struct {
+	BalanceID uint64 `json:"balance_id"`
+	EntryID   uint64 `json:"entry_id"`
+	Reference string `json:"reference"`
+	Amount    string `json:"amount"`
+	Currency  string `json:"currency"`
+}
+
+type auditPolicy struct {
+	OverdraftLimit       int64 `json:"overdraft_limit"`
+	MinBalance           int64 `json:"min_balance"`
+	MaxTransactionAmount int64 `json:"max_transaction_amount"`
+}
+
+// auditRecord collects the operation while it runs, amounts are known only after balances are locked
+type auditRecord struct {
+	operation string
+	params    auditParams
+	amount    money.Money
+	pre       map[uint64]int64
+	post      map[uint64]int64
+}
+
+func newAuditRecord(operation string, params auditParams, amount money.Money) *auditRecord {
+	params.Amount = amount.Decimal()
+	params.Currency = amount.Currency.Code
+	record := newChangeAuditRecord(operation, params)
+	record.amount = amount
+	return record
+}
+
+// newChangeAuditRecord records an operation without a single amount, like a batch, an import
+// or a change of the balance status or policy
+func newChangeAuditRecord(operation string, params auditParams) *auditRecord {
+	return &auditRecord{
+		operation: operation,
+		params:    params,
+		pre:       make(map[uint64]int64),
+		post:      make(map[uint64]int64),
+	}
+}
+
+// before records amount of the locked balance
+func (r *auditRecord) before(balance Balance) {
+	r.pre[balance.ID] = balance.Amount
+}
+
+func (r *auditRecord) after(balanceID uint64, amount int64) {
+	r.post[balanceID] = amount
+}
+
+// unchanged records the locked balance whose amount the operation doesn't change, like its status or holds
+func (r *auditRecord) unchanged(balance db.Balance) {
+	r.pre[balance.ID] = balance.Amount
+	r.post[balance.ID] = balance.Amount
+}
+
+// AuditError points to the first record that doesn't match the chain
+type AuditError struct {
+	RecordID uint64
+	Err      error
+}
+
+func (e *AuditError) Error() string {
+	return fmt.Sprintf("audit record %d: %s", e.RecordID, e.Err)
+}
+
+func (e *AuditError) Unwrap() error {
+	return e.Err
+}
+
+// auditHash links the record to the previous one, every column except id is hashed
+func auditHash(record db.CreateAuditRecordParams) string {
+	fields, _ := json.Marshal([]string{
+		record.PrevHash,
+		record.Actor,
+		record.Client,
+		record.RequestID,
+		record.Operation,
+		record.Params,
+		record.PreAmounts,
+		record.PostAmounts,
+		record.Outcome,
+		record.ErrorMessage,
+		record.CreatedAt.UTC().Format(time.RFC3339),
+	})
+	sum := sha256.Sum256(fields)
+	return hex.EncodeToString(sum[:])
+}
+
+// appendAudit adds the record to the end of the chain. The chain head is locked till the end of
+// the transaction, so it must be the last lock taken, after all balances.
+func appendAudit(ctx context.Context, qtx *db.Queries, record *auditRecord, opErr error) error {
+	params, err := json.Marshal(record.params)
+	if err != nil {
+		return err
+	}
+
+	pre, err := json.Marshal(record.pre)
+	if err != nil {
+		return err
+	}
+
+	post, err := json.Marshal(record.post)
+	if err != nil {
+		return err
+	}
+
+	outcome := AuditApplied
+	var errText string
+	if opErr != nil {
+		// rejected operation changes nothing
+		outcome = AuditRejected
+		post = pre
+		errText = opErr.Error()
+		if runes := []rune(errText); len(runes) > 1000 {
+			errText = string(runes[:1000])
+		}
+	}
+
+	chain, err := qtx.GetAuditChainForUpdate(ctx)
+	if err != nil {
+		return err
+	}
+
+	initiator := InitiatorFrom(ctx)
+	row := db.CreateAuditRecordParams{
+		Actor:        initiator.Actor,
+		Client:       initiator.Client,
+		RequestID:    initiator.RequestID,
+		Operation:    record.operation,
+		Params:       string(params),
+		PreAmounts:   string(pre),
+		PostAmounts:  string(post),
+		Outcome:      outcome,
+		ErrorMessage: errText,
+		PrevHash:     chain.Hash,
+		// mysql DATETIME keeps only seconds, hash must match the stored value
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	row.Hash = auditHash(row)
+
+	id, err := qtx.CreateAuditRecord(ctx, row)
+	if err != nil {
+		return err
+	}
+
+	return qtx.UpdateAuditChain(ctx, db.UpdateAuditChainParams{LastID: uint64(id), Hash: row.Hash})
+}
+
+// auditRejected records failed operation and emits its rejected event in own transaction,
+// the operation's one is already rolled back.
+// Returns the operation error, joined with the audit one if the record can't be written.
+func (s *Service) auditRejected(ctx context.Context, record *auditRecord, opErr error) error {
+	// the record is written even if the request was canceled
+	ctx = context.WithoutCancel(ctx)
+
+	tx, err := s.store.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Join(opErr, fmt.Errorf("audit: %w", err))
+	}
+	defer tx.Rollback()
+
+	qtx := s.store.WithTx(tx)
+
+	err = appendAudit(ctx, qtx, record, opErr)
+	if err == nil {
+		err = emitRejectedEvent(ctx, qtx, record, opErr)
+	}
+	if err == nil {
+		err = commit(ctx, tx)
+	}
+	if err != nil {
+		return errors.Join(opErr, fmt.Errorf("audit: %w", err))
+	}
+
+	return opErr
+}
+
+func (s *Service) GetAuditRecordById(ctx context.Context, id uint64) (db.AuditLog, error) {
+	return s.store.GetAuditRecordByID(ctx, id)
+}
+
+// GetAuditRecords returns up to limit records with id greater than afterID
+func (s *Service) GetAuditRecords(ctx context.Context, afterID uint64, limit int32) ([]db.AuditLog, error) {
+	return s.store.GetAuditRecords(ctx, db.GetAuditRecordsParams{ID: afterID, Limit: limit})
+}
+
+// VerifyAudit walks the whole chain and recomputes every hash, returns the number of verified records
+// or AuditError with the first record that was changed, removed or inserted out of the chain
+func (s *Service) VerifyAudit(ctx context.Context) (int, error) {
+	// head is read first, records appended while verifying are checked next time
+	chain, err := s.store.GetAuditChain(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	prevHash := auditGenesisHash
+	var lastID uint64
+	verified := 0
+	for lastID < chain.LastID {
+		records, err := s.GetAuditRecords(ctx, lastID, auditVerifyPage)
+		if err != nil {
+			return verified, err
+		}
+
+		if len(records) == 0 {
+			return verified, &AuditError{RecordID: chain.LastID, Err: ErrAuditTruncated}
+		}
+
+		for _, record := range records {
+			if record.ID > chain.LastID {
+				// the record the head points to is gone
+				return verified, &AuditError{RecordID: chain.LastID, Err: ErrAuditTruncated}
+			}
+
+			if record.PrevHash != prevHash {
+				return verified, &AuditError{RecordID: record.ID, Err: ErrAuditChainBroken}
+			}
+
+			hash := auditHash(db.CreateAuditRecordParams{
+				Actor:        record.Actor,
+				Client:       record.Client,
+				RequestID:    record.RequestID,
+				Operation:    record.Operation,
+				Params:       record.Params,
+				PreAmounts:   record.PreAmounts,
+				PostAmounts:  record.PostAmounts,
+				Outcome:      record.Outcome,
+				ErrorMessage: record.ErrorMessage,
+				PrevHash:     record.PrevHash,
+				CreatedAt:    record.CreatedAt,
+			})
+			if hash != record.Hash {
+				return verified, &AuditError{RecordID: record.ID, Err: ErrAuditHashMismatch}
+			}
+
+			prevHash = record.Hash
+			lastID = record.ID
+			verified++
+		}
+	}
+
+	if lastID != chain.LastID || prevHash != chain.Hash {
+		return verified, &AuditError{RecordID: chain.LastID, Err: ErrAuditTruncated}
+	}
+
+	return verified, nil
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	db "github.com/tredoc/go-balances/db/sqlc"
+	"github.com/tredoc/go-balances/internal/money"
+)
+
+// Balance is the stored balance paired with its currency, so amounts can be read as money
+type Balance struct {
+	db.Balance
+	Currency money.Currency
+}
+
+func (b Balance) Total() money.Money {
+	return money.New(b.Amount, b.Currency)
+}
+
+func (b Balance) Held() money.Money {
+	return money.New(b.HeldAmount, b.Currency)
+}
+
+func (b Balance) Available() money.Money {
+	return money.New(available(b.Balance), b.Currency)
+}
+
+func toCurrency(currency db.Currency) money.Currency {
+	return money.Currency{Code: currency.Name, Exponent: currency.Exponent}
+}
+
+func withCurrency(ctx context.Context, q *db.Queries, balance db.Balance) (Balance, error) {
+	currency, err := q.GetCurrencyByID(ctx, balance.CurrencyID)
+	if err != nil {
+		return Balance{}, err
+	}
+	return Balance{Balance: balance, Currency: toCurrency(currency)}, nil
+}
+
+// lockBalance locks the balance row for update and loads its currency
+func lockBalance(ctx context.Context, qtx *db.Queries, id uint64) (Balance, error) {
+	// using regular GetBalanceByID will cause deadlock
+	balance, err := lockRow(ctx, qtx, id)
+	if err != nil {
+		return Balance{}, err
+	}
+	return withCurrency(ctx, qtx, balance)
+}
+
+// checkCurrency validates that amount has the currency and precision of the balance
+func checkCurrency(balance Balance, amount money.Money) error {
+	if amount.Currency != balance.Currency {
+		return &PolicyError{BalanceID: balance.ID, Err: ErrCurrencyMismatch}
+	}
+	return nil
+}
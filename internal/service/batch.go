@@ -0,0 +1,278 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	db "github.com/tredoc/go-balances/db/sqlc"
+	"github.com/tredoc/go-balances/internal/money"
+	"github.com/tredoc/go-balances/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"log/slog"
+	"sort"
+	"time"
+)
+
+// MaxBatchLegs limits the number of legs, every involved balance stays locked until the batch commits
+const MaxBatchLegs = 100
+
+// TransferLeg is a single money movement inside of the batch
+type TransferLeg struct {
+	FromID uint64
+	ToID   uint64
+	Amount money.Money
+}
+
+// lockBalances locks balances in ascending id order, so any two transactions
+// lock shared balances in the same order and can't deadlock each other
+func lockBalances(ctx context.Context, qtx *db.Queries, ids []uint64) (map[uint64]Balance, error) {
+	sorted := make([]uint64, 0, len(ids))
+	seen := make(map[uint64]bool, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			sorted = append(sorted, id)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	balances := make(map[uint64]Balance, len(sorted))
+	for _, id := range sorted {
+		balance, err := lockBalance(ctx, qtx, id)
+		if err != nil {
+			return nil, err
+		}
+		balances[id] = balance
+	}
+
+	return balances, nil
+}
+
+// validateLegs checks legs that don't need database, amounts are positive and each leg moves money between two balances
+func validateLegs(legs []TransferLeg) error {
+	if len(legs) == 0 {
+		return ErrEmptyBatch
+	}
+
+	if len(legs) > MaxBatchLegs {
+		return ErrTooManyLegs
+	}
+
+	for _, leg := range legs {
+		if !leg.Amount.IsPositive() {
+			return ErrInvalidAmount
+		}
+
+		if leg.FromID == leg.ToID {
+			return ErrSameBalance
+		}
+	}
+
+	return nil
+}
+
+// BatchTransfer moves money by all legs in one transaction, either all legs are applied or none.
+// Returns batch id and updated balances ordered by id.
+func (s *Service) BatchTransfer(ctx context.Context, legs []TransferLeg) (uint64, []Balance, error) {
+	ctx, span := startSpan(ctx, "BatchTransfer", attribute.Int("batch.legs", len(legs)))
+	start := time.Now()
+	var batchID uint64
+	var balances []Balance
+	err := s.withRetry(ctx, OperationBatch, func() (err error) {
+		batchID, balances, err = s.batchTransfer(ctx, legs)
+		return err
+	})
+	observe(OperationBatch, start, err)
+	s.logOutcome(ctx, OperationBatch, err, slog.Int("legs", len(legs)))
+	tracing.End(span, err)
+	return batchID, balances, err
+}
+
+func (s *Service) batchTransfer(ctx context.Context, legs []TransferLeg) (uint64, []Balance, error) {
+	err := validateLegs(legs)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	tx, err := s.store.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	qtx := s.store.WithTx(tx)
+
+	ids := make([]uint64, 0, len(legs)*2)
+	for _, leg := range legs {
+		ids = append(ids, leg.FromID, leg.ToID)
+	}
+
+	balances, err := lockBalances(ctx, qtx, ids)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	debits := make(map[uint64]int64)
+	credits := make(map[uint64]int64)
+	policies := make(map[uint64]db.BalancePolicy)
+	for _, leg := range legs {
+		err = checkCurrency(balances[leg.FromID], leg.Amount)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		err = checkCurrency(balances[leg.ToID], leg.Amount)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		for _, id := range []uint64{leg.FromID, leg.ToID} {
+			if _, ok := policies[id]; ok {
+				continue
+			}
+
+			policies[id], err = loadPolicy(ctx, qtx, id)
+			if err != nil {
+				return 0, nil, err
+			}
+		}
+
+		err = checkLimit(policies[leg.FromID], leg.Amount.Amount)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		err = checkLimit(policies[leg.ToID], leg.Amount.Amount)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		debits[leg.FromID], err = addAmount(leg.FromID, debits[leg.FromID], leg.Amount.Amount)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		credits[leg.ToID], err = addAmount(leg.ToID, credits[leg.ToID], leg.Amount.Amount)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+
+	for id := range debits {
+		balance := balances[id]
+		err = releaseExpiredHolds(ctx, qtx, &balance.Balance)
+		if err != nil {
+			return 0, nil, err
+		}
+		balances[id] = balance
+
+		err = checkStatus(balance.Balance, true)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		// incoming legs of the same batch can cover outgoing ones
+		if net := debits[id] - credits[id]; net > 0 {
+			err = checkFloor(policies[id], balance.Balance, net)
+			if err != nil {
+				return 0, nil, err
+			}
+		}
+	}
+
+	for id := range credits {
+		err = checkStatus(balances[id].Balance, false)
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+
+	// both sums are positive, so only the final amount can overflow
+	amounts := make(map[uint64]int64, len(balances))
+	for id, balance := range balances {
+		amounts[id], err = addAmount(id, balance.Amount, credits[id]-debits[id])
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+
+	batchID, err := qtx.CreateTransferBatch(ctx, uint32(len(legs)))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// every leg is a transfer with its debit and credit entries, all of them point to the batch,
+	// reconcile and statements count the entries of batch legs, not their transfers
+	batch := sql.NullInt64{Int64: batchID, Valid: true}
+	for _, leg := range legs {
+		_, err = qtx.CreateBatchTransfer(ctx, db.CreateBatchTransferParams{
+			FromBalanceID: leg.FromID,
+			ToBalanceID:   leg.ToID,
+			Amount:        leg.Amount.Amount,
+			BatchID:       batch,
+		})
+		if err != nil {
+			return 0, nil, err
+		}
+
+		_, err = qtx.CreateBatchEntry(ctx, db.CreateBatchEntryParams{BalanceID: leg.FromID, Amount: -leg.Amount.Amount, BatchID: batch})
+		if err != nil {
+			return 0, nil, err
+		}
+
+		_, err = qtx.CreateBatchEntry(ctx, db.CreateBatchEntryParams{BalanceID: leg.ToID, Amount: leg.Amount.Amount, BatchID: batch})
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+
+	updated := make([]Balance, 0, len(balances))
+	for id, balance := range balances {
+		if credits[id] != debits[id] {
+			err = qtx.UpdateBalance(ctx, db.UpdateBalanceParams{ID: id, Amount: amounts[id]})
+			if err != nil {
+				return 0, nil, err
+			}
+
+			// one event per balance with the net change of the whole batch
+			event := newBalanceEvent(balance, OperationBatch, credits[id]-debits[id], amounts[id])
+			event.BatchID = uint64(batchID)
+			err = emitEvent(ctx, qtx, event)
+			if err != nil {
+				return 0, nil, err
+			}
+		}
+		balance.Amount = amounts[id]
+		updated = append(updated, balance)
+	}
+	sort.Slice(updated, func(i, j int) bool { return updated[i].ID < updated[j].ID })
+
+	audit := newChangeAuditRecord(AuditBatch, auditParams{BatchID: uint64(batchID), Legs: make([]auditLeg, 0, len(legs))})
+	for _, leg := range legs {
+		audit.params.Legs = append(audit.params.Legs, auditLeg{
+			FromID:   leg.FromID,
+			ToID:     leg.ToID,
+			Amount:   leg.Amount.Decimal(),
+			Currency: leg.Amount.Currency.Code,
+		})
+	}
+	for id, balance := range balances {
+		audit.before(balance)
+		audit.after(id, amounts[id])
+	}
+	err = appendAudit(ctx, qtx, audit, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	err = commit(ctx, tx)
+
+	return uint64(batchID), updated, err
+}
+
+func (s *Service) GetTransfersByBatchId(ctx context.Context, batchID uint64) ([]db.Transfer, error) {
+	return s.store.GetTransfersByBatchID(ctx, sql.NullInt64{Int64: int64(batchID), Valid: true})
+}
+
+func (s *Service) GetEntriesByBatchId(ctx context.Context, batchID uint64) ([]db.Entry, error) {
+	return s.store.GetEntriesByBatchID(ctx, sql.NullInt64{Int64: int64(batchID), Valid: true})
+}
@@ -0,0 +1,27 @@
+package service
+
+import (
+	"context"
+	"github.com/tredoc/go-balances/internal/store"
+)
+
+type dryRunKey struct{}
+
+// WithDryRun makes operations started with ctx roll their transactions back instead of committing,
+// they return the state the operation would have produced, with all checks and locks of the real run
+func WithDryRun(ctx context.Context) context.Context {
+	return context.WithValue(ctx, dryRunKey{}, true)
+}
+
+func IsDryRun(ctx context.Context) bool {
+	dryRun, _ := ctx.Value(dryRunKey{}).(bool)
+	return dryRun
+}
+
+// commit commits tx or rolls it back in dry run
+func commit(ctx context.Context, tx *store.Tx) error {
+	if IsDryRun(ctx) {
+		return tx.Rollback()
+	}
+	return tx.Commit()
+}
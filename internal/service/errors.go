@@ -0,0 +1,91 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/tredoc/go-balances/internal/money"
+)
+
+var (
+	ErrInvalidAmount        = errors.New("amount must be positive")
+	ErrInsufficientFunds    = errors.New("insufficient funds")
+	ErrOverflow             = money.ErrOverflow
+	ErrBalanceFrozen        = errors.New("balance is frozen")
+	ErrMinBalance           = errors.New("minimum balance violated")
+	ErrOverdraftLimit       = errors.New("overdraft limit exceeded")
+	ErrMaxTransactionAmount = errors.New("max transaction amount exceeded")
+	ErrInvalidPolicy        = errors.New("invalid balance policy")
+
+	ErrBalanceClosed     = errors.New("balance is closed")
+	ErrInvalidStatus     = errors.New("invalid balance status")
+	ErrInvalidTransition = errors.New("balance status transition is not allowed")
+	ErrBalanceNotEmpty   = errors.New("balance is not empty")
+	ErrPendingHolds      = errors.New("balance has pending holds")
+	ErrMissingActor      = errors.New("actor and reason are required")
+
+	ErrEmptyBatch       = errors.New("batch has no legs")
+	ErrTooManyLegs      = errors.New("batch has too many legs")
+	ErrSameBalance      = errors.New("can't transfer to the same balance")
+	ErrCurrencyMismatch = errors.New("balances have different currencies")
+
+	ErrMissingReference   = errors.New("reference is required")
+	ErrDuplicateReference = errors.New("duplicate reference in file")
+	ErrMissingBalance     = errors.New("balance_id or username and currency are required")
+	ErrZeroAmount         = errors.New("amount must not be zero")
+
+	ErrInvalidUsername     = errors.New("username must be 3-32 latin letters, digits, dots, dashes or underscores")
+	ErrInvalidCurrencyCode = errors.New("currency code must be 2-10 uppercase latin letters or digits starting with a letter")
+	ErrInvalidExponent     = errors.New("currency exponent must not be greater than 18")
+	ErrUserExists          = errors.New("user already exists")
+	ErrCurrencyExists      = errors.New("currency already exists")
+	ErrBalanceExists       = errors.New("balance already exists")
+
+	ErrInvalidRate    = money.ErrInvalidRate
+	ErrSameCurrency   = errors.New("exchange rate needs two different currencies")
+	ErrNoExchangeRate = errors.New("no exchange rate")
+
+	ErrHoldNotPending  = errors.New("hold is not pending")
+	ErrHoldExpired     = errors.New("hold is expired")
+	ErrCaptureExceeded = errors.New("capture amount exceeds hold amount")
+
+	ErrAuditHashMismatch = errors.New("record hash doesn't match its content")
+	ErrAuditChainBroken  = errors.New("record isn't linked to the previous one")
+	ErrAuditTruncated    = errors.New("chain head points to a missing record")
+)
+
+// PolicyError is returned when operation violates the policy or the status of the balance,
+// use errors.Is to check which rule was violated
+type PolicyError struct {
+	BalanceID uint64
+	Err       error
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("balance %d: %v", e.BalanceID, e.Err)
+}
+
+func (e *PolicyError) Unwrap() error {
+	return e.Err
+}
+
+// ConflictError is returned when created entity violates uniqueness,
+// use errors.Is to check which entity already exists
+type ConflictError struct {
+	Key string
+	Err error
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Key, e.Err)
+}
+
+func (e *ConflictError) Unwrap() error {
+	return e.Err
+}
+
+// isDuplicateKey checks for mysql unique constraint violation
+func isDuplicateKey(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062
+}
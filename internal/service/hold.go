@@ -0,0 +1,377 @@
+package service
+
+import (
+	"context"
+	"errors"
+	db "github.com/tredoc/go-balances/db/sqlc"
+	"github.com/tredoc/go-balances/internal/money"
+	"github.com/tredoc/go-balances/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"log/slog"
+	"time"
+)
+
+const (
+	HoldPending  = "pending"
+	HoldCaptured = "captured"
+	HoldVoided   = "voided"
+	HoldExpired  = "expired"
+)
+
+// available returns the part of the balance that is not reserved by pending holds
+func available(balance db.Balance) int64 {
+	return balance.Amount - balance.HeldAmount
+}
+
+// releaseExpiredHolds marks expired pending holds of the balance as expired and returns
+// reserved funds back to available amount, balance row must be locked by the caller
+func releaseExpiredHolds(ctx context.Context, qtx *db.Queries, balance *db.Balance) error {
+	holds, err := qtx.GetExpiredHoldsByBalanceIDForUpdate(ctx, db.GetExpiredHoldsByBalanceIDForUpdateParams{BalanceID: balance.ID, ExpiresAt: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+
+	if len(holds) == 0 {
+		return nil
+	}
+
+	var released int64
+	for _, hold := range holds {
+		err = qtx.UpdateHoldStatus(ctx, db.UpdateHoldStatusParams{ID: hold.ID, Status: HoldExpired})
+		if err != nil {
+			return err
+		}
+		released += hold.Amount
+	}
+
+	err = qtx.UpdateBalanceHeldAmount(ctx, db.UpdateBalanceHeldAmountParams{ID: balance.ID, HeldAmount: balance.HeldAmount - released})
+	if err != nil {
+		return err
+	}
+
+	balance.HeldAmount -= released
+	return nil
+}
+
+func (s *Service) GetHoldById(ctx context.Context, id uint64) (db.Hold, error) {
+	return s.store.GetHoldByID(ctx, id)
+}
+
+func (s *Service) GetHoldsByBalanceId(ctx context.Context, balanceID uint64) ([]db.Hold, error) {
+	return s.store.GetHoldsByBalanceID(ctx, balanceID)
+}
+
+// Authorize reserves amount on the balance until the hold is captured, voided or expires after ttl
+func (s *Service) Authorize(ctx context.Context, balanceID uint64, amount money.Money, ttl time.Duration) (*db.Hold, error) {
+	ctx, span := startSpan(ctx, "Authorize", append(amountAttrs(amount), attribute.Int64("balance.id", int64(balanceID)))...)
+	hold, err := s.authorize(ctx, balanceID, amount, ttl)
+	s.logOutcome(ctx, "authorize", err, slog.Uint64("balance_id", balanceID), slog.String("amount", amount.String()))
+	tracing.End(span, err)
+	return hold, err
+}
+
+func (s *Service) authorize(ctx context.Context, balanceID uint64, amount money.Money, ttl time.Duration) (*db.Hold, error) {
+	if !amount.IsPositive() {
+		return nil, ErrInvalidAmount
+	}
+
+	if ttl <= 0 {
+		return nil, errors.New("ttl must be positive")
+	}
+
+	tx, err := s.store.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	qtx := s.store.WithTx(tx)
+
+	balance, err := lockBalance(ctx, qtx, balanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkCurrency(balance, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = releaseExpiredHolds(ctx, qtx, &balance.Balance)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := loadPolicy(ctx, qtx, balanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkDebit(policy, balance.Balance, amount.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	heldAmount, err := addAmount(balanceID, balance.HeldAmount, amount.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	// mysql DATETIME has no time zone, so all expiration checks are done in UTC
+	expiresAt := time.Now().UTC().Add(ttl).Truncate(time.Second)
+	holdID, err := qtx.CreateHold(ctx, db.CreateHoldParams{BalanceID: balanceID, Amount: amount.Amount, ExpiresAt: expiresAt})
+	if err != nil {
+		return nil, err
+	}
+
+	err = qtx.UpdateBalanceHeldAmount(ctx, db.UpdateBalanceHeldAmountParams{ID: balanceID, HeldAmount: heldAmount})
+	if err != nil {
+		return nil, err
+	}
+
+	hold, err := qtx.GetHoldByID(ctx, uint64(holdID))
+	if err != nil {
+		return nil, err
+	}
+
+	audit := newAuditRecord(AuditAuthorize, auditParams{BalanceID: balanceID, HoldID: hold.ID}, amount)
+	audit.unchanged(balance.Balance)
+	err = appendAudit(ctx, qtx, audit, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = commit(ctx, tx)
+
+	return &hold, err
+}
+
+// Capture settles amount of the pending hold, the rest of the hold is released
+func (s *Service) Capture(ctx context.Context, holdID uint64, amount money.Money) (*Balance, error) {
+	ctx, span := startSpan(ctx, "Capture", append(amountAttrs(amount), attribute.Int64("hold.id", int64(holdID)))...)
+	balance, err := s.capture(ctx, holdID, amount)
+	s.logOutcome(ctx, "capture", err, slog.Uint64("hold_id", holdID), slog.String("amount", amount.String()))
+	tracing.End(span, err)
+	return balance, err
+}
+
+func (s *Service) capture(ctx context.Context, holdID uint64, amount money.Money) (*Balance, error) {
+	if !amount.IsPositive() {
+		return nil, ErrInvalidAmount
+	}
+
+	tx, err := s.store.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	qtx := s.store.WithTx(tx)
+
+	balance, hold, err := lockHold(ctx, qtx, holdID)
+	if err != nil {
+		if errors.Is(err, ErrHoldExpired) {
+			// keep the hold expiration even though capture is rejected
+			if commitErr := commit(ctx, tx); commitErr != nil {
+				return nil, commitErr
+			}
+		}
+		return nil, err
+	}
+
+	err = checkCurrency(balance, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	if amount.Amount > hold.Amount {
+		return nil, ErrCaptureExceeded
+	}
+
+	policy, err := loadPolicy(ctx, qtx, balance.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// funds are already reserved by the hold, so check the balance as if the hold is released
+	released := balance.Balance
+	released.HeldAmount -= hold.Amount
+	err = checkDebit(policy, released, amount.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	newAmount, err := subAmount(balance.ID, balance.Amount, amount.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	entryID, err := qtx.CreateEntry(ctx, db.CreateEntryParams{BalanceID: balance.ID, Amount: -amount.Amount})
+	if err != nil {
+		return nil, err
+	}
+
+	err = qtx.UpdateBalance(ctx, db.UpdateBalanceParams{ID: balance.ID, Amount: newAmount})
+	if err != nil {
+		return nil, err
+	}
+
+	event := newBalanceEvent(balance, OperationCapture, -amount.Amount, newAmount)
+	event.EntryID = uint64(entryID)
+	err = emitEvent(ctx, qtx, event)
+	if err != nil {
+		return nil, err
+	}
+
+	err = qtx.UpdateBalanceHeldAmount(ctx, db.UpdateBalanceHeldAmountParams{ID: balance.ID, HeldAmount: balance.HeldAmount - hold.Amount})
+	if err != nil {
+		return nil, err
+	}
+
+	err = qtx.UpdateHoldStatus(ctx, db.UpdateHoldStatusParams{ID: hold.ID, Status: HoldCaptured, CapturedAmount: amount.Amount})
+	if err != nil {
+		return nil, err
+	}
+
+	audit := newAuditRecord(AuditCapture, auditParams{BalanceID: balance.ID, HoldID: hold.ID}, amount)
+	audit.before(balance)
+	audit.after(balance.ID, newAmount)
+	err = appendAudit(ctx, qtx, audit, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = commit(ctx, tx)
+
+	balance.Amount = newAmount
+	balance.HeldAmount -= hold.Amount
+	return &balance, err
+}
+
+// Void releases the pending hold without moving any funds
+func (s *Service) Void(ctx context.Context, holdID uint64) (*Balance, error) {
+	ctx, span := startSpan(ctx, "Void", attribute.Int64("hold.id", int64(holdID)))
+	balance, err := s.void(ctx, holdID)
+	s.logOutcome(ctx, "void", err, slog.Uint64("hold_id", holdID))
+	tracing.End(span, err)
+	return balance, err
+}
+
+func (s *Service) void(ctx context.Context, holdID uint64) (*Balance, error) {
+	tx, err := s.store.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	qtx := s.store.WithTx(tx)
+
+	balance, hold, err := lockHold(ctx, qtx, holdID)
+	if err != nil {
+		if errors.Is(err, ErrHoldExpired) {
+			// expired hold is already released, nothing to void
+			if commitErr := commit(ctx, tx); commitErr != nil {
+				return nil, commitErr
+			}
+		}
+		return nil, err
+	}
+
+	err = qtx.UpdateBalanceHeldAmount(ctx, db.UpdateBalanceHeldAmountParams{ID: balance.ID, HeldAmount: balance.HeldAmount - hold.Amount})
+	if err != nil {
+		return nil, err
+	}
+
+	err = qtx.UpdateHoldStatus(ctx, db.UpdateHoldStatusParams{ID: hold.ID, Status: HoldVoided})
+	if err != nil {
+		return nil, err
+	}
+
+	audit := newChangeAuditRecord(AuditVoid, auditParams{BalanceID: balance.ID, HoldID: hold.ID})
+	audit.unchanged(balance.Balance)
+	err = appendAudit(ctx, qtx, audit, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = commit(ctx, tx)
+
+	balance.HeldAmount -= hold.Amount
+	return &balance, err
+}
+
+// ExpireHolds releases all pending holds with passed ttl and returns the number of affected balances
+func (s *Service) ExpireHolds(ctx context.Context) (int, error) {
+	holds, err := s.store.GetExpiredHolds(ctx, time.Now().UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	seen := make(map[uint64]bool)
+	for _, hold := range holds {
+		if seen[hold.BalanceID] {
+			continue
+		}
+		seen[hold.BalanceID] = true
+
+		err = s.expireBalanceHolds(ctx, hold.BalanceID)
+		if err != nil {
+			return len(seen) - 1, err
+		}
+	}
+
+	return len(seen), nil
+}
+
+func (s *Service) expireBalanceHolds(ctx context.Context, balanceID uint64) error {
+	tx, err := s.store.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	qtx := s.store.WithTx(tx)
+
+	balance, err := lockRow(ctx, qtx, balanceID)
+	if err != nil {
+		return err
+	}
+
+	err = releaseExpiredHolds(ctx, qtx, &balance)
+	if err != nil {
+		return err
+	}
+
+	return commit(ctx, tx)
+}
+
+// lockHold locks the hold together with its balance, the balance is always locked first
+// to keep the same lock order as Withdraw and Transfer
+func lockHold(ctx context.Context, qtx *db.Queries, holdID uint64) (Balance, db.Hold, error) {
+	hold, err := qtx.GetHoldByID(ctx, holdID)
+	if err != nil {
+		return Balance{}, db.Hold{}, err
+	}
+
+	balance, err := lockBalance(ctx, qtx, hold.BalanceID)
+	if err != nil {
+		return Balance{}, db.Hold{}, err
+	}
+
+	// reread hold under lock, it could be settled while we were waiting for the balance
+	hold, err = qtx.GetHoldByIDForUpdate(ctx, holdID)
+	if err != nil {
+		return Balance{}, db.Hold{}, err
+	}
+
+	if hold.Status != HoldPending {
+		return Balance{}, db.Hold{}, ErrHoldNotPending
+	}
+
+	if !hold.ExpiresAt.After(time.Now().UTC()) {
+		err = releaseExpiredHolds(ctx, qtx, &balance.Balance)
+		if err != nil {
+			return Balance{}, db.Hold{}, err
+		}
+		return Balance{}, db.Hold{}, ErrHoldExpired
+	}
+
+	return balance, hold, nil
+}
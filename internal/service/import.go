@@ -0,0 +1,354 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	db "github.com/tredoc/go-balances/db/sqlc"
+	"github.com/tredoc/go-balances/internal/money"
+	"go.opentelemetry.io/otel/attribute"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	ImportApplied = "applied"
+	ImportSkipped = "skipped"
+	ImportFailed  = "failed"
+)
+
+// DefaultImportChunk is the number of lines applied in one transaction
+const DefaultImportChunk = 500
+
+// importHeader is the expected csv header, lines must have either balance_id or username with currency.
+// Amount is a decimal string in the currency of the balance, positive amount is a deposit, negative amount is a payout.
+var importHeader = []string{"balance_id", "username", "currency", "amount", "reference"}
+
+type ImportLine struct {
+	Line      int
+	BalanceID uint64
+	Username  string
+	Currency  string
+	Amount    string
+	Reference string
+
+	// amount parsed with the currency of the balance during validation
+	money money.Money
+}
+
+type ImportResult struct {
+	Line      int
+	Reference string
+	BalanceID uint64
+	EntryID   uint64
+	Status    string
+	Err       error
+}
+
+// ParseImportCSV reads all lines of the file, lines that can't be parsed are returned as failed results
+func ParseImportCSV(r io.Reader) ([]ImportLine, []ImportResult, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = len(importHeader)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("read header: %w", err)
+	}
+
+	for i, name := range importHeader {
+		if strings.TrimSpace(header[i]) != name {
+			return nil, nil, fmt.Errorf("invalid header, expected %s", strings.Join(importHeader, ","))
+		}
+	}
+
+	var lines []ImportLine
+	var failed []ImportResult
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			failed = append(failed, ImportResult{Line: parseErr.StartLine, Status: ImportFailed, Err: err})
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		n, _ := reader.FieldPos(0)
+		line, err := parseImportRecord(n, record)
+		if err != nil {
+			failed = append(failed, ImportResult{Line: n, Reference: line.Reference, Status: ImportFailed, Err: err})
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	return lines, failed, nil
+}
+
+func parseImportRecord(n int, record []string) (ImportLine, error) {
+	line := ImportLine{
+		Line:      n,
+		Username:  strings.TrimSpace(record[1]),
+		Currency:  strings.TrimSpace(record[2]),
+		Amount:    strings.TrimSpace(record[3]),
+		Reference: strings.TrimSpace(record[4]),
+	}
+
+	if id := strings.TrimSpace(record[0]); id != "" {
+		balanceID, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			return line, fmt.Errorf("invalid balance_id: %w", err)
+		}
+		line.BalanceID = balanceID
+	}
+
+	return line, nil
+}
+
+// ImportCSV parses the file and applies all valid lines, see Import
+func (s *Service) ImportCSV(ctx context.Context, r io.Reader, chunkSize int) ([]ImportResult, error) {
+	lines, failed, err := ParseImportCSV(r)
+	if err != nil {
+		return nil, err
+	}
+
+	results := append(failed, s.Import(ctx, lines, chunkSize)...)
+	sort.Slice(results, func(i, j int) bool { return results[i].Line < results[j].Line })
+
+	return results, nil
+}
+
+// Import validates every line up front and applies valid ones in transactions of chunkSize lines.
+// Reference of each line is stored on the entry, so lines applied before a crash are skipped on rerun.
+func (s *Service) Import(ctx context.Context, lines []ImportLine, chunkSize int) []ImportResult {
+	ctx, span := startSpan(ctx, "Import", attribute.Int("import.lines", len(lines)))
+	defer span.End()
+
+	if chunkSize <= 0 {
+		chunkSize = DefaultImportChunk
+	}
+
+	results := make([]ImportResult, len(lines))
+	valid := make([]int, 0, len(lines))
+	references := make(map[string]bool, len(lines))
+	for i, line := range lines {
+		results[i] = ImportResult{Line: line.Line, Reference: line.Reference, BalanceID: line.BalanceID}
+
+		err := s.validateImportLine(ctx, &line, references)
+		if err != nil {
+			results[i].Status = ImportFailed
+			results[i].Err = err
+			continue
+		}
+		results[i].BalanceID = line.BalanceID
+		lines[i] = line
+		valid = append(valid, i)
+	}
+
+	for start := 0; start < len(valid); start += chunkSize {
+		end := start + chunkSize
+		if end > len(valid) {
+			end = len(valid)
+		}
+
+		err := s.importChunk(ctx, lines, results, valid[start:end])
+		if err != nil {
+			for _, i := range valid[start:end] {
+				results[i].Status = ImportFailed
+				results[i].EntryID = 0
+				results[i].Err = err
+			}
+		}
+	}
+
+	return results
+}
+
+func (s *Service) validateImportLine(ctx context.Context, line *ImportLine, references map[string]bool) error {
+	if line.Reference == "" {
+		return ErrMissingReference
+	}
+
+	if references[line.Reference] {
+		return ErrDuplicateReference
+	}
+	references[line.Reference] = true
+
+	var balance db.Balance
+	var err error
+	switch {
+	case line.BalanceID != 0:
+		balance, err = s.store.GetBalanceByID(ctx, line.BalanceID)
+	case line.Username != "" && line.Currency != "":
+		balance, err = s.store.GetBalanceByUsernameAndCurrency(ctx, db.GetBalanceByUsernameAndCurrencyParams{Username: line.Username, Name: line.Currency})
+	default:
+		return ErrMissingBalance
+	}
+	if err != nil {
+		return err
+	}
+	line.BalanceID = balance.ID
+
+	currency, err := s.store.GetCurrencyByID(ctx, balance.CurrencyID)
+	if err != nil {
+		return err
+	}
+
+	line.money, err = money.Parse(line.Amount, toCurrency(currency))
+	if err != nil {
+		return fmt.Errorf("invalid amount: %w", err)
+	}
+
+	if line.money.Amount == 0 {
+		return ErrZeroAmount
+	}
+
+	// payout is applied as a debit of the negated amount, the minimal int64 can't be negated
+	if line.money.Amount == math.MinInt64 {
+		return fmt.Errorf("invalid amount: %w", ErrOverflow)
+	}
+
+	return nil
+}
+
+// importChunk applies lines in one transaction, lines that violate balance rules are marked as failed
+// and don't affect other lines, any other error rolls back the whole chunk
+func (s *Service) importChunk(ctx context.Context, lines []ImportLine, results []ImportResult, chunk []int) error {
+	tx, err := s.store.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	qtx := s.store.WithTx(tx)
+
+	ids := make([]uint64, 0, len(chunk))
+	for _, i := range chunk {
+		ids = append(ids, lines[i].BalanceID)
+	}
+
+	balances, err := lockBalances(ctx, qtx, ids)
+	if err != nil {
+		return err
+	}
+
+	policies := make(map[uint64]db.BalancePolicy, len(balances))
+	changed := make(map[uint64]bool, len(balances))
+	audit := newChangeAuditRecord(AuditImport, auditParams{})
+	for id, balance := range balances {
+		err = releaseExpiredHolds(ctx, qtx, &balance.Balance)
+		if err != nil {
+			return err
+		}
+		balances[id] = balance
+
+		policies[id], err = loadPolicy(ctx, qtx, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, i := range chunk {
+		line := lines[i]
+		balance := balances[line.BalanceID]
+		reference := sql.NullString{String: line.Reference, Valid: true}
+
+		entry, err := qtx.GetEntryByReference(ctx, reference)
+		if err == nil {
+			// line was applied by one of the previous runs
+			results[i].Status = ImportSkipped
+			results[i].EntryID = entry.ID
+			continue
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		amount := line.money.Amount
+		if amount > 0 {
+			err = checkCredit(policies[line.BalanceID], balance.Balance, amount)
+		} else {
+			err = checkDebit(policies[line.BalanceID], balance.Balance, -amount)
+		}
+		if err != nil {
+			results[i].Status = ImportFailed
+			results[i].Err = err
+			continue
+		}
+
+		newAmount, err := addAmount(line.BalanceID, balance.Amount, amount)
+		if err != nil {
+			results[i].Status = ImportFailed
+			results[i].Err = err
+			continue
+		}
+
+		entryID, err := qtx.CreateEntryWithReference(ctx, db.CreateEntryWithReferenceParams{
+			BalanceID: line.BalanceID,
+			Amount:    amount,
+			Reference: reference,
+		})
+		if isDuplicateKey(err) {
+			// the same file is imported concurrently and another run applied the line first
+			results[i].Status = ImportSkipped
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		event := newBalanceEvent(balance, OperationImport, amount, newAmount)
+		event.EntryID = uint64(entryID)
+		err = emitEvent(ctx, qtx, event)
+		if err != nil {
+			return err
+		}
+
+		if !changed[line.BalanceID] {
+			audit.before(balance)
+		}
+		audit.params.Lines = append(audit.params.Lines, auditLine{
+			BalanceID: line.BalanceID,
+			EntryID:   uint64(entryID),
+			Reference: line.Reference,
+			Amount:    line.money.Decimal(),
+			Currency:  line.money.Currency.Code,
+		})
+
+		balance.Amount = newAmount
+		balances[line.BalanceID] = balance
+		changed[line.BalanceID] = true
+
+		results[i].Status = ImportApplied
+		results[i].EntryID = uint64(entryID)
+	}
+
+	for id := range changed {
+		err = qtx.UpdateBalance(ctx, db.UpdateBalanceParams{ID: id, Amount: balances[id].Amount})
+		if err != nil {
+			return err
+		}
+		audit.after(id, balances[id].Amount)
+	}
+
+	// a chunk of only skipped and failed lines changes nothing
+	if len(changed) > 0 {
+		err = appendAudit(ctx, qtx, audit, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return commit(ctx, tx)
+}
@@ -0,0 +1,28 @@
+package service
+
+import (
+	"context"
+	"github.com/tredoc/go-balances/internal/metrics"
+	"log/slog"
+)
+
+// logOutcome logs the result of the mutation, rejections by the rules of the ledger are info,
+// other failures are errors, in both cases the transaction was rolled back and err is the reason
+func (s *Service) logOutcome(ctx context.Context, operation string, err error, attrs ...slog.Attr) {
+	attrs = append(attrs, slog.String("operation", operation))
+	if IsDryRun(ctx) {
+		attrs = append(attrs, slog.Bool("dry_run", true))
+	}
+	if initiator := InitiatorFrom(ctx); initiator.Actor != "" {
+		attrs = append(attrs, slog.String("actor", initiator.Actor))
+	}
+
+	switch result := Outcome(err); result {
+	case metrics.OutcomeSuccess:
+		s.Logger.LogAttrs(ctx, slog.LevelInfo, "operation applied", attrs...)
+	case metrics.OutcomeError:
+		s.Logger.LogAttrs(ctx, slog.LevelError, "operation failed, rolled back", append(attrs, slog.Any("error", err))...)
+	default:
+		s.Logger.LogAttrs(ctx, slog.LevelInfo, "operation rejected, rolled back", append(attrs, slog.String("outcome", result), slog.Any("error", err))...)
+	}
+}
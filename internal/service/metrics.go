@@ -0,0 +1,42 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	db "github.com/tredoc/go-balances/db/sqlc"
+	"github.com/tredoc/go-balances/internal/metrics"
+	"github.com/tredoc/go-balances/internal/money"
+	"time"
+)
+
+// Outcome classifies the result of the operation for metrics and logs, one of metrics.Outcome*
+func Outcome(err error) string {
+	var policyErr *PolicyError
+	switch {
+	case err == nil:
+		return metrics.OutcomeSuccess
+	case errors.Is(err, ErrInsufficientFunds):
+		return metrics.OutcomeInsufficientFunds
+	case errors.Is(err, ErrInvalidAmount), errors.Is(err, money.ErrPrecision), errors.Is(err, money.ErrInvalidFormat):
+		return metrics.OutcomeInvalidAmount
+	case errors.As(err, &policyErr), errors.Is(err, ErrSameBalance), errors.Is(err, sql.ErrNoRows):
+		return metrics.OutcomeRejected
+	default:
+		return metrics.OutcomeError
+	}
+}
+
+// observe records the outcome and the latency of the operation started at start
+func observe(operation string, start time.Time, err error) {
+	metrics.Operations.WithLabelValues(operation, Outcome(err)).Inc()
+	metrics.OperationDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// lockRow selects the balance for update, time spent waiting for the row lock is measured
+func lockRow(ctx context.Context, qtx *db.Queries, id uint64) (db.Balance, error) {
+	start := time.Now()
+	balance, err := qtx.GetBalanceByIDForUpdate(ctx, id)
+	metrics.LockWait.Observe(time.Since(start).Seconds())
+	return balance, err
+}
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	db "github.com/tredoc/go-balances/db/sqlc"
+	"github.com/tredoc/go-balances/internal/money"
+)
+
+// EventBalanceChanged is emitted on every change of the balance amount, operation tells what changed it
+const EventBalanceChanged = "balance.changed"
+
+// events of single operations, they are what webhook subscribers choose from
+const (
+	EventDepositCompleted  = "deposit.completed"
+	EventDepositRejected   = "deposit.rejected"
+	EventWithdrawCompleted = "withdraw.completed"
+	EventWithdrawRejected  = "withdraw.rejected"
+	EventTransferCompleted = "transfer.completed"
+	EventTransferRejected  = "transfer.rejected"
+)
+
+// EventTypes lists all event types that are written to the outbox
+var EventTypes = []string{
+	EventBalanceChanged,
+	EventDepositCompleted,
+	EventDepositRejected,
+	EventWithdrawCompleted,
+	EventWithdrawRejected,
+	EventTransferCompleted,
+	EventTransferRejected,
+}
+
+const (
+	OperationDeposit  = "deposit"
+	OperationWithdraw = "withdraw"
+	OperationTransfer = "transfer"
+	OperationBatch    = "batch"
+	OperationCapture  = "capture"
+	OperationImport   = "import"
+)
+
+// BalanceEvent is the payload of balance.changed event, amounts are in minor units of the currency
+type BalanceEvent struct {
+	BalanceID  uint64 `json:"balance_id"`
+	Operation  string `json:"operation"`
+	Delta      int64  `json:"delta"`
+	Amount     int64  `json:"amount"`
+	Currency   string `json:"currency"`
+	Exponent   uint8  `json:"exponent"`
+	EntryID    uint64 `json:"entry_id,omitempty"`
+	TransferID uint64 `json:"transfer_id,omitempty"`
+	BatchID    uint64 `json:"batch_id,omitempty"`
+}
+
+// newBalanceEvent describes the change of the balance, amount is the new amount of the balance
+func newBalanceEvent(balance Balance, operation string, delta int64, amount int64) BalanceEvent {
+	return BalanceEvent{
+		BalanceID: balance.ID,
+		Operation: operation,
+		Delta:     delta,
+		Amount:    amount,
+		Currency:  balance.Currency.Code,
+		Exponent:  balance.Currency.Exponent,
+	}
+}
+
+// EntryEvent is the payload of deposit.completed and withdraw.completed, it mirrors db.Entry
+type EntryEvent struct {
+	ID        uint64 `json:"id"`
+	BalanceID uint64 `json:"balance_id"`
+	Amount    int64  `json:"amount"`
+	Decimal   string `json:"decimal"`
+	Currency  string `json:"currency"`
+}
+
+// TransferEvent is the payload of transfer.completed, it mirrors db.Transfer
+type TransferEvent struct {
+	ID            uint64 `json:"id"`
+	FromBalanceID uint64 `json:"from_balance_id"`
+	ToBalanceID   uint64 `json:"to_balance_id"`
+	Amount        int64  `json:"amount"`
+	Decimal       string `json:"decimal"`
+	Currency      string `json:"currency"`
+}
+
+// RejectedEvent is the payload of *.rejected events, reason is one of rejectionReasons codes
+type RejectedEvent struct {
+	BalanceID     uint64 `json:"balance_id,omitempty"`
+	FromBalanceID uint64 `json:"from_balance_id,omitempty"`
+	ToBalanceID   uint64 `json:"to_balance_id,omitempty"`
+	Amount        int64  `json:"amount"`
+	Decimal       string `json:"decimal"`
+	Currency      string `json:"currency"`
+	Reason        string `json:"reason"`
+}
+
+// rejectionReasons are stable codes of errors for external consumers, the first matching one is used
+var rejectionReasons = []struct {
+	err    error
+	reason string
+}{
+	{ErrInvalidAmount, "invalid_amount"},
+	{ErrInsufficientFunds, "insufficient_funds"},
+	{ErrOverdraftLimit, "overdraft_limit"},
+	{ErrMinBalance, "min_balance"},
+	{ErrMaxTransactionAmount, "max_transaction_amount"},
+	{ErrBalanceFrozen, "balance_frozen"},
+	{ErrBalanceClosed, "balance_closed"},
+	{ErrCurrencyMismatch, "currency_mismatch"},
+	{ErrOverflow, "overflow"},
+}
+
+// rejectedEvents maps audited operations to their rejected events
+var rejectedEvents = map[string]string{
+	AuditDeposit:  EventDepositRejected,
+	AuditWithdraw: EventWithdrawRejected,
+	AuditTransfer: EventTransferRejected,
+}
+
+func rejectionReason(err error) string {
+	for _, r := range rejectionReasons {
+		if errors.Is(err, r.err) {
+			return r.reason
+		}
+	}
+	return "internal_error"
+}
+
+// emit writes the event to the outbox in the transaction of the change, so the event exists
+// if and only if the change is committed. The balance is locked by the caller, so events of one
+// balance get ids in the order of changes.
+func emit(ctx context.Context, qtx *db.Queries, balanceID uint64, eventType string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	_, err = qtx.CreateOutboxEvent(ctx, db.CreateOutboxEventParams{
+		BalanceID: balanceID,
+		EventType: eventType,
+		Payload:   string(data),
+	})
+	return err
+}
+
+func emitEvent(ctx context.Context, qtx *db.Queries, event BalanceEvent) error {
+	return emit(ctx, qtx, event.BalanceID, EventBalanceChanged, event)
+}
+
+// emitEntryEvent emits deposit.completed or withdraw.completed of the created entry
+func emitEntryEvent(ctx context.Context, qtx *db.Queries, eventType string, entry db.Entry, amount money.Money) error {
+	return emit(ctx, qtx, entry.BalanceID, eventType, EntryEvent{
+		ID:        entry.ID,
+		BalanceID: entry.BalanceID,
+		Amount:    entry.Amount,
+		Decimal:   money.New(entry.Amount, amount.Currency).Decimal(),
+		Currency:  amount.Currency.Code,
+	})
+}
+
+// emitRejectedEvent emits *.rejected event of the failed operation. The event is emitted only if
+// the operation got to locking balances, outbox events always belong to an existing balance.
+func emitRejectedEvent(ctx context.Context, qtx *db.Queries, record *auditRecord, opErr error) error {
+	var balanceID uint64
+	for _, id := range []uint64{record.params.BalanceID, record.params.FromID, record.params.ToID} {
+		if _, ok := record.pre[id]; ok && id != 0 {
+			balanceID = id
+			break
+		}
+	}
+	if balanceID == 0 {
+		return nil
+	}
+
+	return emit(ctx, qtx, balanceID, rejectedEvents[record.operation], RejectedEvent{
+		BalanceID:     record.params.BalanceID,
+		FromBalanceID: record.params.FromID,
+		ToBalanceID:   record.params.ToID,
+		Amount:        record.amount.Amount,
+		Decimal:       record.amount.Decimal(),
+		Currency:      record.amount.Currency.Code,
+		Reason:        rejectionReason(opErr),
+	})
+}
+
+// emitTransferEvents emits balance events of both sides and transfer.completed, amountFrom and amountTo are amounts after it
+func emitTransferEvents(ctx context.Context, qtx *db.Queries, balanceFrom Balance, balanceTo Balance, amountFrom int64, amountTo int64, transferID uint64) error {
+	eventFrom := newBalanceEvent(balanceFrom, OperationTransfer, amountFrom-balanceFrom.Amount, amountFrom)
+	eventFrom.TransferID = transferID
+	err := emitEvent(ctx, qtx, eventFrom)
+	if err != nil {
+		return err
+	}
+
+	eventTo := newBalanceEvent(balanceTo, OperationTransfer, amountTo-balanceTo.Amount, amountTo)
+	eventTo.TransferID = transferID
+	err = emitEvent(ctx, qtx, eventTo)
+	if err != nil {
+		return err
+	}
+
+	amount := money.New(amountTo-balanceTo.Amount, balanceTo.Currency)
+	return emit(ctx, qtx, balanceFrom.ID, EventTransferCompleted, TransferEvent{
+		ID:            transferID,
+		FromBalanceID: balanceFrom.ID,
+		ToBalanceID:   balanceTo.ID,
+		Amount:        amount.Amount,
+		Decimal:       amount.Decimal(),
+		Currency:      amount.Currency.Code,
+	})
+}
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	db "github.com/tredoc/go-balances/db/sqlc"
+	"github.com/tredoc/go-balances/internal/money"
+)
+
+// defaultPolicy is applied to balances without stored policy,
+// customer balances can't go below zero and have no other limits
+func defaultPolicy(balanceID uint64) db.BalancePolicy {
+	return db.BalancePolicy{BalanceID: balanceID}
+}
+
+// loadPolicy reads the policy in share mode so it can't be changed until the operation commits
+func loadPolicy(ctx context.Context, qtx *db.Queries, balanceID uint64) (db.BalancePolicy, error) {
+	policy, err := qtx.GetBalancePolicyForShare(ctx, balanceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return defaultPolicy(balanceID), nil
+	}
+	return policy, err
+}
+
+// checkCredit validates that amount can be added to the balance
+func checkCredit(policy db.BalancePolicy, balance db.Balance, amount int64) error {
+	err := checkStatus(balance, false)
+	if err != nil {
+		return err
+	}
+
+	return checkLimit(policy, amount)
+}
+
+// checkDebit validates that amount can be taken from available part of the balance
+func checkDebit(policy db.BalancePolicy, balance db.Balance, amount int64) error {
+	err := checkStatus(balance, true)
+	if err != nil {
+		return err
+	}
+
+	err = checkLimit(policy, amount)
+	if err != nil {
+		return err
+	}
+
+	return checkFloor(policy, balance, amount)
+}
+
+// checkLimit validates single transaction amount
+func checkLimit(policy db.BalancePolicy, amount int64) error {
+	if policy.MaxTransactionAmount > 0 && amount > policy.MaxTransactionAmount {
+		return &PolicyError{BalanceID: policy.BalanceID, Err: ErrMaxTransactionAmount}
+	}
+	return nil
+}
+
+// checkFloor validates that available amount stays above min balance reduced by overdraft limit
+func checkFloor(policy db.BalancePolicy, balance db.Balance, amount int64) error {
+	rest, err := subAmount(balance.ID, available(balance), amount)
+	if err != nil {
+		return err
+	}
+
+	// can't overflow, SetBalancePolicy rejects such policies
+	floor := policy.MinBalance - policy.OverdraftLimit
+	if rest >= floor {
+		return nil
+	}
+
+	switch {
+	case policy.OverdraftLimit > 0:
+		return &PolicyError{BalanceID: policy.BalanceID, Err: ErrOverdraftLimit}
+	case policy.MinBalance != 0:
+		return &PolicyError{BalanceID: policy.BalanceID, Err: ErrMinBalance}
+	default:
+		return &PolicyError{BalanceID: policy.BalanceID, Err: ErrInsufficientFunds}
+	}
+}
+
+func (s *Service) GetBalancePolicy(ctx context.Context, balanceID uint64) (db.BalancePolicy, error) {
+	policy, err := s.store.GetBalancePolicy(ctx, balanceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// make sure balance exists before returning default policy
+		_, err = s.store.GetBalanceByID(ctx, balanceID)
+		return defaultPolicy(balanceID), err
+	}
+	return policy, err
+}
+
+// SetBalancePolicy stores the policy, balance is locked so policy can't change in the middle of operation
+func (s *Service) SetBalancePolicy(ctx context.Context, policy db.BalancePolicy) error {
+	if policy.OverdraftLimit < 0 || policy.MaxTransactionAmount < 0 {
+		return ErrInvalidPolicy
+	}
+
+	if _, err := money.Sub(policy.MinBalance, policy.OverdraftLimit); err != nil {
+		return ErrInvalidPolicy
+	}
+
+	tx, err := s.store.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	qtx := s.store.WithTx(tx)
+
+	balance, err := lockRow(ctx, qtx, policy.BalanceID)
+	if err != nil {
+		return err
+	}
+
+	err = qtx.UpsertBalancePolicy(ctx, db.UpsertBalancePolicyParams{
+		BalanceID:            policy.BalanceID,
+		OverdraftLimit:       policy.OverdraftLimit,
+		MinBalance:           policy.MinBalance,
+		MaxTransactionAmount: policy.MaxTransactionAmount,
+	})
+	if err != nil {
+		return err
+	}
+
+	audit := newChangeAuditRecord(AuditPolicy, auditParams{BalanceID: policy.BalanceID, Policy: &auditPolicy{
+		OverdraftLimit:       policy.OverdraftLimit,
+		MinBalance:           policy.MinBalance,
+		MaxTransactionAmount: policy.MaxTransactionAmount,
+	}})
+	audit.unchanged(balance)
+	err = appendAudit(ctx, qtx, audit, nil)
+	if err != nil {
+		return err
+	}
+
+	return commit(ctx, tx)
+}
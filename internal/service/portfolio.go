@@ -0,0 +1,154 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	db "github.com/tredoc/go-balances/db/sqlc"
+	"github.com/tredoc/go-balances/internal/money"
+	"github.com/tredoc/go-balances/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"math/big"
+)
+
+// Portfolio is every balance of the user with their total in one currency, all read from one snapshot
+type Portfolio struct {
+	User     db.User
+	Balances []Balance
+	// sum of the balance amounts converted to the reference currency, held amounts included
+	Total money.Money
+	// rates the balances were converted with, keyed by currency code, the reference currency isn't there
+	Rates map[string]string
+}
+
+// SetExchangeRate stores how many units of the quote currency one unit of the base currency costs,
+// like "1.0835" for EUR to USD. Conversions the other way use the inverse rate unless that one is set too.
+// In dry run the rate is validated and written, then rolled back.
+func (s *Service) SetExchangeRate(ctx context.Context, base string, quote string, rate string) error {
+	_, err := money.ParseRate(rate)
+	if err != nil {
+		return err
+	}
+	if base == quote {
+		return ErrSameCurrency
+	}
+
+	tx, err := s.store.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	qtx := s.store.WithTx(tx)
+
+	baseCurrency, err := qtx.GetCurrencyByName(ctx, base)
+	if err != nil {
+		return fmt.Errorf("currency %s: %w", base, err)
+	}
+
+	quoteCurrency, err := qtx.GetCurrencyByName(ctx, quote)
+	if err != nil {
+		return fmt.Errorf("currency %s: %w", quote, err)
+	}
+
+	err = qtx.UpsertExchangeRate(ctx, db.UpsertExchangeRateParams{
+		BaseCurrencyID:  baseCurrency.ID,
+		QuoteCurrencyID: quoteCurrency.ID,
+		Rate:            rate,
+	})
+	if err != nil {
+		return err
+	}
+
+	return commit(ctx, tx)
+}
+
+// UserPortfolio reads balances of the user and exchange rates from one snapshot, so the total never counts
+// a transfer between the balances half way, and converts the balances to the currency
+func (s *Service) UserPortfolio(ctx context.Context, userID uint64, currency string) (*Portfolio, error) {
+	ctx, span := startSpan(ctx, "UserPortfolio", attribute.Int64("user.id", int64(userID)), attribute.String("currency", currency))
+	portfolio, err := s.userPortfolio(ctx, userID, currency)
+	tracing.End(span, err)
+	return portfolio, err
+}
+
+func (s *Service) userPortfolio(ctx context.Context, userID uint64, currency string) (*Portfolio, error) {
+	tx, err := s.store.BeginTx(ctx, snapshotTx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	qtx := s.store.WithTx(tx)
+
+	user, err := qtx.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	reference, err := qtx.GetCurrencyByName(ctx, currency)
+	if err != nil {
+		return nil, fmt.Errorf("currency %s: %w", currency, err)
+	}
+
+	balances, err := userBalances(ctx, qtx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rates, err := qtx.GetExchangeRatesByCurrencyID(ctx, reference.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	portfolio := &Portfolio{
+		User:     user,
+		Balances: balances,
+		Total:    money.New(0, toCurrency(reference)),
+		Rates:    make(map[string]string),
+	}
+	for _, balance := range balances {
+		amount := balance.Total()
+		if balance.CurrencyID != reference.ID {
+			rate, err := rateTo(rates, balance.CurrencyID, reference.ID)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s to %s", err, balance.Currency.Code, reference.Name)
+			}
+
+			amount, err = money.Convert(amount, rate, portfolio.Total.Currency)
+			if err != nil {
+				return nil, &PolicyError{BalanceID: balance.ID, Err: err}
+			}
+			portfolio.Rates[balance.Currency.Code] = money.FormatRate(rate)
+		}
+
+		portfolio.Total.Amount, err = money.Add(portfolio.Total.Amount, amount.Amount)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return portfolio, nil
+}
+
+// rateTo finds the rate from one currency to the other, the inverse of the rate the other way is taken
+// when there is no direct one
+func rateTo(rates []db.ExchangeRate, fromID uint64, toID uint64) (*big.Rat, error) {
+	var inverse *db.ExchangeRate
+	for i, rate := range rates {
+		switch {
+		case rate.BaseCurrencyID == fromID && rate.QuoteCurrencyID == toID:
+			return money.ParseRate(rate.Rate)
+		case rate.BaseCurrencyID == toID && rate.QuoteCurrencyID == fromID:
+			inverse = &rates[i]
+		}
+	}
+
+	if inverse == nil {
+		return nil, ErrNoExchangeRate
+	}
+	rate, err := money.ParseRate(inverse.Rate)
+	if err != nil {
+		return nil, err
+	}
+	return rate.Inv(rate), nil
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	db "github.com/tredoc/go-balances/db/sqlc"
+	"github.com/tredoc/go-balances/internal/money"
+	"regexp"
+)
+
+var (
+	usernameRegexp = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]{2,31}$`)
+	// covers both ISO 4217 codes like USD and custom tokens like USDT
+	currencyCodeRegexp = regexp.MustCompile(`^[A-Z][A-Z0-9]{1,9}$`)
+)
+
+func (s *Service) CreateUser(ctx context.Context, username string) (*db.User, error) {
+	if !usernameRegexp.MatchString(username) {
+		return nil, ErrInvalidUsername
+	}
+
+	id, err := s.store.CreateUser(ctx, username)
+	if isDuplicateKey(err) {
+		return nil, &ConflictError{Key: username, Err: ErrUserExists}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &db.User{ID: uint64(id), Username: username}, nil
+}
+
+func (s *Service) GetUserById(ctx context.Context, id uint64) (db.User, error) {
+	return s.store.GetUserByID(ctx, id)
+}
+
+func (s *Service) GetUserByUsername(ctx context.Context, username string) (db.User, error) {
+	return s.store.GetUserByUsername(ctx, username)
+}
+
+// CreateCurrency adds currency with exponent digits after the decimal point, 2 for USD and 6 for USDT
+func (s *Service) CreateCurrency(ctx context.Context, code string, exponent uint8) (*db.Currency, error) {
+	if !currencyCodeRegexp.MatchString(code) {
+		return nil, ErrInvalidCurrencyCode
+	}
+
+	if exponent > money.MaxExponent {
+		return nil, ErrInvalidExponent
+	}
+
+	id, err := s.store.CreateCurrency(ctx, db.CreateCurrencyParams{Name: code, Exponent: exponent})
+	if isDuplicateKey(err) {
+		return nil, &ConflictError{Key: code, Err: ErrCurrencyExists}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &db.Currency{ID: uint64(id), Name: code, Exponent: exponent}, nil
+}
+
+func (s *Service) GetCurrencyByCode(ctx context.Context, code string) (db.Currency, error) {
+	return s.store.GetCurrencyByName(ctx, code)
+}
+
+// OpenBalance creates empty balance, user can hold only one balance per currency
+func (s *Service) OpenBalance(ctx context.Context, userID uint64, currencyID uint64) (*Balance, error) {
+	_, err := s.store.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	currency, err := s.store.GetCurrencyByID(ctx, currencyID)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := s.store.CreateBalance(ctx, db.CreateBalanceParams{UserID: userID, CurrencyID: currencyID})
+	if isDuplicateKey(err) {
+		return nil, &ConflictError{Key: fmt.Sprintf("user %d currency %d", userID, currencyID), Err: ErrBalanceExists}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	balance, err := s.store.GetBalanceByID(ctx, uint64(id))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Balance{Balance: balance, Currency: toCurrency(currency)}, nil
+}
+
+// GetOrOpenBalance returns the balance of the user in the currency, the balance is opened if it doesn't exist
+func (s *Service) GetOrOpenBalance(ctx context.Context, userID uint64, currencyID uint64) (*Balance, error) {
+	params := db.GetBalanceByUserIDAndCurrencyIDParams{UserID: userID, CurrencyID: currencyID}
+
+	balance, err := s.store.GetBalanceByUserIDAndCurrencyID(ctx, params)
+	if errors.Is(err, sql.ErrNoRows) {
+		var opened *Balance
+		opened, err = s.OpenBalance(ctx, userID, currencyID)
+		if !errors.Is(err, ErrBalanceExists) {
+			return opened, err
+		}
+
+		// balance was opened concurrently
+		balance, err = s.store.GetBalanceByUserIDAndCurrencyID(ctx, params)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := withCurrency(ctx, s.store.Queries, balance)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
@@ -0,0 +1,185 @@
+package service
+
+import (
+	"context"
+	db "github.com/tredoc/go-balances/db/sqlc"
+	"github.com/tredoc/go-balances/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"sort"
+)
+
+const (
+	LineEntry       = "entry"
+	LineTransferIn  = "transfer_in"
+	LineTransferOut = "transfer_out"
+)
+
+// StatementLine is one movement of the balance, amount is negative for debits
+type StatementLine struct {
+	Kind   string
+	ID     uint64
+	Amount int64
+	// other side of the transfer
+	CounterpartyID uint64
+	Reference      string
+	// batch of the entry made by a batch leg
+	BatchID uint64
+}
+
+// Statement lists entries and transfers of the balance, entries go first as neither of them has a time,
+// Credits and Debits are sums of positive and negative lines, Debits is negative
+type Statement struct {
+	Balance Balance
+	Lines   []StatementLine
+	Credits int64
+	Debits  int64
+}
+
+// Discrepancy is a balance whose stored amount doesn't match its ledger
+type Discrepancy struct {
+	BalanceID uint64
+	// amount or held_amount
+	Field    string
+	Stored   int64
+	Expected int64
+}
+
+// Statement reads the balance with its movements from one snapshot
+func (s *Service) Statement(ctx context.Context, balanceID uint64) (*Statement, error) {
+	ctx, span := startSpan(ctx, "Statement", attribute.Int64("balance.id", int64(balanceID)))
+	statement, err := s.statement(ctx, balanceID)
+	tracing.End(span, err)
+	return statement, err
+}
+
+func (s *Service) statement(ctx context.Context, balanceID uint64) (*Statement, error) {
+	// lines and the balance come from a single snapshot, so they add up
+	tx, err := s.store.BeginTx(ctx, snapshotTx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	qtx := s.store.WithTx(tx)
+
+	balance, err := qtx.GetBalanceByID(ctx, balanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	statement := &Statement{}
+	statement.Balance, err = withCurrency(ctx, qtx, balance)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := qtx.GetEntriesByBalanceID(ctx, balanceID)
+	if err != nil {
+		return nil, err
+	}
+
+	transfers, err := qtx.GetTransfersByAccountID(ctx, db.GetTransfersByAccountIDParams{FromBalanceID: balanceID, ToBalanceID: balanceID})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID < entries[j].ID })
+	for _, entry := range entries {
+		statement.Lines = append(statement.Lines, StatementLine{
+			Kind:      LineEntry,
+			ID:        entry.ID,
+			Amount:    entry.Amount,
+			Reference: entry.Reference.String,
+			BatchID:   uint64(entry.BatchID.Int64),
+		})
+	}
+
+	sort.Slice(transfers, func(i, j int) bool { return transfers[i].ID < transfers[j].ID })
+	for _, transfer := range transfers {
+		if transfer.BatchID.Valid {
+			// listed by the entries of the leg
+			continue
+		}
+
+		line := StatementLine{Kind: LineTransferIn, ID: transfer.ID, Amount: transfer.Amount, CounterpartyID: transfer.FromBalanceID}
+		if transfer.FromBalanceID == balanceID {
+			line = StatementLine{Kind: LineTransferOut, ID: transfer.ID, Amount: -transfer.Amount, CounterpartyID: transfer.ToBalanceID}
+		}
+		statement.Lines = append(statement.Lines, line)
+	}
+
+	for _, line := range statement.Lines {
+		if line.Amount > 0 {
+			statement.Credits, err = addAmount(balanceID, statement.Credits, line.Amount)
+		} else {
+			statement.Debits, err = addAmount(balanceID, statement.Debits, line.Amount)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return statement, nil
+}
+
+// Reconcile checks every balance against its ledger from one snapshot: the amount must equal the sum of
+// entries and incoming transfers less outgoing ones, the held amount must equal the sum of pending holds.
+// Transfers of batch legs are counted by their entries.
+func (s *Service) Reconcile(ctx context.Context) ([]Discrepancy, error) {
+	ctx, span := startSpan(ctx, "Reconcile")
+	discrepancies, err := s.reconcile(ctx, nil)
+	tracing.End(span, err)
+	return discrepancies, err
+}
+
+// ReconcileBalances checks only the given balances the way Reconcile does
+func (s *Service) ReconcileBalances(ctx context.Context, balanceIDs []uint64) ([]Discrepancy, error) {
+	if len(balanceIDs) == 0 {
+		// nil would check every balance
+		return nil, nil
+	}
+
+	ctx, span := startSpan(ctx, "ReconcileBalances", attribute.Int("balance.count", len(balanceIDs)))
+	discrepancies, err := s.reconcile(ctx, balanceIDs)
+	tracing.End(span, err)
+	return discrepancies, err
+}
+
+// reconcile checks balanceIDs, nil checks every balance
+func (s *Service) reconcile(ctx context.Context, balanceIDs []uint64) ([]Discrepancy, error) {
+	tx, err := s.store.BeginTx(ctx, snapshotTx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	qtx := s.store.WithTx(tx)
+
+	// the database sums the ledger of every balance and returns only the ones that don't match,
+	// so the check doesn't load the ledger into memory
+	var rows []db.GetBalanceDiscrepanciesRow
+	if balanceIDs == nil {
+		rows, err = qtx.GetBalanceDiscrepancies(ctx)
+	} else {
+		var found []db.GetBalanceDiscrepanciesByIDsRow
+		found, err = qtx.GetBalanceDiscrepanciesByIDs(ctx, balanceIDs)
+		for _, row := range found {
+			rows = append(rows, db.GetBalanceDiscrepanciesRow(row))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var discrepancies []Discrepancy
+	for _, row := range rows {
+		if row.ExpectedAmount != row.Amount {
+			discrepancies = append(discrepancies, Discrepancy{BalanceID: row.ID, Field: "amount", Stored: row.Amount, Expected: row.ExpectedAmount})
+		}
+		if row.ExpectedHeldAmount != row.HeldAmount {
+			discrepancies = append(discrepancies, Discrepancy{BalanceID: row.ID, Field: "held_amount", Stored: row.HeldAmount, Expected: row.ExpectedHeldAmount})
+		}
+	}
+
+	return discrepancies, nil
+}
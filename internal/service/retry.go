@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/go-sql-driver/mysql"
+	"github.com/tredoc/go-balances/internal/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy repeats deposits, withdrawals and transfers that lost a deadlock or waited too long for a lock.
+// The whole transaction is repeated, so the next attempt reads balances again.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var DefaultRetry = RetryPolicy{MaxAttempts: 3, BaseDelay: 10 * time.Millisecond, MaxDelay: 200 * time.Millisecond}
+
+// lockConflict names mysql deadlock and lock wait timeout, both roll the transaction back and can be retried,
+// other errors give empty string
+func lockConflict(err error) string {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return ""
+	}
+
+	switch mysqlErr.Number {
+	case 1213:
+		return "deadlock"
+	case 1205:
+		return "lock_wait_timeout"
+	default:
+		return ""
+	}
+}
+
+// delay doubles after every attempt, up to a half of it is random so competing callers don't retry in step
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, p.MaxDelay)
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+func (s *Service) withRetry(ctx context.Context, operation string, op func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := op()
+		conflict := lockConflict(err)
+		if conflict != "" {
+			metrics.LockConflicts.WithLabelValues(operation, conflict).Inc()
+		}
+		if conflict == "" || attempt >= s.Retry.MaxAttempts {
+			return err
+		}
+		metrics.Retries.WithLabelValues(operation).Inc()
+		trace.SpanFromContext(ctx).AddEvent("retry", trace.WithAttributes(attribute.Int("attempt", attempt), attribute.String("reason", conflict)))
+
+		delay := s.Retry.delay(attempt)
+		s.Logger.WarnContext(ctx, "retrying after lock conflict", "operation", operation, "attempt", attempt, "reason", conflict, "delay", delay)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
@@ -2,36 +2,131 @@ package service
 
 import (
 	"context"
-	"errors"
+	"database/sql"
 	db "github.com/tredoc/go-balances/db/sqlc"
+	"github.com/tredoc/go-balances/internal/money"
 	"github.com/tredoc/go-balances/internal/store"
+	"github.com/tredoc/go-balances/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"log/slog"
 	"time"
 )
 
 type Service struct {
-	store *store.Store
+	store  *store.Store
+	Retry  RetryPolicy
+	Logger *slog.Logger
 }
 
 func New(store *store.Store) *Service {
 	return &Service{
-		store: store,
+		store:  store,
+		Retry:  DefaultRetry,
+		Logger: slog.Default(),
 	}
 }
 
-func (s *Service) GetAllBalances() ([]db.Balance, error) {
-	return s.store.GetAllBalances(context.Background())
+// snapshotTx is a read only transaction whose queries all see the snapshot taken by its first read,
+// so a view of several balances never shows a half applied transfer between them
+var snapshotTx = &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}
+
+// GetAllBalances reads balances and their currencies from one snapshot
+func (s *Service) GetAllBalances(ctx context.Context) ([]Balance, error) {
+	tx, err := s.store.BeginTx(ctx, snapshotTx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	qtx := s.store.WithTx(tx)
+
+	balances, err := qtx.GetAllBalances(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	currencies, err := qtx.GetAllCurrencies(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[uint64]money.Currency, len(currencies))
+	for _, currency := range currencies {
+		byID[currency.ID] = toCurrency(currency)
+	}
+
+	result := make([]Balance, 0, len(balances))
+	for _, balance := range balances {
+		result = append(result, Balance{Balance: balance, Currency: byID[balance.CurrencyID]})
+	}
+
+	return result, nil
+}
+
+func (s *Service) GetBalanceById(ctx context.Context, id uint64) (Balance, error) {
+	balance, err := s.store.GetBalanceByID(ctx, id)
+	if err != nil {
+		return Balance{}, err
+	}
+	return withCurrency(ctx, s.store.Queries, balance)
 }
 
-func (s *Service) GetBalanceById(id uint64) (db.Balance, error) {
-	return s.store.GetBalanceByID(context.Background(), id)
+// GetBalancesByUserId reads balances of the user from one snapshot
+func (s *Service) GetBalancesByUserId(ctx context.Context, userID uint64) ([]Balance, error) {
+	tx, err := s.store.BeginTx(ctx, snapshotTx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return userBalances(ctx, s.store.WithTx(tx), userID)
 }
 
-func (s *Service) Deposit(id uint64, amount int64) (*db.Balance, error) {
-	if amount <= 0 {
-		return nil, errors.New("amount must be positive")
+func userBalances(ctx context.Context, q *db.Queries, userID uint64) ([]Balance, error) {
+	balances, err := q.GetBalancesByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Balance, 0, len(balances))
+	for _, balance := range balances {
+		full, err := withCurrency(ctx, q, balance)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, full)
 	}
 
-	tx, err := s.store.DB.Begin()
+	return result, nil
+}
+
+// Deposit adds amount to the balance, the attempt is recorded to the audit log with the initiator from ctx
+func (s *Service) Deposit(ctx context.Context, id uint64, amount money.Money) (*Balance, error) {
+	ctx, span := startSpan(ctx, "Deposit", append(amountAttrs(amount), attribute.Int64("balance.id", int64(id)))...)
+	start := time.Now()
+	audit := newAuditRecord(AuditDeposit, auditParams{BalanceID: id}, amount)
+	var balance *Balance
+	err := s.withRetry(ctx, OperationDeposit, func() (err error) {
+		balance, err = s.deposit(ctx, id, amount, audit)
+		return err
+	})
+	observe(OperationDeposit, start, err)
+	s.logOutcome(ctx, OperationDeposit, err, slog.Uint64("balance_id", id), slog.String("amount", amount.String()))
+	if err != nil {
+		err = s.auditRejected(ctx, audit, err)
+		tracing.End(span, err)
+		return nil, err
+	}
+	span.End()
+	return balance, nil
+}
+
+func (s *Service) deposit(ctx context.Context, id uint64, amount money.Money, audit *auditRecord) (*Balance, error) {
+	if !amount.IsPositive() {
+		return nil, ErrInvalidAmount
+	}
+
+	tx, err := s.store.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -39,29 +134,93 @@ func (s *Service) Deposit(id uint64, amount int64) (*db.Balance, error) {
 
 	qtx := s.store.WithTx(tx)
 
-	balance, err := qtx.GetBalanceByIDForUpdate(context.Background(), id)
+	balance, err := lockBalance(ctx, qtx, id)
+	if err != nil {
+		return nil, err
+	}
+	audit.before(balance)
+
+	err = checkCurrency(balance, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := loadPolicy(ctx, qtx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkCredit(policy, balance.Balance, amount.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	newAmount, err := addAmount(id, balance.Amount, amount.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	entryID, err := qtx.CreateEntry(ctx, db.CreateEntryParams{BalanceID: id, Amount: amount.Amount})
+	if err != nil {
+		return nil, err
+	}
+
+	err = qtx.UpdateBalance(ctx, db.UpdateBalanceParams{ID: id, Amount: newAmount})
+	if err != nil {
+		return nil, err
+	}
+
+	event := newBalanceEvent(balance, OperationDeposit, amount.Amount, newAmount)
+	event.EntryID = uint64(entryID)
+	err = emitEvent(ctx, qtx, event)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = qtx.CreateEntry(context.Background(), db.CreateEntryParams{BalanceID: id, Amount: amount})
+	entry := db.Entry{ID: uint64(entryID), BalanceID: id, Amount: amount.Amount}
+	err = emitEntryEvent(ctx, qtx, EventDepositCompleted, entry, amount)
+	if err != nil {
+		return nil, err
+	}
 
-	err = qtx.UpdateBalance(context.Background(), db.UpdateBalanceParams{ID: id, Amount: balance.Amount + amount})
+	audit.after(id, newAmount)
+	err = appendAudit(ctx, qtx, audit, nil)
 	if err != nil {
 		return nil, err
 	}
-	err = tx.Commit()
+	err = commit(ctx, tx)
 
-	balance.Amount += amount
+	balance.Amount = newAmount
 	return &balance, err
 }
 
-func (s *Service) Withdraw(id uint64, amount int64) (*db.Balance, error) {
-	if amount <= 0 {
-		return nil, errors.New("amount must be positive")
+// Withdraw takes amount from the balance, the attempt is recorded to the audit log with the initiator from ctx
+func (s *Service) Withdraw(ctx context.Context, id uint64, amount money.Money) (*Balance, error) {
+	ctx, span := startSpan(ctx, "Withdraw", append(amountAttrs(amount), attribute.Int64("balance.id", int64(id)))...)
+	start := time.Now()
+	audit := newAuditRecord(AuditWithdraw, auditParams{BalanceID: id}, amount)
+	var balance *Balance
+	err := s.withRetry(ctx, OperationWithdraw, func() (err error) {
+		balance, err = s.withdraw(ctx, id, amount, audit)
+		return err
+	})
+	observe(OperationWithdraw, start, err)
+	s.logOutcome(ctx, OperationWithdraw, err, slog.Uint64("balance_id", id), slog.String("amount", amount.String()))
+	if err != nil {
+		err = s.auditRejected(ctx, audit, err)
+		tracing.End(span, err)
+		return nil, err
+	}
+	span.End()
+	return balance, nil
+}
+
+func (s *Service) withdraw(ctx context.Context, id uint64, amount money.Money, audit *auditRecord) (*Balance, error) {
+	if !amount.IsPositive() {
+		return nil, ErrInvalidAmount
 	}
 
-	tx, err := s.store.DB.Begin()
+	tx, err := s.store.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -69,36 +228,104 @@ func (s *Service) Withdraw(id uint64, amount int64) (*db.Balance, error) {
 
 	qtx := s.store.WithTx(tx)
 
-	balance, err := qtx.GetBalanceByIDForUpdate(context.Background(), id)
+	balance, err := lockBalance(ctx, qtx, id)
+	if err != nil {
+		return nil, err
+	}
+	audit.before(balance)
+
+	err = checkCurrency(balance, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = releaseExpiredHolds(ctx, qtx, &balance.Balance)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, err := loadPolicy(ctx, qtx, id)
 	if err != nil {
 		return nil, err
 	}
 
-	if balance.Amount < amount {
-		return nil, errors.New("insufficient funds")
+	err = checkDebit(policy, balance.Balance, amount.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	newAmount, err := subAmount(id, balance.Amount, amount.Amount)
+	if err != nil {
+		return nil, err
 	}
 
-	_, err = qtx.CreateEntry(context.Background(), db.CreateEntryParams{BalanceID: id, Amount: -amount})
+	entryID, err := qtx.CreateEntry(ctx, db.CreateEntryParams{BalanceID: id, Amount: -amount.Amount})
 	if err != nil {
 		return nil, err
 	}
 
-	err = qtx.UpdateBalance(context.Background(), db.UpdateBalanceParams{ID: id, Amount: balance.Amount - amount})
+	err = qtx.UpdateBalance(ctx, db.UpdateBalanceParams{ID: id, Amount: newAmount})
 	if err != nil {
 		return nil, err
 	}
-	err = tx.Commit()
 
-	balance.Amount -= amount
+	event := newBalanceEvent(balance, OperationWithdraw, -amount.Amount, newAmount)
+	event.EntryID = uint64(entryID)
+	err = emitEvent(ctx, qtx, event)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := db.Entry{ID: uint64(entryID), BalanceID: id, Amount: -amount.Amount}
+	err = emitEntryEvent(ctx, qtx, EventWithdrawCompleted, entry, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	audit.after(id, newAmount)
+	err = appendAudit(ctx, qtx, audit, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = commit(ctx, tx)
+
+	balance.Amount = newAmount
 	return &balance, err
 }
 
-func (s *Service) Transfer(fromID uint64, toID uint64, amount int64) (*db.Balance, *db.Balance, error) {
-	if amount <= 0 {
-		return nil, nil, errors.New("amount must be positive")
+// Transfer moves amount between balances, the attempt is recorded to the audit log with the initiator from ctx
+func (s *Service) Transfer(ctx context.Context, fromID uint64, toID uint64, amount money.Money) (*Balance, *Balance, error) {
+	ctx, span := startSpan(ctx, "Transfer", append(amountAttrs(amount),
+		attribute.Int64("balance.from_id", int64(fromID)), attribute.Int64("balance.to_id", int64(toID)))...)
+	start := time.Now()
+	audit := newAuditRecord(AuditTransfer, auditParams{FromID: fromID, ToID: toID}, amount)
+	var balanceFrom, balanceTo *Balance
+	err := s.withRetry(ctx, OperationTransfer, func() (err error) {
+		balanceFrom, balanceTo, err = s.transfer(ctx, fromID, toID, amount, audit)
+		return err
+	})
+	observe(OperationTransfer, start, err)
+	s.logOutcome(ctx, OperationTransfer, err, slog.Uint64("from_id", fromID), slog.Uint64("to_id", toID), slog.String("amount", amount.String()))
+	if err != nil {
+		err = s.auditRejected(ctx, audit, err)
+		tracing.End(span, err)
+		return nil, nil, err
+	}
+	span.End()
+	return balanceFrom, balanceTo, nil
+}
+
+func (s *Service) transfer(ctx context.Context, fromID uint64, toID uint64, amount money.Money, audit *auditRecord) (*Balance, *Balance, error) {
+	if !amount.IsPositive() {
+		return nil, nil, ErrInvalidAmount
 	}
 
-	tx, err := s.store.DB.Begin()
+	// both updates would hit one row and the debit would overwrite the credit
+	if fromID == toID {
+		return nil, nil, ErrSameBalance
+	}
+
+	tx, err := s.store.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -108,112 +335,209 @@ func (s *Service) Transfer(fromID uint64, toID uint64, amount int64) (*db.Balanc
 
 	// always use same update order to avoid deadlock
 	if fromID < toID {
-		// using regular GetBalanceByID will cause deadlock
-		balanceFrom, err := qtx.GetBalanceByIDForUpdate(context.Background(), fromID)
+		balanceFrom, err := lockBalance(ctx, qtx, fromID)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		err = releaseExpiredHolds(ctx, qtx, &balanceFrom.Balance)
 		if err != nil {
 			return nil, nil, err
 		}
 
-		if balanceFrom.Amount < amount {
-			return nil, nil, errors.New("insufficient funds")
+		balanceTo, err := lockBalance(ctx, qtx, toID)
+		if err != nil {
+			return nil, nil, err
+		}
+		audit.before(balanceFrom)
+		audit.before(balanceTo)
+
+		err = checkTransfer(ctx, qtx, balanceFrom, balanceTo, amount)
+		if err != nil {
+			return nil, nil, err
 		}
 
-		// using regular GetBalanceByID will cause deadlock
-		balanceTo, err := qtx.GetBalanceByIDForUpdate(context.Background(), toID)
+		amountFrom, amountTo, err := transferAmounts(balanceFrom, balanceTo, amount)
 		if err != nil {
 			return nil, nil, err
 		}
 
-		_, err = qtx.CreateTransfer(context.Background(), db.CreateTransferParams{FromBalanceID: fromID, ToBalanceID: toID, Amount: amount})
+		transferID, err := qtx.CreateTransfer(ctx, db.CreateTransferParams{FromBalanceID: fromID, ToBalanceID: toID, Amount: amount.Amount})
 		if err != nil {
 			return nil, nil, err
 		}
 
 		// add sleep to emulate slow db
 		time.Sleep(150 * time.Millisecond)
-		err = qtx.UpdateBalance(context.Background(), db.UpdateBalanceParams{ID: fromID, Amount: balanceFrom.Amount - amount})
+		err = qtx.UpdateBalance(ctx, db.UpdateBalanceParams{ID: fromID, Amount: amountFrom})
 		if err != nil {
 			return nil, nil, err
 		}
 
 		// add sleep to emulate slow db
 		time.Sleep(100 * time.Millisecond)
-		err = qtx.UpdateBalance(context.Background(), db.UpdateBalanceParams{ID: toID, Amount: balanceTo.Amount + amount})
+		err = qtx.UpdateBalance(ctx, db.UpdateBalanceParams{ID: toID, Amount: amountTo})
 		if err != nil {
 			return nil, nil, err
 		}
 
-		err = tx.Commit()
+		err = emitTransferEvents(ctx, qtx, balanceFrom, balanceTo, amountFrom, amountTo, uint64(transferID))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		audit.after(fromID, amountFrom)
+		audit.after(toID, amountTo)
+		err = appendAudit(ctx, qtx, audit, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		err = commit(ctx, tx)
+		if err != nil {
+			return nil, nil, err
+		}
 
-		balanceFrom.Amount -= amount
-		balanceTo.Amount += amount
-		return &balanceFrom, &balanceTo, err
+		balanceFrom.Amount = amountFrom
+		balanceTo.Amount = amountTo
+		return &balanceFrom, &balanceTo, nil
 
 	} else {
 
-		// using regular GetBalanceByID will cause deadlock
-		balanceTo, err := qtx.GetBalanceByIDForUpdate(context.Background(), toID)
+		balanceTo, err := lockBalance(ctx, qtx, toID)
 		if err != nil {
 			return nil, nil, err
 		}
 
-		// using regular GetBalanceByID will cause deadlock
-		balanceFrom, err := qtx.GetBalanceByIDForUpdate(context.Background(), fromID)
+		balanceFrom, err := lockBalance(ctx, qtx, fromID)
 		if err != nil {
 			return nil, nil, err
 		}
+		audit.before(balanceFrom)
+		audit.before(balanceTo)
 
-		if balanceFrom.Amount < amount {
-			return nil, nil, errors.New("insufficient funds")
+		err = releaseExpiredHolds(ctx, qtx, &balanceFrom.Balance)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		err = checkTransfer(ctx, qtx, balanceFrom, balanceTo, amount)
+		if err != nil {
+			return nil, nil, err
 		}
 
-		_, err = qtx.CreateTransfer(context.Background(), db.CreateTransferParams{FromBalanceID: fromID, ToBalanceID: toID, Amount: amount})
+		amountFrom, amountTo, err := transferAmounts(balanceFrom, balanceTo, amount)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		transferID, err := qtx.CreateTransfer(ctx, db.CreateTransferParams{FromBalanceID: fromID, ToBalanceID: toID, Amount: amount.Amount})
 		if err != nil {
 			return nil, nil, err
 		}
 
 		// add sleep to emulate slow db
 		time.Sleep(150 * time.Millisecond)
-		err = qtx.UpdateBalance(context.Background(), db.UpdateBalanceParams{ID: toID, Amount: balanceTo.Amount + amount})
+		err = qtx.UpdateBalance(ctx, db.UpdateBalanceParams{ID: toID, Amount: amountTo})
 		if err != nil {
 			return nil, nil, err
 		}
 
 		// add sleep to emulate slow db
 		time.Sleep(100 * time.Millisecond)
-		err = qtx.UpdateBalance(context.Background(), db.UpdateBalanceParams{ID: fromID, Amount: balanceFrom.Amount - amount})
+		err = qtx.UpdateBalance(ctx, db.UpdateBalanceParams{ID: fromID, Amount: amountFrom})
 		if err != nil {
 			return nil, nil, err
 		}
 
-		err = tx.Commit()
+		err = emitTransferEvents(ctx, qtx, balanceFrom, balanceTo, amountFrom, amountTo, uint64(transferID))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		audit.after(fromID, amountFrom)
+		audit.after(toID, amountTo)
+		err = appendAudit(ctx, qtx, audit, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		err = commit(ctx, tx)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		balanceFrom.Amount = amountFrom
+		balanceTo.Amount = amountTo
+		return &balanceFrom, &balanceTo, nil
+	}
+}
+
+// checkTransfer validates currencies and policies of both balances, both rows must be locked by the caller
+func checkTransfer(ctx context.Context, qtx *db.Queries, balanceFrom Balance, balanceTo Balance, amount money.Money) error {
+	err := checkCurrency(balanceFrom, amount)
+	if err != nil {
+		return err
+	}
+
+	err = checkCurrency(balanceTo, amount)
+	if err != nil {
+		return err
+	}
+
+	policyFrom, err := loadPolicy(ctx, qtx, balanceFrom.ID)
+	if err != nil {
+		return err
+	}
 
-		balanceFrom.Amount -= amount
-		balanceTo.Amount += amount
-		return &balanceFrom, &balanceTo, err
+	err = checkDebit(policyFrom, balanceFrom.Balance, amount.Amount)
+	if err != nil {
+		return err
 	}
+
+	policyTo, err := loadPolicy(ctx, qtx, balanceTo.ID)
+	if err != nil {
+		return err
+	}
+
+	return checkCredit(policyTo, balanceTo.Balance, amount.Amount)
+}
+
+// transferAmounts returns amounts of both balances after the transfer
+func transferAmounts(balanceFrom Balance, balanceTo Balance, amount money.Money) (int64, int64, error) {
+	amountFrom, err := subAmount(balanceFrom.ID, balanceFrom.Amount, amount.Amount)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	amountTo, err := addAmount(balanceTo.ID, balanceTo.Amount, amount.Amount)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return amountFrom, amountTo, nil
 }
 
-func (s *Service) GetLastTransferID() (uint64, error) {
-	return s.store.GetLastTransferID(context.Background())
+func (s *Service) GetLastTransferID(ctx context.Context) (uint64, error) {
+	return s.store.GetLastTransferID(ctx)
 }
 
-func (s *Service) GetAllCurrencies() ([]db.Currency, error) {
-	return s.store.GetAllCurrencies(context.Background())
+func (s *Service) GetAllCurrencies(ctx context.Context) ([]db.Currency, error) {
+	return s.store.GetAllCurrencies(ctx)
 }
 
-func (s *Service) GetAllEntries() ([]db.Entry, error) {
-	return s.store.GetAllEntries(context.Background())
+func (s *Service) GetAllEntries(ctx context.Context) ([]db.Entry, error) {
+	return s.store.GetAllEntries(ctx)
 }
 
-func (s *Service) GetLastEntryID() (uint64, error) {
-	return s.store.GetLastEntryID(context.Background())
+func (s *Service) GetLastEntryID(ctx context.Context) (uint64, error) {
+	return s.store.GetLastEntryID(ctx)
 }
 
-func (s *Service) GetAllTransfers() ([]db.Transfer, error) {
-	return s.store.GetAllTransfers(context.Background())
+func (s *Service) GetAllTransfers(ctx context.Context) ([]db.Transfer, error) {
+	return s.store.GetAllTransfers(ctx)
 }
 
-func (s *Service) GetAllUsers() ([]db.User, error) {
-	return s.store.GetAllUsers(context.Background())
+func (s *Service) GetAllUsers(ctx context.Context) ([]db.User, error) {
+	return s.store.GetAllUsers(ctx)
 }
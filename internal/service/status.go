@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	db "github.com/tredoc/go-balances/db/sqlc"
+	"github.com/tredoc/go-balances/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"log/slog"
+)
+
+const (
+	StatusActive      = "active"
+	StatusFrozenDebit = "frozen_debit"
+	StatusFrozenAll   = "frozen_all"
+	StatusClosed      = "closed"
+)
+
+// transitions lists statuses each status can be changed to, closed is final
+var transitions = map[string][]string{
+	StatusActive:      {StatusFrozenDebit, StatusFrozenAll, StatusClosed},
+	StatusFrozenDebit: {StatusActive, StatusFrozenAll, StatusClosed},
+	StatusFrozenAll:   {StatusActive, StatusFrozenDebit, StatusClosed},
+	StatusClosed:      {},
+}
+
+func canTransition(from string, to string) bool {
+	for _, status := range transitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+// checkStatus validates that the balance status allows to move money,
+// frozen_debit balance still accepts credits
+func checkStatus(balance db.Balance, debit bool) error {
+	switch balance.Status {
+	case StatusActive:
+		return nil
+	case StatusFrozenDebit:
+		if !debit {
+			return nil
+		}
+		return &PolicyError{BalanceID: balance.ID, Err: ErrBalanceFrozen}
+	case StatusFrozenAll:
+		return &PolicyError{BalanceID: balance.ID, Err: ErrBalanceFrozen}
+	case StatusClosed:
+		return &PolicyError{BalanceID: balance.ID, Err: ErrBalanceClosed}
+	default:
+		return &PolicyError{BalanceID: balance.ID, Err: ErrInvalidStatus}
+	}
+}
+
+// FreezeBalance blocks debits or, if debitOnly is false, all operations on the balance
+func (s *Service) FreezeBalance(ctx context.Context, id uint64, debitOnly bool, actor string, reason string) (*Balance, error) {
+	if debitOnly {
+		return s.SetBalanceStatus(ctx, id, StatusFrozenDebit, actor, reason)
+	}
+	return s.SetBalanceStatus(ctx, id, StatusFrozenAll, actor, reason)
+}
+
+func (s *Service) UnfreezeBalance(ctx context.Context, id uint64, actor string, reason string) (*Balance, error) {
+	return s.SetBalanceStatus(ctx, id, StatusActive, actor, reason)
+}
+
+// CloseBalance retires the balance, only empty balance without pending holds can be closed
+func (s *Service) CloseBalance(ctx context.Context, id uint64, actor string, reason string) (*Balance, error) {
+	return s.SetBalanceStatus(ctx, id, StatusClosed, actor, reason)
+}
+
+// SetBalanceStatus moves the balance to the status and records who did it and why
+func (s *Service) SetBalanceStatus(ctx context.Context, id uint64, status string, actor string, reason string) (*Balance, error) {
+	ctx, span := startSpan(ctx, "SetBalanceStatus", attribute.Int64("balance.id", int64(id)), attribute.String("balance.status", status))
+	balance, err := s.setBalanceStatus(ctx, id, status, actor, reason)
+	s.logOutcome(ctx, "set_status", err, slog.Uint64("balance_id", id), slog.String("status", status))
+	tracing.End(span, err)
+	return balance, err
+}
+
+func (s *Service) setBalanceStatus(ctx context.Context, id uint64, status string, actor string, reason string) (*Balance, error) {
+	if actor == "" || reason == "" {
+		return nil, ErrMissingActor
+	}
+
+	if _, ok := transitions[status]; !ok {
+		return nil, ErrInvalidStatus
+	}
+
+	tx, err := s.store.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	qtx := s.store.WithTx(tx)
+
+	balance, err := lockBalance(ctx, qtx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !canTransition(balance.Status, status) {
+		return nil, &PolicyError{BalanceID: id, Err: ErrInvalidTransition}
+	}
+
+	if status == StatusClosed {
+		err = releaseExpiredHolds(ctx, qtx, &balance.Balance)
+		if err != nil {
+			return nil, err
+		}
+
+		if balance.HeldAmount != 0 {
+			return nil, &PolicyError{BalanceID: id, Err: ErrPendingHolds}
+		}
+
+		if balance.Amount != 0 {
+			return nil, &PolicyError{BalanceID: id, Err: ErrBalanceNotEmpty}
+		}
+	}
+
+	err = qtx.UpdateBalanceStatus(ctx, db.UpdateBalanceStatusParams{ID: id, Status: status})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = qtx.CreateBalanceStatusChange(ctx, db.CreateBalanceStatusChangeParams{
+		BalanceID:  id,
+		FromStatus: balance.Status,
+		ToStatus:   status,
+		Actor:      actor,
+		Reason:     reason,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	audit := newChangeAuditRecord(AuditStatus, auditParams{BalanceID: id, Status: status, Reason: reason})
+	audit.unchanged(balance.Balance)
+	err = appendAudit(ctx, qtx, audit, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = commit(ctx, tx)
+
+	balance.Status = status
+	return &balance, err
+}
+
+func (s *Service) GetBalanceStatusChanges(ctx context.Context, id uint64) ([]db.BalanceStatusChange, error) {
+	return s.store.GetBalanceStatusChangesByBalanceID(ctx, id)
+}
@@ -0,0 +1,23 @@
+package service
+
+import (
+	"context"
+	"github.com/tredoc/go-balances/internal/money"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/tredoc/go-balances/internal/service")
+
+// startSpan starts the span of the operation, queries and the transaction of the operation are its children
+func startSpan(ctx context.Context, operation string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "service."+operation, trace.WithAttributes(attrs...))
+}
+
+func amountAttrs(amount money.Money) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("amount", amount.Decimal()),
+		attribute.String("currency", amount.Currency.Code),
+	}
+}
@@ -2,17 +2,58 @@ package store
 
 import (
 	"database/sql"
+	_ "github.com/go-sql-driver/mysql"
 	db "github.com/tredoc/go-balances/db/sqlc"
+	"github.com/tredoc/go-balances/internal/config"
+	"log/slog"
+	"sync/atomic"
+	"time"
 )
 
+const DefaultSlowQuery = 200 * time.Millisecond
+
 type Store struct {
 	DB *sql.DB
 	*db.Queries
+	// queries slower than SlowQuery are logged as warnings, 0 turns it off
+	SlowQuery time.Duration
+	Logger    *slog.Logger
+	// transactions begun and not yet committed or rolled back
+	inFlight atomic.Int64
 }
 
 func New(conn *sql.DB) *Store {
-	return &Store{
-		DB:      conn,
-		Queries: db.New(conn),
+	s := &Store{
+		DB:        conn,
+		SlowQuery: DefaultSlowQuery,
+		Logger:    slog.Default(),
+	}
+	s.Queries = db.New(tracedDB{db: conn, store: s})
+	return s
+}
+
+// InFlight returns the number of open transactions of the store
+func (s *Store) InFlight() int64 {
+	return s.inFlight.Load()
+}
+
+// Open connects to the database of the config and checks the connection
+func Open(cfg config.DB) (*sql.DB, error) {
+	conn, err := sql.Open("mysql", cfg.DSN())
+	if err != nil {
+		return nil, err
 	}
+
+	conn.SetMaxOpenConns(cfg.MaxOpenConns)
+	conn.SetMaxIdleConns(cfg.MaxIdleConns)
+	conn.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	conn.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	err = conn.Ping()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
 }
@@ -0,0 +1,133 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	db "github.com/tredoc/go-balances/db/sqlc"
+	"github.com/tredoc/go-balances/internal/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+	"time"
+)
+
+var tracer = otel.Tracer("github.com/tredoc/go-balances/internal/store")
+
+// tracedDB starts a client span for every query of db.Queries, it's named after the sqlc query,
+// queries slower than SlowQuery of the store are logged
+type tracedDB struct {
+	db    db.DBTX
+	store *Store
+}
+
+func (t tracedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	defer t.checkSlow(ctx, query, time.Now())
+	ctx, span := startSpan(ctx, queryName(query))
+	result, err := t.db.ExecContext(ctx, query, args...)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (t tracedDB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	ctx, span := startSpan(ctx, "prepare "+queryName(query))
+	stmt, err := t.db.PrepareContext(ctx, query)
+	tracing.End(span, err)
+	return stmt, err
+}
+
+func (t tracedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	defer t.checkSlow(ctx, query, time.Now())
+	ctx, span := startSpan(ctx, queryName(query))
+	rows, err := t.db.QueryContext(ctx, query, args...)
+	tracing.End(span, err)
+	return rows, err
+}
+
+func (t tracedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	defer t.checkSlow(ctx, query, time.Now())
+	ctx, span := startSpan(ctx, queryName(query))
+	row := t.db.QueryRowContext(ctx, query, args...)
+	// no rows isn't a failure of the query
+	err := row.Err()
+	if errors.Is(err, sql.ErrNoRows) {
+		err = nil
+	}
+	tracing.End(span, err)
+	return row
+}
+
+// checkSlow warns about the query started at start, waiting for row locks counts too
+func (t tracedDB) checkSlow(ctx context.Context, query string, start time.Time) {
+	elapsed := time.Since(start)
+	if t.store.SlowQuery > 0 && elapsed >= t.store.SlowQuery {
+		t.store.Logger.WarnContext(ctx, "slow query", "query", queryName(query), "duration", elapsed)
+	}
+}
+
+// Tx is sql.Tx whose commit and rollback are traced, Rollback after Commit is a no-op like for sql.Tx
+type Tx struct {
+	*sql.Tx
+	ctx   context.Context
+	store *Store
+	done  bool
+}
+
+// BeginTx starts the transaction, the spans of begin, commit and rollback are children of ctx
+func (s *Store) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	beginCtx, span := startSpan(ctx, "begin")
+	tx, err := s.DB.BeginTx(beginCtx, opts)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, err
+	}
+	s.inFlight.Add(1)
+	return &Tx{Tx: tx, ctx: ctx, store: s}, nil
+}
+
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return sql.ErrTxDone
+	}
+	tx.done = true
+	tx.store.inFlight.Add(-1)
+
+	_, span := startSpan(tx.ctx, "commit")
+	err := tx.Tx.Commit()
+	tracing.End(span, err)
+	return err
+}
+
+func (tx *Tx) Rollback() error {
+	if tx.done {
+		return sql.ErrTxDone
+	}
+	tx.done = true
+	tx.store.inFlight.Add(-1)
+
+	_, span := startSpan(tx.ctx, "rollback")
+	err := tx.Tx.Rollback()
+	tracing.End(span, err)
+	return err
+}
+
+// WithTx returns queries that run in the transaction
+func (s *Store) WithTx(tx *Tx) *db.Queries {
+	return db.New(tracedDB{db: tx.Tx, store: s})
+}
+
+func startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "db."+name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attribute.String("db.system", "mysql")))
+}
+
+// queryName takes the name from sqlc header of the query, "-- name: GetBalanceByID :one"
+func queryName(query string) string {
+	header, _, _ := strings.Cut(query, "\n")
+	name, ok := strings.CutPrefix(header, "-- name: ")
+	if !ok {
+		return "query"
+	}
+	name, _, _ = strings.Cut(name, " ")
+	return name
+}
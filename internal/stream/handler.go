@@ -0,0 +1,138 @@
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HeartbeatInterval is how often an idle stream gets a comment line, it keeps proxies from closing it
+const HeartbeatInterval = 15 * time.Second
+
+// Handler serves server-sent events of balance updates:
+//
+//	GET /stream?balance_id=1,2&user_id=3
+//
+// Every message has the outbox event id, a reconnecting client sends it back in Last-Event-ID header
+// (browsers do it on their own) or in after query parameter and gets all updates it missed.
+func (h *Hub) Handler() http.Handler {
+	return http.HandlerFunc(h.serveStream)
+}
+
+func (h *Hub) serveStream(w http.ResponseWriter, r *http.Request) {
+	filter, after, err := parseRequest(r.URL.Query(), r.Header.Get("Last-Event-ID"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ctx := r.Context()
+
+	// subscribe before catching up, so nothing committed in between is lost
+	sub, err := h.Subscribe(ctx, filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	if after > 0 {
+		err = h.CatchUp(ctx, filter, after, sub.Start, func(update Update) error {
+			return writeUpdate(w, update)
+		})
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+
+	heartbeat := time.NewTicker(HeartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			_, err = io.WriteString(w, ": heartbeat\n\n")
+		case update, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if update.ID <= after {
+				continue
+			}
+			err = writeUpdate(w, update)
+		}
+
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// parseRequest reads the filter and the resume token, the query parameter wins over the header
+func parseRequest(query url.Values, lastEventID string) (Filter, uint64, error) {
+	var filter Filter
+	for _, value := range query["balance_id"] {
+		for _, part := range strings.Split(value, ",") {
+			id, err := strconv.ParseUint(strings.TrimSpace(part), 10, 64)
+			if err != nil || id == 0 {
+				return Filter{}, 0, fmt.Errorf("invalid balance_id %q", part)
+			}
+			filter.BalanceIDs = append(filter.BalanceIDs, id)
+		}
+	}
+
+	if value := query.Get("user_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || id == 0 {
+			return Filter{}, 0, fmt.Errorf("invalid user_id %q", value)
+		}
+		filter.UserID = id
+	}
+
+	if len(filter.BalanceIDs) == 0 && filter.UserID == 0 {
+		return Filter{}, 0, ErrEmptyFilter
+	}
+
+	token := lastEventID
+	if value := query.Get("after"); value != "" {
+		token = value
+	}
+
+	var after uint64
+	if token != "" {
+		var err error
+		after, err = strconv.ParseUint(token, 10, 64)
+		if err != nil {
+			return Filter{}, 0, errors.New("invalid resume token")
+		}
+	}
+
+	return filter, after, nil
+}
+
+// writeUpdate writes one message, the payload is a single line of json, so it's one data field
+func writeUpdate(w io.Writer, update Update) error {
+	_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", update.ID, update.Type, update.Payload)
+	return err
+}
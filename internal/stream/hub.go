@@ -0,0 +1,314 @@
+package stream
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	db "github.com/tredoc/go-balances/db/sqlc"
+	"github.com/tredoc/go-balances/internal/service"
+	"github.com/tredoc/go-balances/internal/store"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultBatchSize  = 500
+	DefaultInterval   = 200 * time.Millisecond
+	DefaultGapTimeout = 5 * time.Second
+	DefaultBuffer     = 256
+)
+
+var ErrEmptyFilter = errors.New("at least one balance id or user id is required")
+
+// Update is a balance.changed outbox event, its id is the resume token of the stream
+type Update struct {
+	ID        uint64
+	BalanceID uint64
+	UserID    uint64
+	Type      string
+	Payload   json.RawMessage
+	CreatedAt time.Time
+}
+
+// Filter selects updates of the listed balances and of all balances of the user
+type Filter struct {
+	BalanceIDs []uint64
+	UserID     uint64
+}
+
+func (f Filter) match(update Update) bool {
+	return (f.UserID != 0 && f.UserID == update.UserID) || slices.Contains(f.BalanceIDs, update.BalanceID)
+}
+
+// Subscription gets live updates on C. C is closed when the subscriber is too slow to keep up with
+// updates or the hub is stopped, the client should resubscribe with the id of the last received update.
+type Subscription struct {
+	C <-chan Update
+	// Start is the hub position at the moment of subscribing, C gets only updates after it
+	Start  uint64
+	c      chan Update
+	filter Filter
+	hub    *Hub
+	closed bool
+}
+
+// Close unsubscribes, it's safe to call it more than once
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	s.hub.drop(s)
+}
+
+// Hub polls the outbox and fans balance updates out to subscribers, one hub serves all streams of the process.
+//
+// Outbox ids are taken at insert but become visible at commit, so a smaller id can show up after
+// a bigger one. The hub doesn't pass an id gap until it's filled or GapTimeout is over (a rolled back
+// transaction leaves a gap forever), so updates are dispatched in id order, at most once, and the id
+// of the last one is a valid resume token. The price is the delivery guarantee:
+//   - while a gap is open, updates after it wait, a rolled back operation delays the stream by GapTimeout
+//   - an event whose transaction commits more than GapTimeout after a bigger id showed up is never
+//     streamed, neither live nor by CatchUp of a client that resumes after a bigger id
+//
+// The stream is for live views, consumers that must see every event use webhooks or the outbox relay.
+type Hub struct {
+	store     *store.Store
+	BatchSize int
+	Interval  time.Duration
+	// how long a gap holds updates back, longer lets slower transactions in and delays the stream more
+	// after rollbacks
+	GapTimeout time.Duration
+	Buffer     int
+
+	ready       chan struct{}
+	mu          sync.Mutex
+	cursor      uint64
+	gapSince    time.Time
+	owners      map[uint64]uint64
+	subscribers map[*Subscription]struct{}
+}
+
+func NewHub(store *store.Store) *Hub {
+	return &Hub{
+		store:       store,
+		BatchSize:   DefaultBatchSize,
+		Interval:    DefaultInterval,
+		GapTimeout:  DefaultGapTimeout,
+		Buffer:      DefaultBuffer,
+		ready:       make(chan struct{}),
+		owners:      make(map[uint64]uint64),
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Run polls the outbox until ctx is done, it starts from the last event, older ones are read by CatchUp
+func (h *Hub) Run(ctx context.Context) error {
+	cursor, err := h.store.GetLastOutboxEventID(ctx)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	h.mu.Lock()
+	h.cursor = cursor
+	h.mu.Unlock()
+	close(h.ready)
+
+	defer func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		for s := range h.subscribers {
+			h.drop(s)
+		}
+	}()
+
+	for {
+		dispatched, err := h.poll(ctx)
+		if err != nil && ctx.Err() == nil {
+			return err
+		}
+
+		if dispatched > 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(h.Interval):
+		}
+	}
+}
+
+// Subscribe registers the filter, it waits until Run has read the hub position
+func (h *Hub) Subscribe(ctx context.Context, filter Filter) (*Subscription, error) {
+	if len(filter.BalanceIDs) == 0 && filter.UserID == 0 {
+		return nil, ErrEmptyFilter
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-h.ready:
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	c := make(chan Update, h.Buffer)
+	s := &Subscription{C: c, Start: h.cursor, c: c, filter: filter, hub: h}
+	h.subscribers[s] = struct{}{}
+	return s, nil
+}
+
+// CatchUp sends updates with ids in (after, until] that match the filter, it reads the outbox directly,
+// so a reconnecting client gets what it missed before switching to the live subscription
+func (h *Hub) CatchUp(ctx context.Context, filter Filter, after uint64, until uint64, send func(Update) error) error {
+	for after < until {
+		rows, err := h.store.GetOutboxEventsAfterID(ctx, db.GetOutboxEventsAfterIDParams{ID: after, Limit: int32(h.BatchSize)})
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+
+		for _, row := range rows {
+			if row.ID > until {
+				return nil
+			}
+			after = row.ID
+
+			update, ok, err := h.update(ctx, row)
+			if err != nil {
+				return err
+			}
+			if ok && filter.match(update) {
+				err = send(update)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// poll dispatches new events and returns the number of events the hub moved past
+func (h *Hub) poll(ctx context.Context) (int, error) {
+	h.mu.Lock()
+	cursor := h.cursor
+	h.mu.Unlock()
+
+	rows, err := h.store.GetOutboxEventsAfterID(ctx, db.GetOutboxEventsAfterIDParams{ID: cursor, Limit: int32(h.BatchSize)})
+	if err != nil {
+		return 0, err
+	}
+
+	// owners are read before taking the lock, so subscribers are not blocked by the database
+	updates := make([]Update, len(rows))
+	matched := make([]bool, len(rows))
+	for i, row := range rows {
+		updates[i], matched[i], err = h.update(ctx, row)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	dispatched := 0
+	for i, row := range rows {
+		if row.ID != h.cursor+1 {
+			if h.gapSince.IsZero() {
+				h.gapSince = time.Now()
+			}
+			if time.Since(h.gapSince) < h.GapTimeout {
+				break
+			}
+		}
+
+		h.cursor = row.ID
+		h.gapSince = time.Time{}
+		dispatched++
+
+		if matched[i] {
+			h.dispatch(updates[i])
+		}
+	}
+
+	return dispatched, nil
+}
+
+// dispatch sends the update to matching subscribers, a subscriber with the full buffer is dropped
+// instead of blocking the others
+func (h *Hub) dispatch(update Update) {
+	for s := range h.subscribers {
+		if !s.filter.match(update) {
+			continue
+		}
+
+		select {
+		case s.c <- update:
+		default:
+			h.drop(s)
+		}
+	}
+}
+
+// drop must be called with h.mu held
+func (h *Hub) drop(s *Subscription) {
+	if s.closed {
+		return
+	}
+
+	s.closed = true
+	delete(h.subscribers, s)
+	close(s.c)
+}
+
+// update converts the outbox row, ok is false for events that are not streamed
+func (h *Hub) update(ctx context.Context, row db.OutboxEvent) (Update, bool, error) {
+	if row.EventType != service.EventBalanceChanged {
+		return Update{}, false, nil
+	}
+
+	userID, err := h.owner(ctx, row.BalanceID)
+	if err != nil {
+		return Update{}, false, err
+	}
+
+	return Update{
+		ID:        row.ID,
+		BalanceID: row.BalanceID,
+		UserID:    userID,
+		Type:      row.EventType,
+		Payload:   json.RawMessage(row.Payload),
+		CreatedAt: row.CreatedAt,
+	}, true, nil
+}
+
+// owner returns the user of the balance, balances never change owners, so they are cached forever
+func (h *Hub) owner(ctx context.Context, balanceID uint64) (uint64, error) {
+	h.mu.Lock()
+	userID, ok := h.owners[balanceID]
+	h.mu.Unlock()
+	if ok {
+		return userID, nil
+	}
+
+	balance, err := h.store.GetBalanceByID(ctx, balanceID)
+	if err != nil {
+		return 0, err
+	}
+
+	h.mu.Lock()
+	h.owners[balanceID] = balance.UserID
+	h.mu.Unlock()
+
+	return balance.UserID, nil
+}
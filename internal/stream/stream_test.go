@@ -0,0 +1,93 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/url"
+	"testing"
+)
+
+func TestParseRequest(t *testing.T) {
+	t.Run("Test filter and resume token are read", func(t *testing.T) {
+		filter, after, err := parseRequest(url.Values{"balance_id": {"1,2", "3"}, "user_id": {"7"}}, "40")
+		assert.Nil(t, err)
+		assert.Equal(t, filter, Filter{BalanceIDs: []uint64{1, 2, 3}, UserID: 7})
+		assert.Equal(t, after, uint64(40))
+
+		_, after, err = parseRequest(url.Values{"user_id": {"7"}, "after": {"42"}}, "40")
+		assert.Nil(t, err)
+		assert.Equal(t, after, uint64(42))
+	})
+
+	t.Run("Test invalid request is rejected", func(t *testing.T) {
+		_, _, err := parseRequest(url.Values{}, "")
+		assert.ErrorIs(t, err, ErrEmptyFilter)
+
+		_, _, err = parseRequest(url.Values{"balance_id": {"1,x"}}, "")
+		assert.NotNil(t, err)
+
+		_, _, err = parseRequest(url.Values{"user_id": {"0"}}, "")
+		assert.NotNil(t, err)
+
+		_, _, err = parseRequest(url.Values{"user_id": {"1"}}, "abc")
+		assert.NotNil(t, err)
+	})
+}
+
+func TestWriteUpdate(t *testing.T) {
+	t.Run("Test update is written as server-sent event", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := writeUpdate(&buf, Update{ID: 42, Type: "balance.changed", Payload: json.RawMessage(`{"balance_id":1}`)})
+		assert.Nil(t, err)
+		assert.Equal(t, buf.String(), "id: 42\nevent: balance.changed\ndata: {\"balance_id\":1}\n\n")
+	})
+}
+
+func TestDispatch(t *testing.T) {
+	hub := NewHub(nil)
+	hub.Buffer = 1
+	close(hub.ready)
+
+	t.Run("Test updates go to matching subscribers", func(t *testing.T) {
+		byBalance, err := hub.Subscribe(context.Background(), Filter{BalanceIDs: []uint64{1}})
+		assert.Nil(t, err)
+		defer byBalance.Close()
+
+		byUser, err := hub.Subscribe(context.Background(), Filter{UserID: 7})
+		assert.Nil(t, err)
+		defer byUser.Close()
+
+		hub.dispatch(Update{ID: 1, BalanceID: 2, UserID: 7})
+		assert.Equal(t, len(byBalance.C), 0)
+		assert.Equal(t, (<-byUser.C).ID, uint64(1))
+
+		hub.dispatch(Update{ID: 2, BalanceID: 1, UserID: 8})
+		assert.Equal(t, (<-byBalance.C).ID, uint64(2))
+		assert.Equal(t, len(byUser.C), 0)
+	})
+
+	t.Run("Test slow subscriber is dropped", func(t *testing.T) {
+		sub, err := hub.Subscribe(context.Background(), Filter{BalanceIDs: []uint64{1}})
+		assert.Nil(t, err)
+
+		hub.dispatch(Update{ID: 3, BalanceID: 1})
+		hub.dispatch(Update{ID: 4, BalanceID: 1})
+
+		update, ok := <-sub.C
+		assert.True(t, ok)
+		assert.Equal(t, update.ID, uint64(3))
+
+		_, ok = <-sub.C
+		assert.False(t, ok)
+
+		sub.Close()
+		assert.Equal(t, len(hub.subscribers), 0)
+	})
+
+	t.Run("Test empty filter is rejected", func(t *testing.T) {
+		_, err := hub.Subscribe(context.Background(), Filter{})
+		assert.ErrorIs(t, err, ErrEmptyFilter)
+	})
+}
@@ -0,0 +1,61 @@
+package stress
+
+import (
+	"context"
+	"fmt"
+	"github.com/tredoc/go-balances/internal/linearize"
+	"github.com/tredoc/go-balances/internal/metrics"
+	"github.com/tredoc/go-balances/internal/money"
+	"github.com/tredoc/go-balances/internal/service"
+)
+
+// serviceLedger is the service as a linearize.Ledger, amounts are in the currencies of the balances
+type serviceLedger struct {
+	services   *service.Service
+	currencies map[uint64]money.Currency
+}
+
+func (l serviceLedger) Deposit(ctx context.Context, balanceID uint64, amount int64) (int64, error) {
+	balance, err := l.services.Deposit(ctx, balanceID, money.New(amount, l.currencies[balanceID]))
+	if err != nil {
+		return 0, ledgerError(err)
+	}
+	return balance.Amount, nil
+}
+
+func (l serviceLedger) Withdraw(ctx context.Context, balanceID uint64, amount int64) (int64, error) {
+	balance, err := l.services.Withdraw(ctx, balanceID, money.New(amount, l.currencies[balanceID]))
+	if err != nil {
+		return 0, ledgerError(err)
+	}
+	return balance.Amount, nil
+}
+
+func (l serviceLedger) Transfer(ctx context.Context, fromID uint64, toID uint64, amount int64) (int64, int64, error) {
+	from, to, err := l.services.Transfer(ctx, fromID, toID, money.New(amount, l.currencies[fromID]))
+	if err != nil {
+		return 0, 0, ledgerError(err)
+	}
+	return from.Amount, to.Amount, nil
+}
+
+func (l serviceLedger) Read(ctx context.Context, balanceID uint64) (int64, error) {
+	balance, err := l.services.GetBalanceById(ctx, balanceID)
+	if err != nil {
+		return 0, ledgerError(err)
+	}
+	return balance.Amount, nil
+}
+
+// ledgerError tells the history what the error means for the outcome: lack of funds is a rejection,
+// other rules of the ledger are checked before anything is written, any other error could come after commit
+func ledgerError(err error) error {
+	switch service.Outcome(err) {
+	case metrics.OutcomeInsufficientFunds:
+		return fmt.Errorf("%w: %w", linearize.ErrRejected, err)
+	case metrics.OutcomeInvalidAmount, metrics.OutcomeRejected:
+		return fmt.Errorf("%w: %w", linearize.ErrNotApplied, err)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,132 @@
+package stress
+
+import (
+	"fmt"
+	"github.com/tredoc/go-balances/internal/linearize"
+	"github.com/tredoc/go-balances/internal/metrics"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+type sample struct {
+	operation string
+	outcome   string
+	latency   time.Duration
+}
+
+// OperationStats sums up one kind of operations, Outcomes are keyed by metrics.Outcome* values
+type OperationStats struct {
+	Count    int            `json:"count"`
+	Outcomes map[string]int `json:"outcomes"`
+	P50      time.Duration  `json:"p50"`
+	P90      time.Duration  `json:"p90"`
+	P99      time.Duration  `json:"p99"`
+	Max      time.Duration  `json:"max"`
+}
+
+type Report struct {
+	Seed     uint64        `json:"seed"`
+	Elapsed  time.Duration `json:"elapsed"`
+	Balances []uint64      `json:"balances"`
+	// operations per second of all workers, rejected ones count too
+	Throughput float64                    `json:"throughput"`
+	Operations map[string]*OperationStats `json:"operations"`
+	// deadlocks and lock wait timeouts hit during the run, Retries of them were repeated
+	LockConflicts int `json:"lock_conflicts"`
+	Retries       int `json:"retries"`
+	// verdict of the history check, empty when the history wasn't recorded
+	Linearizability linearize.Verdict     `json:"linearizability,omitempty"`
+	Anomaly         []linearize.Operation `json:"anomaly,omitempty"`
+	Violations      []string              `json:"violations"`
+}
+
+func newReport(samples [][]sample, elapsed time.Duration) *Report {
+	report := &Report{Elapsed: elapsed, Operations: make(map[string]*OperationStats)}
+
+	latencies := make(map[string][]time.Duration)
+	total := 0
+	for _, worker := range samples {
+		for _, s := range worker {
+			stats, ok := report.Operations[s.operation]
+			if !ok {
+				stats = &OperationStats{Outcomes: make(map[string]int)}
+				report.Operations[s.operation] = stats
+			}
+			stats.Count++
+			stats.Outcomes[s.outcome]++
+			latencies[s.operation] = append(latencies[s.operation], s.latency)
+			total++
+		}
+	}
+
+	for operation, stats := range report.Operations {
+		sorted := latencies[operation]
+		slices.Sort(sorted)
+		stats.P50 = percentile(sorted, 50)
+		stats.P90 = percentile(sorted, 90)
+		stats.P99 = percentile(sorted, 99)
+		stats.Max = sorted[len(sorted)-1]
+	}
+
+	if elapsed > 0 {
+		report.Throughput = float64(total) / elapsed.Seconds()
+	}
+	return report
+}
+
+// percentile takes the nearest rank of sorted latencies
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+// Failed counts operations that ended with an error rather than with a rejection by the rules of the ledger
+func (r *Report) Failed() int {
+	failed := 0
+	for _, stats := range r.Operations {
+		failed += stats.Outcomes[metrics.OutcomeError]
+	}
+	return failed
+}
+
+// Print writes the report as a table for a terminal
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "seed %d, %d balances, %s elapsed, %.1f ops/s\n\n", r.Seed, len(r.Balances), r.Elapsed.Round(time.Millisecond), r.Throughput)
+
+	outcomes := []string{metrics.OutcomeSuccess, metrics.OutcomeInsufficientFunds, metrics.OutcomeRejected, metrics.OutcomeError}
+	fmt.Fprintf(w, "%-10s %8s %8s %8s %8s %8s %10s %10s %10s %10s\n", "operation", "count", "success", "funds", "rejected", "error", "p50", "p90", "p99", "max")
+	for _, operation := range []string{OperationDeposit, OperationWithdraw, OperationTransfer, OperationRead} {
+		stats, ok := r.Operations[operation]
+		if !ok {
+			continue
+		}
+		fmt.Fprintf(w, "%-10s %8d", operation, stats.Count)
+		for _, outcome := range outcomes {
+			fmt.Fprintf(w, " %8d", stats.Outcomes[outcome])
+		}
+		fmt.Fprintf(w, " %10s %10s %10s %10s\n", round(stats.P50), round(stats.P90), round(stats.P99), round(stats.Max))
+	}
+
+	fmt.Fprintf(w, "\nlock conflicts %d, retried %d\n", r.LockConflicts, r.Retries)
+	if r.Linearizability != "" {
+		fmt.Fprintf(w, "history is %s\n", r.Linearizability)
+	}
+	for _, op := range r.Anomaly {
+		fmt.Fprintf(w, "  %s\n", op)
+	}
+
+	if len(r.Violations) == 0 {
+		fmt.Fprintln(w, "invariants hold")
+		return
+	}
+	fmt.Fprintf(w, "%d invariant violations:\n  %s\n", len(r.Violations), strings.Join(r.Violations, "\n  "))
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(10 * time.Microsecond)
+}
@@ -0,0 +1,345 @@
+package stress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/tredoc/go-balances/internal/linearize"
+	"github.com/tredoc/go-balances/internal/metrics"
+	"github.com/tredoc/go-balances/internal/money"
+	"github.com/tredoc/go-balances/internal/service"
+	"math/rand/v2"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultBalances      = 10
+	DefaultWorkers       = 8
+	DefaultDuration      = 10 * time.Second
+	DefaultMaxAmount     = 1000
+	DefaultInitialAmount = 100000
+)
+
+const (
+	OperationDeposit  = "deposit"
+	OperationWithdraw = "withdraw"
+	OperationTransfer = "transfer"
+	OperationRead     = "read"
+)
+
+// Mix is the relative weight of every operation, 0 leaves the operation out
+type Mix struct {
+	Deposits    int
+	Withdrawals int
+	Transfers   int
+	// reads change nothing, they let the history check catch dirty and stale reads
+	Reads int
+}
+
+// Runner opens fresh balances, hammers them with random operations from concurrent workers and then checks
+// the invariants of the ledger. Balances of the previous runs and of real users are never touched or read.
+type Runner struct {
+	services *service.Service
+	// number of balances, they are spread over Currencies evenly
+	Balances int
+	Workers  int
+	Duration time.Duration
+	Mix      Mix
+	// amounts are random from 1 to MaxAmount minor units
+	MaxAmount int64
+	// deposited to every balance before the run
+	InitialAmount int64
+	Currencies    []string
+	// the same seed gives the same sequence of operations of every worker, 0 takes a random one
+	Seed uint64
+	// record every call and check that the history is linearizable after the run
+	CheckHistory bool
+}
+
+func New(services *service.Service) *Runner {
+	return &Runner{
+		services:      services,
+		Balances:      DefaultBalances,
+		Workers:       DefaultWorkers,
+		Duration:      DefaultDuration,
+		Mix:           Mix{Deposits: 1, Withdrawals: 1, Transfers: 2},
+		MaxAmount:     DefaultMaxAmount,
+		InitialAmount: DefaultInitialAmount,
+		Currencies:    []string{"USD"},
+	}
+}
+
+func (r *Runner) validate() error {
+	switch {
+	case r.Workers < 1:
+		return errors.New("workers must be positive")
+	case r.Duration <= 0:
+		return errors.New("duration must be positive")
+	case r.MaxAmount < 1:
+		return errors.New("max amount must be positive")
+	case r.InitialAmount < 0:
+		return errors.New("initial amount must not be negative")
+	case r.Mix.Deposits < 0 || r.Mix.Withdrawals < 0 || r.Mix.Transfers < 0 || r.Mix.Reads < 0:
+		return errors.New("mix weights must not be negative")
+	case r.Mix.total() == 0:
+		return errors.New("mix has no operations")
+	case len(r.Currencies) == 0:
+		return errors.New("no currencies")
+	case r.Balances < len(r.Currencies):
+		return errors.New("every currency needs a balance")
+	case r.Mix.Transfers > 0 && r.Balances < 2*len(r.Currencies):
+		return errors.New("transfers need two balances of every currency")
+	}
+	return nil
+}
+
+func (m Mix) total() int {
+	return m.Deposits + m.Withdrawals + m.Transfers + m.Reads
+}
+
+// account is a balance of the run with the amount it must have after all acknowledged operations
+type account struct {
+	balance  service.Balance
+	expected atomic.Int64
+}
+
+// Run opens the balances, runs the workload for Duration or until ctx is done and verifies the result.
+// Returned error means the run couldn't be done, broken invariants are Violations of the report.
+func (r *Runner) Run(ctx context.Context) (*Report, error) {
+	err := r.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	seed := r.Seed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+
+	accounts, err := r.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// same currency balances, transfers pick both sides from one group
+	groups := make(map[string][]*account)
+	for _, acc := range accounts {
+		code := acc.balance.Currency.Code
+		groups[code] = append(groups[code], acc)
+	}
+
+	ledger := serviceLedger{services: r.services, currencies: make(map[uint64]money.Currency)}
+	initial := make(map[uint64]int64)
+	for _, acc := range accounts {
+		ledger.currencies[acc.balance.ID] = acc.balance.Currency
+		initial[acc.balance.ID] = acc.balance.Amount
+	}
+
+	var recorder *linearize.Recorder
+	if r.CheckHistory {
+		recorder = linearize.NewRecorder()
+	}
+
+	conflictsBefore := metrics.Sum(metrics.LockConflicts)
+	retriesBefore := metrics.Sum(metrics.Retries)
+
+	runCtx, cancel := context.WithTimeout(ctx, r.Duration)
+	defer cancel()
+
+	samples := make([][]sample, r.Workers)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := range r.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := worker{
+				runner:   r,
+				client:   linearize.NewClient(ledger, recorder, i),
+				rand:     rand.New(rand.NewPCG(seed, uint64(i))),
+				accounts: accounts,
+				groups:   groups,
+			}
+			samples[i] = w.run(runCtx)
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	report := newReport(samples, elapsed)
+	report.Seed = seed
+	report.LockConflicts = int(metrics.Sum(metrics.LockConflicts) - conflictsBefore)
+	report.Retries = int(metrics.Sum(metrics.Retries) - retriesBefore)
+	for _, acc := range accounts {
+		report.Balances = append(report.Balances, acc.balance.ID)
+	}
+
+	report.Violations, err = r.verify(context.WithoutCancel(ctx), accounts)
+	if err != nil {
+		return report, err
+	}
+
+	if recorder != nil {
+		result := linearize.Check(recorder.History(), initial)
+		report.Linearizability = result.Verdict
+		report.Anomaly = result.Anomaly
+		if result.Verdict == linearize.NotLinearizable {
+			report.Violations = append(report.Violations, fmt.Sprintf("history is not linearizable, %d operations have no valid order", len(result.Anomaly)))
+		}
+	}
+
+	return report, nil
+}
+
+// open creates a user with one balance for every balance of the run, a user can't have two balances
+// of one currency
+func (r *Runner) open(ctx context.Context) ([]*account, error) {
+	run := strconv.FormatInt(time.Now().UnixNano(), 36)
+
+	accounts := make([]*account, 0, r.Balances)
+	for i := range r.Balances {
+		currency, err := r.services.GetCurrencyByCode(ctx, r.Currencies[i%len(r.Currencies)])
+		if err != nil {
+			return nil, fmt.Errorf("currency %s: %w", r.Currencies[i%len(r.Currencies)], err)
+		}
+
+		user, err := r.services.CreateUser(ctx, fmt.Sprintf("stress-%s-%d", run, i))
+		if err != nil {
+			return nil, err
+		}
+
+		balance, err := r.services.OpenBalance(ctx, user.ID, currency.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		if r.InitialAmount > 0 {
+			balance, err = r.services.Deposit(ctx, balance.ID, money.New(r.InitialAmount, balance.Currency))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		acc := &account{balance: *balance}
+		acc.expected.Store(balance.Amount)
+		accounts = append(accounts, acc)
+	}
+
+	return accounts, nil
+}
+
+// verify rereads the balances after the run:
+//   - the sum of the balances of every currency is what acknowledged deposits and withdrawals left,
+//     transfers only move money inside the run
+//   - no balance is negative
+//   - every balance is what its acknowledged operations left
+//   - the stored amounts of the run's balances match their entries, transfers and holds
+func (r *Runner) verify(ctx context.Context, accounts []*account) ([]string, error) {
+	var violations []string
+	expected := make(map[string]int64)
+	stored := make(map[string]int64)
+
+	for _, acc := range accounts {
+		balance, err := r.services.GetBalanceById(ctx, acc.balance.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		code := balance.Currency.Code
+		expected[code] += acc.expected.Load()
+		stored[code] += balance.Amount
+
+		if balance.Amount < 0 {
+			violations = append(violations, fmt.Sprintf("balance %d is negative: %d", balance.ID, balance.Amount))
+		}
+		if balance.Amount != acc.expected.Load() {
+			violations = append(violations, fmt.Sprintf("balance %d has %d, acknowledged operations left %d", balance.ID, balance.Amount, acc.expected.Load()))
+		}
+	}
+
+	for _, code := range r.Currencies {
+		if stored[code] != expected[code] {
+			violations = append(violations, fmt.Sprintf("%s total is %d, expected %d", code, stored[code], expected[code]))
+		}
+	}
+
+	ids := make([]uint64, 0, len(accounts))
+	for _, acc := range accounts {
+		ids = append(ids, acc.balance.ID)
+	}
+
+	// balances outside the run may be changed by anything, only the run's ones are checked
+	discrepancies, err := r.services.ReconcileBalances(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range discrepancies {
+		violations = append(violations, fmt.Sprintf("balance %d %s is %d, ledger has %d", d.BalanceID, d.Field, d.Stored, d.Expected))
+	}
+
+	return violations, nil
+}
+
+type worker struct {
+	runner   *Runner
+	client   *linearize.Client
+	rand     *rand.Rand
+	accounts []*account
+	groups   map[string][]*account
+}
+
+// run issues operations one after another until ctx is done. Operations get a context that isn't canceled,
+// so the run never ends with an operation whose outcome is unknown.
+func (w *worker) run(ctx context.Context) []sample {
+	var samples []sample
+	opCtx := context.WithoutCancel(ctx)
+	for ctx.Err() == nil {
+		samples = append(samples, w.step(opCtx))
+	}
+	return samples
+}
+
+func (w *worker) step(ctx context.Context) sample {
+	mix := w.runner.Mix
+	amount := 1 + w.rand.Int64N(w.runner.MaxAmount)
+	acc := w.accounts[w.rand.IntN(len(w.accounts))]
+
+	var operation string
+	var err error
+	start := time.Now()
+	switch pick := w.rand.IntN(mix.total()); {
+	case pick < mix.Deposits:
+		operation = OperationDeposit
+		_, err = w.client.Deposit(ctx, acc.balance.ID, amount)
+		if err == nil {
+			acc.expected.Add(amount)
+		}
+	case pick < mix.Deposits+mix.Withdrawals:
+		operation = OperationWithdraw
+		_, err = w.client.Withdraw(ctx, acc.balance.ID, amount)
+		if err == nil {
+			acc.expected.Add(-amount)
+		}
+	case pick < mix.Deposits+mix.Withdrawals+mix.Transfers:
+		operation = OperationTransfer
+		group := w.groups[acc.balance.Currency.Code]
+		to := group[w.rand.IntN(len(group)-1)]
+		if to == acc {
+			// the last one takes the place of the sender
+			to = group[len(group)-1]
+		}
+		_, _, err = w.client.Transfer(ctx, acc.balance.ID, to.balance.ID, amount)
+		if err == nil {
+			acc.expected.Add(-amount)
+			to.expected.Add(amount)
+		}
+	default:
+		operation = OperationRead
+		_, err = w.client.Read(ctx, acc.balance.ID)
+	}
+
+	return sample{operation: operation, outcome: service.Outcome(err), latency: time.Since(start)}
+}
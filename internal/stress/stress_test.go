@@ -0,0 +1,86 @@
+package stress
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/tredoc/go-balances/internal/metrics"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	t.Run("Test defaults are valid", func(t *testing.T) {
+		assert.NoError(t, New(nil).validate())
+	})
+
+	t.Run("Test invalid runs are refused", func(t *testing.T) {
+		cases := map[string]func(r *Runner){
+			"no workers":          func(r *Runner) { r.Workers = 0 },
+			"no duration":         func(r *Runner) { r.Duration = 0 },
+			"no amount":           func(r *Runner) { r.MaxAmount = 0 },
+			"negative weight":     func(r *Runner) { r.Mix.Withdrawals = -1 },
+			"empty mix":           func(r *Runner) { r.Mix = Mix{} },
+			"no currencies":       func(r *Runner) { r.Currencies = nil },
+			"currency no balance": func(r *Runner) { r.Balances = 1; r.Currencies = []string{"USD", "EUR"} },
+			"one side transfers":  func(r *Runner) { r.Balances = 3; r.Currencies = []string{"USD", "EUR"} },
+		}
+		for name, change := range cases {
+			runner := New(nil)
+			change(runner)
+			assert.Error(t, runner.validate(), name)
+		}
+	})
+
+	t.Run("Test single balance is enough without transfers", func(t *testing.T) {
+		runner := New(nil)
+		runner.Balances = 1
+		runner.Mix = Mix{Deposits: 1, Withdrawals: 1}
+		assert.NoError(t, runner.validate())
+	})
+}
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Millisecond)
+	}
+
+	assert.Equal(t, 50*time.Millisecond, percentile(sorted, 50))
+	assert.Equal(t, 99*time.Millisecond, percentile(sorted, 99))
+	assert.Equal(t, time.Millisecond, percentile(sorted[:1], 99))
+	assert.Equal(t, 2*time.Millisecond, percentile(sorted[:2], 90))
+	assert.Zero(t, percentile(nil, 50))
+}
+
+func TestReport(t *testing.T) {
+	samples := [][]sample{
+		{
+			{operation: OperationDeposit, outcome: metrics.OutcomeSuccess, latency: 2 * time.Millisecond},
+			{operation: OperationWithdraw, outcome: metrics.OutcomeInsufficientFunds, latency: time.Millisecond},
+		},
+		{
+			{operation: OperationDeposit, outcome: metrics.OutcomeSuccess, latency: 4 * time.Millisecond},
+			{operation: OperationTransfer, outcome: metrics.OutcomeError, latency: 8 * time.Millisecond},
+		},
+	}
+
+	report := newReport(samples, 2*time.Second)
+
+	assert.Equal(t, 2.0, report.Throughput)
+	assert.Equal(t, 2, report.Operations[OperationDeposit].Count)
+	assert.Equal(t, 2, report.Operations[OperationDeposit].Outcomes[metrics.OutcomeSuccess])
+	assert.Equal(t, 2*time.Millisecond, report.Operations[OperationDeposit].P50)
+	assert.Equal(t, 4*time.Millisecond, report.Operations[OperationDeposit].Max)
+	assert.Equal(t, 1, report.Operations[OperationWithdraw].Outcomes[metrics.OutcomeInsufficientFunds])
+	assert.Equal(t, 1, report.Failed())
+
+	var out bytes.Buffer
+	report.Print(&out)
+	assert.Contains(t, out.String(), "invariants hold")
+
+	report.Violations = []string{"balance 1 is negative: -1"}
+	out.Reset()
+	report.Print(&out)
+	assert.Contains(t, out.String(), "1 invariant violations")
+	assert.Contains(t, out.String(), "balance 1 is negative")
+}
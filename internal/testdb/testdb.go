@@ -0,0 +1,142 @@
+package testdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/tredoc/go-balances/internal/config"
+	"github.com/tredoc/go-balances/internal/money"
+	"github.com/tredoc/go-balances/internal/schema"
+	"github.com/tredoc/go-balances/internal/service"
+	"github.com/tredoc/go-balances/internal/store"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"testing"
+)
+
+// ErrUnavailable means there is no mysql server to provision a database on
+var ErrUnavailable = errors.New("test database unavailable")
+
+// Database is a database created for tests, Close drops it
+type Database struct {
+	// config of the base database with the name of the new one
+	Config config.DB
+	Conn   *sql.DB
+	// connection to the server without a database, the new one is dropped through it
+	server *sql.DB
+}
+
+// Create makes a database named after the one of cfg with a random suffix, migrates it up and connects to it.
+// Tests on it don't depend on what earlier runs left and don't see changes of tests on other databases.
+func Create(cfg config.DB) (*Database, error) {
+	serverCfg := cfg
+	serverCfg.Name = ""
+	server, err := store.Open(serverCfg)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnavailable, err)
+	}
+
+	d := &Database{Config: cfg, server: server}
+	d.Config.Name = fmt.Sprintf("%s_test_%s", cfg.Name, strconv.FormatUint(rand.Uint64(), 36))
+	_, err = server.Exec("CREATE DATABASE `" + d.Config.Name + "`")
+	if err != nil {
+		server.Close()
+		return nil, err
+	}
+
+	err = migrate(d.Config)
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+
+	d.Conn, err = store.Open(d.Config)
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func migrate(cfg config.DB) error {
+	migrator, err := schema.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	return migrator.Up()
+}
+
+// Close disconnects and drops the database
+func (d *Database) Close() error {
+	var errs []error
+	if d.Conn != nil {
+		errs = append(errs, d.Conn.Close())
+	}
+	_, err := d.server.Exec("DROP DATABASE IF EXISTS `" + d.Config.Name + "`")
+	errs = append(errs, err, d.server.Close())
+	return errors.Join(errs...)
+}
+
+// New creates a database for the test and drops it when the test is done. The test is skipped when
+// the environment has no database config, unless CI is set. When the config is there, a server that
+// can't be reached fails the test, so a broken setup doesn't pass as skipped tests.
+func New(t testing.TB) *Database {
+	t.Helper()
+
+	cfg, err := config.Load("")
+	if err != nil && os.Getenv("CI") != "" {
+		t.Fatalf("no database config on CI: %v", err)
+	}
+	if err != nil {
+		t.Skipf("no database config: %v", err)
+	}
+
+	d, err := Create(cfg.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		err := d.Close()
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	return d
+}
+
+// Balance opens a balance of a new user through the service and deposits amount minor units to it,
+// so fixtures have entries, events and audit records like balances of real users
+func Balance(t testing.TB, services *service.Service, currency string, amount int64) *service.Balance {
+	t.Helper()
+	ctx := context.Background()
+
+	found, err := services.GetCurrencyByCode(ctx, currency)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := services.CreateUser(ctx, "fixture_"+strconv.FormatUint(rand.Uint64(), 36))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	balance, err := services.OpenBalance(ctx, user.ID, found.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if amount > 0 {
+		balance, err = services.Deposit(ctx, balance.ID, money.New(amount, balance.Currency))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return balance
+}
@@ -0,0 +1,51 @@
+package testdb
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/tredoc/go-balances/internal/config"
+	"testing"
+	"time"
+)
+
+func TestCreate(t *testing.T) {
+	t.Run("Test unreachable server is unavailable", func(t *testing.T) {
+		cfg := config.Default().DB
+		cfg.Host = "127.0.0.1"
+		cfg.Port = 1
+		cfg.User = "root"
+		cfg.Name = "balance"
+		cfg.DialTimeout = time.Second
+
+		_, err := Create(cfg)
+		assert.True(t, errors.Is(err, ErrUnavailable))
+	})
+
+	t.Run("Test database is migrated and dropped on close", func(t *testing.T) {
+		d := New(t)
+
+		var tables int
+		err := d.Conn.QueryRow("SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = ?", d.Config.Name).Scan(&tables)
+		assert.NoError(t, err)
+		assert.Positive(t, tables)
+
+		var version uint
+		err = d.Conn.QueryRow("SELECT version FROM schema_migrations").Scan(&version)
+		assert.NoError(t, err)
+		assert.Positive(t, version)
+
+		cfg, err := config.Load("")
+		assert.NoError(t, err)
+		other, err := Create(cfg.DB)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.NotEqual(t, d.Config.Name, other.Config.Name)
+
+		err = other.Close()
+		assert.NoError(t, err)
+		err = d.Conn.QueryRow("SELECT COUNT(*) FROM information_schema.schemata WHERE schema_name = ?", other.Config.Name).Scan(&tables)
+		assert.NoError(t, err)
+		assert.Zero(t, tables)
+	})
+}
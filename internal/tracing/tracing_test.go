@@ -0,0 +1,142 @@
+package tracing
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tredoc/go-balances/internal/config"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+// collector is a stand-in otlp collector that keeps names of received spans
+type collector struct {
+	collectorpb.UnimplementedTraceServiceServer
+	mu       sync.Mutex
+	spans    []string
+	services []string
+}
+
+func (c *collector) Export(_ context.Context, req *collectorpb.ExportTraceServiceRequest) (*collectorpb.ExportTraceServiceResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, resourceSpans := range req.ResourceSpans {
+		for _, attr := range resourceSpans.Resource.Attributes {
+			if attr.Key == "service.name" {
+				c.services = append(c.services, attr.Value.GetStringValue())
+			}
+		}
+		for _, scopeSpans := range resourceSpans.ScopeSpans {
+			for _, span := range scopeSpans.Spans {
+				c.spans = append(c.spans, span.Name)
+			}
+		}
+	}
+	return &collectorpb.ExportTraceServiceResponse{}, nil
+}
+
+// recordSpans installs a provider that keeps ended spans in memory for the duration of the test
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func TestSetup(t *testing.T) {
+	t.Run("Test spans are exported to otlp collector", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+
+		received := &collector{}
+		server := grpc.NewServer()
+		collectorpb.RegisterTraceServiceServer(server, received)
+		go server.Serve(listener)
+		defer server.Stop()
+
+		previous := otel.GetTracerProvider()
+		defer otel.SetTracerProvider(previous)
+
+		shutdown, err := Setup(context.Background(), config.Tracing{
+			Exporter:    "otlp",
+			Endpoint:    listener.Addr().String(),
+			Insecure:    true,
+			ServiceName: "balances-test",
+		})
+		require.NoError(t, err)
+
+		_, span := otel.Tracer("test").Start(context.Background(), "service.Transfer")
+		span.End()
+
+		require.NoError(t, shutdown(context.Background()))
+
+		received.mu.Lock()
+		defer received.mu.Unlock()
+		assert.Equal(t, []string{"service.Transfer"}, received.spans)
+		assert.Equal(t, []string{"balances-test"}, received.services)
+	})
+
+	t.Run("Test unknown exporter", func(t *testing.T) {
+		_, err := Setup(context.Background(), config.Tracing{Exporter: "jaeger"})
+		assert.ErrorContains(t, err, "jaeger")
+	})
+}
+
+func TestPropagation(t *testing.T) {
+	_, err := Setup(context.Background(), config.Tracing{Exporter: "none"})
+	require.NoError(t, err)
+
+	t.Run("Test grpc call continues the trace of the caller", func(t *testing.T) {
+		recorder := recordSpans(t)
+
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("traceparent", traceparent))
+		var handled trace.SpanContext
+		_, err := UnaryServerInterceptor()(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/ledger.v1.Ledger/Deposit"},
+			func(ctx context.Context, req any) (any, error) {
+				handled = trace.SpanContextFromContext(ctx)
+				return nil, nil
+			})
+		require.NoError(t, err)
+
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", handled.TraceID().String())
+
+		spans := recorder.Ended()
+		require.Len(t, spans, 1)
+		assert.Equal(t, "/ledger.v1.Ledger/Deposit", spans[0].Name())
+		assert.Equal(t, "00f067aa0ba902b7", spans[0].Parent().SpanID().String())
+		assert.True(t, spans[0].Parent().IsRemote())
+	})
+
+	t.Run("Test http request continues the trace of the caller", func(t *testing.T) {
+		recorder := recordSpans(t)
+
+		var handled trace.SpanContext
+		handler := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handled = trace.SpanContextFromContext(r.Context())
+		}))
+
+		req := httptest.NewRequest(http.MethodGet, "/stream", nil)
+		req.Header.Set("traceparent", traceparent)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", handled.TraceID().String())
+
+		spans := recorder.Ended()
+		require.Len(t, spans, 1)
+		assert.Equal(t, "GET /stream", spans[0].Name())
+	})
+}
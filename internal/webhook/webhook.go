@@ -0,0 +1,434 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	db "github.com/tredoc/go-balances/db/sqlc"
+	"github.com/tredoc/go-balances/internal/outbox"
+	"github.com/tredoc/go-balances/internal/service"
+	"github.com/tredoc/go-balances/internal/store"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+const (
+	DefaultMaxAttempts = 10
+	DefaultBaseDelay   = 10 * time.Second
+	DefaultMaxDelay    = time.Hour
+	DefaultBatchSize   = 50
+	DefaultLease       = time.Minute
+	DefaultInterval    = time.Second
+)
+
+// replayPage is the number of outbox events read at once by Replay
+const replayPage = 500
+
+var (
+	ErrInvalidURL       = errors.New("webhook url must be absolute http or https url")
+	ErrMissingSecret    = errors.New("webhook secret must be at least 16 characters")
+	ErrNoEventTypes     = errors.New("at least one event type is required")
+	ErrUnknownEventType = errors.New("unknown event type")
+	ErrInvalidWindow    = errors.New("replay window start must be before its end")
+)
+
+// Webhooks manages subscriptions and delivers outbox events to them. It's an outbox.Sink:
+// the relay fans events out into webhook_deliveries and DeliverOnce sends them with retries.
+type Webhooks struct {
+	store       *store.Store
+	client      *http.Client
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	BatchSize   int
+	// Lease is how long a claimed delivery is hidden from other workers, it must be longer than the client timeout
+	Lease    time.Duration
+	Interval time.Duration
+	now      func() time.Time
+}
+
+func New(store *store.Store, client *http.Client) *Webhooks {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	return &Webhooks{
+		store:       store,
+		client:      client,
+		MaxAttempts: DefaultMaxAttempts,
+		BaseDelay:   DefaultBaseDelay,
+		MaxDelay:    DefaultMaxDelay,
+		BatchSize:   DefaultBatchSize,
+		Lease:       DefaultLease,
+		Interval:    DefaultInterval,
+		now:         time.Now,
+	}
+}
+
+// clock returns current time in UTC with seconds precision, the one mysql DATETIME keeps
+func (w *Webhooks) clock() time.Time {
+	return w.now().UTC().Truncate(time.Second)
+}
+
+// Body is what the subscriber receives, id is the outbox event id and stays the same across retries and replays
+type Body struct {
+	ID        uint64          `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+func (w *Webhooks) Subscribe(ctx context.Context, rawURL string, secret string, eventTypes []string) (*db.WebhookSubscription, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, ErrInvalidURL
+	}
+
+	if len(secret) < 16 {
+		return nil, ErrMissingSecret
+	}
+
+	if len(eventTypes) == 0 {
+		return nil, ErrNoEventTypes
+	}
+
+	for _, eventType := range eventTypes {
+		if !slices.Contains(service.EventTypes, eventType) {
+			return nil, ErrUnknownEventType
+		}
+	}
+
+	id, err := w.store.CreateWebhookSubscription(ctx, db.CreateWebhookSubscriptionParams{
+		Url:        rawURL,
+		Secret:     secret,
+		EventTypes: strings.Join(eventTypes, ","),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	subscription, err := w.store.GetWebhookSubscriptionByID(ctx, uint64(id))
+	if err != nil {
+		return nil, err
+	}
+
+	return &subscription, nil
+}
+
+// SetActive pauses or resumes the subscription, subscriptions are never deleted to keep the delivery log
+func (w *Webhooks) SetActive(ctx context.Context, subscriptionID uint64, active bool) error {
+	_, err := w.store.GetWebhookSubscriptionByID(ctx, subscriptionID)
+	if err != nil {
+		return err
+	}
+
+	return w.store.UpdateWebhookSubscriptionActive(ctx, db.UpdateWebhookSubscriptionActiveParams{ID: subscriptionID, Active: active})
+}
+
+func (w *Webhooks) GetSubscriptions(ctx context.Context) ([]db.WebhookSubscription, error) {
+	return w.store.GetAllWebhookSubscriptions(ctx)
+}
+
+func (w *Webhooks) GetDeliveries(ctx context.Context, subscriptionID uint64) ([]db.WebhookDelivery, error) {
+	return w.store.GetWebhookDeliveriesBySubscriptionID(ctx, subscriptionID)
+}
+
+func (w *Webhooks) GetAttempts(ctx context.Context, deliveryID uint64) ([]db.WebhookAttempt, error) {
+	return w.store.GetWebhookAttemptsByDeliveryID(ctx, deliveryID)
+}
+
+func subscribed(subscription db.WebhookSubscription, eventType string) bool {
+	return slices.Contains(strings.Split(subscription.EventTypes, ","), eventType)
+}
+
+// Publish creates deliveries of the event for all active subscriptions of its type,
+// publishing the same event again doesn't create duplicates. Deliveries reference the outbox row,
+// so the foreign key check waits for any transaction that locked it.
+func (w *Webhooks) Publish(ctx context.Context, event outbox.Event) error {
+	subscriptions, err := w.store.GetActiveWebhookSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, subscription := range subscriptions {
+		if !subscribed(subscription, event.Type) {
+			continue
+		}
+
+		err = w.store.CreateWebhookDelivery(ctx, db.CreateWebhookDeliveryParams{
+			SubscriptionID: subscription.ID,
+			EventID:        event.ID,
+			EventType:      event.Type,
+			NextAttemptAt:  w.clock(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Replay delivers again all events of the subscription created in [from, to), including events
+// that were delivered already or happened before the subscription. Returns the number of deliveries.
+func (w *Webhooks) Replay(ctx context.Context, subscriptionID uint64, from time.Time, to time.Time) (int, error) {
+	if !from.Before(to) {
+		return 0, ErrInvalidWindow
+	}
+
+	subscription, err := w.store.GetWebhookSubscriptionByID(ctx, subscriptionID)
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	var afterID uint64
+	for {
+		events, err := w.store.GetOutboxEventsByCreatedAt(ctx, db.GetOutboxEventsByCreatedAtParams{
+			CreatedFrom: from.UTC(),
+			CreatedTo:   to.UTC(),
+			AfterID:     afterID,
+			PageSize:    replayPage,
+		})
+		if err != nil {
+			return replayed, err
+		}
+
+		for _, event := range events {
+			afterID = event.ID
+			if !subscribed(subscription, event.EventType) {
+				continue
+			}
+
+			err = w.store.ReplayWebhookDelivery(ctx, db.ReplayWebhookDeliveryParams{
+				SubscriptionID: subscription.ID,
+				EventID:        event.ID,
+				EventType:      event.EventType,
+				NextAttemptAt:  w.clock(),
+			})
+			if err != nil {
+				return replayed, err
+			}
+			replayed++
+		}
+
+		if len(events) < replayPage {
+			return replayed, nil
+		}
+	}
+}
+
+// backoff returns the delay before the next attempt, it doubles after every failed attempt
+func (w *Webhooks) backoff(attempts int) time.Duration {
+	delay := w.BaseDelay
+	for i := 1; i < attempts && delay < w.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, w.MaxDelay)
+}
+
+// claim hides due deliveries from other workers for Lease, a delivery that isn't finished
+// by then, because the worker died, is picked up again
+func (w *Webhooks) claim(ctx context.Context) ([]db.WebhookDelivery, error) {
+	tx, err := w.store.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	qtx := w.store.WithTx(tx)
+
+	now := w.clock()
+	deliveries, err := qtx.GetDueWebhookDeliveriesForUpdate(ctx, db.GetDueWebhookDeliveriesForUpdateParams{
+		NextAttemptAt: now,
+		Limit:         int32(w.BatchSize),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, delivery := range deliveries {
+		err = qtx.UpdateWebhookDeliveryNextAttempt(ctx, db.UpdateWebhookDeliveryNextAttemptParams{
+			ID:            delivery.ID,
+			NextAttemptAt: now.Add(w.Lease),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return deliveries, tx.Commit()
+}
+
+// DeliverOnce sends due deliveries and returns how many of them were attempted
+func (w *Webhooks) DeliverOnce(ctx context.Context) (int, error) {
+	deliveries, err := w.claim(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, delivery := range deliveries {
+		err = w.deliver(ctx, delivery)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(deliveries), nil
+}
+
+// Run delivers until ctx is done, it waits for Interval only when nothing is due
+func (w *Webhooks) Run(ctx context.Context) error {
+	for {
+		attempted, err := w.DeliverOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			return err
+		}
+
+		if attempted > 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(w.Interval):
+		}
+	}
+}
+
+// deliver makes one attempt and records it, only errors of the database are returned
+func (w *Webhooks) deliver(ctx context.Context, delivery db.WebhookDelivery) error {
+	subscription, err := w.store.GetWebhookSubscriptionByID(ctx, delivery.SubscriptionID)
+	if err != nil {
+		return err
+	}
+
+	if !subscription.Active {
+		// paused subscriptions get their events by replay after resuming
+		return w.finish(ctx, delivery, DeliveryFailed, 0, "subscription is not active", 0, false)
+	}
+
+	event, err := w.store.GetOutboxEventByID(ctx, delivery.EventID)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(Body{
+		ID:        event.ID,
+		Type:      event.EventType,
+		CreatedAt: event.CreatedAt,
+		Data:      json.RawMessage(event.Payload),
+	})
+	if err != nil {
+		return err
+	}
+
+	started := time.Now()
+	statusCode, sendErr := w.send(ctx, subscription, delivery, body)
+	duration := time.Since(started)
+
+	var errText string
+	if sendErr != nil {
+		errText = sendErr.Error()
+	}
+
+	status := DeliveryDelivered
+	if sendErr != nil {
+		status = DeliveryPending
+		if int(delivery.Attempts)+1 >= w.MaxAttempts {
+			status = DeliveryFailed
+		}
+	}
+
+	return w.finish(ctx, delivery, status, statusCode, errText, duration, true)
+}
+
+func (w *Webhooks) send(ctx context.Context, subscription db.WebhookSubscription, delivery db.WebhookDelivery, body []byte) (int32, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event-Id", strconv.FormatUint(delivery.EventID, 10))
+	req.Header.Set("X-Webhook-Event-Type", delivery.EventType)
+	req.Header.Set("X-Webhook-Delivery-Id", strconv.FormatUint(delivery.ID, 10))
+	req.Header.Set(SignatureHeader, Sign(subscription.Secret, w.now(), body))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return int32(resp.StatusCode), errors.New("subscriber responded with " + resp.Status)
+	}
+
+	return int32(resp.StatusCode), nil
+}
+
+// finish writes the attempt to the delivery log and schedules the next one if the delivery is still pending
+func (w *Webhooks) finish(ctx context.Context, delivery db.WebhookDelivery, status string, statusCode int32, errText string, duration time.Duration, attempted bool) error {
+	tx, err := w.store.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	qtx := w.store.WithTx(tx)
+
+	attempts := delivery.Attempts
+	if attempted {
+		attempts++
+
+		if runes := []rune(errText); len(runes) > 1000 {
+			errText = string(runes[:1000])
+		}
+
+		_, err = qtx.CreateWebhookAttempt(ctx, db.CreateWebhookAttemptParams{
+			DeliveryID:   delivery.ID,
+			StatusCode:   statusCode,
+			ErrorMessage: errText,
+			DurationMs:   uint32(duration.Milliseconds()),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	now := w.clock()
+	result := db.UpdateWebhookDeliveryResultParams{
+		ID:            delivery.ID,
+		Status:        status,
+		Attempts:      attempts,
+		NextAttemptAt: now,
+	}
+	switch status {
+	case DeliveryDelivered:
+		result.DeliveredAt = sql.NullTime{Time: now, Valid: true}
+	case DeliveryPending:
+		result.NextAttemptAt = now.Add(w.backoff(int(attempts)))
+	}
+
+	err = qtx.UpdateWebhookDeliveryResult(ctx, result)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
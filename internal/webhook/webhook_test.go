@@ -0,0 +1,58 @@
+package webhook
+
+import (
+	"github.com/stretchr/testify/assert"
+	db "github.com/tredoc/go-balances/db/sqlc"
+	"testing"
+	"time"
+)
+
+func TestSignature(t *testing.T) {
+	secret := "0123456789abcdef"
+	body := []byte(`{"id":1,"type":"deposit.completed"}`)
+	now := time.Unix(1700000000, 0)
+
+	t.Run("Test signed body is verified", func(t *testing.T) {
+		header := Sign(secret, now, body)
+		assert.Regexp(t, `^t=1700000000,v1=[0-9a-f]{64}$`, header)
+		assert.Nil(t, Verify(secret, header, body, time.Minute, now.Add(30*time.Second)))
+	})
+
+	t.Run("Test tampered or stale request is rejected", func(t *testing.T) {
+		header := Sign(secret, now, body)
+
+		err := Verify(secret, header, []byte(`{"id":2,"type":"deposit.completed"}`), time.Minute, now)
+		assert.ErrorIs(t, err, ErrInvalidSignature)
+
+		err = Verify("another secret!!", header, body, time.Minute, now)
+		assert.ErrorIs(t, err, ErrInvalidSignature)
+
+		err = Verify(secret, header, body, time.Minute, now.Add(2*time.Minute))
+		assert.ErrorIs(t, err, ErrInvalidSignature)
+
+		err = Verify(secret, "v1=abc", body, time.Minute, now)
+		assert.ErrorIs(t, err, ErrInvalidSignature)
+	})
+}
+
+func TestBackoff(t *testing.T) {
+	t.Run("Test delay doubles up to the limit", func(t *testing.T) {
+		w := &Webhooks{BaseDelay: 10 * time.Second, MaxDelay: time.Minute}
+
+		var delays []time.Duration
+		for attempts := 1; attempts <= 5; attempts++ {
+			delays = append(delays, w.backoff(attempts))
+		}
+		assert.Equal(t, delays, []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, time.Minute, time.Minute})
+	})
+}
+
+func TestSubscribed(t *testing.T) {
+	t.Run("Test event types are matched exactly", func(t *testing.T) {
+		subscription := db.WebhookSubscription{EventTypes: "deposit.completed,withdraw.rejected"}
+		assert.True(t, subscribed(subscription, "deposit.completed"))
+		assert.True(t, subscribed(subscription, "withdraw.rejected"))
+		assert.False(t, subscribed(subscription, "deposit"))
+		assert.False(t, subscribed(subscription, "transfer.completed"))
+	})
+}